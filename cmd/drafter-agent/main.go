@@ -19,9 +19,10 @@ func main() {
 	vsockPort := flag.Uint("vsock-port", 26, "VSock port")
 	vsockTimeout := flag.Duration("vsock-timeout", time.Minute, "VSock dial timeout")
 
-	shellCmd := flag.String("shell-cmd", "sh", "Shell to use to run the before suspend and after resume commands")
+	shellCmd := flag.String("shell-cmd", "sh", "Shell to use to run the before suspend, after resume, shutdown and probe commands")
 	beforeSuspendCmd := flag.String("before-suspend-cmd", "", "Command to run before the VM is suspended (leave empty to disable)")
 	afterResumeCmd := flag.String("after-resume-cmd", "", "Command to run after the VM has been resumed (leave empty to disable)")
+	shutdownCmd := flag.String("shutdown-cmd", "poweroff", "Command to run to shut down the VM when the host asks for it (leave empty to disable)")
 
 	flag.Parse()
 
@@ -55,6 +56,28 @@ func main() {
 		cancel()
 	}()
 
+	var shutdown func(ctx context.Context) error
+	if strings.TrimSpace(*shutdownCmd) != "" {
+		shutdown = func(ctx context.Context) error {
+			log.Println("Running shutdown command")
+
+			// We don't wait for the command to exit since shutting down stops the agent, which would prevent it from replying
+			cmd := exec.Command(*shellCmd, "-c", *shutdownCmd)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+
+			if err := cmd.Start(); err != nil {
+				return err
+			}
+
+			go func() {
+				_ = cmd.Wait() // We ignore errors here since the command's output is already logged
+			}()
+
+			return nil
+		}
+	}
+
 	agentClient := ipc.NewAgentClient[struct{}](
 		struct{}{},
 
@@ -88,6 +111,16 @@ func main() {
 
 			return nil
 		},
+		shutdown,
+		func(ctx context.Context, command string) error {
+			log.Println("Running probe command")
+
+			cmd := exec.CommandContext(ctx, *shellCmd, "-c", command)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+
+			return cmd.Run()
+		},
 	)
 
 	for {
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/loopholelabs/drafter/pkg/firecrackertest"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		done := make(chan os.Signal, 1)
+		signal.Notify(done, os.Interrupt, syscall.SIGTERM)
+
+		<-done
+
+		log.Println("Exiting gracefully")
+
+		cancel()
+	}()
+
+	if err := firecrackertest.RunFirecracker(
+		ctx,
+
+		os.Args[1:],
+		os.Stdout,
+
+		firecrackertest.ServerHooks{
+			OnRequestReceived: func(method, resource string) {
+				log.Println("Received request", method, resource)
+			},
+		},
+	); err != nil {
+		panic(err)
+	}
+}
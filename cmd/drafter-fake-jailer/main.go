@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+
+	"github.com/loopholelabs/drafter/pkg/firecrackertest"
+)
+
+func main() {
+	if err := firecrackertest.RunJailer(os.Args[1:]); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/loopholelabs/drafter/pkg/snapshotter"
+	"github.com/loopholelabs/goroutine-manager/pkg/manager"
+)
+
+func main() {
+	basePath := flag.String("base", filepath.Join("out", "package", "memory.bin"), "Path to the memory file that the VM was resumed from")
+	rawDiffPaths := flag.String("diffs", "[]", "Paths to the memory files of the checkpoints to merge, oldest first")
+	outputPath := flag.String("output", filepath.Join("out", "merged", "memory.bin"), "Path to write the merged memory file to (can be the same as --base to merge in place)")
+
+	flag.Parse()
+
+	var diffPaths []string
+	if err := json.Unmarshal([]byte(*rawDiffPaths), &diffPaths); err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var errs error
+	defer func() {
+		if errs != nil {
+			panic(errs)
+		}
+	}()
+
+	goroutineManager := manager.NewGoroutineManager(
+		ctx,
+		&errs,
+		manager.GoroutineManagerHooks{},
+	)
+	defer goroutineManager.Wait()
+	defer goroutineManager.StopAllGoroutines()
+	defer goroutineManager.CreateBackgroundPanicCollector()()
+
+	go func() {
+		done := make(chan os.Signal, 1)
+		signal.Notify(done, os.Interrupt)
+
+		<-done
+
+		log.Println("Exiting gracefully")
+
+		cancel()
+	}()
+
+	if err := os.MkdirAll(filepath.Dir(*outputPath), os.ModePerm); err != nil {
+		panic(err)
+	}
+
+	if err := snapshotter.MergeDiffSnapshots(
+		goroutineManager.Context(),
+
+		*basePath,
+		diffPaths,
+
+		*outputPath,
+
+		snapshotter.MergeDiffSnapshotsHooks{
+			OnBeforeMergeDiffSnapshot: func(path string) {
+				log.Println("Merging checkpoint", path)
+			},
+		},
+	); err != nil {
+		panic(err)
+	}
+
+	log.Println("Merged", len(diffPaths), "checkpoints into", *outputPath)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"log"
 	"os"
@@ -18,11 +19,20 @@ func main() {
 	namespaceVethCIDR := flag.String("namespace-veth-cidr", "10.0.15.0/24", "CIDR for the veths inside the namespace")
 	blockedSubnetCIDR := flag.String("blocked-subnet-cidr", "10.0.15.0/24", "CIDR to block for the namespace")
 
-	namespaceInterface := flag.String("namespace-interface", "tap0", "Name for the interface inside the namespace")
-	namespaceInterfaceGateway := flag.String("namespace-interface-gateway", "172.16.0.1", "Gateway for the interface inside the namespace")
-	namespaceInterfaceNetmask := flag.Uint("namespace-interface-netmask", 30, "Netmask for the interface inside the namespace")
-	namespaceInterfaceIP := flag.String("namespace-interface-ip", "172.16.0.2", "IP for the interface inside the namespace")
-	namespaceInterfaceMAC := flag.String("namespace-interface-mac", "02:0e:d9:fd:68:3d", "MAC address for the interface inside the namespace")
+	defaultNamespaceInterfaces, err := json.Marshal([]nat.NamespaceInterface{
+		{
+			Interface: "tap0",
+			Gateway:   "172.16.0.1",
+			Netmask:   30,
+			IP:        "172.16.0.2",
+			MAC:       "02:0e:d9:fd:68:3d",
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	rawNamespaceInterfaces := flag.String("namespace-interfaces", string(defaultNamespaceInterfaces), "Interfaces to create inside each namespace (incoming traffic is forwarded to the first one)")
 
 	namespacePrefix := flag.String("namespace-prefix", "ark", "Prefix for the namespace IDs")
 
@@ -33,6 +43,11 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	var namespaceInterfaces []nat.NamespaceInterface
+	if err := json.Unmarshal([]byte(*rawNamespaceInterfaces), &namespaceInterfaces); err != nil {
+		panic(err)
+	}
+
 	var errs error
 	defer func() {
 		if errs != nil {
@@ -71,11 +86,7 @@ func main() {
 			NamespaceVethCIDR: *namespaceVethCIDR,
 			BlockedSubnetCIDR: *blockedSubnetCIDR,
 
-			NamespaceInterface:        *namespaceInterface,
-			NamespaceInterfaceGateway: *namespaceInterfaceGateway,
-			NamespaceInterfaceNetmask: uint32(*namespaceInterfaceNetmask),
-			NamespaceInterfaceIP:      *namespaceInterfaceIP,
-			NamespaceInterfaceMAC:     *namespaceInterfaceMAC,
+			NamespaceInterfaces: namespaceInterfaces,
 
 			NamespacePrefix: *namespacePrefix,
 
@@ -2,17 +2,28 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
 	"flag"
 	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/loopholelabs/drafter/pkg/packager"
+	"github.com/loopholelabs/drafter/pkg/snapshotter"
 	"github.com/loopholelabs/goroutine-manager/pkg/manager"
 )
 
+const (
+	// Package path that stands for stdout when archiving and stdin when extracting
+	stdioPackagePath = "-"
+
+	progressInterval = time.Second
+)
+
 func main() {
 	defaultDevices, err := json.Marshal([]packager.PackagerDevice{
 		{
@@ -49,9 +60,44 @@ func main() {
 
 	rawDevices := flag.String("devices", string(defaultDevices), "Devices configuration")
 
-	packagePath := flag.String("package-path", filepath.Join("out", "app.tar.zst"), "Path to package file")
+	packagePath := flag.String("package-path", filepath.Join("out", "app.tar.zst"), "Path to package file (- to write the package to stdout when archiving or read it from stdin when extracting)")
 
 	extract := flag.Bool("extract", false, "Whether to extract or archive")
+	signingKeyPath := flag.String("signing-key", "", "Path to a PEM-encoded ed25519 private key to sign the package manifest with when archiving (if empty, the manifest isn't signed)")
+	verificationKeyPath := flag.String("verification-key", "", "Path to a PEM-encoded ed25519 public key to verify the package manifest with when extracting (if empty, the signature isn't verified)")
+	manifestBlockSize := flag.Uint("manifest-block-size", packager.DefaultManifestBlockSize, "Block size to compute the Merkle trees in the package manifest with when archiving")
+
+	seekable := flag.Bool("seekable", false, "Whether to create a seekable package, whose devices can be served by the registry or read by the peer without extracting them")
+	seekableFrameSize := flag.Int("seekable-frame-size", packager.DefaultSeekableFrameSize, "Amount of uncompressed data in each independently compressed frame of a seekable package (in bytes)")
+
+	noCompression := flag.Bool("no-compression", false, "Whether to archive without compression, e.g. for devices that are already compressed")
+	compressionLevel := flag.Int("compression-level", 0, "zstd compression level from 1 (fastest) to 22 (smallest) to archive with (0 uses zstd's default level)")
+	compressionConcurrency := flag.Int("compression-concurrency", 0, "Number of goroutines to compress with in parallel (0 uses GOMAXPROCS)")
+	compressionWindowSize := flag.Int("compression-window-size", 0, "zstd window size to archive with (in bytes; a power of two between 1 KiB and 512 MiB; 0 uses the default for the level)")
+	compressionDictionaryPath := flag.String("compression-dictionary", "", "Path to a zstd dictionary (e.g. created with zstd --train) to archive with; it is stored in the package")
+
+	noSparse := flag.Bool("no-sparse", false, "Whether to archive devices that contain blocks of zeros as regular instead of sparse entries")
+
+	chunkStorePath := flag.String("chunk-store", "", "Path to a chunk store; if set, a chunked package that references the chunks in the store is created when archiving, and chunked packages are extracted from it")
+	deltaBasePath := flag.String("delta-base", "", "Path to the previous version of a chunked package; if set, a delta from it to --package-path is written to --delta-path instead of archiving or extracting")
+	deltaPath := flag.String("delta-path", filepath.Join("out", "app.delta.tar.zst"), "Path to the delta to create or apply")
+	applyDelta := flag.Bool("apply-delta", false, "Whether to add the chunks from --delta-path to --chunk-store and write the chunked package it contains to --package-path")
+
+	ociLayoutPath := flag.String("oci-layout", "", "Path to an OCI image layout directory; if set, the package is stored in or extracted from it as an OCI artifact instead of --package-path")
+	ociTag := flag.String("oci-tag", packager.DefaultOCITag, "Tag of the package in --oci-layout")
+	ociPush := flag.String("oci-push", "", "Reference (e.g. ghcr.io/loopholelabs/drafter-oci:latest) to push the package tagged with --oci-tag in --oci-layout to instead of archiving or extracting")
+	ociPull := flag.String("oci-pull", "", "Reference to pull a package from into --oci-layout (tagged with --oci-tag) instead of archiving or extracting")
+	ociInsecure := flag.Bool("oci-insecure", false, "Whether to connect to the OCI registry over plain HTTP")
+	ociUsername := flag.String("oci-username", "", "Username for the OCI registry (if empty, requests are anonymous)")
+	ociPassword := flag.String("oci-password", "", "Password or token for the OCI registry")
+
+	inspect := flag.Bool("inspect", false, "Whether to print the entries, sizes, compression ratio, manifest and config of --package-path as JSON instead of archiving or extracting")
+	diffBase := flag.String("diff-base", "", "Path to a package to compare --package-path to; if set, the ranges that changed in each device are printed as JSON instead of archiving or extracting")
+	rawDiffBaseDevices := flag.String("diff-base-devices", "", "Devices configuration to compare --devices to; if set, the ranges that changed in each device are printed as JSON instead of archiving or extracting")
+	diffBlockSize := flag.Int64("diff-block-size", packager.DefaultDiffBlockSize, "Block size to compare devices with when diffing (in bytes)")
+
+	extractWriteBufferSize := flag.Int64("extract-write-buffer-size", 0, "Maximum amount of decompressed data to buffer while writing extracted devices to disk in parallel (in bytes; 0 disables parallel writes)")
+	decompressionConcurrency := flag.Int("decompression-concurrency", 0, "Number of goroutines to decompress with in parallel when extracting (0 uses zstd's default)")
 
 	flag.Parse()
 
@@ -90,37 +136,405 @@ func main() {
 		cancel()
 	}()
 
-	if *extract {
-		if err := packager.ExtractPackage(
+	var (
+		processedChunks, reusedChunks int
+		processedBytes, reusedBytes   int64
+	)
+	onChunkProcessed := func(id string, size int64, reused bool) {
+		processedChunks++
+		processedBytes += size
+
+		if reused {
+			reusedChunks++
+			reusedBytes += size
+		}
+	}
+
+	// We only log progress once per interval since it is reported for every read
+	var lastProgress time.Time
+	onProgress := func(deviceBytes, packageBytes int64) {
+		if time.Since(lastProgress) < progressInterval {
+			return
+		}
+		lastProgress = time.Now()
+
+		log.Printf("Processed %v bytes of devices and %v bytes of package", deviceBytes, packageBytes)
+	}
+
+	var (
+		processedBlobs, reusedBlobs int
+		transferredBytes            int64
+	)
+	ociHooks := packager.PackagerHooks{
+		OnBlobProcessed: func(digest string, size int64, reused bool) {
+			processedBlobs++
+
+			if reused {
+				reusedBlobs++
+			} else {
+				transferredBytes += size
+			}
+		},
+	}
+	ociRegistryOptions := packager.OCIRegistryOptions{
+		Insecure: *ociInsecure,
+		Username: *ociUsername,
+		Password: *ociPassword,
+	}
+
+	if *inspect {
+		info, err := packager.InspectPackage(
 			goroutineManager.Context(),
 
 			*packagePath,
-			devices,
+
+			packager.ExtractOptions{
+				DecoderConcurrency: *decompressionConcurrency,
+
+				ChunkStorePath: *chunkStorePath,
+			},
+			packager.PackagerHooks{},
+		)
+		if err != nil {
+			panic(err)
+		}
+
+		var config *snapshotter.PackageConfiguration
+		if info.Config != nil {
+			config = &snapshotter.PackageConfiguration{}
+			if err := json.Unmarshal(info.Config, config); err != nil {
+				panic(err)
+			}
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		if err := encoder.Encode(struct {
+			*packager.PackageInfo
+
+			CompressionRatio float64                           `json:"compressionRatio"`
+			Config           *snapshotter.PackageConfiguration `json:"config,omitempty"`
+		}{
+			PackageInfo: info,
+
+			CompressionRatio: info.CompressionRatio(),
+			Config:           config,
+		}); err != nil {
+			panic(err)
+		}
+
+		return
+	}
+
+	if strings.TrimSpace(*diffBase) != "" || strings.TrimSpace(*rawDiffBaseDevices) != "" {
+		diffHooks := packager.PackagerHooks{
+			OnBeforeProcessFile: func(name, path string) {
+				log.Println("Hashing device", name, "from", path)
+			},
+		}
+
+		var diffs []packager.DeviceDiff
+		if strings.TrimSpace(*diffBase) != "" {
+			diffs, err = packager.DiffPackages(
+				goroutineManager.Context(),
+
+				*diffBase,
+				*packagePath,
+
+				*diffBlockSize,
+
+				packager.ExtractOptions{
+					DecoderConcurrency: *decompressionConcurrency,
+
+					ChunkStorePath: *chunkStorePath,
+				},
+				diffHooks,
+			)
+		} else {
+			var baseDevices []packager.PackagerDevice
+			if err := json.Unmarshal([]byte(*rawDiffBaseDevices), &baseDevices); err != nil {
+				panic(err)
+			}
+
+			diffs, err = packager.DiffDevices(
+				goroutineManager.Context(),
+
+				baseDevices,
+				devices,
+
+				*diffBlockSize,
+
+				diffHooks,
+			)
+		}
+		if err != nil {
+			panic(err)
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		if err := encoder.Encode(diffs); err != nil {
+			panic(err)
+		}
+
+		return
+	}
+
+	if strings.TrimSpace(*ociPush) != "" {
+		if err := packager.PushOCIPackage(
+			goroutineManager.Context(),
+
+			*ociLayoutPath,
+			*ociTag,
+
+			*ociPush,
+
+			ociRegistryOptions,
+			ociHooks,
+		); err != nil {
+			panic(err)
+		}
+
+		log.Printf("Pushed package to %v with %v blobs (%v bytes), of which %v were already in the registry", *ociPush, processedBlobs, transferredBytes, reusedBlobs)
+
+		return
+	}
+
+	if strings.TrimSpace(*ociPull) != "" {
+		if err := packager.PullOCIPackage(
+			goroutineManager.Context(),
+
+			*ociPull,
+
+			*ociLayoutPath,
+			*ociTag,
+
+			ociRegistryOptions,
+			ociHooks,
+		); err != nil {
+			panic(err)
+		}
+
+		log.Printf("Pulled package from %v with %v blobs (%v bytes), of which %v were already in the layout", *ociPull, processedBlobs, transferredBytes, reusedBlobs)
+
+		return
+	}
+
+	if *applyDelta {
+		if err := packager.ApplyDelta(
+			goroutineManager.Context(),
+
+			*deltaPath,
+			*chunkStorePath,
+
+			*packagePath,
 
 			packager.PackagerHooks{
-				OnBeforeProcessFile: func(name, path string) {
-					log.Println("Extracting device", name, "to", path)
+				OnUnknownFile: func(name string) {
+					log.Println("Skipping unknown file", name)
 				},
+				OnChunkProcessed: onChunkProcessed,
 			},
 		); err != nil {
 			panic(err)
 		}
 
+		log.Printf("Applied delta with %v chunks (%v bytes), of which %v were already in the chunk store", processedChunks, processedBytes, reusedChunks)
+
 		return
 	}
 
-	if err := packager.ArchivePackage(
-		goroutineManager.Context(),
+	if strings.TrimSpace(*deltaBasePath) != "" {
+		if err := packager.CreateDelta(
+			goroutineManager.Context(),
+
+			*deltaBasePath,
+			*packagePath,
+			*chunkStorePath,
+
+			*deltaPath,
+
+			packager.PackagerHooks{
+				OnBeforeProcessFile: func(name, path string) {
+					log.Println("Adding changed chunks of device", name, "to delta")
+				},
+				OnChunkProcessed: onChunkProcessed,
+			},
+		); err != nil {
+			panic(err)
+		}
+
+		log.Printf("Created delta with %v of %v chunks (%v of %v bytes)", processedChunks-reusedChunks, processedChunks, processedBytes-reusedBytes, processedBytes)
+
+		return
+	}
 
-		devices,
-		*packagePath,
+	if *extract {
+		var verificationKey ed25519.PublicKey
+		if strings.TrimSpace(*verificationKeyPath) != "" {
+			verificationKey, err = packager.ReadVerificationKey(*verificationKeyPath)
+			if err != nil {
+				panic(err)
+			}
+		}
 
-		packager.PackagerHooks{
+		extractOptions := packager.ExtractOptions{
+			WriteBufferSize:    *extractWriteBufferSize,
+			DecoderConcurrency: *decompressionConcurrency,
+			VerificationKey:    verificationKey,
+			ChunkStorePath:     *chunkStorePath,
+		}
+		extractHooks := packager.PackagerHooks{
 			OnBeforeProcessFile: func(name, path string) {
-				log.Println("Archiving device", name, "from", path)
+				log.Println("Extracting device", name, "to", path)
 			},
+			OnUnknownFile: func(name string) {
+				log.Println("Skipping unknown file", name)
+			},
+			OnProgress: onProgress,
+		}
+
+		if strings.TrimSpace(*ociLayoutPath) != "" {
+			if err := packager.ExtractOCIPackage(
+				goroutineManager.Context(),
+
+				*ociLayoutPath,
+				*ociTag,
+
+				devices,
+
+				extractOptions,
+				extractHooks,
+			); err != nil {
+				panic(err)
+			}
+
+			return
+		}
+
+		if *packagePath == stdioPackagePath {
+			if err := packager.ExtractPackageFromReader(
+				goroutineManager.Context(),
+
+				os.Stdin,
+				devices,
+
+				extractOptions,
+				extractHooks,
+			); err != nil {
+				panic(err)
+			}
+
+			return
+		}
+
+		if err := packager.ExtractPackage(
+			goroutineManager.Context(),
+
+			*packagePath,
+			devices,
+
+			extractOptions,
+			extractHooks,
+		); err != nil {
+			panic(err)
+		}
+
+		return
+	}
+
+	var signingKey ed25519.PrivateKey
+	if strings.TrimSpace(*signingKeyPath) != "" {
+		signingKey, err = packager.ReadSigningKey(*signingKeyPath)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var compressionDictionary []byte
+	if strings.TrimSpace(*compressionDictionaryPath) != "" {
+		compressionDictionary, err = os.ReadFile(*compressionDictionaryPath)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	archiveOptions := packager.ArchiveOptions{
+		ManifestBlockSize: uint32(*manifestBlockSize),
+		SigningKey:        signingKey,
+
+		Seekable:          *seekable,
+		SeekableFrameSize: *seekableFrameSize,
+
+		ChunkStorePath: *chunkStorePath,
+
+		Compression: packager.CompressionOptions{
+			Disabled: *noCompression,
+
+			Level:       *compressionLevel,
+			Concurrency: *compressionConcurrency,
+			WindowSize:  *compressionWindowSize,
+
+			Dictionary: compressionDictionary,
 		},
-	); err != nil {
-		panic(err)
+
+		DisableSparse: *noSparse,
+	}
+	archiveHooks := packager.PackagerHooks{
+		OnBeforeProcessFile: func(name, path string) {
+			log.Println("Archiving device", name, "from", path)
+		},
+		OnChunkProcessed: onChunkProcessed,
+		OnProgress:       onProgress,
+	}
+
+	if strings.TrimSpace(*ociLayoutPath) != "" {
+		if err := packager.ArchiveOCIPackage(
+			goroutineManager.Context(),
+
+			devices,
+
+			*ociLayoutPath,
+			*ociTag,
+
+			archiveOptions,
+			archiveHooks,
+		); err != nil {
+			panic(err)
+		}
+
+		return
+	}
+
+	if *packagePath == stdioPackagePath {
+		if err := packager.ArchivePackageToWriter(
+			goroutineManager.Context(),
+
+			devices,
+			os.Stdout,
+
+			archiveOptions,
+			archiveHooks,
+		); err != nil {
+			panic(err)
+		}
+	} else {
+		if err := packager.ArchivePackage(
+			goroutineManager.Context(),
+
+			devices,
+			*packagePath,
+
+			archiveOptions,
+			archiveHooks,
+		); err != nil {
+			panic(err)
+		}
+	}
+
+	if processedChunks > 0 {
+		log.Printf("Archived %v chunks (%v bytes), of which %v (%v bytes) were already in the chunk store", processedChunks, processedBytes, reusedChunks, reusedBytes)
 	}
 }
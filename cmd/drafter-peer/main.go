@@ -14,12 +14,16 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 	"bytes"
 	"fmt"
 	"net/http"
 	"crypto/tls"
 
+	"github.com/loopholelabs/drafter/pkg/console"
+	"github.com/loopholelabs/drafter/pkg/cpuset"
+	"github.com/loopholelabs/drafter/pkg/hypervisor"
 	"github.com/loopholelabs/drafter/pkg/ipc"
 	"github.com/loopholelabs/drafter/pkg/mounter"
 	"github.com/loopholelabs/drafter/pkg/packager"
@@ -35,6 +39,7 @@ type CompositeDevices struct {
 	Base    string `json:"base"`
 	Overlay string `json:"overlay"`
 	State   string `json:"state"`
+	Package string `json:"package"`
 
 	BlockSize uint32 `json:"blockSize"`
 
@@ -64,11 +69,21 @@ func main() {
 
 	resumeTimeout := flag.Duration("resume-timeout", time.Minute, "Maximum amount of time to wait for agent and liveness to resume")
 	rescueTimeout := flag.Duration("rescue-timeout", time.Minute, "Maximum amount of time to wait for rescue operations")
+	shutdownGrace := flag.Duration("shutdown-grace", time.Second*30, "Maximum amount of time to wait for the VM to shut down on SIGTERM before killing it")
 
 	netns := flag.String("netns", "ark0", "Network namespace to run Firecracker in")
 
-	numaNode := flag.Int("numa-node", 0, "NUMA node to run Firecracker in")
+	rawNUMANode := flag.String("numa-node", "0", "NUMA node to run Firecracker in (auto or -1 to use the NUMA node with the most free memory, or the node that contains all of --cpus)")
+	rawCPUs := flag.String("cpus", "", "CPUs to run Firecracker on in the kernel's CPU list format (e.g. 0-3,8) (leave empty to use all CPUs of the NUMA node)")
+	pinVCPUs := flag.Bool("pin-vcpus", false, "Whether to pin each vCPU to one of Firecracker's CPUs")
+	allocateCPUs := flag.Int("allocate-cpus", 0, "Number of CPUs to exclusively allocate to Firecracker from the CPUs that aren't used by other runners or peers on the host (0 to disable) (overrides --cpus)")
+	cpuAllocatorState := flag.String("cpu-allocator-state", filepath.Join("/run", "drafter", "cpus.json"), "Path to the state file of the host's CPU allocator (must be the same for all runners and peers on the host) (ignored unless --allocate-cpus is set)")
 	cgroupVersion := flag.Int("cgroup-version", 2, "Cgroup version to use for Jailer")
+	rawCgroups := flag.String("cgroups", "{}", `Additional cgroup settings for Firecracker (e.g. {"cpu.max": "50000 100000", "memory.max": "1073741824"}) (overrides the cpuset derived from --numa-node and --cpus)`)
+	parentCgroup := flag.String("parent-cgroup", "", "Cgroup to create Firecracker's cgroup in (leave empty to use the Jailer's default)")
+	rawResourceLimits := flag.String("resource-limits", "{}", `Resource limits for Firecracker (e.g. {"no-file": 2048, "fsize": 1073741824})`)
+
+	skipCompatibilityCheck := flag.Bool("skip-compatibility-check", false, "Whether to resume the VM without checking that this host is compatible with the package (e.g. its architecture, CPU features and Firecracker version)")
 
 	experimentalMapPrivate := flag.Bool("experimental-map-private", false, "(Experimental) Whether to use MAP_PRIVATE for memory and state devices")
 	experimentalMapPrivateStateOutput := flag.String("experimental-map-private-state-output", "", "(Experimental) Path to write the local changes to the shared state to (leave empty to write back to device directly) (ignored unless --experimental-map-private)")
@@ -203,6 +218,14 @@ func main() {
 		panic(err)
 	}
 
+	metricsInterval := flag.Duration("metrics-interval", 0, "Interval in which to log the VM's metrics (0 to disable)")
+
+	consoleSocket := flag.String("console-socket", "", "Path to a UNIX socket to attach to the VM's console on (leave empty to disable) (overrides --enable-input)")
+	consoleHistorySize := flag.Int("console-history-size", 64*1024, "Number of bytes of console output to replay when attaching (ignored unless --console-socket or --console-log is set)")
+	consoleLog := flag.String("console-log", "", "Path of a file to write the VM's console output to (leave empty to disable) (overrides --enable-input)")
+	consoleLogMaxSize := flag.Int64("console-log-max-size", 10*1024*1024, "Size (in bytes) after which the console log is rotated (0 to disable rotation)")
+	consoleLogMaxFiles := flag.Int("console-log-max-files", 5, "Number of rotated console logs to keep")
+
 	rawDevices := flag.String("devices", string(defaultDevices), "Devices configuration")
 
 	raddr := flag.String("raddr", "localhost:1337", "Remote address to connect to (leave empty to disable)")
@@ -210,6 +233,14 @@ func main() {
 
 	concurrency := flag.Int("concurrency", 1024, "Number of concurrent workers to use in migrations")
 
+	balloonInflateBeforeMigration := flag.Bool("balloon-inflate-before-migration", false, "Whether to inflate the balloon before migrating so that the guest releases its free memory (requires a VM package with a balloon device)")
+	balloonInflateAmount := flag.Int("balloon-inflate-amount", 0, "Size (in MiB) to inflate the balloon to before migrating (0 to use the guest's free memory) (ignored unless --balloon-inflate-before-migration)")
+	balloonInflateTimeout := flag.Duration("balloon-inflate-timeout", time.Second*10, "Maximum amount of time to wait for the guest to inflate the balloon (ignored unless --balloon-inflate-before-migration)")
+	balloonSkipZeroBlocks := flag.Bool("balloon-skip-zero-blocks", false, "Whether to skip memory blocks that only contain zeros (such as free pages that the guest zeroed) in the initial migration pass (always enabled with --balloon-inflate-before-migration)")
+	balloonDeflateAfterResume := flag.Bool("balloon-deflate-after-resume", false, "Whether to deflate the balloon after resuming (requires a VM package with a balloon device)")
+
+	mmdsData := flag.String("mmds-data", "", "JSON object to set as the VM's metadata document after resuming (requires a VM package with MMDS enabled) (leave empty to disable)")
+
 	flag.Parse()
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -220,6 +251,33 @@ func main() {
 		panic(err)
 	}
 
+	var cgroups map[string]string
+	if err := json.Unmarshal([]byte(*rawCgroups), &cgroups); err != nil {
+		panic(err)
+	}
+
+	var resourceLimits map[string]uint64
+	if err := json.Unmarshal([]byte(*rawResourceLimits), &resourceLimits); err != nil {
+		panic(err)
+	}
+
+	numaNode, err := cpuset.ParseNUMANode(*rawNUMANode)
+	if err != nil {
+		panic(err)
+	}
+
+	cpus, err := cpuset.ParseList(*rawCPUs)
+	if err != nil {
+		panic(err)
+	}
+
+	var mmds map[string]any
+	if strings.TrimSpace(*mmdsData) != "" {
+		if err := json.Unmarshal([]byte(*mmdsData), &mmds); err != nil {
+			panic(err)
+		}
+	}
+
 	var errs error
 	defer func() {
 		if errs != nil {
@@ -240,7 +298,7 @@ func main() {
 
 	done := make(chan os.Signal, 1)
 	go func() {
-		signal.Notify(done, os.Interrupt)
+		signal.Notify(done, os.Interrupt, syscall.SIGTERM)
 
 		v := <-done
 
@@ -282,6 +340,66 @@ func main() {
 		writers = []io.Writer{conn}
 	}
 
+	var vmConsole *console.Console
+	if strings.TrimSpace(*consoleSocket) != "" || strings.TrimSpace(*consoleLog) != "" {
+		vmConsole, err = console.NewConsole(console.ConsoleConfiguration{
+			HistorySize: *consoleHistorySize,
+
+			LogPath:     *consoleLog,
+			LogMaxSize:  *consoleLogMaxSize,
+			LogMaxFiles: *consoleLogMaxFiles,
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		defer func() {
+			defer goroutineManager.CreateForegroundPanicCollector()()
+
+			if err := vmConsole.Close(); err != nil {
+				panic(err)
+			}
+		}()
+	}
+
+	if *allocateCPUs > 0 {
+		cpuAllocator := cpuset.NewAllocator(*cpuAllocatorState)
+
+		allocation, err := cpuAllocator.Allocate(*allocateCPUs, numaNode)
+		if err != nil {
+			panic(err)
+		}
+
+		defer func() {
+			defer goroutineManager.CreateForegroundPanicCollector()()
+
+			if err := cpuAllocator.Release(allocation); err != nil {
+				panic(err)
+			}
+		}()
+
+		cpus = allocation.CPUs
+
+		log.Println("Allocated CPUs", cpuset.FormatList(allocation.CPUs), "on NUMA node", allocation.NUMANode)
+	}
+
+	var monitoringHooks hypervisor.MonitoringHooks
+	if *metricsInterval > 0 {
+		monitoringHooks.OnMetrics = func(metrics hypervisor.Metrics) {
+			p, err := json.Marshal(metrics)
+			if err != nil {
+				log.Println("Could not marshal metrics:", err)
+
+				return
+			}
+
+			log.Println("Metrics:", string(p))
+		}
+		monitoringHooks.OnError = func(err error) {
+			log.Println("Stopped reporting metrics:", err)
+		}
+	}
+
 	p, err := peer.StartPeer[struct{}, ipc.AgentServerRemote[struct{}]](
 		goroutineManager.Context(),
 		context.Background(), // Never give up on rescue operations
@@ -296,15 +414,26 @@ func main() {
 			GID: *gid,
 
 			NetNS:         *netns,
-			NumaNode:      *numaNode,
+			NumaNode:      numaNode,
+			CPUs:          cpus,
+			PinVCPUs:      *pinVCPUs,
 			CgroupVersion: *cgroupVersion,
 
+			Cgroups:        cgroups,
+			ParentCgroup:   *parentCgroup,
+			ResourceLimits: resourceLimits,
+
 			EnableOutput: *enableOutput,
 			EnableInput:  *enableInput,
 		},
+		snapshotter.HypervisorRuntime{
+			Console: vmConsole,
+		},
 
 		packager.StateName,
 		packager.MemoryName,
+
+		monitoringHooks,
 	)
 
 	defer func() {
@@ -333,6 +462,49 @@ func main() {
 		}
 	})
 
+	if strings.TrimSpace(*consoleSocket) != "" {
+		consoleServer, err := console.StartConsoleServer(*consoleSocket, vmConsole)
+		if err != nil {
+			panic(err)
+		}
+
+		defer func() {
+			defer goroutineManager.CreateForegroundPanicCollector()()
+
+			if err := consoleServer.Close(); err != nil {
+				panic(err)
+			}
+		}()
+
+		log.Println("Attach to the VM's console on", *consoleSocket, "(detach with CTRL-])")
+	}
+
+	if p.CgroupPath != "" {
+		log.Println("Running Firecracker in cgroup", p.CgroupPath)
+	}
+
+	if *metricsInterval > 0 {
+		goroutineManager.StartForegroundGoroutine(func(_ context.Context) {
+			ticker := time.NewTicker(*metricsInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-goroutineManager.Context().Done():
+					return
+
+				case <-ticker.C:
+					break
+				}
+
+				// We only log errors here since the VM might be suspended or migrated away while we flush
+				if err := p.FlushMetrics(goroutineManager.Context()); err != nil {
+					log.Println("Could not flush metrics:", err)
+				}
+			}
+		})
+	}
+
 	migrateFromDevices := []peer.MigrateFromDevice[struct{}, ipc.AgentServerRemote[struct{}], struct{}]{}
 	for _, device := range devices {
 		migrateFromDevices = append(migrateFromDevices, peer.MigrateFromDevice[struct{}, ipc.AgentServerRemote[struct{}], struct{}]{
@@ -341,6 +513,7 @@ func main() {
 			Base:    device.Base,
 			Overlay: device.Overlay,
 			State:   device.State,
+			Package: device.Package,
 
 			BlockSize: device.BlockSize,
 
@@ -432,6 +605,8 @@ func main() {
 
 			ExperimentalMapPrivateStateOutput:  *experimentalMapPrivateStateOutput,
 			ExperimentalMapPrivateMemoryOutput: *experimentalMapPrivateMemoryOutput,
+
+			SkipCompatibilityCheck: *skipCompatibilityCheck,
 		},
 	)
 
@@ -455,6 +630,22 @@ func main() {
 
 	log.Println("Resumed VM in", time.Since(before), "on", p.VMPath)
 
+	if *balloonDeflateAfterResume {
+		if err := resumedPeer.UpdateBalloon(goroutineManager.Context(), 0); err != nil {
+			panic(err)
+		}
+
+		log.Println("Deflated balloon")
+	}
+
+	if mmds != nil {
+		if err := resumedPeer.PutMMDS(goroutineManager.Context(), mmds); err != nil {
+			panic(err)
+		}
+
+		log.Println("Set MMDS data")
+	}
+
 
 
 
@@ -519,14 +710,23 @@ func main() {
 		case <-goroutineManager.Context().Done():
 			return
 
-		case <-done:
+		case sig := <-done:
 			before = time.Now()
 
-			if err := resumedPeer.SuspendAndCloseAgentServer(goroutineManager.Context(), *resumeTimeout); err != nil {
-				panic(err)
-			}
+			// On SIGTERM we shut down the VM instead of writing its state back to the devices
+			if sig == syscall.SIGTERM {
+				if err := resumedPeer.Shutdown(goroutineManager.Context(), *shutdownGrace); err != nil {
+					panic(err)
+				}
 
-			log.Println("Suspend:", time.Since(before))
+				log.Println("Shutdown:", time.Since(before))
+			} else {
+				if err := resumedPeer.SuspendAndCloseAgentServer(goroutineManager.Context(), *resumeTimeout); err != nil {
+					panic(err)
+				}
+
+				log.Println("Suspend:", time.Since(before))
+			}
 
 			log.Println("Shutting down")
 
@@ -592,14 +792,23 @@ func main() {
 	case <-goroutineManager.Context().Done():
 		return
 
-	case <-done:
+	case sig := <-done:
 		before = time.Now()
 
-		if err := resumedPeer.SuspendAndCloseAgentServer(goroutineManager.Context(), *resumeTimeout); err != nil {
-			panic(err)
-		}
+		// On SIGTERM we shut down the VM instead of writing its state back to the devices
+		if sig == syscall.SIGTERM {
+			if err := resumedPeer.Shutdown(goroutineManager.Context(), *shutdownGrace); err != nil {
+				panic(err)
+			}
+
+			log.Println("Shutdown:", time.Since(before))
+		} else {
+			if err := resumedPeer.SuspendAndCloseAgentServer(goroutineManager.Context(), *resumeTimeout); err != nil {
+				panic(err)
+			}
 
-		log.Println("Suspend:", time.Since(before))
+			log.Println("Suspend:", time.Since(before))
+		}
 
 		log.Println("Shutting down")
 
@@ -664,10 +873,32 @@ func main() {
 		*resumeTimeout,
 		*concurrency,
 
+		peer.MigrateToBalloonConfiguration{
+			InflateBeforeMigration: *balloonInflateBeforeMigration,
+			AmountMiB:              *balloonInflateAmount,
+			InflateTimeout:         *balloonInflateTimeout,
+			SkipZeroBlocks:         *balloonSkipZeroBlocks,
+		},
+
 		[]io.Reader{conn},
 		[]io.Writer{conn},
 
 		peer.MigrateToHooks{
+			OnBeforeInflateBalloon: func(amountMiB int) {
+				log.Println("Inflating balloon to", amountMiB, "MiB")
+			},
+			OnAfterInflateBalloon: func(actualMiB int) {
+				log.Println("Inflated balloon to", actualMiB, "MiB")
+			},
+
+			OnDeviceZeroBlocksSkipped: func(deviceID uint32, remote bool, blocks int) {
+				if remote {
+					log.Println("Skipped", blocks, "zero blocks for remote device", deviceID)
+				} else {
+					log.Println("Skipped", blocks, "zero blocks for local device", deviceID)
+				}
+			},
+
 			OnBeforeGetDirtyBlocks: func(deviceID uint32, remote bool) {
 				if remote {
 					log.Println("Getting dirty blocks for remote device", deviceID)
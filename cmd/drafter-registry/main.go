@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 
 	"github.com/loopholelabs/drafter/pkg/packager"
 	"github.com/loopholelabs/drafter/pkg/registry"
@@ -63,6 +65,10 @@ func main() {
 
 	concurrency := flag.Int("concurrency", 1024, "Number of concurrent workers to use in migrations")
 
+	manifestPath := flag.String("manifest", "", "Path to the package manifest to verify devices that are read from files against before serving them (if empty, they aren't verified; devices from seekable packages are always verified against the manifest in their package)")
+	manifestSignaturePath := flag.String("manifest-signature", "", "Path to the signature of the package manifest")
+	verificationKeyPath := flag.String("verification-key", "", "Path to a PEM-encoded ed25519 public key to verify the manifest signatures with (if empty, signatures aren't verified)")
+
 	flag.Parse()
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -73,6 +79,24 @@ func main() {
 		panic(err)
 	}
 
+	var verificationKey ed25519.PublicKey
+	if strings.TrimSpace(*verificationKeyPath) != "" {
+		verificationKey, err = packager.ReadVerificationKey(*verificationKeyPath)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var manifest *packager.Manifest
+	if strings.TrimSpace(*manifestPath) != "" {
+		manifest, err = packager.ReadManifest(*manifestPath, *manifestSignaturePath, verificationKey)
+		if err != nil {
+			panic(err)
+		}
+
+		log.Println("Verifying devices against manifest", *manifestPath)
+	}
+
 	lis, err := net.Listen("tcp", *laddr)
 	if err != nil {
 		panic(err)
@@ -146,9 +170,13 @@ l:
 				}
 			}()
 
-			openedDevices, defers, err := registry.OpenDevices(
+			openedDevices, defers, err := registry.OpenDevicesWithOptions(
 				devices,
 
+				registry.OpenDevicesOptions{
+					Manifest:        manifest,
+					VerificationKey: verificationKey,
+				},
 				registry.OpenDevicesHooks{
 					OnDeviceOpened: func(deviceID uint32, name string) {
 						log.Println("Opened device", deviceID, "with name", name)
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/exec"
@@ -13,6 +14,9 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/loopholelabs/drafter/pkg/console"
+	"github.com/loopholelabs/drafter/pkg/cpuset"
+	"github.com/loopholelabs/drafter/pkg/hypervisor"
 	"github.com/loopholelabs/drafter/pkg/ipc"
 	"github.com/loopholelabs/drafter/pkg/packager"
 	"github.com/loopholelabs/drafter/pkg/peer"
@@ -27,6 +31,15 @@ type SharableDevice struct {
 	Name   string `json:"name"`
 	Path   string `json:"path"`
 	Shared bool   `json:"shared"`
+
+	RateLimiter *hypervisor.RateLimiter `json:"rateLimiter"`
+}
+
+type NetworkInterfaceRateLimiters struct {
+	Interface string `json:"interface"`
+
+	RxRateLimiter *hypervisor.RateLimiter `json:"rxRateLimiter"`
+	TxRateLimiter *hypervisor.RateLimiter `json:"txRateLimiter"`
 }
 
 func main() {
@@ -82,17 +95,40 @@ func main() {
 
 	resumeTimeout := flag.Duration("resume-timeout", time.Minute, "Maximum amount of time to wait for agent and liveness to resume")
 	rescueTimeout := flag.Duration("rescue-timeout", time.Second*5, "Maximum amount of time to wait for rescue operations")
+	shutdownGrace := flag.Duration("shutdown-grace", time.Second*30, "Maximum amount of time to wait for the VM to shut down on SIGTERM before killing it")
 
 	netns := flag.String("netns", "ark0", "Network namespace to run Firecracker in")
 
-	numaNode := flag.Int("numa-node", 0, "NUMA node to run Firecracker in")
+	rawNUMANode := flag.String("numa-node", "0", "NUMA node to run Firecracker in (auto or -1 to use the NUMA node with the most free memory, or the node that contains all of --cpus)")
+	rawCPUs := flag.String("cpus", "", "CPUs to run Firecracker on in the kernel's CPU list format (e.g. 0-3,8) (leave empty to use all CPUs of the NUMA node)")
+	pinVCPUs := flag.Bool("pin-vcpus", false, "Whether to pin each vCPU to one of Firecracker's CPUs")
+	allocateCPUs := flag.Int("allocate-cpus", 0, "Number of CPUs to exclusively allocate to Firecracker from the CPUs that aren't used by other runners or peers on the host (0 to disable) (overrides --cpus)")
+	cpuAllocatorState := flag.String("cpu-allocator-state", filepath.Join("/run", "drafter", "cpus.json"), "Path to the state file of the host's CPU allocator (must be the same for all runners and peers on the host) (ignored unless --allocate-cpus is set)")
 	cgroupVersion := flag.Int("cgroup-version", 2, "Cgroup version to use for Jailer")
+	rawCgroups := flag.String("cgroups", "{}", `Additional cgroup settings for Firecracker (e.g. {"cpu.max": "50000 100000", "memory.max": "1073741824"}) (overrides the cpuset derived from --numa-node and --cpus)`)
+	parentCgroup := flag.String("parent-cgroup", "", "Cgroup to create Firecracker's cgroup in (leave empty to use the Jailer's default)")
+	rawResourceLimits := flag.String("resource-limits", "{}", `Resource limits for Firecracker (e.g. {"no-file": 2048, "fsize": 1073741824})`)
+
+	skipCompatibilityCheck := flag.Bool("skip-compatibility-check", false, "Whether to resume the VM without checking that this host is compatible with the package (e.g. its architecture, CPU features and Firecracker version)")
 
 	experimentalMapPrivate := flag.Bool("experimental-map-private", false, "(Experimental) Whether to use MAP_PRIVATE for memory and state devices")
 	experimentalMapPrivateStateOutput := flag.String("experimental-map-private-state-output", "", "(Experimental) Path to write the local changes to the shared state to (leave empty to write back to device directly) (ignored unless --experimental-map-private)")
 	experimentalMapPrivateMemoryOutput := flag.String("experimental-map-private-memory-output", "", "(Experimental) Path to write the local changes to the shared memory to (leave empty to write back to device directly) (ignored unless --experimental-map-private)")
 
+	checkpointInterval := flag.Duration("checkpoint-interval", 0, "Interval in which to write incremental checkpoints of the VM (0 to disable)")
+	checkpointDir := flag.String("checkpoint-dir", filepath.Join("out", "checkpoints"), "Directory to write checkpoints to (must be on the same filesystem as --chroot-base-dir) (ignored unless --checkpoint-interval is set)")
+
+	metricsInterval := flag.Duration("metrics-interval", 0, "Interval in which to log the VM's metrics (0 to disable)")
+
+	consoleSocket := flag.String("console-socket", "", "Path to a UNIX socket to attach to the VM's console on (leave empty to disable) (overrides --enable-input)")
+	consoleHistorySize := flag.Int("console-history-size", 64*1024, "Number of bytes of console output to replay when attaching (ignored unless --console-socket or --console-log is set)")
+	consoleLog := flag.String("console-log", "", "Path of a file to write the VM's console output to (leave empty to disable) (overrides --enable-input)")
+	consoleLogMaxSize := flag.Int64("console-log-max-size", 10*1024*1024, "Size (in bytes) after which the console log is rotated (0 to disable rotation)")
+	consoleLogMaxFiles := flag.Int("console-log-max-files", 5, "Number of rotated console logs to keep")
+
 	rawDevices := flag.String("devices", string(defaultDevices), "Devices configuration")
+	rawNetworkRateLimiters := flag.String("network-rate-limiters", "[]", "Rate limiters to apply to the VM's network interfaces after resuming")
+	mmdsData := flag.String("mmds-data", "", "JSON object to set as the VM's metadata document after resuming (requires a VM package with MMDS enabled) (leave empty to disable)")
 
 	flag.Parse()
 
@@ -104,6 +140,38 @@ func main() {
 		panic(err)
 	}
 
+	var networkRateLimiters []NetworkInterfaceRateLimiters
+	if err := json.Unmarshal([]byte(*rawNetworkRateLimiters), &networkRateLimiters); err != nil {
+		panic(err)
+	}
+
+	var cgroups map[string]string
+	if err := json.Unmarshal([]byte(*rawCgroups), &cgroups); err != nil {
+		panic(err)
+	}
+
+	var resourceLimits map[string]uint64
+	if err := json.Unmarshal([]byte(*rawResourceLimits), &resourceLimits); err != nil {
+		panic(err)
+	}
+
+	numaNode, err := cpuset.ParseNUMANode(*rawNUMANode)
+	if err != nil {
+		panic(err)
+	}
+
+	cpus, err := cpuset.ParseList(*rawCPUs)
+	if err != nil {
+		panic(err)
+	}
+
+	var mmds map[string]any
+	if strings.TrimSpace(*mmdsData) != "" {
+		if err := json.Unmarshal([]byte(*mmdsData), &mmds); err != nil {
+			panic(err)
+		}
+	}
+
 	firecrackerBin, err := exec.LookPath(*rawFirecrackerBin)
 	if err != nil {
 		panic(err)
@@ -160,7 +228,7 @@ func main() {
 
 	done := make(chan os.Signal, 1)
 	go func() {
-		signal.Notify(done, os.Interrupt)
+		signal.Notify(done, os.Interrupt, syscall.SIGTERM)
 
 		v := <-done
 
@@ -175,6 +243,66 @@ func main() {
 		cancel()
 	}()
 
+	var vmConsole *console.Console
+	if strings.TrimSpace(*consoleSocket) != "" || strings.TrimSpace(*consoleLog) != "" {
+		vmConsole, err = console.NewConsole(console.ConsoleConfiguration{
+			HistorySize: *consoleHistorySize,
+
+			LogPath:     *consoleLog,
+			LogMaxSize:  *consoleLogMaxSize,
+			LogMaxFiles: *consoleLogMaxFiles,
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		defer func() {
+			defer goroutineManager.CreateForegroundPanicCollector()()
+
+			if err := vmConsole.Close(); err != nil {
+				panic(err)
+			}
+		}()
+	}
+
+	if *allocateCPUs > 0 {
+		cpuAllocator := cpuset.NewAllocator(*cpuAllocatorState)
+
+		allocation, err := cpuAllocator.Allocate(*allocateCPUs, numaNode)
+		if err != nil {
+			panic(err)
+		}
+
+		defer func() {
+			defer goroutineManager.CreateForegroundPanicCollector()()
+
+			if err := cpuAllocator.Release(allocation); err != nil {
+				panic(err)
+			}
+		}()
+
+		cpus = allocation.CPUs
+
+		log.Println("Allocated CPUs", cpuset.FormatList(allocation.CPUs), "on NUMA node", allocation.NUMANode)
+	}
+
+	var monitoringHooks hypervisor.MonitoringHooks
+	if *metricsInterval > 0 {
+		monitoringHooks.OnMetrics = func(metrics hypervisor.Metrics) {
+			p, err := json.Marshal(metrics)
+			if err != nil {
+				log.Println("Could not marshal metrics:", err)
+
+				return
+			}
+
+			log.Println("Metrics:", string(p))
+		}
+		monitoringHooks.OnError = func(err error) {
+			log.Println("Stopped reporting metrics:", err)
+		}
+	}
+
 	r, err := runner.StartRunner[struct{}, ipc.AgentServerRemote[struct{}]](
 		goroutineManager.Context(),
 		context.Background(), // Never give up on rescue operations
@@ -189,15 +317,26 @@ func main() {
 			GID: *gid,
 
 			NetNS:         *netns,
-			NumaNode:      *numaNode,
+			NumaNode:      numaNode,
+			CPUs:          cpus,
+			PinVCPUs:      *pinVCPUs,
 			CgroupVersion: *cgroupVersion,
 
+			Cgroups:        cgroups,
+			ParentCgroup:   *parentCgroup,
+			ResourceLimits: resourceLimits,
+
 			EnableOutput: *enableOutput,
 			EnableInput:  *enableInput,
 		},
+		snapshotter.HypervisorRuntime{
+			Console: vmConsole,
+		},
 
 		packager.StateName,
 		packager.MemoryName,
+
+		monitoringHooks,
 	)
 
 	defer func() {
@@ -226,6 +365,49 @@ func main() {
 		}
 	})
 
+	if strings.TrimSpace(*consoleSocket) != "" {
+		consoleServer, err := console.StartConsoleServer(*consoleSocket, vmConsole)
+		if err != nil {
+			panic(err)
+		}
+
+		defer func() {
+			defer goroutineManager.CreateForegroundPanicCollector()()
+
+			if err := consoleServer.Close(); err != nil {
+				panic(err)
+			}
+		}()
+
+		log.Println("Attach to the VM's console on", *consoleSocket, "(detach with CTRL-])")
+	}
+
+	if r.CgroupPath != "" {
+		log.Println("Running Firecracker in cgroup", r.CgroupPath)
+	}
+
+	if *metricsInterval > 0 {
+		goroutineManager.StartForegroundGoroutine(func(_ context.Context) {
+			ticker := time.NewTicker(*metricsInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-goroutineManager.Context().Done():
+					return
+
+				case <-ticker.C:
+					break
+				}
+
+				// We only log errors here since the VM might be suspended or migrated away while we flush
+				if err := r.FlushMetrics(goroutineManager.Context()); err != nil {
+					log.Println("Could not flush metrics:", err)
+				}
+			}
+		})
+	}
+
 	for index, device := range devices {
 		log.Println("Requested local device", index, "with name", device.Name)
 
@@ -312,6 +494,10 @@ func main() {
 
 			ExperimentalMapPrivateStateOutput:  *experimentalMapPrivateStateOutput,
 			ExperimentalMapPrivateMemoryOutput: *experimentalMapPrivateMemoryOutput,
+
+			TrackDirtyPages: *checkpointInterval > 0,
+
+			SkipCompatibilityCheck: *skipCompatibilityCheck,
 		},
 	)
 
@@ -335,23 +521,104 @@ func main() {
 
 	log.Println("Resumed VM in", time.Since(before), "on", r.VMPath)
 
+	for _, device := range devices {
+		if device.RateLimiter == nil {
+			continue
+		}
+
+		if err := resumedRunner.UpdateDriveRateLimiter(goroutineManager.Context(), device.Name, device.RateLimiter); err != nil {
+			panic(err)
+		}
+
+		log.Println("Updated rate limiter for device", device.Name)
+	}
+
+	for _, networkRateLimiter := range networkRateLimiters {
+		if err := resumedRunner.UpdateNetworkInterfaceRateLimiters(goroutineManager.Context(), networkRateLimiter.Interface, networkRateLimiter.RxRateLimiter, networkRateLimiter.TxRateLimiter); err != nil {
+			panic(err)
+		}
+
+		log.Println("Updated rate limiters for network interface", networkRateLimiter.Interface)
+	}
+
+	if mmds != nil {
+		if err := resumedRunner.PutMMDS(goroutineManager.Context(), mmds); err != nil {
+			panic(err)
+		}
+
+		log.Println("Set MMDS data")
+	}
+
+	// We need to stop checkpointing before suspending the VM
+	checkpointCtx, cancelCheckpointCtx := context.WithCancel(goroutineManager.Context())
+	defer cancelCheckpointCtx()
+
+	checkpointsDone := make(chan struct{})
+	if *checkpointInterval > 0 {
+		goroutineManager.StartForegroundGoroutine(func(_ context.Context) {
+			defer close(checkpointsDone)
+
+			ticker := time.NewTicker(*checkpointInterval)
+			defer ticker.Stop()
+
+			for i := 0; ; i++ {
+				select {
+				case <-checkpointCtx.Done():
+					return
+
+				case <-ticker.C:
+					break
+				}
+
+				var (
+					statePath  = filepath.Join(*checkpointDir, fmt.Sprintf("%v", i), "state.bin")
+					memoryPath = filepath.Join(*checkpointDir, fmt.Sprintf("%v", i), "memory.bin")
+				)
+				if err := resumedRunner.Checkpoint(checkpointCtx, statePath, memoryPath); err != nil {
+					if checkpointCtx.Err() != nil {
+						return
+					}
+
+					panic(err)
+				}
+
+				log.Println("Wrote checkpoint", i, "to", memoryPath)
+			}
+		})
+	} else {
+		close(checkpointsDone)
+	}
+
 	bubbleSignals = true
 
+	var sig os.Signal
 	select {
 	case <-goroutineManager.Context().Done():
 		return
 
-	case <-done:
+	case sig = <-done:
 		break
 	}
 
+	cancelCheckpointCtx()
+	<-checkpointsDone
+
 	before = time.Now()
 
-	if err := resumedRunner.SuspendAndCloseAgentServer(goroutineManager.Context(), *resumeTimeout); err != nil {
-		panic(err)
-	}
+	// On SIGTERM we shut down the VM instead of writing its state back to the devices
+	if sig == syscall.SIGTERM {
+		if err := resumedRunner.Shutdown(goroutineManager.Context(), *shutdownGrace); err != nil {
+			panic(err)
+		}
 
-	log.Println("Suspend:", time.Since(before))
+		log.Println("Shutdown:", time.Since(before))
+	} else {
+		if err := resumedRunner.SuspendAndCloseAgentServer(goroutineManager.Context(), *resumeTimeout); err != nil {
+			panic(err)
+		}
+
+		log.Println("Suspend:", time.Since(before))
+	}
 
 	log.Println("Shutting down")
 }
@@ -11,6 +11,8 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/loopholelabs/drafter/pkg/cpuset"
+	"github.com/loopholelabs/drafter/pkg/hypervisor"
 	"github.com/loopholelabs/drafter/pkg/packager"
 	"github.com/loopholelabs/drafter/pkg/snapshotter"
 	"github.com/loopholelabs/goroutine-manager/pkg/manager"
@@ -30,12 +32,19 @@ func main() {
 
 	resumeTimeout := flag.Duration("resume-timeout", time.Minute, "Maximum amount of time to wait for agent and liveness to resume")
 
+	rawReadinessProbes := flag.String("readiness-probes", "[]", `Probes that need to succeed before the VM is snapshotted (e.g. [{"type": "tcp", "address": "172.16.0.2:6379"}, {"type": "http", "url": "http://172.16.0.2:8080/healthz"}, {"type": "exec", "command": "valkey-cli ping"}])`)
+	readinessInterval := flag.Duration("readiness-interval", time.Millisecond*500, "Time to wait between failed attempts of a readiness probe")
+	readinessAttemptTimeout := flag.Duration("readiness-attempt-timeout", time.Second*10, "Maximum amount of time for a single attempt of a readiness probe (0 to use --readiness-interval)")
+	readinessTimeout := flag.Duration("readiness-timeout", time.Minute*5, "Maximum amount of time to wait for all readiness probes to succeed (0 to wait indefinitely)")
+
 	netns := flag.String("netns", "ark0", "Network namespace to run Firecracker in")
-	iface := flag.String("interface", "tap0", "Name of the interface in the network namespace to use")
-	mac := flag.String("mac", "02:0e:d9:fd:68:3d", "MAC of the interface in the network namespace to use")
 
-	numaNode := flag.Int("numa-node", 0, "NUMA node to run Firecracker in")
+	rawNUMANode := flag.String("numa-node", "0", "NUMA node to run Firecracker in (auto or -1 to use the NUMA node with the most free memory, or the node that contains all of --cpus)")
+	rawCPUs := flag.String("cpus", "", "CPUs to run Firecracker on in the kernel's CPU list format (e.g. 0-3,8) (leave empty to use all CPUs of the NUMA node)")
 	cgroupVersion := flag.Int("cgroup-version", 2, "Cgroup version to use for Jailer")
+	rawCgroups := flag.String("cgroups", "{}", `Additional cgroup settings for Firecracker (e.g. {"cpu.max": "50000 100000", "memory.max": "1073741824"}) (overrides the cpuset derived from --numa-node and --cpus)`)
+	parentCgroup := flag.String("parent-cgroup", "", "Cgroup to create Firecracker's cgroup in (leave empty to use the Jailer's default)")
+	rawResourceLimits := flag.String("resource-limits", "{}", `Resource limits for Firecracker (e.g. {"no-file": 2048, "fsize": 1073741824})`)
 
 	livenessVSockPort := flag.Int("liveness-vsock-port", 25, "Liveness VSock port")
 	agentVSockPort := flag.Int("agent-vsock-port", 26, "Agent VSock port")
@@ -78,11 +87,33 @@ func main() {
 
 	rawDevices := flag.String("devices", string(defaultDevices), "Devices configuration")
 
+	defaultNetworkInterfaces, err := json.Marshal([]snapshotter.NetworkInterfaceConfiguration{
+		{
+			Interface: "tap0",
+			MAC:       "02:0e:d9:fd:68:3d",
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	rawNetworkInterfaces := flag.String("network-interfaces", string(defaultNetworkInterfaces), "Network interfaces configuration (interfaces in the network namespace to use)")
+
 	cpuCount := flag.Int("cpu-count", 1, "CPU count")
 	memorySize := flag.Int("memory-size", 1024, "Memory size (in MB)")
 	cpuTemplate := flag.String("cpu-template", "None", "Firecracker CPU template (see https://github.com/firecracker-microvm/firecracker/blob/main/docs/cpu_templates/cpu-templates.md#static-cpu-templates for the options)")
 	bootArgs := flag.String("boot-args", snapshotter.DefaultBootArgs, "Boot/kernel arguments")
 
+	enableBalloon := flag.Bool("enable-balloon", false, "Whether to attach a balloon device to the VM")
+	balloonDeflateOnOOM := flag.Bool("balloon-deflate-on-oom", true, "Whether the guest may deflate the balloon if it runs out of memory (ignored unless --enable-balloon)")
+	balloonStatisticsInterval := flag.Duration("balloon-statistics-interval", time.Second, "Interval in which the guest updates the balloon statistics (0 to disable) (ignored unless --enable-balloon)")
+	balloonFreePageReporting := flag.Bool("balloon-free-page-reporting", false, "Whether the guest should continuously report free pages to the host (requires a Firecracker version with free page reporting support) (ignored unless --enable-balloon)")
+
+	enableMMDS := flag.Bool("enable-mmds", false, "Whether to enable the microVM metadata service (MMDS) for the VM")
+	mmdsVersion := flag.String("mmds-version", string(hypervisor.MMDSVersionV2), "MMDS version to use (V1 or V2) (ignored unless --enable-mmds)")
+	mmdsIPv4Address := flag.String("mmds-ipv4-address", "", "IPv4 address that the guest can reach the MMDS on (leave empty to use 169.254.169.254) (ignored unless --enable-mmds)")
+	rawMMDSNetworkInterfaces := flag.String("mmds-network-interfaces", `["tap0"]`, "Network interfaces that the guest can reach the MMDS on (ignored unless --enable-mmds)")
+
 	flag.Parse()
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -93,6 +124,57 @@ func main() {
 		panic(err)
 	}
 
+	var networkInterfaces []snapshotter.NetworkInterfaceConfiguration
+	if err := json.Unmarshal([]byte(*rawNetworkInterfaces), &networkInterfaces); err != nil {
+		panic(err)
+	}
+
+	var readinessProbes []snapshotter.ReadinessProbe
+	if err := json.Unmarshal([]byte(*rawReadinessProbes), &readinessProbes); err != nil {
+		panic(err)
+	}
+
+	var cgroups map[string]string
+	if err := json.Unmarshal([]byte(*rawCgroups), &cgroups); err != nil {
+		panic(err)
+	}
+
+	var resourceLimits map[string]uint64
+	if err := json.Unmarshal([]byte(*rawResourceLimits), &resourceLimits); err != nil {
+		panic(err)
+	}
+
+	numaNode, err := cpuset.ParseNUMANode(*rawNUMANode)
+	if err != nil {
+		panic(err)
+	}
+
+	cpus, err := cpuset.ParseList(*rawCPUs)
+	if err != nil {
+		panic(err)
+	}
+
+	var balloon *hypervisor.Balloon
+	if *enableBalloon {
+		balloon = &hypervisor.Balloon{
+			DeflateOnOOM:       *balloonDeflateOnOOM,
+			StatisticsInterval: *balloonStatisticsInterval,
+			FreePageReporting:  *balloonFreePageReporting,
+		}
+	}
+
+	var mmds *hypervisor.MMDS
+	if *enableMMDS {
+		mmds = &hypervisor.MMDS{
+			Version:     hypervisor.MMDSVersion(*mmdsVersion),
+			IPv4Address: *mmdsIPv4Address,
+		}
+
+		if err := json.Unmarshal([]byte(*rawMMDSNetworkInterfaces), &mmds.NetworkInterfaces); err != nil {
+			panic(err)
+		}
+	}
+
 	firecrackerBin, err := exec.LookPath(*rawFirecrackerBin)
 	if err != nil {
 		panic(err)
@@ -141,11 +223,21 @@ func main() {
 			CPUTemplate: *cpuTemplate,
 
 			BootArgs: *bootArgs,
+
+			Balloon: balloon,
+			MMDS:    mmds,
 		},
 		snapshotter.LivenessConfiguration{
 			LivenessVSockPort: uint32(*livenessVSockPort),
 			ResumeTimeout:     *resumeTimeout,
 		},
+		snapshotter.ReadinessConfiguration{
+			Probes: readinessProbes,
+
+			Interval:       *readinessInterval,
+			AttemptTimeout: *readinessAttemptTimeout,
+			Timeout:        *readinessTimeout,
+		},
 
 		snapshotter.HypervisorConfiguration{
 			FirecrackerBin: firecrackerBin,
@@ -157,15 +249,20 @@ func main() {
 			GID: *gid,
 
 			NetNS:         *netns,
-			NumaNode:      *numaNode,
+			NumaNode:      numaNode,
+			CPUs:          cpus,
 			CgroupVersion: *cgroupVersion,
 
+			Cgroups:        cgroups,
+			ParentCgroup:   *parentCgroup,
+			ResourceLimits: resourceLimits,
+
 			EnableOutput: *enableOutput,
 			EnableInput:  *enableInput,
 		},
+		snapshotter.HypervisorRuntime{},
 		snapshotter.NetworkConfiguration{
-			Interface: *iface,
-			MAC:       *mac,
+			Interfaces: networkInterfaces,
 		},
 		snapshotter.AgentConfiguration{
 			AgentVSockPort: uint32(*agentVSockPort),
@@ -6,10 +6,17 @@ type BootSource struct {
 }
 
 type Drive struct {
-	DriveID      string `json:"drive_id"`
-	PathOnHost   string `json:"path_on_host"`
-	IsRootDevice bool   `json:"is_root_device"`
-	IsReadOnly   bool   `json:"is_read_only"`
+	DriveID      string       `json:"drive_id"`
+	PathOnHost   string       `json:"path_on_host"`
+	IsRootDevice bool         `json:"is_root_device"`
+	IsReadOnly   bool         `json:"is_read_only"`
+	RateLimiter  *RateLimiter `json:"rate_limiter,omitempty"`
+}
+
+type PartialDrive struct {
+	DriveID     string       `json:"drive_id"`
+	PathOnHost  string       `json:"path_on_host,omitempty"`
+	RateLimiter *RateLimiter `json:"rate_limiter,omitempty"`
 }
 
 type MachineConfig struct {
@@ -22,10 +29,29 @@ type Action struct {
 	ActionType string `json:"action_type"`
 }
 
+type TokenBucket struct {
+	Size         int64 `json:"size"`
+	OneTimeBurst int64 `json:"one_time_burst,omitempty"`
+	RefillTime   int64 `json:"refill_time"` // In milliseconds
+}
+
+type RateLimiter struct {
+	Bandwidth *TokenBucket `json:"bandwidth,omitempty"`
+	Ops       *TokenBucket `json:"ops,omitempty"`
+}
+
 type NetworkInterface struct {
-	IfaceID     string `json:"iface_id"`
-	GuestMAC    string `json:"guest_mac"`
-	HostDevName string `json:"host_dev_name"`
+	IfaceID       string       `json:"iface_id"`
+	GuestMAC      string       `json:"guest_mac"`
+	HostDevName   string       `json:"host_dev_name"`
+	RxRateLimiter *RateLimiter `json:"rx_rate_limiter,omitempty"`
+	TxRateLimiter *RateLimiter `json:"tx_rate_limiter,omitempty"`
+}
+
+type PartialNetworkInterface struct {
+	IfaceID       string       `json:"iface_id"`
+	RxRateLimiter *RateLimiter `json:"rx_rate_limiter,omitempty"`
+	TxRateLimiter *RateLimiter `json:"tx_rate_limiter,omitempty"`
 }
 
 type VirtualMachineStateRequest struct {
@@ -55,3 +81,97 @@ type VSock struct {
 	GuestCID int    `json:"guest_cid"`
 	UDSPath  string `json:"uds_path"`
 }
+
+type Balloon struct {
+	AmountMib             int  `json:"amount_mib"`
+	DeflateOnOOM          bool `json:"deflate_on_oom"`
+	StatsPollingIntervalS int  `json:"stats_polling_interval_s"`
+	FreePageReporting     bool `json:"free_page_reporting,omitempty"` // `omitempty` here since free page reporting is only available in newer Firecracker versions
+}
+
+type MMDSConfig struct {
+	Version           string   `json:"version"`
+	NetworkInterfaces []string `json:"network_interfaces"`
+	IPv4Address       string   `json:"ipv4_address,omitempty"`
+}
+
+type BalloonUpdate struct {
+	AmountMib int `json:"amount_mib"`
+}
+
+type BalloonStatistics struct {
+	TargetPages     int   `json:"target_pages"`
+	ActualPages     int   `json:"actual_pages"`
+	TargetMib       int   `json:"target_mib"`
+	ActualMib       int   `json:"actual_mib"`
+	SwapIn          int64 `json:"swap_in,omitempty"`
+	SwapOut         int64 `json:"swap_out,omitempty"`
+	MajorFaults     int64 `json:"major_faults,omitempty"`
+	MinorFaults     int64 `json:"minor_faults,omitempty"`
+	FreeMemory      int64 `json:"free_memory,omitempty"`
+	TotalMemory     int64 `json:"total_memory,omitempty"`
+	AvailableMemory int64 `json:"available_memory,omitempty"`
+	DiskCaches      int64 `json:"disk_caches,omitempty"`
+}
+
+// Metrics is a single line of Firecracker's metrics file; counters are the increments since the previous line
+type Metrics struct {
+	UTCTimestampMs int64        `json:"utc_timestamp_ms"`
+	VCPU           VCPUMetrics  `json:"vcpu"`
+	VSock          VSockMetrics `json:"vsock"`
+
+	// The per-device metrics use dynamic keys (`block_<drive_id>` and `net_<iface_id>`), so they can't be decoded with struct tags
+	Block map[string]BlockDeviceMetrics `json:"-"`
+	Net   map[string]NetDeviceMetrics   `json:"-"`
+}
+
+type VCPUMetrics struct {
+	ExitIOIn      int64 `json:"exit_io_in"`
+	ExitIOOut     int64 `json:"exit_io_out"`
+	ExitMMIORead  int64 `json:"exit_mmio_read"`
+	ExitMMIOWrite int64 `json:"exit_mmio_write"`
+	Failures      int64 `json:"failures"`
+}
+
+type BlockDeviceMetrics struct {
+	ReadBytes                  int64 `json:"read_bytes"`
+	WriteBytes                 int64 `json:"write_bytes"`
+	ReadCount                  int64 `json:"read_count"`
+	WriteCount                 int64 `json:"write_count"`
+	FlushCount                 int64 `json:"flush_count"`
+	RateLimiterThrottledEvents int64 `json:"rate_limiter_throttled_events"`
+}
+
+type NetDeviceMetrics struct {
+	RxBytesCount           int64 `json:"rx_bytes_count"`
+	RxPacketsCount         int64 `json:"rx_packets_count"`
+	TxBytesCount           int64 `json:"tx_bytes_count"`
+	TxPacketsCount         int64 `json:"tx_packets_count"`
+	RxRateLimiterThrottled int64 `json:"rx_rate_limiter_throttled"`
+	TxRateLimiterThrottled int64 `json:"tx_rate_limiter_throttled"`
+}
+
+type VSockMetrics struct {
+	RxBytesCount   int64 `json:"rx_bytes_count"`
+	TxBytesCount   int64 `json:"tx_bytes_count"`
+	RxPacketsCount int64 `json:"rx_packets_count"`
+	TxPacketsCount int64 `json:"tx_packets_count"`
+	ConnsAdded     int64 `json:"conns_added"`
+	ConnsKilled    int64 `json:"conns_killed"`
+	ConnsRemoved   int64 `json:"conns_removed"`
+}
+
+type Error struct {
+	FaultMessage string `json:"fault_message"`
+}
+
+type InstanceInfo struct {
+	AppName    string `json:"app_name"`
+	ID         string `json:"id"`
+	State      string `json:"state"`
+	VMMVersion string `json:"vmm_version"`
+}
+
+type FirecrackerVersion struct {
+	FirecrackerVersion string `json:"firecracker_version"`
+}
@@ -13,22 +13,34 @@ import (
 )
 
 var (
-	ErrCouldNotSetBootSource        = errors.New("could not set boot source")
-	ErrCouldNotSetDrive             = errors.New("could not set drive")
-	ErrCouldNotSetMachineConfig     = errors.New("could not set machine config")
-	ErrCouldNotSetVSock             = errors.New("could not set vsock")
-	ErrCouldNotSetNetworkInterfaces = errors.New("could not set network interfaces")
-	ErrCouldNotStartInstance        = errors.New("could not start instance")
-	ErrCouldNotStopInstance         = errors.New("could not stop instance")
-	ErrCouldNotPauseInstance        = errors.New("could not pause instance")
-	ErrCouldNotCreateSnapshot       = errors.New("could not create snapshot")
-	ErrCouldNotResumeSnapshot       = errors.New("could not resume snapshot")
-	ErrCouldNotFlushSnapshot        = errors.New("could not flush snapshot")
-	ErrUnknownSnapshotType          = errors.New("could not work with unknown snapshot type")
-	ErrCouldNotMarshalJSON          = errors.New("could not marshal JSON")
-	ErrCouldNotCreateHTTPRequest    = errors.New("could not create HTTP request")
-	ErrCouldNotReadHTTPResponse     = errors.New("could not read HTTP response")
-	ErrHTTPResponseFailed           = errors.New("response status of HTTP request code indicates failure")
+	ErrCouldNotSetBootSource          = errors.New("could not set boot source")
+	ErrCouldNotSetDrive               = errors.New("could not set drive")
+	ErrCouldNotSetMachineConfig       = errors.New("could not set machine config")
+	ErrCouldNotSetVSock               = errors.New("could not set vsock")
+	ErrCouldNotSetNetworkInterfaces   = errors.New("could not set network interfaces")
+	ErrCouldNotUpdateDrive            = errors.New("could not update drive")
+	ErrCouldNotUpdateNetworkInterface = errors.New("could not update network interface")
+	ErrCouldNotSetBalloon             = errors.New("could not set balloon")
+	ErrCouldNotUpdateBalloon          = errors.New("could not update balloon")
+	ErrCouldNotGetBalloonStatistics   = errors.New("could not get balloon statistics")
+	ErrCouldNotSetMMDSConfig          = errors.New("could not set MMDS config")
+	ErrCouldNotPutMMDS                = errors.New("could not put MMDS data")
+	ErrCouldNotPatchMMDS              = errors.New("could not patch MMDS data")
+	ErrCouldNotDecodeJSON             = errors.New("could not decode JSON")
+	ErrCouldNotStartInstance          = errors.New("could not start instance")
+	ErrCouldNotStopInstance           = errors.New("could not stop instance")
+	ErrCouldNotPauseInstance          = errors.New("could not pause instance")
+	ErrCouldNotResumeInstance         = errors.New("could not resume instance")
+	ErrCouldNotCreateSnapshot         = errors.New("could not create snapshot")
+	ErrCouldNotResumeSnapshot         = errors.New("could not resume snapshot")
+	ErrCouldNotFlushSnapshot          = errors.New("could not flush snapshot")
+	ErrCouldNotFlushMetrics           = errors.New("could not flush metrics")
+	ErrCouldNotGetVersion             = errors.New("could not get version")
+	ErrUnknownSnapshotType            = errors.New("could not work with unknown snapshot type")
+	ErrCouldNotMarshalJSON            = errors.New("could not marshal JSON")
+	ErrCouldNotCreateHTTPRequest      = errors.New("could not create HTTP request")
+	ErrCouldNotReadHTTPResponse       = errors.New("could not read HTTP response")
+	ErrHTTPResponseFailed             = errors.New("response status of HTTP request code indicates failure")
 )
 
 type SnapshotType byte
@@ -37,6 +49,7 @@ const (
 	SnapshotTypeFull = iota
 	SnapshotTypeMsync
 	SnapshotTypeMsyncAndState
+	SnapshotTypeDiff
 )
 
 func submitJSON(ctx context.Context, method string, client *http.Client, body any, resource string) error {
@@ -67,6 +80,34 @@ func submitJSON(ctx context.Context, method string, client *http.Client, body an
 	return nil
 }
 
+func fetchJSON(ctx context.Context, client *http.Client, body any, resource string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/"+resource, nil)
+	if err != nil {
+		return errors.Join(ErrCouldNotCreateHTTPRequest, err)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			return errors.Join(ErrCouldNotReadHTTPResponse, err)
+		}
+
+		return errors.Join(ErrHTTPResponseFailed, errors.New(string(b)))
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(body); err != nil {
+		return errors.Join(ErrCouldNotDecodeJSON, err)
+	}
+
+	return nil
+}
+
 func StartVM(
 	ctx context.Context,
 
@@ -74,15 +115,17 @@ func StartVM(
 
 	kernelPath string,
 
-	disks []string,
+	drives []v1.Drive,
 
 	cpuCount int,
 	memorySize int,
 	cpuTemplate string,
 	bootArgs string,
 
-	hostInterface string,
-	hostMAC string,
+	balloon *v1.Balloon,
+
+	networkInterfaces []v1.NetworkInterface,
+	mmdsConfig *v1.MMDSConfig,
 
 	vsockPath string,
 	vsockCID int,
@@ -100,18 +143,13 @@ func StartVM(
 		return errors.Join(ErrCouldNotSetBootSource, err)
 	}
 
-	for _, disk := range disks {
+	for _, drive := range drives {
 		if err := submitJSON(
 			ctx,
 			http.MethodPut,
 			client,
-			&v1.Drive{
-				DriveID:      disk,
-				PathOnHost:   disk,
-				IsRootDevice: false,
-				IsReadOnly:   false,
-			},
-			path.Join("drives", disk),
+			&drive,
+			path.Join("drives", drive.DriveID),
 		); err != nil {
 			return errors.Join(ErrCouldNotSetDrive, err)
 		}
@@ -144,30 +182,263 @@ func StartVM(
 		return errors.Join(ErrCouldNotSetVSock, err)
 	}
 
+	if balloon != nil {
+		if err := submitJSON(
+			ctx,
+			http.MethodPut,
+			client,
+			balloon,
+			"balloon",
+		); err != nil {
+			return errors.Join(ErrCouldNotSetBalloon, err)
+		}
+	}
+
+	for _, networkInterface := range networkInterfaces {
+		if err := submitJSON(
+			ctx,
+			http.MethodPut,
+			client,
+			&networkInterface,
+			path.Join("network-interfaces", networkInterface.IfaceID),
+		); err != nil {
+			return errors.Join(ErrCouldNotSetNetworkInterfaces, err)
+		}
+	}
+
+	// The MMDS config references network interfaces, so it needs to be set after they have been attached
+	if mmdsConfig != nil {
+		if err := submitJSON(
+			ctx,
+			http.MethodPut,
+			client,
+			mmdsConfig,
+			path.Join("mmds", "config"),
+		); err != nil {
+			return errors.Join(ErrCouldNotSetMMDSConfig, err)
+		}
+	}
+
 	if err := submitJSON(
 		ctx,
 		http.MethodPut,
 		client,
-		&v1.NetworkInterface{
-			IfaceID:     hostInterface,
-			GuestMAC:    hostMAC,
-			HostDevName: hostInterface,
+		&v1.Action{
+			ActionType: "InstanceStart",
 		},
-		path.Join("network-interfaces", hostInterface),
+		"actions",
+	); err != nil {
+		return errors.Join(ErrCouldNotStartInstance, err)
+	}
+
+	return nil
+}
+
+func UpdateDrive(
+	ctx context.Context,
+	client *http.Client,
+
+	drive v1.PartialDrive,
+) error {
+	if err := submitJSON(
+		ctx,
+		http.MethodPatch,
+		client,
+		&drive,
+		path.Join("drives", drive.DriveID),
+	); err != nil {
+		return errors.Join(ErrCouldNotUpdateDrive, err)
+	}
+
+	return nil
+}
+
+func UpdateNetworkInterface(
+	ctx context.Context,
+	client *http.Client,
+
+	networkInterface v1.PartialNetworkInterface,
+) error {
+	if err := submitJSON(
+		ctx,
+		http.MethodPatch,
+		client,
+		&networkInterface,
+		path.Join("network-interfaces", networkInterface.IfaceID),
 	); err != nil {
-		return errors.Join(ErrCouldNotSetNetworkInterfaces, err)
+		return errors.Join(ErrCouldNotUpdateNetworkInterface, err)
 	}
 
+	return nil
+}
+
+func UpdateBalloon(
+	ctx context.Context,
+	client *http.Client,
+
+	amountMib int,
+) error {
+	if err := submitJSON(
+		ctx,
+		http.MethodPatch,
+		client,
+		&v1.BalloonUpdate{
+			AmountMib: amountMib,
+		},
+		"balloon",
+	); err != nil {
+		return errors.Join(ErrCouldNotUpdateBalloon, err)
+	}
+
+	return nil
+}
+
+func GetBalloonStatistics(
+	ctx context.Context,
+	client *http.Client,
+) (*v1.BalloonStatistics, error) {
+	var balloonStatistics v1.BalloonStatistics
+	if err := fetchJSON(
+		ctx,
+		client,
+		&balloonStatistics,
+		path.Join("balloon", "statistics"),
+	); err != nil {
+		return nil, errors.Join(ErrCouldNotGetBalloonStatistics, err)
+	}
+
+	return &balloonStatistics, nil
+}
+
+func GetVersion(
+	ctx context.Context,
+	client *http.Client,
+) (string, error) {
+	var version v1.FirecrackerVersion
+	if err := fetchJSON(
+		ctx,
+		client,
+		&version,
+		"version",
+	); err != nil {
+		return "", errors.Join(ErrCouldNotGetVersion, err)
+	}
+
+	return version.FirecrackerVersion, nil
+}
+
+// PutMMDS replaces the MMDS data store with `data`, which needs to marshal to a JSON object
+func PutMMDS(
+	ctx context.Context,
+	client *http.Client,
+
+	data any,
+) error {
+	if err := submitJSON(
+		ctx,
+		http.MethodPut,
+		client,
+		data,
+		"mmds",
+	); err != nil {
+		return errors.Join(ErrCouldNotPutMMDS, err)
+	}
+
+	return nil
+}
+
+// PatchMMDS merges `patch` into the MMDS data store as a JSON merge patch (RFC 7396)
+func PatchMMDS(
+	ctx context.Context,
+	client *http.Client,
+
+	patch any,
+) error {
+	if err := submitJSON(
+		ctx,
+		http.MethodPatch,
+		client,
+		patch,
+		"mmds",
+	); err != nil {
+		return errors.Join(ErrCouldNotPatchMMDS, err)
+	}
+
+	return nil
+}
+
+func PauseVM(
+	ctx context.Context,
+	client *http.Client,
+) error {
+	if err := submitJSON(
+		ctx,
+		http.MethodPatch,
+		client,
+		&v1.VirtualMachineStateRequest{
+			State: "Paused",
+		},
+		"vm",
+	); err != nil {
+		return errors.Join(ErrCouldNotPauseInstance, err)
+	}
+
+	return nil
+}
+
+func ResumeVM(
+	ctx context.Context,
+	client *http.Client,
+) error {
+	if err := submitJSON(
+		ctx,
+		http.MethodPatch,
+		client,
+		&v1.VirtualMachineStateRequest{
+			State: "Resumed",
+		},
+		"vm",
+	); err != nil {
+		return errors.Join(ErrCouldNotResumeInstance, err)
+	}
+
+	return nil
+}
+
+func FlushMetrics(
+	ctx context.Context,
+	client *http.Client,
+) error {
 	if err := submitJSON(
 		ctx,
 		http.MethodPut,
 		client,
 		&v1.Action{
-			ActionType: "InstanceStart",
+			ActionType: "FlushMetrics",
 		},
 		"actions",
 	); err != nil {
-		return errors.Join(ErrCouldNotStartInstance, err)
+		return errors.Join(ErrCouldNotFlushMetrics, err)
+	}
+
+	return nil
+}
+
+// SendCtrlAltDel sends CTRL-ALT-DEL to the guest, which causes Firecracker to exit once the guest has shut down (only supported on x86_64)
+func SendCtrlAltDel(
+	ctx context.Context,
+	client *http.Client,
+) error {
+	if err := submitJSON(
+		ctx,
+		http.MethodPut,
+		client,
+		&v1.Action{
+			ActionType: "SendCtrlAltDel",
+		},
+		"actions",
+	); err != nil {
+		return errors.Join(ErrCouldNotStopInstance, err)
 	}
 
 	return nil
@@ -190,22 +461,16 @@ func CreateSnapshot(
 		st = "Msync"
 	case SnapshotTypeMsyncAndState:
 		st = "MsyncAndState"
+	case SnapshotTypeDiff:
+		st = "Diff"
 
 	default:
 		return ErrUnknownSnapshotType
 	}
 
 	if snapshotType != SnapshotTypeMsync {
-		if err := submitJSON(
-			ctx,
-			http.MethodPatch,
-			client,
-			&v1.VirtualMachineStateRequest{
-				State: "Paused",
-			},
-			"vm",
-		); err != nil {
-			return errors.Join(ErrCouldNotPauseInstance, err)
+		if err := PauseVM(ctx, client); err != nil {
+			return err
 		}
 	}
 
@@ -234,6 +499,7 @@ func ResumeSnapshot(
 	memoryPath string,
 
 	shared bool,
+	trackDirtyPages bool,
 ) error {
 	if err := submitJSON(
 		ctx,
@@ -245,7 +511,7 @@ func ResumeSnapshot(
 				BackendType: "File",
 				BackendPath: memoryPath,
 			},
-			EnableDiffSnapshots:  false,
+			EnableDiffSnapshots:  trackDirtyPages,
 			ResumeVirtualMachine: true,
 			Shared:               shared,
 		},
@@ -0,0 +1,137 @@
+package firecracker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	v1 "github.com/loopholelabs/drafter/internal/api/http/firecracker/v1"
+)
+
+var (
+	ErrCouldNotDecodeMetrics = errors.New("could not decode metrics")
+	ErrCouldNotReadLine      = errors.New("could not read line")
+)
+
+const (
+	FirecrackerMetricsName = "metrics.fifo"
+	FirecrackerLogName     = "log.fifo"
+
+	blockMetricsPrefix = "block_"
+	netMetricsPrefix   = "net_"
+
+	// Firecracker writes timestamps in local time without a time zone
+	logTimeLayout = "2006-01-02T15:04:05.999999999"
+)
+
+type MonitoringHooks struct {
+	OnMetrics func(metrics *v1.Metrics)
+	OnLog     func(entry *LogEntry)
+	// Called if the metrics or log FIFO can't be read; the VM keeps running, but no more metrics or logs are reported from it
+	OnError func(err error)
+}
+
+type LogEntry struct {
+	Time time.Time
+
+	Instance string
+	Thread   string
+	Level    string
+
+	Message string
+}
+
+func DecodeMetrics(line []byte) (*v1.Metrics, error) {
+	var metrics v1.Metrics
+	if err := json.Unmarshal(line, &metrics); err != nil {
+		return nil, errors.Join(ErrCouldNotDecodeMetrics, err)
+	}
+
+	var groups map[string]json.RawMessage
+	if err := json.Unmarshal(line, &groups); err != nil {
+		return nil, errors.Join(ErrCouldNotDecodeMetrics, err)
+	}
+
+	metrics.Block = map[string]v1.BlockDeviceMetrics{}
+	metrics.Net = map[string]v1.NetDeviceMetrics{}
+	for key, group := range groups {
+		if driveID, ok := strings.CutPrefix(key, blockMetricsPrefix); ok {
+			var blockMetrics v1.BlockDeviceMetrics
+			if err := json.Unmarshal(group, &blockMetrics); err != nil {
+				return nil, errors.Join(ErrCouldNotDecodeMetrics, err)
+			}
+
+			metrics.Block[driveID] = blockMetrics
+		} else if ifaceID, ok := strings.CutPrefix(key, netMetricsPrefix); ok {
+			var netMetrics v1.NetDeviceMetrics
+			if err := json.Unmarshal(group, &netMetrics); err != nil {
+				return nil, errors.Join(ErrCouldNotDecodeMetrics, err)
+			}
+
+			metrics.Net[ifaceID] = netMetrics
+		}
+	}
+
+	return &metrics, nil
+}
+
+// ParseLogEntry parses a line like `2024-01-01T00:00:00.000000000 [anonymous-instance:main:INFO] Message`;
+// if the line doesn't have this format (e.g. for multi-line messages), the whole line is used as the message
+func ParseLogEntry(line string) *LogEntry {
+	entry := &LogEntry{
+		Message: line,
+	}
+
+	rawTime, rest, ok := strings.Cut(line, " [")
+	if !ok {
+		return entry
+	}
+
+	t, err := time.ParseInLocation(logTimeLayout, rawTime, time.Local)
+	if err != nil {
+		return entry
+	}
+
+	rawPrefix, message, ok := strings.Cut(rest, "] ")
+	if !ok {
+		return entry
+	}
+
+	prefix := strings.Split(rawPrefix, ":")
+
+	entry.Time = t
+	entry.Instance = prefix[0]
+	if len(prefix) > 1 {
+		entry.Thread = prefix[1]
+	}
+	if len(prefix) > 2 {
+		entry.Level = prefix[2]
+	}
+	entry.Message = message
+
+	return entry
+}
+
+func readLines(r io.Reader, onLine func(line []byte)) error {
+	reader := bufio.NewReader(r)
+
+	for {
+		// We don't use a `bufio.Scanner` since a metrics line can be longer than its maximum token size
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSuffix(line, []byte("\n")); len(line) > 0 {
+			onLine(line)
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return errors.Join(ErrCouldNotReadLine, err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package firecracker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	ErrCouldNotListThreads = errors.New("could not list threads")
+	ErrCouldNotPinVCPU     = errors.New("could not pin vCPU")
+)
+
+const (
+	// Firecracker names the thread of vCPU N `fc_vcpu N`
+	vcpuThreadNamePrefix = "fc_vcpu "
+)
+
+// PinVCPUs pins the vCPU threads of the Firecracker process `pid` to `cpus`, assigning them round-robin in vCPU order.
+// The vCPU threads are only created once a VM is started or a snapshot is loaded, so this is a no-op before that.
+func PinVCPUs(pid int, cpus []int) error {
+	if len(cpus) == 0 {
+		return nil
+	}
+
+	taskPath := filepath.Join("/proc", fmt.Sprintf("%v", pid), "task")
+
+	tasks, err := os.ReadDir(taskPath)
+	if err != nil {
+		return errors.Join(ErrCouldNotListThreads, err)
+	}
+
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+
+		comm, err := os.ReadFile(filepath.Join(taskPath, task.Name(), "comm"))
+		if err != nil {
+			// The thread might have exited in the meantime
+			continue
+		}
+
+		rawIndex, ok := strings.CutPrefix(strings.TrimSpace(string(comm)), vcpuThreadNamePrefix)
+		if !ok {
+			continue
+		}
+
+		index, err := strconv.Atoi(rawIndex)
+		if err != nil {
+			continue
+		}
+
+		var set unix.CPUSet
+		set.Set(cpus[index%len(cpus)])
+
+		if err := unix.SchedSetaffinity(tid, &set); err != nil {
+			return errors.Join(ErrCouldNotPinVCPU, fmt.Errorf("vCPU %v", index), err)
+		}
+	}
+
+	return nil
+}
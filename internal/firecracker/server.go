@@ -4,9 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"sync"
 
 	"github.com/lithammer/shortuuid/v4"
@@ -23,21 +26,38 @@ var (
 	ErrCouldNotCreateVMPathDirectory  = errors.New("could not create VM path directory")
 	ErrCouldNotCreateInotifyWatcher   = errors.New("could not create inotify watcher")
 	ErrCouldNotAddInotifyWatch        = errors.New("could not add inotify watch")
-	ErrCouldNotReadNUMACPUList        = errors.New("could not read NUMA CPU list")
 	ErrCouldNotStartFirecrackerServer = errors.New("could not start firecracker server")
 	ErrCouldNotCloseWatcher           = errors.New("could not close watcher")
 	ErrCouldNotCloseServer            = errors.New("could not close server")
 	ErrCouldNotWaitForFirecracker     = errors.New("could not wait for firecracker")
+	ErrCouldNotCreateFIFO             = errors.New("could not create FIFO")
+	ErrCouldNotChownFIFO              = errors.New("could not change ownership of FIFO")
+	ErrCouldNotOpenFIFO               = errors.New("could not open FIFO")
+	ErrCouldNotReadFIFO               = errors.New("could not read FIFO")
+	ErrCouldNotCreateConsolePipe      = errors.New("could not create console pipe")
 )
 
 const (
 	FirecrackerSocketName = "firecracker.sock"
+
+	consoleInputBufferSize = 32 * 1024
 )
 
+// Console is the serial console of a VM; Firecracker writes the VM's output to it and reads the VM's input from it
+type Console interface {
+	io.Writer
+
+	// ReadContext reads input for the VM; it returns `ctx.Err()` once `ctx` is cancelled without consuming any input
+	ReadContext(ctx context.Context, p []byte) (int, error)
+}
+
 type FirecrackerServer struct {
 	VMPath string
 	VMPid  int
 
+	// Cgroup of the Firecracker process relative to the root of the cgroup hierarchy (e.g. `/sys/fs/cgroup` for cgroup v2)
+	CgroupPath string
+
 	Wait  func() error
 	Close func() error
 }
@@ -55,10 +75,19 @@ func StartFirecrackerServer(
 
 	netns string,
 	numaNode int,
+	cpus string,
 	cgroupVersion int,
 
+	cgroups map[string]string,
+	parentCgroup string,
+	resourceLimits map[string]uint64,
+
 	enableOutput bool,
 	enableInput bool,
+
+	console Console,
+
+	hooks MonitoringHooks,
 ) (server *FirecrackerServer, errs error) {
 	server = &FirecrackerServer{
 		Wait: func() error {
@@ -80,7 +109,8 @@ func StartFirecrackerServer(
 
 	id := shortuuid.New()
 
-	server.VMPath = filepath.Join(chrootBaseDir, "firecracker", id, "root")
+	// The jailer names the chroot directory after the basename of the Firecracker binary
+	server.VMPath = filepath.Join(chrootBaseDir, filepath.Base(firecrackerBin), id, "root")
 	if err := os.MkdirAll(server.VMPath, os.ModePerm); err != nil {
 		panic(errors.Join(ErrCouldNotCreateVMPathDirectory, err))
 	}
@@ -95,14 +125,93 @@ func StartFirecrackerServer(
 		panic(errors.Join(ErrCouldNotAddInotifyWatch, err))
 	}
 
-	cpus, err := os.ReadFile(filepath.Join("/sys", "devices", "system", "node", fmt.Sprintf("node%v", numaNode), "cpulist"))
-	if err != nil {
-		panic(errors.Join(ErrCouldNotReadNUMACPUList, err))
+	firecrackerArgs := []string{
+		"--api-sock",
+		FirecrackerSocketName,
 	}
 
-	cmd := exec.CommandContext(
-		ctx, // We use ctx, not goroutineManager.Context() here since this resource outlives the function call
-		jailerBin,
+	var (
+		monitoringReaders []*os.File
+		monitoringWriters []*os.File
+		monitoringOnLines []func(line []byte)
+
+		monitoringWg sync.WaitGroup
+	)
+	closeMonitoringFiles := func() {
+		for _, f := range append(monitoringReaders, monitoringWriters...) {
+			_ = f.Close() // We ignore errors here since we only use this to clean up
+		}
+	}
+
+	for _, monitor := range []struct {
+		enabled bool
+		name    string
+		args    []string
+		onLine  func(line []byte)
+	}{
+		{
+			enabled: hooks.OnMetrics != nil,
+			name:    FirecrackerMetricsName,
+			args:    []string{"--metrics-path", FirecrackerMetricsName},
+			onLine: func(line []byte) {
+				metrics, err := DecodeMetrics(line)
+				if err != nil {
+					// We skip lines that we can't decode instead of stopping the VM
+					return
+				}
+
+				hooks.OnMetrics(metrics)
+			},
+		},
+		{
+			enabled: hooks.OnLog != nil,
+			name:    FirecrackerLogName,
+			args:    []string{"--log-path", FirecrackerLogName, "--level", "Info", "--show-level"},
+			onLine: func(line []byte) {
+				hooks.OnLog(ParseLogEntry(string(line)))
+			},
+		},
+	} {
+		if !monitor.enabled {
+			continue
+		}
+
+		fifoPath := filepath.Join(server.VMPath, monitor.name)
+		if err := unix.Mkfifo(fifoPath, 0600); err != nil {
+			closeMonitoringFiles()
+
+			panic(errors.Join(ErrCouldNotCreateFIFO, err))
+		}
+
+		if err := os.Chown(fifoPath, uid, gid); err != nil {
+			closeMonitoringFiles()
+
+			panic(errors.Join(ErrCouldNotChownFIFO, err))
+		}
+
+		// Opening the read end with `O_NONBLOCK` doesn't wait for a writer
+		reader, err := os.OpenFile(fifoPath, os.O_RDONLY|unix.O_NONBLOCK, 0)
+		if err != nil {
+			closeMonitoringFiles()
+
+			panic(errors.Join(ErrCouldNotOpenFIFO, err))
+		}
+		monitoringReaders = append(monitoringReaders, reader)
+
+		// We keep a write end open until Firecracker has exited, otherwise the reader would get an EOF before Firecracker has opened the FIFO
+		writer, err := os.OpenFile(fifoPath, os.O_WRONLY, 0)
+		if err != nil {
+			closeMonitoringFiles()
+
+			panic(errors.Join(ErrCouldNotOpenFIFO, err))
+		}
+		monitoringWriters = append(monitoringWriters, writer)
+
+		monitoringOnLines = append(monitoringOnLines, monitor.onLine)
+		firecrackerArgs = append(firecrackerArgs, monitor.args...)
+	}
+
+	jailerArgs := []string{
 		"--chroot-base-dir",
 		chrootBaseDir,
 		"--uid",
@@ -113,25 +222,79 @@ func StartFirecrackerServer(
 		filepath.Join("/var", "run", "netns", netns),
 		"--cgroup-version",
 		fmt.Sprintf("%v", cgroupVersion),
-		"--cgroup",
-		fmt.Sprintf("cpuset.mems=%v", numaNode),
-		"--cgroup",
-		fmt.Sprintf("cpuset.cpus=%s", cpus),
+	}
+
+	// Explicit cgroup settings take precedence over the cpuset derived from the NUMA node and CPUs
+	cgroupSettings := map[string]string{}
+	if numaNode >= 0 {
+		cgroupSettings["cpuset.mems"] = fmt.Sprintf("%v", numaNode)
+	}
+	if cpus != "" {
+		cgroupSettings["cpuset.cpus"] = cpus
+	}
+	maps.Copy(cgroupSettings, cgroups)
+
+	// We sort the settings so that the jailer applies them in a stable order
+	for _, file := range slices.Sorted(maps.Keys(cgroupSettings)) {
+		jailerArgs = append(jailerArgs, "--cgroup", fmt.Sprintf("%v=%v", file, cgroupSettings[file]))
+	}
+
+	for _, resource := range slices.Sorted(maps.Keys(resourceLimits)) {
+		jailerArgs = append(jailerArgs, "--resource-limit", fmt.Sprintf("%v=%v", resource, resourceLimits[resource]))
+	}
+
+	if parentCgroup != "" {
+		jailerArgs = append(jailerArgs, "--parent-cgroup", parentCgroup)
+	} else {
+		// The jailer uses the basename of the Firecracker binary as the parent cgroup by default
+		parentCgroup = filepath.Base(firecrackerBin)
+	}
+
+	server.CgroupPath = filepath.Join("/", parentCgroup, id)
+
+	jailerArgs = append(
+		jailerArgs,
 		"--id",
 		id,
 		"--exec-file",
 		firecrackerBin,
 		"--",
-		"--api-sock",
-		FirecrackerSocketName,
 	)
 
+	cmd := exec.CommandContext(
+		ctx, // We use ctx, not goroutineManager.Context() here since this resource outlives the function call
+		jailerBin,
+		jailerArgs...,
+	)
+	cmd.Args = append(cmd.Args, firecrackerArgs...)
+
 	if enableOutput {
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 	}
 
-	if enableInput {
+	var consoleInputWriter *os.File
+	if console != nil {
+		if enableOutput {
+			cmd.Stdout = io.MultiWriter(os.Stdout, console)
+		} else {
+			cmd.Stdout = console
+		}
+
+		// We use a pipe instead of setting `cmd.Stdin` to the console, since `cmd.Wait` would otherwise wait for the next input after Firecracker has exited
+		var consoleInputReader *os.File
+		consoleInputReader, consoleInputWriter, err = os.Pipe()
+		if err != nil {
+			closeMonitoringFiles()
+
+			panic(errors.Join(ErrCouldNotCreateConsolePipe, err))
+		}
+		defer consoleInputReader.Close() // Firecracker has its own copy of the read end after `cmd.Start`
+
+		cmd.Stdin = consoleInputReader
+	}
+
+	if enableInput && console == nil {
 		cmd.Stdin = os.Stdin
 	} else {
 		// Don't forward CTRL-C etc. signals from parent to child process
@@ -143,16 +306,81 @@ func StartFirecrackerServer(
 	}
 
 	if err := cmd.Start(); err != nil {
+		closeMonitoringFiles()
+
+		if consoleInputWriter != nil {
+			_ = consoleInputWriter.Close()
+		}
+
 		panic(errors.Join(ErrCouldNotStartFirecrackerServer, err))
 	}
 	server.VMPid = cmd.Process.Pid
 
+	// Reading the console is cancelled once Firecracker has exited so that we don't consume input that is meant for the next VMM
+	consoleInputCtx, cancelConsoleInputCtx := context.WithCancel(context.Background())
+	var consoleInputWg sync.WaitGroup
+	if consoleInputWriter != nil {
+		consoleInputWg.Add(1)
+
+		// It is safe to start a background goroutine here since the wait function cancels reading the console once Firecracker has exited
+		goroutineManager.StartBackgroundGoroutine(func(_ context.Context) {
+			defer consoleInputWg.Done()
+
+			buf := make([]byte, consoleInputBufferSize)
+			for {
+				n, err := console.ReadContext(consoleInputCtx, buf)
+				if n > 0 {
+					if _, err := consoleInputWriter.Write(buf[:n]); err != nil {
+						return // We ignore errors here since they only occur once Firecracker has exited
+					}
+				}
+
+				if err != nil {
+					return // We ignore errors here since they only occur once Firecracker has exited or the console is closed
+				}
+			}
+		})
+	}
+
+	for i, reader := range monitoringReaders {
+		monitoringWg.Add(1)
+
+		// It is safe to start a background goroutine here since the readers reach EOF once Firecracker has exited and
+		// the wait function has closed the write ends
+		goroutineManager.StartBackgroundGoroutine(func(_ context.Context) {
+			defer monitoringWg.Done()
+			defer reader.Close()
+
+			// Metrics and logs are only a side channel, so we stop reading instead of stopping the VM if we can't read them
+			if err := readLines(reader, monitoringOnLines[i]); err != nil {
+				if hook := hooks.OnError; hook != nil {
+					hook(errors.Join(ErrCouldNotReadFIFO, err))
+				}
+			}
+		})
+	}
+
 	var closeLock sync.Mutex
 	closed := false
 
 	// We can only run this once since `cmd.Wait()` releases resources after the first call
 	server.Wait = sync.OnceValue(func() error {
-		if err := cmd.Wait(); err != nil {
+		err := cmd.Wait()
+
+		// Closing the write ends once Firecracker has exited lets the readers process the remaining lines and then reach EOF
+		for _, writer := range monitoringWriters {
+			_ = writer.Close() // We ignore errors here since the FIFO is only used to keep the reader open
+		}
+		monitoringWg.Wait()
+
+		cancelConsoleInputCtx()
+		consoleInputWg.Wait()
+
+		if consoleInputWriter != nil {
+			_ = consoleInputWriter.Close() // We ignore errors here since Firecracker has already exited
+		}
+
+		if err != nil {
 			closeLock.Lock()
 			defer closeLock.Unlock()
 
@@ -219,7 +447,8 @@ func StartFirecrackerServer(
 			if !closed {
 				closed = true
 
-				if err := cmd.Process.Kill(); err != nil {
+				// The process might have already exited by itself, e.g. if the guest was shut down
+				if err := cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
 					closeLock.Unlock()
 
 					return err
@@ -35,27 +35,33 @@ var (
 	ErrCouldNotDeleteLink              = errors.New("could not delete link")
 	ErrCouldNotSetLinkDown             = errors.New("could not set link down")
 	ErrCouldNotDeleteNamespace         = errors.New("could not delete namespace")
+	ErrNoNamespaceInterfaces           = errors.New("at least one namespace interface is required")
 )
 
+// TapInterface is a tap device inside a namespace; each tap device needs its own subnet
+type TapInterface struct {
+	Name string
+
+	Gateway string
+	Netmask uint32
+
+	IP  string
+	MAC string
+}
+
 type Namespace struct {
 	id string
 
-	hostInterface      string
-	namespaceInterface string
-
-	namespaceInterfaceGateway string
-	namespaceInterfaceNetmask uint32
+	hostInterface       string
+	namespaceInterfaces []TapInterface
 
 	hostVethInternalIP string
 	hostVethExternalIP string
 
-	namespaceInterfaceIP string
-	namespaceVethIP      string
+	namespaceVethIP string
 
 	blockedSubnet string
 
-	namespaceInterfaceMAC string
-
 	veth0 string
 	veth1 string
 
@@ -69,42 +75,30 @@ func NewNamespace(
 	id string,
 
 	hostInterface string,
-	namespaceInterface string,
-
-	namespaceInterfaceGateway string,
-	namespaceInterfaceNetmask uint32,
+	namespaceInterfaces []TapInterface,
 
 	hostVethInternalIP string,
 	hostVethExternalIP string,
 
-	namespaceInterfaceIP string,
 	namespaceVethIP string,
 
 	blockedSubnet string,
 
-	namespaceInterfaceMAC string,
-
 	allowIncomingTraffic bool,
 ) *Namespace {
 	return &Namespace{
 		id: id,
 
-		hostInterface:      hostInterface,
-		namespaceInterface: namespaceInterface,
-
-		namespaceInterfaceGateway: namespaceInterfaceGateway,
-		namespaceInterfaceNetmask: namespaceInterfaceNetmask,
+		hostInterface:       hostInterface,
+		namespaceInterfaces: namespaceInterfaces,
 
 		hostVethInternalIP: hostVethInternalIP,
 		hostVethExternalIP: hostVethExternalIP,
 
-		namespaceInterfaceIP: namespaceInterfaceIP,
-		namespaceVethIP:      namespaceVethIP,
+		namespaceVethIP: namespaceVethIP,
 
 		blockedSubnet: blockedSubnet,
 
-		namespaceInterfaceMAC: namespaceInterfaceMAC,
-
 		veth0: fmt.Sprintf("%s-veth0", id),
 		veth1: fmt.Sprintf("%s-veth1", id),
 
@@ -112,7 +106,20 @@ func NewNamespace(
 	}
 }
 
+// The first namespace interface is the primary interface; incoming traffic is only forwarded to it
+func (n *Namespace) primaryInterfaceIP() string {
+	if len(n.namespaceInterfaces) < 1 {
+		return ""
+	}
+
+	return n.namespaceInterfaces[0].IP
+}
+
 func (n *Namespace) Open() error {
+	if len(n.namespaceInterfaces) < 1 {
+		return ErrNoNamespaceInterfaces
+	}
+
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
@@ -129,38 +136,40 @@ func (n *Namespace) Open() error {
 	}
 	defer nsHandle.Close()
 
-	tapIface := &netlink.Tuntap{
-		LinkAttrs: netlink.NewLinkAttrs(),
-	}
+	for _, namespaceInterface := range n.namespaceInterfaces {
+		tapIface := &netlink.Tuntap{
+			LinkAttrs: netlink.NewLinkAttrs(),
+		}
 
-	tapIface.Name = n.namespaceInterface
-	tapIface.Mode = netlink.TUNTAP_MODE_TAP
+		tapIface.Name = namespaceInterface.Name
+		tapIface.Mode = netlink.TUNTAP_MODE_TAP
 
-	if err := netlink.LinkAdd(tapIface); err != nil {
-		return errors.Join(ErrCouldNotAddTapInterface, err)
-	}
+		if err := netlink.LinkAdd(tapIface); err != nil {
+			return errors.Join(ErrCouldNotAddTapInterface, err)
+		}
 
-	gatewaySubnet := fmt.Sprintf("%s/%d", n.namespaceInterfaceGateway, n.namespaceInterfaceNetmask)
-	parsedGatewaySubnet, err := netlink.ParseAddr(gatewaySubnet)
-	if err != nil {
-		return errors.Join(ErrCouldNotParseGatewaySubnet, err)
-	}
+		gatewaySubnet := fmt.Sprintf("%s/%d", namespaceInterface.Gateway, namespaceInterface.Netmask)
+		parsedGatewaySubnet, err := netlink.ParseAddr(gatewaySubnet)
+		if err != nil {
+			return errors.Join(ErrCouldNotParseGatewaySubnet, err)
+		}
 
-	if err := netlink.AddrAdd(tapIface, parsedGatewaySubnet); err != nil {
-		return errors.Join(ErrCouldNotAddAddressToInterface, err)
-	}
+		if err := netlink.AddrAdd(tapIface, parsedGatewaySubnet); err != nil {
+			return errors.Join(ErrCouldNotAddAddressToInterface, err)
+		}
 
-	parsedMAC, err := net.ParseMAC(n.namespaceInterfaceMAC)
-	if err != nil {
-		return errors.Join(ErrCouldNotParseMACAddress, err)
-	}
+		parsedMAC, err := net.ParseMAC(namespaceInterface.MAC)
+		if err != nil {
+			return errors.Join(ErrCouldNotParseMACAddress, err)
+		}
 
-	if err := netlink.LinkSetHardwareAddr(tapIface, parsedMAC); err != nil {
-		return errors.Join(ErrCouldNotSetHardwareAddress, err)
-	}
+		if err := netlink.LinkSetHardwareAddr(tapIface, parsedMAC); err != nil {
+			return errors.Join(ErrCouldNotSetHardwareAddress, err)
+		}
 
-	if err := netlink.LinkSetUp(tapIface); err != nil {
-		return errors.Join(ErrCouldNotSetLinkUp, err)
+		if err := netlink.LinkSetUp(tapIface); err != nil {
+			return errors.Join(ErrCouldNotSetLinkUp, err)
+		}
 	}
 
 	veth0Iface := &netlink.Veth{
@@ -243,20 +252,23 @@ func (n *Namespace) Open() error {
 		return errors.Join(ErrCouldNotNewIPTable, err)
 	}
 
-	if err = iptable.Append("nat", "POSTROUTING", "-o", n.veth0, "-s", n.namespaceInterfaceIP, "-j", "SNAT", "--to", n.namespaceVethIP); err != nil {
-		return errors.Join(ErrCouldNotAppendIPTableRule, err)
+	// All interfaces share the namespace veth for outgoing traffic
+	for _, namespaceInterface := range n.namespaceInterfaces {
+		if err = iptable.Append("nat", "POSTROUTING", "-o", n.veth0, "-s", namespaceInterface.IP, "-j", "SNAT", "--to", n.namespaceVethIP); err != nil {
+			return errors.Join(ErrCouldNotAppendIPTableRule, err)
+		}
 	}
 
-	if err := iptable.Append("nat", "PREROUTING", "-i", n.veth0, "-d", n.namespaceVethIP, "-j", "DNAT", "--to", n.namespaceInterfaceIP); err != nil {
+	if err := iptable.Append("nat", "PREROUTING", "-i", n.veth0, "-d", n.namespaceVethIP, "-j", "DNAT", "--to", n.primaryInterfaceIP()); err != nil {
 		return errors.Join(ErrCouldNotAppendIPTableRule, err)
 	}
 
 	if n.allowIncomingTraffic {
-		if err := iptable.Append("nat", "PREROUTING", "-d", n.hostVethInternalIP, "-j", "DNAT", "--to-destination", n.namespaceInterfaceIP); err != nil {
+		if err := iptable.Append("nat", "PREROUTING", "-d", n.hostVethInternalIP, "-j", "DNAT", "--to-destination", n.primaryInterfaceIP()); err != nil {
 			return errors.Join(ErrCouldNotAppendIPTableRule, err)
 		}
 
-		if err := iptable.Append("nat", "POSTROUTING", "-d", n.namespaceInterfaceIP, "-j", "MASQUERADE"); err != nil {
+		if err := iptable.Append("nat", "POSTROUTING", "-d", n.primaryInterfaceIP(), "-j", "MASQUERADE"); err != nil {
 			return errors.Join(ErrCouldNotAppendIPTableRule, err)
 		}
 	}
@@ -340,21 +352,23 @@ func (n *Namespace) Close() error {
 	}
 
 	if n.allowIncomingTraffic {
-		if err := iptable.Append("nat", "PREROUTING", "-d", n.hostVethInternalIP, "-j", "DNAT", "--to-destination", n.namespaceInterfaceIP); err != nil {
+		if err := iptable.Append("nat", "PREROUTING", "-d", n.hostVethInternalIP, "-j", "DNAT", "--to-destination", n.primaryInterfaceIP()); err != nil {
 			return errors.Join(ErrCouldNotAppendIPTableRule, err)
 		}
 
-		if err := iptable.Append("nat", "POSTROUTING", "-d", n.namespaceInterfaceIP, "-j", "MASQUERADE"); err != nil {
+		if err := iptable.Append("nat", "POSTROUTING", "-d", n.primaryInterfaceIP(), "-j", "MASQUERADE"); err != nil {
 			return errors.Join(ErrCouldNotAppendIPTableRule, err)
 		}
 	}
 
-	if err := iptable.Delete("nat", "PREROUTING", "-i", n.veth0, "-d", n.namespaceVethIP, "-j", "DNAT", "--to", n.namespaceInterfaceIP); err != nil {
+	if err := iptable.Delete("nat", "PREROUTING", "-i", n.veth0, "-d", n.namespaceVethIP, "-j", "DNAT", "--to", n.primaryInterfaceIP()); err != nil {
 		return errors.Join(ErrCouldNotDeleteIPTableRule, err)
 	}
 
-	if err := iptable.Delete("nat", "POSTROUTING", "-o", n.veth0, "-s", n.namespaceInterfaceIP, "-j", "SNAT", "--to", n.namespaceVethIP); err != nil {
-		return errors.Join(ErrCouldNotDeleteIPTableRule, err)
+	for _, namespaceInterface := range n.namespaceInterfaces {
+		if err := iptable.Delete("nat", "POSTROUTING", "-o", n.veth0, "-s", namespaceInterface.IP, "-j", "SNAT", "--to", n.namespaceVethIP); err != nil {
+			return errors.Join(ErrCouldNotDeleteIPTableRule, err)
+		}
 	}
 
 	if n.parsedDefaultAddress != nil {
@@ -383,17 +397,19 @@ func (n *Namespace) Close() error {
 		return errors.Join(ErrCouldNotSetOriginalNamespace, err)
 	}
 
-	tapIface, err := netlink.LinkByName(n.namespaceInterface)
-	if err != nil {
-		return errors.Join(ErrCouldNotAddTapInterface, err)
-	}
+	for _, namespaceInterface := range n.namespaceInterfaces {
+		tapIface, err := netlink.LinkByName(namespaceInterface.Name)
+		if err != nil {
+			return errors.Join(ErrCouldNotAddTapInterface, err)
+		}
 
-	if err = netlink.LinkSetDown(tapIface); err != nil {
-		return errors.Join(ErrCouldNotSetLinkDown, err)
-	}
+		if err = netlink.LinkSetDown(tapIface); err != nil {
+			return errors.Join(ErrCouldNotSetLinkDown, err)
+		}
 
-	if err := netlink.LinkDel(tapIface); err != nil {
-		return errors.Join(ErrCouldNotDeleteLink, err)
+		if err := netlink.LinkDel(tapIface); err != nil {
+			return errors.Join(ErrCouldNotDeleteLink, err)
+		}
 	}
 
 	return netns.DeleteNamed(n.id)
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"io"
 	"os"
+
+	"golang.org/x/sys/unix"
 )
 
 var (
@@ -11,6 +13,9 @@ var (
 	ErrCouldNotCreateDestinationFile = errors.New("could not create destination file")
 	ErrCouldNotCopyFileContent       = errors.New("could not copy file content")
 	ErrCouldNotChangeFileOwner       = errors.New("could not change file owner")
+	ErrCouldNotGetFileInfo           = errors.New("could not get file info")
+	ErrCouldNotResizeDestinationFile = errors.New("could not resize destination file")
+	ErrCouldNotFindDataInSourceFile  = errors.New("could not find data in source file")
 )
 
 func CopyFile(src, dst string, uid int, gid int) (int64, error) {
@@ -26,9 +31,9 @@ func CopyFile(src, dst string, uid int, gid int) (int64, error) {
 	}
 	defer dstFile.Close()
 
-	n, err := io.Copy(dstFile, srcFile)
+	n, err := CopySparse(dstFile, srcFile)
 	if err != nil {
-		return 0, errors.Join(ErrCouldNotCopyFileContent, err)
+		return 0, err
 	}
 
 	if err := os.Chown(dst, uid, gid); err != nil {
@@ -37,3 +42,94 @@ func CopyFile(src, dst string, uid int, gid int) (int64, error) {
 
 	return n, nil
 }
+
+// CopySparse copies all of `src` to the start of `dst` and returns the size of `src`. If both are regular files, it creates a reflink
+// (sharing the data blocks on filesystems like Btrfs or XFS), or if that isn't supported, it only copies the data regions of `src` and leaves
+// its holes as holes in `dst`. `dst` is resized to the size of `src`. Block devices can't have holes, so they are copied byte by byte.
+// Afterwards, the offset of `dst` is at its end so that padding can be appended.
+func CopySparse(dst, src *os.File) (int64, error) {
+	srcInfo, err := src.Stat()
+	if err != nil {
+		return 0, errors.Join(ErrCouldNotGetFileInfo, err)
+	}
+
+	dstInfo, err := dst.Stat()
+	if err != nil {
+		return 0, errors.Join(ErrCouldNotGetFileInfo, err)
+	}
+
+	if !srcInfo.Mode().IsRegular() || !dstInfo.Mode().IsRegular() {
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return 0, errors.Join(ErrCouldNotCopyFileContent, err)
+		}
+
+		if _, err := dst.Seek(0, io.SeekStart); err != nil {
+			return 0, errors.Join(ErrCouldNotCopyFileContent, err)
+		}
+
+		n, err := io.Copy(dst, src)
+		if err != nil {
+			return 0, errors.Join(ErrCouldNotCopyFileContent, err)
+		}
+
+		return n, nil
+	}
+
+	size := srcInfo.Size()
+
+	// Reflinks replace the entire content of `dst`, so we don't need to resize it; they fail if the files are on different filesystems
+	// or if the filesystem doesn't support them, in which case we fall back to copying the data
+	if err := unix.IoctlFileClone(int(dst.Fd()), int(src.Fd())); err == nil {
+		if _, err := dst.Seek(size, io.SeekStart); err != nil {
+			return 0, errors.Join(ErrCouldNotCopyFileContent, err)
+		}
+
+		return size, nil
+	}
+
+	// Truncating to 0 first removes any previous content, so that every region that we don't write to is a hole
+	if err := dst.Truncate(0); err != nil {
+		return 0, errors.Join(ErrCouldNotResizeDestinationFile, err)
+	}
+
+	if err := dst.Truncate(size); err != nil {
+		return 0, errors.Join(ErrCouldNotResizeDestinationFile, err)
+	}
+
+	fd := int(src.Fd())
+	for offset := int64(0); offset < size; {
+		dataStart, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// There is no more data after `offset`
+			break
+		}
+
+		dataEnd := size
+		if errors.Is(err, unix.EINVAL) {
+			// The filesystem doesn't support `SEEK_DATA`, so we treat the rest of the file as data
+			dataStart = offset
+		} else if err != nil {
+			return 0, errors.Join(ErrCouldNotFindDataInSourceFile, err)
+		} else {
+			dataEnd, err = unix.Seek(fd, dataStart, unix.SEEK_HOLE)
+			if err != nil {
+				return 0, errors.Join(ErrCouldNotFindDataInSourceFile, err)
+			}
+		}
+
+		if _, err := io.Copy(
+			io.NewOffsetWriter(dst, dataStart),
+			io.NewSectionReader(src, dataStart, dataEnd-dataStart),
+		); err != nil {
+			return 0, errors.Join(ErrCouldNotCopyFileContent, err)
+		}
+
+		offset = dataEnd
+	}
+
+	if _, err := dst.Seek(size, io.SeekStart); err != nil {
+		return 0, errors.Join(ErrCouldNotCopyFileContent, err)
+	}
+
+	return size, nil
+}
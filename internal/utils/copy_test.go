@@ -0,0 +1,226 @@
+package utils_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/loopholelabs/drafter/internal/utils"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Regions are large enough to span multiple filesystem blocks, so that holes are kept as holes
+	regionSize = 1024 * 1024
+)
+
+// createSparseFile writes a file with one region of random data for every `true` in `regions` and a hole for every `false`
+func createSparseFile(t *testing.T, path string, regions []bool) []byte {
+	t.Helper()
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	content := make([]byte, len(regions)*regionSize)
+	for i, data := range regions {
+		if !data {
+			continue
+		}
+
+		region := content[i*regionSize : (i+1)*regionSize]
+		if _, err := rand.Read(region); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := f.WriteAt(region, int64(i*regionSize)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := f.Truncate(int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+
+	return content
+}
+
+// createNonEmptyFile writes a file that is larger than the files that are copied to it and doesn't contain any holes
+func createNonEmptyFile(t *testing.T, path string, size int) {
+	t.Helper()
+
+	if err := os.WriteFile(path, bytes.Repeat([]byte{0xff}, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// isHole returns whether the region at `offset` doesn't contain any data
+func isHole(t *testing.T, f *os.File, offset int64) bool {
+	t.Helper()
+
+	dataStart, err := unix.Seek(int(f.Fd()), offset, unix.SEEK_DATA)
+	if errors.Is(err, unix.ENXIO) {
+		return true
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dataStart >= offset+regionSize
+}
+
+// supportsReflinks returns whether files in `dir` can be cloned with `FICLONE`
+func supportsReflinks(t *testing.T, dir string) bool {
+	t.Helper()
+
+	src, err := os.Create(filepath.Join(dir, "reflink-src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	defer os.Remove(src.Name())
+
+	dst, err := os.Create(filepath.Join(dir, "reflink-dst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	defer os.Remove(dst.Name())
+
+	return unix.IoctlFileClone(int(dst.Fd()), int(src.Fd())) == nil
+}
+
+func copySparse(t *testing.T, dstPath, srcPath string) *os.File {
+	t.Helper()
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = dst.Close()
+	})
+
+	srcInfo, err := src.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := utils.CopySparse(dst, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != srcInfo.Size() {
+		t.Errorf("copied %v bytes, want %v", n, srcInfo.Size())
+	}
+
+	offset, err := dst.Seek(0, io.SeekCurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if offset != srcInfo.Size() {
+		t.Errorf("destination offset is %v after copying, want %v", offset, srcInfo.Size())
+	}
+
+	return dst
+}
+
+func checkCopy(t *testing.T, dst *os.File, expected []byte, regions []bool) {
+	t.Helper()
+
+	actual, err := os.ReadFile(dst.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(actual, expected) {
+		t.Fatalf("destination has %v bytes that differ from the source's %v bytes", len(actual), len(expected))
+	}
+
+	for i, data := range regions {
+		if !data && !isHole(t, dst, int64(i*regionSize)) {
+			t.Errorf("region %v of the destination is a hole in the source, but contains data", i)
+		}
+	}
+}
+
+func TestCopySparse(t *testing.T) {
+	for name, regions := range map[string][]bool{
+		"holes at start and end": {false, true, false, true, false},
+		"hole at start":          {false, false, true},
+		"hole at end":            {true, true, false},
+		"only data":              {true, true},
+		"only holes":             {false, false, false},
+		"empty":                  {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			srcPath := filepath.Join(dir, "src")
+			expected := createSparseFile(t, srcPath, regions)
+
+			// The destination is larger than the source and doesn't have holes, so its previous content must be removed
+			dstPath := filepath.Join(dir, "dst")
+			createNonEmptyFile(t, dstPath, (len(regions)+2)*regionSize)
+
+			checkCopy(t, copySparse(t, dstPath, srcPath), expected, regions)
+		})
+	}
+}
+
+func TestCopySparseFallsBackToCopyingDataRegions(t *testing.T) {
+	srcDir := t.TempDir()
+
+	// Reflinks can't be created between filesystems, so if the temporary directory supports them, we copy to another one
+	dstDir := t.TempDir()
+	if supportsReflinks(t, srcDir) {
+		var err error
+		dstDir, err = os.MkdirTemp("/dev/shm", "drafter")
+		if err != nil {
+			t.Skip("temporary directory supports reflinks and there is no other filesystem to copy to:", err)
+		}
+		defer os.RemoveAll(dstDir)
+	}
+
+	regions := []bool{false, true, false, false, true, false}
+
+	srcPath := filepath.Join(srcDir, "src")
+	expected := createSparseFile(t, srcPath, regions)
+
+	dstPath := filepath.Join(dstDir, "dst")
+	createNonEmptyFile(t, dstPath, (len(regions)+2)*regionSize)
+
+	checkCopy(t, copySparse(t, dstPath, srcPath), expected, regions)
+}
+
+func TestCopySparseReflinks(t *testing.T) {
+	dir := t.TempDir()
+	if !supportsReflinks(t, dir) {
+		t.Skip("temporary directory doesn't support reflinks")
+	}
+
+	regions := []bool{false, true, true, false}
+
+	srcPath := filepath.Join(dir, "src")
+	expected := createSparseFile(t, srcPath, regions)
+
+	dstPath := filepath.Join(dir, "dst")
+	createNonEmptyFile(t, dstPath, (len(regions)+2)*regionSize)
+
+	checkCopy(t, copySparse(t, dstPath, srcPath), expected, regions)
+}
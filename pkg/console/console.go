@@ -0,0 +1,232 @@
+package console
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+const (
+	// DetachKey (CTRL-]) detaches an attached client from the console without sending the key to the VM
+	DetachKey = 0x1d
+
+	// Number of output chunks that are queued for an attached client; clients that can't keep up miss output
+	clientOutputQueueLength = 1024
+)
+
+type ConsoleConfiguration struct {
+	// Number of bytes of output to keep in memory, which are replayed to attaching clients and sent along with migrations
+	HistorySize int `json:"historySize"`
+
+	// Path of a file to append the output to; disabled if empty
+	LogPath string `json:"logPath"`
+	// Size (in bytes) after which the log file is rotated; never rotated if 0
+	LogMaxSize int64 `json:"logMaxSize"`
+	// Number of rotated log files to keep
+	LogMaxFiles int `json:"logMaxFiles"`
+}
+
+// Console is the serial console of a VM: the VMM writes the guest's output to it and reads the guest's input from it,
+// while clients can attach to it to see the output and send input
+type Console struct {
+	history *RingBuffer
+	log     *RotatingFile
+	logErr  error
+
+	// Input is handed to readers one chunk at a time so that readers can stop waiting for input without consuming any
+	input        chan []byte
+	pendingInput []byte
+	inputLock    chan struct{}
+
+	clients     map[chan []byte]struct{}
+	clientsLock sync.Mutex
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewConsole(consoleConfiguration ConsoleConfiguration) (*Console, error) {
+	console := &Console{
+		history: NewRingBuffer(consoleConfiguration.HistorySize),
+
+		input:     make(chan []byte),
+		inputLock: make(chan struct{}, 1),
+
+		clients: map[chan []byte]struct{}{},
+
+		closed: make(chan struct{}),
+	}
+
+	if consoleConfiguration.LogPath != "" {
+		var err error
+		console.log, err = OpenRotatingFile(consoleConfiguration.LogPath, consoleConfiguration.LogMaxSize, consoleConfiguration.LogMaxFiles)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return console, nil
+}
+
+// Write adds output of the VM to the history and log file and sends it to the attached clients.
+// It never fails so that the VM doesn't block on its output; errors writing the log file are returned by `Close`.
+func (c *Console) Write(p []byte) (int, error) {
+	c.clientsLock.Lock()
+	defer c.clientsLock.Unlock()
+
+	_, _ = c.history.Write(p) // Writing to the ring buffer can't fail
+
+	if c.log != nil && c.logErr == nil {
+		if _, err := c.log.Write(p); err != nil {
+			c.logErr = err
+		}
+	}
+
+	if len(c.clients) > 0 {
+		chunk := bytes.Clone(p) // The caller may reuse `p` after we return
+
+		for output := range c.clients {
+			select {
+			case output <- chunk:
+			default:
+			}
+		}
+	}
+
+	return len(p), nil
+}
+
+// Read returns input of the attached clients for the VM
+func (c *Console) Read(p []byte) (int, error) {
+	return c.ReadContext(context.Background(), p)
+}
+
+// ReadContext is like `Read`, but returns `ctx.Err()` once `ctx` is cancelled without consuming any input, e.g. so that input
+// isn't lost once the VMM that reads it has exited
+func (c *Console) ReadContext(ctx context.Context, p []byte) (int, error) {
+	select {
+	case c.inputLock <- struct{}{}:
+	case <-c.closed:
+		return 0, io.EOF
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	defer func() {
+		<-c.inputLock
+	}()
+
+	if len(c.pendingInput) == 0 {
+		select {
+		case c.pendingInput = <-c.input:
+		case <-c.closed:
+			return 0, io.EOF
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	n := copy(p, c.pendingInput)
+	c.pendingInput = c.pendingInput[n:]
+
+	return n, nil
+}
+
+// History returns the last output of the VM
+func (c *Console) History() []byte {
+	return c.history.Bytes()
+}
+
+// Attach replays the history to `conn`, then sends the VM's output to it and its input to the VM until `conn` is closed,
+// the client sends the `DetachKey` or the console is closed. The VM keeps running after detaching. The caller needs to close
+// `conn` after Attach returns.
+func (c *Console) Attach(conn io.ReadWriter) error {
+	output := make(chan []byte, clientOutputQueueLength)
+
+	// We take the history while holding the clients lock so that the client neither misses nor duplicates any output
+	c.clientsLock.Lock()
+	history := c.history.Bytes()
+	c.clients[output] = struct{}{}
+	c.clientsLock.Unlock()
+
+	defer func() {
+		c.clientsLock.Lock()
+		delete(c.clients, output)
+		c.clientsLock.Unlock()
+	}()
+
+	if _, err := conn.Write(history); err != nil {
+		return errors.Join(ErrCouldNotWriteHistory, err)
+	}
+
+	inputDone := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			if n > 0 {
+				input, _, detach := bytes.Cut(buf[:n], []byte{DetachKey})
+				if len(input) > 0 {
+					select {
+					// `buf` is reused for the next read, so the reader needs its own copy of the input
+					case c.input <- bytes.Clone(input):
+
+					case <-c.closed:
+						inputDone <- nil
+
+						return
+					}
+				}
+
+				if detach {
+					inputDone <- nil
+
+					return
+				}
+			}
+
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					inputDone <- nil
+				} else {
+					inputDone <- errors.Join(ErrCouldNotReadInput, err)
+				}
+
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case chunk := <-output:
+			if _, err := conn.Write(chunk); err != nil {
+				return errors.Join(ErrCouldNotWriteOutput, err)
+			}
+
+		case err := <-inputDone:
+			return err
+
+		case <-c.closed:
+			return nil
+		}
+	}
+}
+
+// Close detaches all clients, makes `Read` return `io.EOF` and closes the log file
+func (c *Console) Close() (errs error) {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+
+		c.clientsLock.Lock()
+		defer c.clientsLock.Unlock()
+
+		errs = c.logErr
+		if c.log != nil {
+			errs = errors.Join(errs, c.log.Close())
+		}
+	})
+
+	return
+}
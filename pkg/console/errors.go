@@ -0,0 +1,18 @@
+package console
+
+import "errors"
+
+var (
+	ErrCouldNotCreateLogDirectory = errors.New("could not create log directory")
+	ErrCouldNotOpenLogFile        = errors.New("could not open log file")
+	ErrCouldNotStatLogFile        = errors.New("could not stat log file")
+	ErrCouldNotRotateLogFile      = errors.New("could not rotate log file")
+	ErrCouldNotWriteLogFile       = errors.New("could not write log file")
+	ErrCouldNotCloseLogFile       = errors.New("could not close log file")
+	ErrCouldNotWriteHistory       = errors.New("could not write history to client")
+	ErrCouldNotWriteOutput        = errors.New("could not write output to client")
+	ErrCouldNotReadInput          = errors.New("could not read input from client")
+	ErrCouldNotWriteInput         = errors.New("could not write input to VM")
+	ErrCouldNotListenOnSocket     = errors.New("could not listen on console socket")
+	ErrCouldNotAcceptClient       = errors.New("could not accept console client")
+)
@@ -0,0 +1,57 @@
+package console
+
+import "sync"
+
+// RingBuffer keeps the last `size` bytes that were written to it
+type RingBuffer struct {
+	buf  []byte
+	pos  int
+	full bool
+
+	lock sync.Mutex
+}
+
+func NewRingBuffer(size int) *RingBuffer {
+	return &RingBuffer{
+		buf: make([]byte, max(size, 0)),
+	}
+}
+
+func (r *RingBuffer) Write(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	size := len(r.buf)
+	if size == 0 {
+		return len(p), nil
+	}
+
+	n := len(p)
+
+	// Only the tail of writes that are larger than the buffer can fit
+	if len(p) >= size {
+		p = p[len(p)-size:]
+	}
+
+	copied := copy(r.buf[r.pos:], p)
+	copy(r.buf, p[copied:])
+
+	if r.pos+len(p) >= size {
+		r.full = true
+	}
+	r.pos = (r.pos + len(p)) % size
+
+	return n, nil
+}
+
+// Bytes returns a copy of the buffer's content, from oldest to newest
+func (r *RingBuffer) Bytes() []byte {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !r.full {
+		return append([]byte{}, r.buf[:r.pos]...)
+	}
+
+	return append(append([]byte{}, r.buf[r.pos:]...), r.buf[:r.pos]...)
+}
@@ -0,0 +1,129 @@
+package console
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+)
+
+type ConsoleServer struct {
+	SocketPath string
+
+	Wait  func() error
+	Close func() error
+}
+
+// StartConsoleServer listens on a UNIX socket and attaches every client that connects to it to `console`,
+// e.g. with `socat -,raw,echo=0 UNIX-CONNECT:$socketPath`
+func StartConsoleServer(
+	socketPath string,
+
+	console *Console,
+) (consoleServer *ConsoleServer, err error) {
+	consoleServer = &ConsoleServer{
+		SocketPath: socketPath,
+
+		Wait: func() error {
+			return nil
+		},
+		Close: func() error {
+			return nil
+		},
+	}
+
+	lis, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotListenOnSocket, err)
+	}
+
+	var (
+		connsLock sync.Mutex
+		conns     = map[net.Conn]struct{}{}
+		connsWg   sync.WaitGroup
+
+		closeLock sync.Mutex
+		closed    bool
+	)
+
+	acceptErr := make(chan error, 1)
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				closeLock.Lock()
+				defer closeLock.Unlock()
+
+				if closed { // Don't treat closed errors as errors if we closed the listener
+					acceptErr <- nil
+				} else {
+					acceptErr <- errors.Join(ErrCouldNotAcceptClient, err)
+				}
+
+				return
+			}
+
+			// We need to check this here since `Close` might have already closed all tracked connections
+			closeLock.Lock()
+			if closed {
+				closeLock.Unlock()
+
+				_ = conn.Close()
+
+				continue
+			}
+
+			connsLock.Lock()
+			conns[conn] = struct{}{}
+			connsWg.Add(1)
+			connsLock.Unlock()
+
+			closeLock.Unlock()
+
+			go func() {
+				defer connsWg.Done()
+
+				// Errors of individual clients (e.g. a client disconnecting while we write to it) don't affect the console or other clients
+				_ = console.Attach(conn)
+
+				connsLock.Lock()
+				delete(conns, conn)
+				connsLock.Unlock()
+
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	consoleServer.Wait = sync.OnceValue(func() error {
+		err := <-acceptErr
+
+		connsWg.Wait()
+
+		return err
+	})
+
+	consoleServer.Close = func() error {
+		closeLock.Lock()
+
+		if !closed {
+			closed = true
+
+			_ = lis.Close() // We ignore errors here since we might interrupt an `Accept()`
+
+			connsLock.Lock()
+			for conn := range conns {
+				_ = conn.Close() // Detaches the client
+			}
+			connsLock.Unlock()
+
+			_ = os.Remove(socketPath) // We ignore errors here since the file might already have been removed, but we don't want to use `RemoveAll` cause it could remove a directory
+		}
+
+		closeLock.Unlock()
+
+		return consoleServer.Wait()
+	}
+
+	return
+}
@@ -0,0 +1,182 @@
+package cpuset
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/lithammer/shortuuid/v4"
+	"golang.org/x/sys/unix"
+)
+
+type Allocation struct {
+	ID  string `json:"id"`
+	PID int    `json:"pid"`
+
+	NUMANode int   `json:"numaNode"`
+	CPUs     []int `json:"cpus"`
+}
+
+type allocatorState struct {
+	Allocations []Allocation `json:"allocations"`
+}
+
+// Allocator hands out non-overlapping CPUs to VMs on the same host. Its state is kept in a file that is locked while
+// it is being changed, so multiple processes (e.g. concurrently running peers) can share an allocator by using the same state file.
+// Allocations of processes that have exited without releasing them (e.g. because they crashed) are reclaimed automatically.
+type Allocator struct {
+	statePath string
+}
+
+func NewAllocator(statePath string) *Allocator {
+	return &Allocator{
+		statePath: statePath,
+	}
+}
+
+// Allocate reserves `count` free CPUs on `numaNode` for the current process, or on the NUMA node with the most free CPUs if `numaNode` is `AutoNUMANode`
+func (a *Allocator) Allocate(count int, numaNode int) (allocation *Allocation, err error) {
+	if count <= 0 {
+		return nil, errors.Join(ErrInvalidCPUCount, fmt.Errorf("CPU count %v", count))
+	}
+
+	nodes, err := GetNUMANodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.update(func(state *allocatorState) error {
+		allocated := map[int]struct{}{}
+		for _, allocation := range state.Allocations {
+			for _, cpu := range allocation.CPUs {
+				allocated[cpu] = struct{}{}
+			}
+		}
+
+		var (
+			candidateNode = NoNUMANode
+			candidateCPUs []int
+			found         bool
+		)
+		for _, node := range nodes {
+			// Hosts without NUMA information have all CPUs in a single pseudo-node, which we treat as node 0
+			if id := max(node.ID, 0); numaNode >= 0 && id != numaNode {
+				continue
+			}
+
+			free := []int{}
+			for _, cpu := range node.CPUs {
+				if _, ok := allocated[cpu]; !ok {
+					free = append(free, cpu)
+				}
+			}
+
+			if len(free) >= count && (!found || len(free) > len(candidateCPUs)) {
+				candidateNode = node.ID
+				candidateCPUs = free
+				found = true
+			}
+		}
+
+		if !found {
+			return errors.Join(ErrNotEnoughFreeCPUs, fmt.Errorf("requested %v CPUs", count))
+		}
+
+		allocation = &Allocation{
+			ID:  shortuuid.New(),
+			PID: os.Getpid(),
+
+			NUMANode: candidateNode,
+			CPUs:     candidateCPUs[:count],
+		}
+
+		state.Allocations = append(state.Allocations, *allocation)
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return allocation, nil
+}
+
+// Release returns the CPUs of `allocation` to the allocator; releasing an allocation that was already released is a no-op
+func (a *Allocator) Release(allocation *Allocation) error {
+	return a.update(func(state *allocatorState) error {
+		state.Allocations = slices.DeleteFunc(state.Allocations, func(candidate Allocation) bool {
+			return candidate.ID == allocation.ID
+		})
+
+		return nil
+	})
+}
+
+// Allocations returns all active allocations on the host
+func (a *Allocator) Allocations() (allocations []Allocation, err error) {
+	if err := a.update(func(state *allocatorState) error {
+		allocations = slices.Clone(state.Allocations)
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return allocations, nil
+}
+
+func (a *Allocator) update(change func(state *allocatorState) error) error {
+	if err := os.MkdirAll(filepath.Dir(a.statePath), os.ModePerm); err != nil {
+		return errors.Join(ErrCouldNotCreateStateDir, err)
+	}
+
+	file, err := os.OpenFile(a.statePath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Join(ErrCouldNotOpenStateFile, err)
+	}
+	defer file.Close()
+
+	if err := unix.Flock(int(file.Fd()), unix.LOCK_EX); err != nil {
+		return errors.Join(ErrCouldNotLockStateFile, err)
+	}
+	defer unix.Flock(int(file.Fd()), unix.LOCK_UN)
+
+	rawState, err := io.ReadAll(file)
+	if err != nil {
+		return errors.Join(ErrCouldNotReadStateFile, err)
+	}
+
+	var state allocatorState
+	if len(rawState) > 0 {
+		if err := json.Unmarshal(rawState, &state); err != nil {
+			return errors.Join(ErrCouldNotDecodeAllocatorState, err)
+		}
+	}
+
+	// We reclaim the allocations of processes that have exited without releasing them
+	state.Allocations = slices.DeleteFunc(state.Allocations, func(allocation Allocation) bool {
+		return errors.Is(unix.Kill(allocation.PID, 0), unix.ESRCH)
+	})
+
+	if err := change(&state); err != nil {
+		return err
+	}
+
+	rawState, err = json.Marshal(state)
+	if err != nil {
+		return errors.Join(ErrCouldNotWriteStateFile, err)
+	}
+
+	if err := file.Truncate(0); err != nil {
+		return errors.Join(ErrCouldNotWriteStateFile, err)
+	}
+
+	if _, err := file.WriteAt(rawState, 0); err != nil {
+		return errors.Join(ErrCouldNotWriteStateFile, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,21 @@
+package cpuset
+
+import "errors"
+
+var (
+	ErrInvalidCPUList               = errors.New("invalid CPU list")
+	ErrCouldNotReadNUMANodes        = errors.New("could not read NUMA nodes")
+	ErrCouldNotReadNUMACPUList      = errors.New("could not read NUMA CPU list")
+	ErrCouldNotReadNUMAMemoryInfo   = errors.New("could not read NUMA memory info")
+	ErrCouldNotReadOnlineCPUs       = errors.New("could not read online CPUs")
+	ErrUnknownNUMANode              = errors.New("unknown NUMA node")
+	ErrInvalidNUMANode              = errors.New("invalid NUMA node")
+	ErrInvalidCPUCount              = errors.New("invalid CPU count")
+	ErrNotEnoughFreeCPUs            = errors.New("not enough free CPUs")
+	ErrCouldNotCreateStateDir       = errors.New("could not create allocator state directory")
+	ErrCouldNotOpenStateFile        = errors.New("could not open allocator state file")
+	ErrCouldNotLockStateFile        = errors.New("could not lock allocator state file")
+	ErrCouldNotReadStateFile        = errors.New("could not read allocator state file")
+	ErrCouldNotWriteStateFile       = errors.New("could not write allocator state file")
+	ErrCouldNotDecodeAllocatorState = errors.New("could not decode allocator state")
+)
@@ -0,0 +1,67 @@
+package cpuset
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ParseList parses a CPU list in the kernel's format (e.g. `0-3,8,10-11`) into a sorted list of unique CPUs
+func ParseList(list string) ([]int, error) {
+	cpus := []int{}
+
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return cpus, nil
+	}
+
+	for _, part := range strings.Split(list, ",") {
+		rawStart, rawEnd, isRange := strings.Cut(strings.TrimSpace(part), "-")
+
+		start, err := strconv.Atoi(rawStart)
+		if err != nil || start < 0 {
+			return nil, errors.Join(ErrInvalidCPUList, fmt.Errorf("invalid CPU %q", rawStart))
+		}
+
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(rawEnd)
+			if err != nil || end < start {
+				return nil, errors.Join(ErrInvalidCPUList, fmt.Errorf("invalid CPU range %q", part))
+			}
+		}
+
+		for cpu := start; cpu <= end; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+
+	slices.Sort(cpus)
+
+	return slices.Compact(cpus), nil
+}
+
+// FormatList formats CPUs in the kernel's CPU list format, collapsing consecutive CPUs into ranges
+func FormatList(cpus []int) string {
+	sorted := slices.Compact(slices.Sorted(slices.Values(cpus)))
+
+	parts := []string{}
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] == sorted[j]+1 {
+			j++
+		}
+
+		if i == j {
+			parts = append(parts, strconv.Itoa(sorted[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%v-%v", sorted[i], sorted[j]))
+		}
+
+		i = j + 1
+	}
+
+	return strings.Join(parts, ",")
+}
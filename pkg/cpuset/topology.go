@@ -0,0 +1,276 @@
+package cpuset
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// NoNUMANode is the ID of the pseudo-node that contains all online CPUs on systems without NUMA information (e.g. in some containers)
+	NoNUMANode = -1
+
+	// AutoNUMANode lets `Place` and `Allocator.Allocate` choose the NUMA node
+	AutoNUMANode = -1
+	// AutoNUMANodeName is the name of `AutoNUMANode` for `ParseNUMANode`
+	AutoNUMANodeName = "auto"
+)
+
+var (
+	nodesPath     = filepath.Join("/sys", "devices", "system", "node")
+	onlineCPUPath = filepath.Join("/sys", "devices", "system", "cpu", "online")
+)
+
+type NUMANode struct {
+	ID   int
+	CPUs []int
+
+	// Free memory of the node in bytes; 0 if unknown
+	FreeMemory uint64
+}
+
+// GetNUMANodes returns the host's NUMA nodes sorted by ID. If the host doesn't expose NUMA information,
+// a single node with the ID `NoNUMANode` that contains all online CPUs is returned instead.
+func GetNUMANodes() ([]NUMANode, error) {
+	entries, err := os.ReadDir(nodesPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, errors.Join(ErrCouldNotReadNUMANodes, err)
+		}
+
+		entries = nil
+	}
+
+	nodes := []NUMANode{}
+	for _, entry := range entries {
+		rawID, ok := strings.CutPrefix(entry.Name(), "node")
+		if !ok {
+			continue
+		}
+
+		id, err := strconv.Atoi(rawID)
+		if err != nil {
+			continue
+		}
+
+		rawCPUs, err := os.ReadFile(filepath.Join(nodesPath, entry.Name(), "cpulist"))
+		if err != nil {
+			return nil, errors.Join(ErrCouldNotReadNUMACPUList, err)
+		}
+
+		cpus, err := ParseList(string(rawCPUs))
+		if err != nil {
+			return nil, errors.Join(ErrCouldNotReadNUMACPUList, err)
+		}
+
+		// Memory-only nodes (e.g. CXL memory) can't run the VMM
+		if len(cpus) == 0 {
+			continue
+		}
+
+		freeMemory, err := getFreeMemory(filepath.Join(nodesPath, entry.Name(), "meminfo"))
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, NUMANode{
+			ID:   id,
+			CPUs: cpus,
+
+			FreeMemory: freeMemory,
+		})
+	}
+
+	if len(nodes) > 0 {
+		slices.SortFunc(nodes, func(a, b NUMANode) int {
+			return a.ID - b.ID
+		})
+
+		return nodes, nil
+	}
+
+	cpus, err := getOnlineCPUs()
+	if err != nil {
+		return nil, err
+	}
+
+	return []NUMANode{
+		{
+			ID:   NoNUMANode,
+			CPUs: cpus,
+		},
+	}, nil
+}
+
+// ParseNUMANode parses a NUMA node ID, or `AutoNUMANodeName` (or -1) for `AutoNUMANode`
+func ParseNUMANode(raw string) (int, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == AutoNUMANodeName {
+		return AutoNUMANode, nil
+	}
+
+	numaNode, err := strconv.Atoi(raw)
+	if err != nil || numaNode < AutoNUMANode {
+		return AutoNUMANode, errors.Join(ErrInvalidNUMANode, fmt.Errorf("NUMA node %q", raw))
+	}
+
+	return numaNode, nil
+}
+
+// MostFreeMemoryNUMANode returns the node with the most free memory, preferring the node with the lower ID if nodes have the same amount.
+// CPUs that have already been allocated to other VMMs aren't taken into account; use an `Allocator` for this.
+func MostFreeMemoryNUMANode(nodes []NUMANode) (NUMANode, error) {
+	if len(nodes) == 0 {
+		return NUMANode{}, ErrUnknownNUMANode
+	}
+
+	mostFreeMemory := nodes[0]
+	for _, node := range nodes[1:] {
+		if node.FreeMemory > mostFreeMemory.FreeMemory {
+			mostFreeMemory = node
+		}
+	}
+
+	return mostFreeMemory, nil
+}
+
+// Place resolves the NUMA node and CPUs to run a VMM on. If `cpus` is set, the VMM is pinned to them and
+// `numaNode` is only used to bind its memory (or, if `numaNode` is `AutoNUMANode`, the node that contains all of the CPUs is used).
+// If `cpus` is empty, the VMM is pinned to all CPUs of `numaNode`, or of the node with the most free memory if `numaNode` is
+// `AutoNUMANode`; this doesn't take CPUs that are already used by other VMMs into account.
+// The returned node is `NoNUMANode` if the VMM's memory shouldn't be bound to a node, e.g. on hosts without NUMA information.
+func Place(numaNode int, cpus []int) (int, []int, error) {
+	nodes, err := GetNUMANodes()
+	if err != nil {
+		return NoNUMANode, nil, err
+	}
+
+	if len(cpus) > 0 {
+		if numaNode >= 0 {
+			if nodes[0].ID == NoNUMANode {
+				return NoNUMANode, cpus, nil
+			}
+
+			if !slices.ContainsFunc(nodes, func(node NUMANode) bool {
+				return node.ID == numaNode
+			}) {
+				return NoNUMANode, nil, errors.Join(ErrUnknownNUMANode, fmt.Errorf("NUMA node %v", numaNode))
+			}
+
+			return numaNode, cpus, nil
+		}
+
+		for _, node := range nodes {
+			if node.ID == NoNUMANode {
+				continue
+			}
+
+			if !slices.ContainsFunc(cpus, func(cpu int) bool {
+				return !slices.Contains(node.CPUs, cpu)
+			}) {
+				return node.ID, cpus, nil
+			}
+		}
+
+		// The CPUs span multiple nodes, so we don't bind the memory to any of them
+		return NoNUMANode, cpus, nil
+	}
+
+	if numaNode < 0 {
+		node, err := MostFreeMemoryNUMANode(nodes)
+		if err != nil {
+			return NoNUMANode, nil, err
+		}
+
+		return node.ID, node.CPUs, nil
+	}
+
+	// Hosts without NUMA information have all CPUs in a single pseudo-node, which we treat as node 0
+	if nodes[0].ID == NoNUMANode {
+		if numaNode != 0 {
+			return NoNUMANode, nil, errors.Join(ErrUnknownNUMANode, fmt.Errorf("NUMA node %v", numaNode))
+		}
+
+		return NoNUMANode, nodes[0].CPUs, nil
+	}
+
+	for _, node := range nodes {
+		if node.ID == numaNode {
+			return node.ID, node.CPUs, nil
+		}
+	}
+
+	return NoNUMANode, nil, errors.Join(ErrUnknownNUMANode, fmt.Errorf("NUMA node %v", numaNode))
+}
+
+func getFreeMemory(meminfoPath string) (uint64, error) {
+	file, err := os.Open(meminfoPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+
+		return 0, errors.Join(ErrCouldNotReadNUMAMemoryInfo, err)
+	}
+	defer file.Close()
+
+	// Lines have the format `Node 0 MemFree:        12345678 kB`
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[2] != "MemFree:" {
+			continue
+		}
+
+		freeMemory, err := strconv.ParseUint(fields[3], 10, 64)
+		if err != nil {
+			return 0, errors.Join(ErrCouldNotReadNUMAMemoryInfo, err)
+		}
+
+		return freeMemory * 1024, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, errors.Join(ErrCouldNotReadNUMAMemoryInfo, err)
+	}
+
+	return 0, nil
+}
+
+func getOnlineCPUs() ([]int, error) {
+	rawCPUs, err := os.ReadFile(onlineCPUPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, errors.Join(ErrCouldNotReadOnlineCPUs, err)
+		}
+
+		// Without sysfs, we fall back to the CPUs that this process may run on
+		var set unix.CPUSet
+		if err := unix.SchedGetaffinity(0, &set); err != nil {
+			return nil, errors.Join(ErrCouldNotReadOnlineCPUs, err)
+		}
+
+		cpus := []int{}
+		for cpu := 0; len(cpus) < set.Count(); cpu++ {
+			if set.IsSet(cpu) {
+				cpus = append(cpus, cpu)
+			}
+		}
+
+		return cpus, nil
+	}
+
+	cpus, err := ParseList(string(rawCPUs))
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotReadOnlineCPUs, err)
+	}
+
+	return cpus, nil
+}
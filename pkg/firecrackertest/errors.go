@@ -0,0 +1,43 @@
+package firecrackertest
+
+import "errors"
+
+var (
+	ErrCouldNotListenOnAPISocket     = errors.New("could not listen on API socket")
+	ErrCouldNotListenOnVSock         = errors.New("could not listen on VSock")
+	ErrCouldNotServeAPI              = errors.New("could not serve API")
+	ErrCouldNotParseFlags            = errors.New("could not parse flags")
+	ErrMissingAPISocket              = errors.New("missing API socket")
+	ErrMissingJailerID               = errors.New("missing jailer ID")
+	ErrMissingExecFile               = errors.New("missing exec file")
+	ErrCouldNotCreateChrootDirectory = errors.New("could not create chroot directory")
+	ErrCouldNotChangeDirectory       = errors.New("could not change directory")
+	ErrCouldNotExecFirecracker       = errors.New("could not exec Firecracker")
+	ErrCouldNotStartServer           = errors.New("could not start server")
+	ErrCouldNotOpenMetricsFile       = errors.New("could not open metrics file")
+	ErrCouldNotOpenLogFile           = errors.New("could not open log file")
+
+	ErrInstanceNotStarted            = errors.New("instance is not started")
+	ErrInstanceAlreadyStarted        = errors.New("operation is not supported after the instance has been started")
+	ErrInstanceNotPaused             = errors.New("instance must be paused")
+	ErrMissingBootSource             = errors.New("missing boot source")
+	ErrMissingMachineConfig          = errors.New("missing machine config")
+	ErrUnknownAction                 = errors.New("unknown action type")
+	ErrUnknownVMState                = errors.New("unknown VM state")
+	ErrUnknownSnapshotType           = errors.New("unknown snapshot type")
+	ErrUnknownMemoryBackend          = errors.New("unknown memory backend type")
+	ErrMsyncRequiresSharedSnapshot   = errors.New("msync snapshots require a snapshot that was loaded with a shared memory backend")
+	ErrDiffRequiresDirtyPageTracking = errors.New("diff snapshots require a snapshot that was loaded with dirty page tracking")
+	ErrIDMismatch                    = errors.New("ID in path does not match ID in body")
+	ErrUnknownDrive                  = errors.New("unknown drive")
+	ErrUnknownNetworkInterface       = errors.New("unknown network interface")
+	ErrMissingBalloon                = errors.New("balloon device is not configured")
+	ErrUnknownMMDSVersion            = errors.New("unknown MMDS version")
+	ErrBalloonStatisticsDisabled     = errors.New("balloon statistics are not enabled")
+	ErrCouldNotDecodeBody            = errors.New("could not decode request body")
+	ErrCouldNotStatFile              = errors.New("could not stat file")
+	ErrCouldNotWriteState            = errors.New("could not write state file")
+	ErrCouldNotReadState             = errors.New("could not read state file")
+	ErrCouldNotWriteMemory           = errors.New("could not write memory file")
+	ErrCouldNotWriteMetrics          = errors.New("could not write metrics")
+)
@@ -0,0 +1,108 @@
+package firecrackertest
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// RunFirecracker emulates the `firecracker` binary by serving the fake API on `--api-sock` until `ctx` is cancelled
+// or the guest is shut down. The console output of the emulated guest is written to `console`.
+func RunFirecracker(
+	ctx context.Context,
+
+	args []string,
+	console io.Writer,
+
+	hooks ServerHooks,
+) error {
+	fs := flag.NewFlagSet("firecracker", flag.ContinueOnError)
+
+	apiSock := fs.String("api-sock", "", "Path to the API socket")
+
+	// We accept (but ignore) the remaining flags that Firecracker supports so that callers don't need to special-case us
+	_ = fs.String("id", "", "MicroVM unique identifier")
+	_ = fs.String("level", "", "Log level")
+	logPath := fs.String("log-path", "", "Path to a fifo or a file used for logging")
+	metricsPath := fs.String("metrics-path", "", "Path to a fifo or a file used for metrics")
+	_ = fs.Bool("show-level", false, "Whether to show the log level")
+	_ = fs.Bool("show-log-origin", false, "Whether to show the log origin")
+	_ = fs.Bool("boot-timer", false, "Whether to enable the boot timer device")
+	_ = fs.Bool("no-seccomp", false, "Whether to disable seccomp filtering")
+
+	if err := fs.Parse(args); err != nil {
+		return errors.Join(ErrCouldNotParseFlags, err)
+	}
+
+	if strings.TrimSpace(*apiSock) == "" {
+		return ErrMissingAPISocket
+	}
+
+	instanceStart := hooks.OnInstanceStart
+	hooks.OnInstanceStart = func() {
+		fmt.Fprintln(console, "Booting fake guest kernel")
+
+		if instanceStart != nil {
+			instanceStart()
+		}
+	}
+
+	snapshotLoad := hooks.OnSnapshotLoad
+	hooks.OnSnapshotLoad = func(statePath, memoryPath string, resumed bool) {
+		fmt.Fprintln(console, "Restored fake guest from snapshot")
+
+		if snapshotLoad != nil {
+			snapshotLoad(statePath, memoryPath, resumed)
+		}
+	}
+
+	// Like Firecracker, we don't create the metrics and log files, since they are usually FIFOs that the caller reads from
+	var metricsOutput, logOutput io.Writer
+	if strings.TrimSpace(*metricsPath) != "" {
+		metricsFile, err := os.OpenFile(*metricsPath, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return errors.Join(ErrCouldNotOpenMetricsFile, err)
+		}
+		defer metricsFile.Close()
+
+		metricsOutput = metricsFile
+	}
+
+	if strings.TrimSpace(*logPath) != "" {
+		logFile, err := os.OpenFile(*logPath, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return errors.Join(ErrCouldNotOpenLogFile, err)
+		}
+		defer logFile.Close()
+
+		logOutput = logFile
+	}
+
+	server, err := StartServer(*apiSock, metricsOutput, logOutput, hooks)
+	if err != nil {
+		return errors.Join(ErrCouldNotStartServer, err)
+	}
+	defer server.Close()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Wait()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return server.Close()
+
+	case <-server.Stopped():
+		fmt.Fprintln(console, "Fake guest is shutting down")
+
+		return server.Close()
+
+	case err := <-serveErr:
+		return err
+	}
+}
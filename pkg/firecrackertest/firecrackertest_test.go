@@ -0,0 +1,441 @@
+package firecrackertest_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	iutils "github.com/loopholelabs/drafter/internal/utils"
+	"github.com/loopholelabs/drafter/pkg/console"
+	"github.com/loopholelabs/drafter/pkg/firecrackertest"
+	"github.com/loopholelabs/drafter/pkg/hypervisor"
+	"github.com/loopholelabs/drafter/pkg/ipc"
+	"github.com/loopholelabs/drafter/pkg/packager"
+	"github.com/loopholelabs/drafter/pkg/runner"
+	"github.com/loopholelabs/drafter/pkg/snapshotter"
+	"github.com/pojntfx/panrpc/go/pkg/rpc"
+)
+
+const (
+	// The test binary runs as the fake jailer and Firecracker if it is started through links with these names
+	fakeJailerName      = "drafter-fake-jailer"
+	fakeFirecrackerName = "drafter-fake-firecracker"
+
+	livenessVSockPort = 25
+	agentVSockPort    = 26
+
+	readyCommand = "true"
+	// The fake agent doesn't reply to the first probe with this command, so it only succeeds if the attempt times out and is retried
+	hangOnceCommand = "hang-once"
+
+	resumeTimeout = time.Second * 10
+)
+
+func TestMain(m *testing.M) {
+	switch filepath.Base(os.Args[0]) {
+	case fakeJailerName:
+		if err := firecrackertest.RunJailer(os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+
+			os.Exit(1)
+		}
+
+	case fakeFirecrackerName:
+		if err := runFakeFirecracker(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// runFakeFirecracker runs the fake Firecracker with an emulated guest that pings the liveness server after booting and
+// connects to the agent server after booting and after being resumed, like `drafter-liveness` and `drafter-agent` would
+func runFakeFirecracker() error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	return firecrackertest.RunFirecracker(
+		ctx,
+
+		os.Args[1:],
+		os.Stdout,
+
+		firecrackertest.ServerHooks{
+			OnInstanceStart: func() {
+				go func() {
+					conn, err := dialHostVSock(livenessVSockPort)
+					if err != nil {
+						fmt.Fprintln(os.Stderr, "Could not send liveness ping:", err)
+
+						return
+					}
+					_ = conn.Close()
+
+					if err := runFakeAgent(ctx); err != nil {
+						fmt.Fprintln(os.Stderr, "Fake agent stopped:", err)
+					}
+				}()
+			},
+			OnSnapshotLoad: func(statePath, memoryPath string, resumed bool) {
+				go func() {
+					if err := runFakeAgent(ctx); err != nil {
+						fmt.Fprintln(os.Stderr, "Fake agent stopped:", err)
+					}
+				}()
+			},
+		},
+	)
+}
+
+// dialHostVSock connects to a VSock listener on the host; the fake Firecracker runs in the VM's directory, which is where
+// the VSock's UNIX sockets are
+func dialHostVSock(port uint32) (net.Conn, error) {
+	return net.Dial("unix", fmt.Sprintf("%s_%d", snapshotter.VSockName, port))
+}
+
+var probeHung atomic.Bool
+
+func runFakeAgent(ctx context.Context) error {
+	conn, err := dialHostVSock(agentVSockPort)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	registry := rpc.NewRegistry[struct{}, json.RawMessage](
+		ipc.NewAgentClient(
+			struct{}{},
+
+			func(ctx context.Context) error {
+				return nil
+			},
+			func(ctx context.Context) error {
+				return nil
+			},
+			nil,
+			func(ctx context.Context, command string) error {
+				switch command {
+				case readyCommand:
+					return nil
+
+				case hangOnceCommand:
+					if probeHung.CompareAndSwap(false, true) {
+						<-ctx.Done()
+
+						return ctx.Err()
+					}
+
+					return nil
+
+				default:
+					return fmt.Errorf("unknown command %q", command)
+				}
+			},
+		),
+
+		&rpc.RegistryHooks{},
+	)
+
+	encoder := json.NewEncoder(conn)
+	decoder := json.NewDecoder(conn)
+
+	return registry.LinkStream(
+		ctx,
+
+		func(v rpc.Message[json.RawMessage]) error {
+			return encoder.Encode(v)
+		},
+		func(v *rpc.Message[json.RawMessage]) error {
+			return decoder.Decode(v)
+		},
+
+		func(v any) (json.RawMessage, error) {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+
+			return json.RawMessage(b), nil
+		},
+		func(data json.RawMessage, v any) error {
+			return json.Unmarshal([]byte(data), v)
+		},
+
+		nil,
+	)
+}
+
+func readState(t *testing.T, statePath string) firecrackertest.VMState {
+	t.Helper()
+
+	stateFile, err := os.Open(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stateFile.Close()
+
+	// The state device is padded, so we can't use `json.Unmarshal`
+	var state firecrackertest.VMState
+	if err := json.NewDecoder(stateFile).Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+
+	return state
+}
+
+func TestCreateSnapshotAndResume(t *testing.T) {
+	testBin, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		binDir     = t.TempDir()
+		inputDir   = t.TempDir()
+		packageDir = t.TempDir()
+	)
+
+	// We can't use `t.TempDir` for the chroots since the VSock's UNIX socket paths would be too long
+	chrootBaseDir, err := os.MkdirTemp("", "drafter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(chrootBaseDir)
+
+	jailerBin := filepath.Join(binDir, fakeJailerName)
+	if err := os.Symlink(testBin, jailerBin); err != nil {
+		t.Fatal(err)
+	}
+
+	firecrackerBin := filepath.Join(binDir, fakeFirecrackerName)
+	if err := os.Symlink(testBin, firecrackerBin); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, size := range map[string]int{
+		packager.KernelName: 1024,
+		packager.DiskName:   1024 * 1024,
+	} {
+		if err := os.WriteFile(filepath.Join(inputDir, name), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	vmConsole, err := console.NewConsole(console.ConsoleConfiguration{
+		HistorySize: 4096,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vmConsole.Close()
+
+	hypervisorConfiguration := snapshotter.HypervisorConfiguration{
+		FirecrackerBin: firecrackerBin,
+		JailerBin:      jailerBin,
+
+		ChrootBaseDir: chrootBaseDir,
+
+		UID: os.Getuid(),
+		GID: os.Getgid(),
+
+		NumaNode:      -1,
+		CgroupVersion: 2,
+	}
+	hypervisorRuntime := snapshotter.HypervisorRuntime{
+		Console: vmConsole,
+	}
+
+	devices := []snapshotter.SnapshotDevice{}
+	for _, name := range []string{packager.StateName, packager.MemoryName, packager.KernelName, packager.DiskName, packager.ConfigName} {
+		device := snapshotter.SnapshotDevice{
+			Name:   name,
+			Output: filepath.Join(packageDir, name),
+		}
+
+		switch name {
+		case packager.KernelName, packager.DiskName:
+			device.Input = filepath.Join(inputDir, name)
+		}
+
+		if name == packager.DiskName {
+			device.RateLimiter = &hypervisor.RateLimiter{
+				Bandwidth: &hypervisor.TokenBucket{
+					Size:       1024 * 1024,
+					RefillTime: time.Second,
+				},
+			}
+		}
+
+		devices = append(devices, device)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := snapshotter.CreateSnapshot(
+		ctx,
+
+		devices,
+
+		snapshotter.VMConfiguration{
+			CPUCount:   1,
+			MemorySize: 16,
+			BootArgs:   "console=ttyS0",
+		},
+		snapshotter.LivenessConfiguration{
+			LivenessVSockPort: livenessVSockPort,
+			ResumeTimeout:     resumeTimeout,
+		},
+		snapshotter.ReadinessConfiguration{
+			Probes: []snapshotter.ReadinessProbe{
+				{
+					Type:    snapshotter.ReadinessProbeTypeExec,
+					Command: readyCommand,
+				},
+				{
+					Type:    snapshotter.ReadinessProbeTypeExec,
+					Command: hangOnceCommand,
+				},
+			},
+			Interval:       time.Millisecond * 100,
+			AttemptTimeout: time.Millisecond * 500,
+		},
+
+		hypervisorConfiguration,
+		hypervisorRuntime,
+		snapshotter.NetworkConfiguration{},
+		snapshotter.AgentConfiguration{
+			AgentVSockPort: agentVSockPort,
+			ResumeTimeout:  resumeTimeout,
+		},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if history := string(vmConsole.History()); !strings.Contains(history, "Booting fake guest kernel") {
+		t.Errorf("console history %q doesn't contain the fake guest's boot message", history)
+	}
+
+	state := readState(t, filepath.Join(packageDir, packager.StateName))
+	if state.State != firecrackertest.StatePaused {
+		t.Errorf("snapshot has VM state %q, want %q", state.State, firecrackertest.StatePaused)
+	}
+
+	if rateLimiter := state.Drives[packager.DiskName].RateLimiter; rateLimiter == nil || rateLimiter.Bandwidth == nil || rateLimiter.Bandwidth.Size != 1024*1024 {
+		t.Errorf("snapshot has disk rate limiter %+v, want a bandwidth bucket of 1 MiB", rateLimiter)
+	}
+
+	memoryInfo, err := os.Stat(filepath.Join(packageDir, packager.MemoryName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if memoryInfo.Size() < 16*1024*1024 {
+		t.Errorf("memory device has %v bytes, want at least %v", memoryInfo.Size(), 16*1024*1024)
+	}
+
+	packageConfigFile, err := os.Open(filepath.Join(packageDir, packager.ConfigName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer packageConfigFile.Close()
+
+	// The config device is padded, so we can't use `json.Unmarshal`
+	var packageConfig snapshotter.PackageConfiguration
+	if err := json.NewDecoder(packageConfigFile).Decode(&packageConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	if packageConfig.FirecrackerVersion != firecrackertest.FirecrackerVersion {
+		t.Errorf("package has Firecracker version %q, want %q", packageConfig.FirecrackerVersion, firecrackertest.FirecrackerVersion)
+	}
+
+	if packageConfig.AgentVSockPort != agentVSockPort {
+		t.Errorf("package has agent VSock port %v, want %v", packageConfig.AgentVSockPort, agentVSockPort)
+	}
+
+	r, err := runner.StartRunner[struct{}, ipc.AgentServerRemote[struct{}]](
+		ctx,
+		context.Background(),
+
+		hypervisorConfiguration,
+		hypervisorRuntime,
+
+		packager.StateName,
+		packager.MemoryName,
+
+		hypervisor.MonitoringHooks{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	for _, device := range devices {
+		if _, err := iutils.CopyFile(device.Output, filepath.Join(r.VMPath, device.Name), hypervisorConfiguration.UID, hypervisorConfiguration.GID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resumedRunner, err := r.Resume(
+		ctx,
+
+		resumeTimeout,
+		resumeTimeout,
+		packageConfig.AgentVSockPort,
+
+		struct{}{},
+		ipc.AgentServerAcceptHooks[ipc.AgentServerRemote[struct{}], struct{}]{},
+
+		runner.SnapshotLoadConfiguration{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Buckets that aren't set are removed, so this replaces the bandwidth limit with an operations limit
+	if err := resumedRunner.UpdateDriveRateLimiter(ctx, packager.DiskName, &hypervisor.RateLimiter{
+		Ops: &hypervisor.TokenBucket{
+			Size:       100,
+			RefillTime: time.Second,
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A nil rate limiter leaves the limits unchanged
+	if err := resumedRunner.UpdateDriveRateLimiter(ctx, packager.DiskName, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := resumedRunner.SuspendAndCloseAgentServer(ctx, resumeTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	state = readState(t, filepath.Join(r.VMPath, packager.StateName))
+	if state.State != firecrackertest.StatePaused {
+		t.Errorf("suspended VM has state %q, want %q", state.State, firecrackertest.StatePaused)
+	}
+
+	if rateLimiter := state.Drives[packager.DiskName].RateLimiter; rateLimiter == nil || rateLimiter.Bandwidth != nil || rateLimiter.Ops == nil || rateLimiter.Ops.Size != 100 {
+		t.Errorf("suspended VM has disk rate limiter %+v, want only an operations bucket of 100", rateLimiter)
+	}
+}
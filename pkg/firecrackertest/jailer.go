@@ -0,0 +1,81 @@
+package firecrackertest
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+type repeatedFlag []string
+
+func (r *repeatedFlag) String() string {
+	return strings.Join(*r, ",")
+}
+
+func (r *repeatedFlag) Set(value string) error {
+	*r = append(*r, value)
+
+	return nil
+}
+
+// RunJailer emulates the `jailer` binary without requiring root privileges: instead of chrooting, it changes
+// into the directory that the jailer would have chrooted into and replaces itself with `--exec-file`, so that
+// the PID and the socket paths seen by `firecracker.StartFirecrackerServer` match those of a real jailer.
+// On success, RunJailer never returns.
+func RunJailer(args []string) error {
+	fs := flag.NewFlagSet("jailer", flag.ContinueOnError)
+
+	chrootBaseDir := fs.String("chroot-base-dir", "/srv/jailer", "Base directory for the chroot")
+	id := fs.String("id", "", "Jail ID")
+	execFile := fs.String("exec-file", "", "Path to the Firecracker binary")
+
+	// We accept (but ignore) the isolation flags since we don't isolate the process
+	_ = fs.Int("uid", 0, "UID to switch to")
+	_ = fs.Int("gid", 0, "GID to switch to")
+	_ = fs.String("netns", "", "Network namespace to join")
+	_ = fs.Int("cgroup-version", 1, "Cgroup version")
+	_ = fs.String("parent-cgroup", "", "Parent cgroup")
+	_ = fs.Bool("daemonize", false, "Whether to daemonize")
+	_ = fs.Bool("new-pid-ns", false, "Whether to create a new PID namespace")
+
+	var cgroups, resourceLimits repeatedFlag
+	fs.Var(&cgroups, "cgroup", "Cgroup and value to set")
+	fs.Var(&resourceLimits, "resource-limit", "Resource limit to set")
+
+	if err := fs.Parse(args); err != nil {
+		return errors.Join(ErrCouldNotParseFlags, err)
+	}
+
+	if strings.TrimSpace(*id) == "" {
+		return ErrMissingJailerID
+	}
+
+	if strings.TrimSpace(*execFile) == "" {
+		return ErrMissingExecFile
+	}
+
+	// We need an absolute path since we change the working directory before exec'ing
+	firecrackerBin, err := filepath.Abs(*execFile)
+	if err != nil {
+		return errors.Join(ErrCouldNotExecFirecracker, err)
+	}
+
+	root := filepath.Join(*chrootBaseDir, filepath.Base(firecrackerBin), *id, "root")
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return errors.Join(ErrCouldNotCreateChrootDirectory, err)
+	}
+
+	if err := os.Chdir(root); err != nil {
+		return errors.Join(ErrCouldNotChangeDirectory, err)
+	}
+
+	// `fs.Args()` contains everything after the `--` separator, which are the arguments for Firecracker
+	if err := syscall.Exec(firecrackerBin, append([]string{firecrackerBin}, fs.Args()...), os.Environ()); err != nil {
+		return errors.Join(ErrCouldNotExecFirecracker, err)
+	}
+
+	return nil
+}
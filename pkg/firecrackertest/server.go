@@ -0,0 +1,989 @@
+package firecrackertest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	v1 "github.com/loopholelabs/drafter/internal/api/http/firecracker/v1"
+)
+
+const (
+	FirecrackerVersion = "1.8.0-drafter-fake"
+
+	StateNotStarted = "Not started"
+	StateRunning    = "Running"
+	StatePaused     = "Paused"
+)
+
+// The state of the emulated VM, which is also what gets written to the state file of a snapshot
+type VMState struct {
+	State string `json:"state"`
+
+	BootSource        *v1.BootSource                 `json:"bootSource"`
+	Drives            map[string]v1.Drive            `json:"drives"`
+	MachineConfig     *v1.MachineConfig              `json:"machineConfig"`
+	VSock             *v1.VSock                      `json:"vsock"`
+	NetworkInterfaces map[string]v1.NetworkInterface `json:"networkInterfaces"`
+	Balloon           *v1.Balloon                    `json:"balloon"`
+	MMDSConfig        *v1.MMDSConfig                 `json:"mmdsConfig"`
+
+	// Only set if the VM was restored from a snapshot
+	MemoryBackendPath string `json:"memoryBackendPath"`
+	SharedMemory      bool   `json:"sharedMemory"`
+	TrackDirtyPages   bool   `json:"trackDirtyPages"`
+}
+
+type ServerHooks struct {
+	OnInstanceStart   func()
+	OnInstanceStop    func()
+	OnSnapshotCreate  func(snapshotType, statePath, memoryPath string)
+	OnSnapshotLoad    func(statePath, memoryPath string, resumed bool)
+	OnVMStateChange   func(state string)
+	OnRequestReceived func(method, resource string)
+}
+
+type Server struct {
+	SocketPath string
+
+	Wait  func() error
+	Close func() error
+
+	hooks ServerHooks
+
+	metricsOutput io.Writer
+	logOutput     io.Writer
+
+	stopped chan struct{}
+
+	vm     VMState
+	vmLock sync.Mutex
+
+	// Like with Firecracker, the MMDS data store isn't part of the VM state, so it is empty after loading a snapshot
+	mmds map[string]any
+
+	// Hooks are queued while the VM lock is held and called once it has been released
+	pendingHooks []func()
+
+	vsockLis net.Listener
+}
+
+// StartServer serves the subset of the Firecracker API that `internal/firecracker` uses on a UNIX socket,
+// keeping track of the VM configuration and writing plausible state and memory files for snapshots.
+// If set, metrics are written to `metricsOutput` on `FlushMetrics` actions and log messages to `logOutput`.
+func StartServer(
+	socketPath string,
+
+	metricsOutput io.Writer,
+	logOutput io.Writer,
+
+	hooks ServerHooks,
+) (server *Server, err error) {
+	server = &Server{
+		SocketPath: socketPath,
+
+		Wait: func() error {
+			return nil
+		},
+		Close: func() error {
+			return nil
+		},
+
+		hooks: hooks,
+
+		metricsOutput: metricsOutput,
+		logOutput:     logOutput,
+
+		stopped: make(chan struct{}),
+
+		vm: VMState{
+			State: StateNotStarted,
+
+			Drives:            map[string]v1.Drive{},
+			NetworkInterfaces: map[string]v1.NetworkInterface{},
+		},
+	}
+
+	lis, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotListenOnAPISocket, err)
+	}
+
+	srv := &http.Server{
+		Handler: server.handler(),
+	}
+
+	var (
+		closeLock sync.Mutex
+		closed    bool
+	)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(lis)
+	}()
+
+	server.writeLog("INFO", "Running Firecracker v"+FirecrackerVersion)
+
+	server.Wait = sync.OnceValue(func() error {
+		if err := <-serveErr; err != nil {
+			closeLock.Lock()
+			defer closeLock.Unlock()
+
+			if closed && errors.Is(err, http.ErrServerClosed) { // Don't treat closed errors as errors if we closed the server
+				return nil
+			}
+
+			return errors.Join(ErrCouldNotServeAPI, err)
+		}
+
+		return nil
+	})
+
+	server.Close = func() error {
+		closeLock.Lock()
+
+		if !closed {
+			closed = true
+
+			_ = srv.Close() // We ignore errors here since we might interrupt a network connection
+
+			server.vmLock.Lock()
+			server.closeVSock()
+			server.vmLock.Unlock()
+
+			_ = os.Remove(socketPath) // We ignore errors here since the file might already have been removed, but we don't want to use `RemoveAll` cause it could remove a directory
+		}
+
+		closeLock.Unlock()
+
+		return server.Wait()
+	}
+
+	return
+}
+
+// Stopped is closed once the guest requested a shutdown, e.g. after a `SendCtrlAltDel` action
+func (s *Server) Stopped() <-chan struct{} {
+	return s.stopped
+}
+
+// State returns a copy of the current state of the emulated VM
+func (s *Server) State() VMState {
+	s.vmLock.Lock()
+	defer s.vmLock.Unlock()
+
+	return s.copyState()
+}
+
+// MMDS returns a copy of the MMDS data store
+func (s *Server) MMDS() map[string]any {
+	s.vmLock.Lock()
+	defer s.vmLock.Unlock()
+
+	return maps.Clone(s.mmds) // We don't need a deep copy since `mergePatch` doesn't modify nested objects in place
+}
+
+// DialHostVSock connects to a listener on the host side of the VSock like a guest-initiated connection would,
+// which makes it possible to emulate guest components such as `drafter-liveness` and `drafter-agent`
+func (s *Server) DialHostVSock(port uint32) (net.Conn, error) {
+	s.vmLock.Lock()
+	vsock := s.vm.VSock
+	s.vmLock.Unlock()
+
+	if vsock == nil {
+		return nil, ErrInstanceNotStarted
+	}
+
+	return net.Dial("unix", fmt.Sprintf("%s_%d", vsock.UDSPath, port))
+}
+
+func (s *Server) copyState() VMState {
+	vm := s.vm
+
+	vm.Drives = maps.Clone(s.vm.Drives)
+	vm.NetworkInterfaces = maps.Clone(s.vm.NetworkInterfaces)
+
+	return vm
+}
+
+func (s *Server) setState(state string) {
+	s.vm.State = state
+
+	s.writeLog("INFO", "Changed VM state to "+state)
+
+	if hook := s.hooks.OnVMStateChange; hook != nil {
+		s.pendingHooks = append(s.pendingHooks, func() {
+			hook(state)
+		})
+	}
+}
+
+func (s *Server) openVSock() error {
+	s.closeVSock()
+
+	if s.vm.VSock == nil {
+		return nil
+	}
+
+	// Firecracker listens on the UDS path for host-initiated connections; we accept and drop them since there is no guest
+	lis, err := net.Listen("unix", s.vm.VSock.UDSPath)
+	if err != nil {
+		return errors.Join(ErrCouldNotListenOnVSock, err)
+	}
+	s.vsockLis = lis
+
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+
+			_ = conn.Close()
+		}
+	}()
+
+	return nil
+}
+
+func (s *Server) closeVSock() {
+	if s.vsockLis != nil {
+		_ = s.vsockLis.Close()
+		_ = os.Remove(s.vsockLis.Addr().String())
+
+		s.vsockLis = nil
+	}
+}
+
+func writeFault(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(v1.Error{
+		FaultMessage: err.Error(),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// handle decodes the request body into `body` and calls `fn` with the VM lock held
+func handle[T any](s *Server, fn func(r *http.Request, body *T) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if hook := s.hooks.OnRequestReceived; hook != nil {
+			hook(r.Method, r.URL.Path)
+		}
+
+		var body T
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			writeFault(w, http.StatusBadRequest, errors.Join(ErrCouldNotDecodeBody, err))
+
+			return
+		}
+
+		s.vmLock.Lock()
+		err := fn(r, &body)
+		pendingHooks := s.pendingHooks
+		s.pendingHooks = nil
+		s.vmLock.Unlock()
+
+		for _, hook := range pendingHooks {
+			hook()
+		}
+
+		if err != nil {
+			writeFault(w, http.StatusBadRequest, err)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) requireNotStarted() error {
+	if s.vm.State != StateNotStarted {
+		return ErrInstanceAlreadyStarted
+	}
+
+	return nil
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		s.vmLock.Lock()
+		state := s.vm.State
+		s.vmLock.Unlock()
+
+		writeJSON(w, v1.InstanceInfo{
+			AppName:    "Firecracker",
+			ID:         "anonymous-instance",
+			State:      state,
+			VMMVersion: FirecrackerVersion,
+		})
+	})
+
+	mux.HandleFunc("GET /version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, v1.FirecrackerVersion{
+			FirecrackerVersion: FirecrackerVersion,
+		})
+	})
+
+	mux.HandleFunc("PUT /boot-source", handle(s, func(r *http.Request, body *v1.BootSource) error {
+		if err := s.requireNotStarted(); err != nil {
+			return err
+		}
+
+		s.vm.BootSource = body
+
+		return nil
+	}))
+
+	mux.HandleFunc("PUT /drives/{id}", handle(s, func(r *http.Request, body *v1.Drive) error {
+		if err := s.requireNotStarted(); err != nil {
+			return err
+		}
+
+		if body.DriveID != r.PathValue("id") {
+			return ErrIDMismatch
+		}
+
+		if _, err := os.Stat(body.PathOnHost); err != nil {
+			return errors.Join(ErrCouldNotStatFile, err)
+		}
+
+		// Like Firecracker, we disable buckets with a size or refill time of 0
+		body.RateLimiter = mergeRateLimiter(nil, body.RateLimiter)
+
+		s.vm.Drives[body.DriveID] = *body
+
+		return nil
+	}))
+
+	mux.HandleFunc("PATCH /drives/{id}", handle(s, func(r *http.Request, body *v1.PartialDrive) error {
+		if s.vm.State == StateNotStarted {
+			return ErrInstanceNotStarted
+		}
+
+		if body.DriveID != r.PathValue("id") {
+			return ErrIDMismatch
+		}
+
+		drive, ok := s.vm.Drives[body.DriveID]
+		if !ok {
+			return ErrUnknownDrive
+		}
+
+		if body.PathOnHost != "" {
+			if _, err := os.Stat(body.PathOnHost); err != nil {
+				return errors.Join(ErrCouldNotStatFile, err)
+			}
+
+			drive.PathOnHost = body.PathOnHost
+		}
+
+		drive.RateLimiter = mergeRateLimiter(drive.RateLimiter, body.RateLimiter)
+
+		s.vm.Drives[body.DriveID] = drive
+
+		return nil
+	}))
+
+	mux.HandleFunc("PUT /machine-config", handle(s, func(r *http.Request, body *v1.MachineConfig) error {
+		if err := s.requireNotStarted(); err != nil {
+			return err
+		}
+
+		s.vm.MachineConfig = body
+
+		return nil
+	}))
+
+	mux.HandleFunc("PUT /vsock", handle(s, func(r *http.Request, body *v1.VSock) error {
+		if err := s.requireNotStarted(); err != nil {
+			return err
+		}
+
+		s.vm.VSock = body
+
+		return nil
+	}))
+
+	mux.HandleFunc("PUT /network-interfaces/{id}", handle(s, func(r *http.Request, body *v1.NetworkInterface) error {
+		if err := s.requireNotStarted(); err != nil {
+			return err
+		}
+
+		if body.IfaceID != r.PathValue("id") {
+			return ErrIDMismatch
+		}
+
+		body.RxRateLimiter = mergeRateLimiter(nil, body.RxRateLimiter)
+		body.TxRateLimiter = mergeRateLimiter(nil, body.TxRateLimiter)
+
+		s.vm.NetworkInterfaces[body.IfaceID] = *body
+
+		return nil
+	}))
+
+	mux.HandleFunc("PATCH /network-interfaces/{id}", handle(s, func(r *http.Request, body *v1.PartialNetworkInterface) error {
+		if s.vm.State == StateNotStarted {
+			return ErrInstanceNotStarted
+		}
+
+		if body.IfaceID != r.PathValue("id") {
+			return ErrIDMismatch
+		}
+
+		networkInterface, ok := s.vm.NetworkInterfaces[body.IfaceID]
+		if !ok {
+			return ErrUnknownNetworkInterface
+		}
+
+		networkInterface.RxRateLimiter = mergeRateLimiter(networkInterface.RxRateLimiter, body.RxRateLimiter)
+		networkInterface.TxRateLimiter = mergeRateLimiter(networkInterface.TxRateLimiter, body.TxRateLimiter)
+
+		s.vm.NetworkInterfaces[body.IfaceID] = networkInterface
+
+		return nil
+	}))
+
+	mux.HandleFunc("PUT /balloon", handle(s, func(r *http.Request, body *v1.Balloon) error {
+		if err := s.requireNotStarted(); err != nil {
+			return err
+		}
+
+		s.vm.Balloon = body
+
+		return nil
+	}))
+
+	mux.HandleFunc("PATCH /balloon", handle(s, func(r *http.Request, body *v1.BalloonUpdate) error {
+		if s.vm.State == StateNotStarted {
+			return ErrInstanceNotStarted
+		}
+
+		if s.vm.Balloon == nil {
+			return ErrMissingBalloon
+		}
+
+		balloon := *s.vm.Balloon
+		balloon.AmountMib = body.AmountMib
+		s.vm.Balloon = &balloon
+
+		return nil
+	}))
+
+	mux.HandleFunc("GET /balloon/statistics", func(w http.ResponseWriter, r *http.Request) {
+		if hook := s.hooks.OnRequestReceived; hook != nil {
+			hook(r.Method, r.URL.Path)
+		}
+
+		s.vmLock.Lock()
+		balloon := s.vm.Balloon
+		machineConfig := s.vm.MachineConfig
+		s.vmLock.Unlock()
+
+		if balloon == nil {
+			writeFault(w, http.StatusBadRequest, ErrMissingBalloon)
+
+			return
+		}
+
+		if balloon.StatsPollingIntervalS <= 0 {
+			writeFault(w, http.StatusBadRequest, ErrBalloonStatisticsDisabled)
+
+			return
+		}
+
+		// There is no guest, so the balloon always has its target size and half of the remaining memory is reported as free
+		memSizeMib := 0
+		if machineConfig != nil {
+			memSizeMib = machineConfig.MemSizeMib
+		}
+
+		freeMemory := max(int64(memSizeMib-balloon.AmountMib), 0) * 1024 * 1024 / 2
+
+		writeJSON(w, v1.BalloonStatistics{
+			TargetPages:     balloon.AmountMib * 256,
+			ActualPages:     balloon.AmountMib * 256,
+			TargetMib:       balloon.AmountMib,
+			ActualMib:       balloon.AmountMib,
+			FreeMemory:      freeMemory,
+			TotalMemory:     int64(memSizeMib) * 1024 * 1024,
+			AvailableMemory: freeMemory,
+		})
+	})
+
+	mux.HandleFunc("PUT /mmds/config", handle(s, func(r *http.Request, body *v1.MMDSConfig) error {
+		if err := s.requireNotStarted(); err != nil {
+			return err
+		}
+
+		if body.Version != "V1" && body.Version != "V2" {
+			return ErrUnknownMMDSVersion
+		}
+
+		for _, ifaceID := range body.NetworkInterfaces {
+			if _, ok := s.vm.NetworkInterfaces[ifaceID]; !ok {
+				return ErrUnknownNetworkInterface
+			}
+		}
+
+		s.vm.MMDSConfig = body
+
+		return nil
+	}))
+
+	mux.HandleFunc("PUT /mmds", handle(s, func(r *http.Request, body *map[string]any) error {
+		s.mmds = *body
+
+		return nil
+	}))
+
+	mux.HandleFunc("PATCH /mmds", handle(s, func(r *http.Request, body *map[string]any) error {
+		s.mmds = mergePatch(s.mmds, *body)
+
+		return nil
+	}))
+
+	mux.HandleFunc("GET /mmds", func(w http.ResponseWriter, r *http.Request) {
+		if hook := s.hooks.OnRequestReceived; hook != nil {
+			hook(r.Method, r.URL.Path)
+		}
+
+		s.vmLock.Lock()
+		mmds := mergePatch(nil, s.mmds)
+		s.vmLock.Unlock()
+
+		writeJSON(w, mmds)
+	})
+
+	mux.HandleFunc("PUT /actions", handle(s, func(r *http.Request, body *v1.Action) error {
+		switch body.ActionType {
+		case "InstanceStart":
+			if err := s.requireNotStarted(); err != nil {
+				return err
+			}
+
+			if s.vm.BootSource == nil {
+				return ErrMissingBootSource
+			}
+
+			if s.vm.MachineConfig == nil {
+				return ErrMissingMachineConfig
+			}
+
+			if _, err := os.Stat(s.vm.BootSource.KernelImagePath); err != nil {
+				return errors.Join(ErrCouldNotStatFile, err)
+			}
+
+			if err := s.openVSock(); err != nil {
+				return err
+			}
+
+			s.setState(StateRunning)
+
+			if hook := s.hooks.OnInstanceStart; hook != nil {
+				s.pendingHooks = append(s.pendingHooks, hook)
+			}
+
+		case "SendCtrlAltDel":
+			if s.vm.State == StateNotStarted {
+				return ErrInstanceNotStarted
+			}
+
+			// The guest reboots on CTRL-ALT-DEL, which causes Firecracker to exit
+			select {
+			case <-s.stopped:
+			default:
+				close(s.stopped)
+
+				if hook := s.hooks.OnInstanceStop; hook != nil {
+					s.pendingHooks = append(s.pendingHooks, hook)
+				}
+			}
+
+		case "FlushMetrics":
+			if err := s.writeMetrics(); err != nil {
+				return err
+			}
+
+		default:
+			return ErrUnknownAction
+		}
+
+		return nil
+	}))
+
+	mux.HandleFunc("PATCH /vm", handle(s, func(r *http.Request, body *v1.VirtualMachineStateRequest) error {
+		if s.vm.State == StateNotStarted {
+			return ErrInstanceNotStarted
+		}
+
+		switch body.State {
+		case "Paused":
+			s.setState(StatePaused)
+
+		case "Resumed":
+			s.setState(StateRunning)
+
+		default:
+			return ErrUnknownVMState
+		}
+
+		return nil
+	}))
+
+	mux.HandleFunc("PUT /snapshot/create", handle(s, func(r *http.Request, body *v1.SnapshotCreateRequest) error {
+		if s.vm.State == StateNotStarted {
+			return ErrInstanceNotStarted
+		}
+
+		switch body.SnapshotType {
+		case "Full":
+			if s.vm.State != StatePaused {
+				return ErrInstanceNotPaused
+			}
+
+			if err := s.writeState(body.SnapshotPath); err != nil {
+				return err
+			}
+
+			if err := s.writeMemory(body.MemoryFilePath); err != nil {
+				return err
+			}
+
+		case "Diff":
+			if !s.vm.TrackDirtyPages {
+				return ErrDiffRequiresDirtyPageTracking
+			}
+
+			if s.vm.State != StatePaused {
+				return ErrInstanceNotPaused
+			}
+
+			if err := s.writeState(body.SnapshotPath); err != nil {
+				return err
+			}
+
+			if err := s.writeDiffMemory(body.MemoryFilePath); err != nil {
+				return err
+			}
+
+		case "Msync":
+			// The live migration fork flushes the shared memory mapping to its backing file without pausing the VM
+			if !s.vm.SharedMemory {
+				return ErrMsyncRequiresSharedSnapshot
+			}
+
+		case "MsyncAndState":
+			if !s.vm.SharedMemory {
+				return ErrMsyncRequiresSharedSnapshot
+			}
+
+			if s.vm.State != StatePaused {
+				return ErrInstanceNotPaused
+			}
+
+			if err := s.writeState(body.SnapshotPath); err != nil {
+				return err
+			}
+
+		default:
+			return ErrUnknownSnapshotType
+		}
+
+		if hook := s.hooks.OnSnapshotCreate; hook != nil {
+			s.pendingHooks = append(s.pendingHooks, func() {
+				hook(body.SnapshotType, body.SnapshotPath, body.MemoryFilePath)
+			})
+		}
+
+		return nil
+	}))
+
+	mux.HandleFunc("PUT /snapshot/load", handle(s, func(r *http.Request, body *v1.SnapshotLoadRequest) error {
+		if err := s.requireNotStarted(); err != nil {
+			return err
+		}
+
+		if body.MemoryBackend.BackendType != "File" {
+			return ErrUnknownMemoryBackend
+		}
+
+		if _, err := os.Stat(body.MemoryBackend.BackendPath); err != nil {
+			return errors.Join(ErrCouldNotStatFile, err)
+		}
+
+		stateFile, err := os.Open(body.SnapshotPath)
+		if err != nil {
+			return errors.Join(ErrCouldNotReadState, err)
+		}
+		defer stateFile.Close()
+
+		// We use a decoder instead of `json.Unmarshal` since the state file might be padded or be a block device
+		var vm VMState
+		if err := json.NewDecoder(stateFile).Decode(&vm); err != nil {
+			return errors.Join(ErrCouldNotReadState, err)
+		}
+
+		if vm.Drives == nil {
+			vm.Drives = map[string]v1.Drive{}
+		}
+
+		if vm.NetworkInterfaces == nil {
+			vm.NetworkInterfaces = map[string]v1.NetworkInterface{}
+		}
+
+		vm.MemoryBackendPath = body.MemoryBackend.BackendPath
+		vm.SharedMemory = body.Shared
+		vm.TrackDirtyPages = body.EnableDiffSnapshots
+
+		s.vm = vm
+
+		if err := s.openVSock(); err != nil {
+			return err
+		}
+
+		if body.ResumeVirtualMachine {
+			s.setState(StateRunning)
+		} else {
+			s.setState(StatePaused)
+		}
+
+		if hook := s.hooks.OnSnapshotLoad; hook != nil {
+			s.pendingHooks = append(s.pendingHooks, func() {
+				hook(body.SnapshotPath, body.MemoryBackend.BackendPath, body.ResumeVirtualMachine)
+			})
+		}
+
+		return nil
+	}))
+
+	return mux
+}
+
+// writeLog writes a log line in the format that Firecracker uses with `--show-level`
+func (s *Server) writeLog(level, message string) {
+	if s.logOutput == nil {
+		return
+	}
+
+	_, _ = fmt.Fprintf(s.logOutput, "%s [anonymous-instance:fc_api:%s] %s\n", time.Now().Format("2006-01-02T15:04:05.000000000"), level, message) // We ignore errors here since Firecracker doesn't fail if it can't log either
+}
+
+// writeMetrics writes a metrics line with per-device keys for all configured drives and network interfaces;
+// all counters are 0 since there is no guest
+func (s *Server) writeMetrics() error {
+	if s.metricsOutput == nil {
+		return nil
+	}
+
+	metrics := map[string]any{
+		"utc_timestamp_ms": time.Now().UnixMilli(),
+		"vcpu":             v1.VCPUMetrics{},
+		"vsock":            v1.VSockMetrics{},
+	}
+
+	for driveID := range s.vm.Drives {
+		metrics["block_"+driveID] = v1.BlockDeviceMetrics{}
+	}
+
+	for ifaceID := range s.vm.NetworkInterfaces {
+		metrics["net_"+ifaceID] = v1.NetDeviceMetrics{}
+	}
+
+	p, err := json.Marshal(metrics)
+	if err != nil {
+		return errors.Join(ErrCouldNotWriteMetrics, err)
+	}
+
+	if _, err := s.metricsOutput.Write(append(p, '\n')); err != nil {
+		return errors.Join(ErrCouldNotWriteMetrics, err)
+	}
+
+	return nil
+}
+
+func (s *Server) writeState(statePath string) error {
+	vm := s.copyState()
+	vm.MemoryBackendPath = ""
+	vm.SharedMemory = false
+	vm.TrackDirtyPages = false
+
+	p, err := json.Marshal(vm)
+	if err != nil {
+		return errors.Join(ErrCouldNotWriteState, err)
+	}
+
+	// We don't truncate here since the state file might be a block device
+	stateFile, err := os.OpenFile(statePath, os.O_CREATE|os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		return errors.Join(ErrCouldNotWriteState, err)
+	}
+	defer stateFile.Close()
+
+	if err := truncateIfRegular(stateFile, 0); err != nil {
+		return errors.Join(ErrCouldNotWriteState, err)
+	}
+
+	if _, err := stateFile.Write(p); err != nil {
+		return errors.Join(ErrCouldNotWriteState, err)
+	}
+
+	return nil
+}
+
+func (s *Server) writeMemory(memoryPath string) error {
+	if s.vm.MemoryBackendPath == memoryPath {
+		// The guest memory is already backed by this file
+		return nil
+	}
+
+	memorySize := int64(0)
+	if s.vm.MachineConfig != nil {
+		memorySize = int64(s.vm.MachineConfig.MemSizeMib) * 1024 * 1024
+	}
+
+	memoryFile, err := os.OpenFile(memoryPath, os.O_CREATE|os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		return errors.Join(ErrCouldNotWriteMemory, err)
+	}
+	defer memoryFile.Close()
+
+	// A freshly booted guest has untouched (zeroed) memory, so a sparse file is a faithful representation
+	if err := truncateIfRegular(memoryFile, memorySize); err != nil {
+		return errors.Join(ErrCouldNotWriteMemory, err)
+	}
+
+	if s.vm.MemoryBackendPath == "" {
+		return nil
+	}
+
+	// The guest memory was restored from a snapshot, so we carry its contents over
+	backendFile, err := os.Open(s.vm.MemoryBackendPath)
+	if err != nil {
+		return errors.Join(ErrCouldNotWriteMemory, err)
+	}
+	defer backendFile.Close()
+
+	if _, err := io.Copy(memoryFile, io.LimitReader(backendFile, memorySize)); err != nil {
+		return errors.Join(ErrCouldNotWriteMemory, err)
+	}
+
+	return nil
+}
+
+func (s *Server) writeDiffMemory(memoryPath string) error {
+	memorySize := int64(0)
+	if s.vm.MachineConfig != nil {
+		memorySize = int64(s.vm.MachineConfig.MemSizeMib) * 1024 * 1024
+	}
+
+	memoryFile, err := os.OpenFile(memoryPath, os.O_CREATE|os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		return errors.Join(ErrCouldNotWriteMemory, err)
+	}
+	defer memoryFile.Close()
+
+	// There is no guest that could dirty any pages, so the diff is a sparse file without any data
+	if err := truncateIfRegular(memoryFile, memorySize); err != nil {
+		return errors.Join(ErrCouldNotWriteMemory, err)
+	}
+
+	return nil
+}
+
+func truncateIfRegular(f *os.File, size int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+
+	return f.Truncate(size)
+}
+
+// mergeRateLimiter updates the buckets of `current` that are set in `patch` like Firecracker does: buckets that aren't set are left
+// unchanged, and buckets with a size or refill time of 0 are disabled
+func mergeRateLimiter(current, patch *v1.RateLimiter) *v1.RateLimiter {
+	if patch == nil {
+		return current
+	}
+
+	merged := &v1.RateLimiter{}
+	if current != nil {
+		*merged = *current
+	}
+
+	merged.Bandwidth = mergeTokenBucket(merged.Bandwidth, patch.Bandwidth)
+	merged.Ops = mergeTokenBucket(merged.Ops, patch.Ops)
+
+	if merged.Bandwidth == nil && merged.Ops == nil {
+		return nil
+	}
+
+	return merged
+}
+
+func mergeTokenBucket(current, patch *v1.TokenBucket) *v1.TokenBucket {
+	switch {
+	case patch == nil:
+		return current
+
+	case patch.Size == 0 || patch.RefillTime == 0:
+		return nil
+
+	default:
+		return patch
+	}
+}
+
+// mergePatch applies a JSON merge patch (RFC 7396) to `target` without modifying it
+func mergePatch(target, patch map[string]any) map[string]any {
+	merged := maps.Clone(target)
+	if merged == nil {
+		merged = map[string]any{}
+	}
+
+	for key, value := range patch {
+		if value == nil {
+			delete(merged, key)
+
+			continue
+		}
+
+		if valuePatch, ok := value.(map[string]any); ok {
+			targetValue, _ := merged[key].(map[string]any) // Non-object values are replaced
+			merged[key] = mergePatch(targetValue, valuePatch)
+
+			continue
+		}
+
+		merged[key] = value
+	}
+
+	return merged
+}
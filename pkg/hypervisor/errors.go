@@ -0,0 +1,8 @@
+package hypervisor
+
+import "errors"
+
+var (
+	// ErrUnsupported is returned when a hypervisor doesn't implement the optional capability that an operation needs
+	ErrUnsupported = errors.New("operation not supported by hypervisor")
+)
@@ -0,0 +1,277 @@
+package hypervisor
+
+import (
+	"context"
+	"time"
+)
+
+type SnapshotType byte
+
+const (
+	// Pauses the VM and writes the complete state and memory to new files
+	SnapshotTypeFull SnapshotType = iota
+	// Pauses the VM and writes the state and a sparse memory file with only the pages that changed since the last snapshot was created or loaded; requires dirty page tracking
+	SnapshotTypeDiff
+)
+
+type MMDSVersion string
+
+const (
+	MMDSVersionV1 MMDSVersion = "V1"
+	// Requires the guest to get a session token before reading the metadata, like AWS' IMDSv2
+	MMDSVersionV2 MMDSVersion = "V2"
+)
+
+// TokenBucket refills `Size` tokens every `RefillTime`; `OneTimeBurst` tokens can be used once on top of that
+type TokenBucket struct {
+	Size         int64         `json:"size"`
+	OneTimeBurst int64         `json:"oneTimeBurst"`
+	RefillTime   time.Duration `json:"refillTime"`
+}
+
+// RateLimiter limits a device's bandwidth (in bytes) and/or operations; a nil bucket is unlimited
+type RateLimiter struct {
+	Bandwidth *TokenBucket `json:"bandwidth"`
+	Ops       *TokenBucket `json:"ops"`
+}
+
+type Disk struct {
+	// Path of the disk; also used as the disk's ID
+	Name string
+
+	RateLimiter *RateLimiter
+}
+
+type NetworkInterface struct {
+	// Name of the tap device on the host; also used as the interface's ID
+	HostInterface string
+	GuestMAC      string
+
+	RxRateLimiter *RateLimiter
+	TxRateLimiter *RateLimiter
+}
+
+type Balloon struct {
+	// Initial size of the balloon (in MiB)
+	AmountMiB int `json:"amountMiB"`
+	// Whether the guest may deflate the balloon if it runs out of memory
+	DeflateOnOOM bool `json:"deflateOnOOM"`
+	// Interval in which the guest updates the balloon statistics (rounded to seconds); statistics are disabled if 0
+	StatisticsInterval time.Duration `json:"statisticsInterval"`
+	// Whether the guest continuously reports free pages to the host, which releases them without having to inflate the balloon
+	FreePageReporting bool `json:"freePageReporting"`
+}
+
+type BalloonStatistics struct {
+	TargetMiB int
+	ActualMiB int
+
+	// Guest memory statistics (in bytes); these are 0 if the guest doesn't report them
+	FreeMemory      int64
+	TotalMemory     int64
+	AvailableMemory int64
+	DiskCaches      int64
+}
+
+type VCPUMetrics struct {
+	// Number of VM exits caused by port I/O and MMIO accesses
+	ExitIOIn      int64
+	ExitIOOut     int64
+	ExitMMIORead  int64
+	ExitMMIOWrite int64
+
+	Failures int64
+}
+
+type DiskMetrics struct {
+	ReadBytes  int64
+	WriteBytes int64
+	ReadCount  int64
+	WriteCount int64
+	FlushCount int64
+
+	// Number of times that I/O was delayed by the disk's rate limiter
+	RateLimiterThrottledEvents int64
+}
+
+type NetworkInterfaceMetrics struct {
+	RxBytes   int64
+	RxPackets int64
+	TxBytes   int64
+	TxPackets int64
+
+	// Number of times that traffic was delayed by the interface's rate limiters
+	RxRateLimiterThrottledEvents int64
+	TxRateLimiterThrottledEvents int64
+}
+
+type VSockMetrics struct {
+	RxBytes   int64
+	RxPackets int64
+	TxBytes   int64
+	TxPackets int64
+
+	ConnectionsAdded   int64
+	ConnectionsKilled  int64
+	ConnectionsRemoved int64
+}
+
+// Metrics are the counters of a VM since the previous metrics were reported
+type Metrics struct {
+	Time time.Time
+
+	VCPU VCPUMetrics
+
+	// Disk metrics by disk name
+	Disks map[string]DiskMetrics
+	// Network interface metrics by host interface name
+	NetworkInterfaces map[string]NetworkInterfaceMetrics
+
+	VSock VSockMetrics
+}
+
+// LogEntry is a log message of the VMM (not the guest's console output)
+type LogEntry struct {
+	Time time.Time
+
+	Thread string
+	Level  string
+
+	Message string
+}
+
+// MonitoringHooks are called from a separate goroutine whenever the VMM reports metrics or writes a log message;
+// if a hook is nil, the VMM isn't configured to report this data
+type MonitoringHooks struct {
+	OnMetrics func(metrics Metrics)
+	OnLog     func(entry LogEntry)
+	// Called if the VMM's metrics or logs can't be read anymore; the VM keeps running, but the other hooks aren't called anymore
+	OnError func(err error)
+}
+
+// MMDS configures the microVM metadata service, which lets the guest read a JSON document set by the host over HTTP
+type MMDS struct {
+	Version MMDSVersion `json:"version"`
+	// Host interfaces of the network interfaces that the guest can reach the MMDS on
+	NetworkInterfaces []string `json:"networkInterfaces"`
+	// IPv4 address that the guest can reach the MMDS on; if empty, the hypervisor's default (169.254.169.254 for Firecracker) is used
+	IPv4Address string `json:"ipv4Address"`
+}
+
+type BootConfiguration struct {
+	KernelPath string
+
+	Disks []Disk
+
+	CPUCount    int
+	MemorySize  int
+	CPUTemplate string
+	BootArgs    string
+
+	// Balloon device to attach; if nil, the VM has no balloon device
+	Balloon *Balloon
+
+	NetworkInterfaces []NetworkInterface
+	// Metadata service to enable; if nil, the guest has no metadata service
+	MMDS *MMDS
+
+	VSockPath string
+	VSockCID  int
+}
+
+// Hypervisor manages the lifecycle of a single VMM process and the VM running in it.
+// Relative paths passed to its methods are relative to `VMPath()`.
+// Capabilities that not every VMM has are separate interfaces (e.g. `BalloonController`), which callers detect with type assertions.
+type Hypervisor interface {
+	// Start starts the VMM process; cancelling `ctx` stops it
+	Start(ctx context.Context, hooks MonitoringHooks) error
+
+	// VMPath is the directory that the VMM resolves relative paths against, e.g. the jailer's chroot
+	VMPath() string
+	VMPid() int
+
+	// Boot configures and boots a fresh VM
+	Boot(ctx context.Context, configuration BootConfiguration) error
+	Pause(ctx context.Context) error
+	// Resume continues a paused VM
+	Resume(ctx context.Context) error
+
+	CreateSnapshot(ctx context.Context, statePath, memoryPath string, snapshotType SnapshotType) error
+	// ResumeSnapshot loads a snapshot and resumes the VM; if `shared` is set, the memory file is mapped with `MAP_SHARED`,
+	// and if `trackDirtyPages` is set, `SnapshotTypeDiff` snapshots can be created
+	ResumeSnapshot(ctx context.Context, statePath, memoryPath string, shared, trackDirtyPages bool) error
+
+	Wait() error
+	Close() error
+}
+
+// VersionReporter is implemented by hypervisors that know the version of their VMM
+type VersionReporter interface {
+	// Version is the version of the VMM, which is recorded in packages so that snapshots aren't resumed with an incompatible VMM
+	Version(ctx context.Context) (string, error)
+}
+
+// CgroupReporter is implemented by hypervisors that run the VMM in its own cgroup
+type CgroupReporter interface {
+	// CgroupPath is the cgroup of the VMM process relative to the root of the cgroup hierarchy; empty if the VMM doesn't have its own cgroup
+	CgroupPath() string
+}
+
+// Msyncer is implemented by hypervisors that can write the memory of a snapshot that was resumed with `shared` set back to its
+// backing file, like the Firecracker fork with live migration support
+type Msyncer interface {
+	// Msync flushes guest memory to the backing file of the resumed snapshot without pausing the VM
+	Msync(ctx context.Context, statePath string) error
+	// MsyncAndSnapshotState pauses the VM, flushes guest memory to the backing file of the resumed snapshot and writes the state to `statePath`
+	MsyncAndSnapshotState(ctx context.Context, statePath string) error
+}
+
+// CPUSetReporter is implemented by hypervisors that restrict the VMM to a set of CPUs
+type CPUSetReporter interface {
+	// CPUs are the CPUs that the VMM can run on; empty if it can run on all CPUs of the host
+	CPUs() []int
+}
+
+// RateLimiterUpdater is implemented by hypervisors that can change the rate limiters of a running VM
+type RateLimiterUpdater interface {
+	// UpdateDriveRateLimiter updates the rate limiter of a disk of the running VM; nil buckets are removed, so pass a `RateLimiter`
+	// without buckets to remove all limits. If `rateLimiter` is nil, the limits are left unchanged.
+	UpdateDriveRateLimiter(ctx context.Context, name string, rateLimiter *RateLimiter) error
+	// UpdateNetworkInterfaceRateLimiters updates the rate limiters of a network interface of the running VM; nil limiters are left unchanged,
+	// and nil buckets of the others are removed
+	UpdateNetworkInterfaceRateLimiters(ctx context.Context, hostInterface string, rxRateLimiter, txRateLimiter *RateLimiter) error
+}
+
+// DriveSwapper is implemented by hypervisors that can change the backing device of a disk of a running VM
+type DriveSwapper interface {
+	// UpdateDrivePath makes a disk of the running VM use the file or block device at `path` as its backing device
+	UpdateDrivePath(ctx context.Context, name string, path string) error
+}
+
+// BalloonController is implemented by hypervisors that support balloon devices
+type BalloonController interface {
+	// UpdateBalloon sets the target size of the balloon (in MiB); the guest inflates or deflates the balloon asynchronously
+	UpdateBalloon(ctx context.Context, amountMiB int) error
+	GetBalloonStatistics(ctx context.Context) (*BalloonStatistics, error)
+}
+
+// MMDSController is implemented by hypervisors that have a metadata service
+type MMDSController interface {
+	// PutMMDS replaces the metadata document of the VM with `data`, which needs to marshal to a JSON object.
+	// The document isn't part of snapshots, so it needs to be set again after resuming a snapshot.
+	PutMMDS(ctx context.Context, data any) error
+	// PatchMMDS merges `patch` into the metadata document of the VM as a JSON merge patch (RFC 7396)
+	PatchMMDS(ctx context.Context, patch any) error
+}
+
+// MetricsFlusher is implemented by hypervisors that report metrics in intervals
+type MetricsFlusher interface {
+	// FlushMetrics causes the VMM to report its metrics immediately instead of waiting for its next reporting interval
+	FlushMetrics(ctx context.Context) error
+}
+
+// ShutdownRequester is implemented by hypervisors that can ask the guest to shut down
+type ShutdownRequester interface {
+	// RequestShutdown asks the guest to shut down, after which the VMM exits; it doesn't wait for the VMM to exit
+	RequestShutdown(ctx context.Context) error
+}
@@ -17,6 +17,7 @@ var (
 	ErrCouldNotMarshalJSON     = errors.New("could not marshal JSON")
 	ErrCouldNotUnmarshalJSON   = errors.New("could not unmarshal JSON")
 	ErrAgentContextCancelled   = errors.New("agent context cancelled")
+	ErrShutdownNotSupported    = errors.New("shutdown is not supported by this agent")
 )
 
 // The RPCs the agent server can call on this client
@@ -26,6 +27,8 @@ type AgentClientLocal[G any] struct {
 
 	beforeSuspend func(ctx context.Context) error
 	afterResume   func(ctx context.Context) error
+	shutdown      func(ctx context.Context) error
+	probe         func(ctx context.Context, command string) error
 }
 
 // The RPCs this client can call on the agent server
@@ -37,12 +40,18 @@ func NewAgentClient[G any](
 
 	beforeSuspend func(ctx context.Context) error,
 	afterResume func(ctx context.Context) error,
+	// If nil, the host falls back to other ways of shutting down the guest
+	shutdown func(ctx context.Context) error,
+	// If nil, the host can't use probes that run commands in the guest
+	probe func(ctx context.Context, command string) error,
 ) *AgentClientLocal[G] {
 	return &AgentClientLocal[G]{
 		GuestService: guestService,
 
 		beforeSuspend: beforeSuspend,
 		afterResume:   afterResume,
+		shutdown:      shutdown,
+		probe:         probe,
 	}
 }
 
@@ -54,6 +63,24 @@ func (l *AgentClientLocal[G]) AfterResume(ctx context.Context) error {
 	return l.afterResume(ctx)
 }
 
+func (l *AgentClientLocal[G]) Shutdown(ctx context.Context) error {
+	if l.shutdown == nil {
+		return ErrShutdownNotSupported
+	}
+
+	return l.shutdown(ctx)
+}
+
+// Probe returns `false` without running `command` if the agent doesn't support probes; errors are only returned as strings to
+// the host, so it couldn't tell this apart from a failing command otherwise
+func (l *AgentClientLocal[G]) Probe(ctx context.Context, command string) (bool, error) {
+	if l.probe == nil {
+		return false, nil
+	}
+
+	return true, l.probe(ctx, command)
+}
+
 type ConnectedAgentClient[L *AgentClientLocal[G], R AgentClientRemote, G any] struct {
 	Remote R
 
@@ -33,6 +33,10 @@ type AgentServerRemote[G any] struct {
 
 	BeforeSuspend func(ctx context.Context) error
 	AfterResume   func(ctx context.Context) error
+	// Shutdown asks the agent to shut down the guest; it returns once the shutdown has been started
+	Shutdown func(ctx context.Context) error
+	// Probe asks the agent to run `command` in the guest; it returns `false` if the agent doesn't support probes, and an error if the command fails
+	Probe func(ctx context.Context, command string) (bool, error)
 }
 
 type AgentServer[L AgentServerLocal, R AgentServerRemote[G], G any] struct {
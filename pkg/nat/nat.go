@@ -11,6 +11,14 @@ import (
 	"github.com/loopholelabs/goroutine-manager/pkg/manager"
 )
 
+type NamespaceInterface struct {
+	Interface string `json:"interface"`
+	Gateway   string `json:"gateway"`
+	Netmask   uint32 `json:"netmask"`
+	IP        string `json:"ip"`
+	MAC       string `json:"mac"`
+}
+
 type TranslationConfiguration struct {
 	HostInterface string `json:"hostInterface"`
 
@@ -18,11 +26,8 @@ type TranslationConfiguration struct {
 	NamespaceVethCIDR string `json:"namespaceVethCIDR"`
 	BlockedSubnetCIDR string `json:"blockedSubnetCIDR"`
 
-	NamespaceInterface        string `json:"namespaceInterface"`
-	NamespaceInterfaceGateway string `json:"namespaceInterfaceGateway"`
-	NamespaceInterfaceNetmask uint32 `json:"namespaceInterfaceNetmask"`
-	NamespaceInterfaceIP      string `json:"namespaceInterfaceIP"`
-	NamespaceInterfaceMAC     string `json:"namespaceInterfaceMAC"`
+	// Tap devices to create in each namespace; incoming traffic is forwarded to the first one
+	NamespaceInterfaces []NamespaceInterface `json:"namespaceInterfaces"`
 
 	NamespacePrefix string `json:"namespacePrefix"`
 
@@ -57,6 +62,23 @@ func CreateNAT(
 	defer goroutineManager.StopAllGoroutines()
 	defer goroutineManager.CreateBackgroundPanicCollector()()
 
+	if len(translationConfiguration.NamespaceInterfaces) < 1 {
+		panic(errors.Join(ErrCouldNotCreateNAT, network.ErrNoNamespaceInterfaces))
+	}
+
+	namespaceInterfaces := []network.TapInterface{}
+	for _, namespaceInterface := range translationConfiguration.NamespaceInterfaces {
+		namespaceInterfaces = append(namespaceInterfaces, network.TapInterface{
+			Name: namespaceInterface.Interface,
+
+			Gateway: namespaceInterface.Gateway,
+			Netmask: namespaceInterface.Netmask,
+
+			IP:  namespaceInterface.IP,
+			MAC: namespaceInterface.MAC,
+		})
+	}
+
 	// Check if the host interface exists
 	if _, err := net.InterfaceByName(translationConfiguration.HostInterface); err != nil {
 		panic(errors.Join(ErrCouldNotFindHostInterface, err))
@@ -264,21 +286,15 @@ func CreateNAT(
 				id,
 
 				translationConfiguration.HostInterface,
-				translationConfiguration.NamespaceInterface,
-
-				translationConfiguration.NamespaceInterfaceGateway,
-				translationConfiguration.NamespaceInterfaceNetmask,
+				namespaceInterfaces,
 
 				hostVeth.GetFirstIP().String(),
 				hostVeth.GetSecondIP().String(),
 
-				translationConfiguration.NamespaceInterfaceIP,
 				namespaceVeth.String(),
 
 				translationConfiguration.BlockedSubnetCIDR,
 
-				translationConfiguration.NamespaceInterfaceMAC,
-
 				translationConfiguration.AllowIncomingTraffic,
 			)
 			if err := namespace.Open(); err != nil {
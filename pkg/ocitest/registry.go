@@ -0,0 +1,410 @@
+package ocitest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// Path of the token service that clients are sent to for bearer authentication
+	TokenPath = "/token"
+
+	tokenService = "ocitest"
+)
+
+type RegistryHooks struct {
+	OnRequestReceived func(method, path string)
+	OnTokenIssued     func(service, scope string)
+	OnBlobUploaded    func(repository, digest string, size int)
+	OnManifestPushed  func(repository, reference, digest string)
+}
+
+// Registry is an in-memory stand-in for an OCI distribution registry that implements the subset of the API that `packager`
+// uses to push and pull packages (https://github.com/opencontainers/distribution-spec/blob/main/spec.md).
+// If `Username` is set, requests must use basic authentication with `Username` and `Password`. If `Token` is set, requests must
+// use bearer authentication with `Token` instead, which clients get from the registry's token service at `TokenPath` (using basic
+// authentication if `Username` is set) after being challenged for it.
+type Registry struct {
+	Username string
+	Password string
+
+	Token string
+
+	hooks RegistryHooks
+
+	lock sync.Mutex
+
+	// Blobs are shared between repositories since they are content-addressed
+	blobs map[string][]byte
+	// Manifests by repository and tag or digest
+	manifests map[string]map[string]manifest
+	// Pending uploads by session ID
+	uploads map[string][]byte
+}
+
+type manifest struct {
+	mediaType string
+	content   []byte
+}
+
+type registryError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewRegistry creates an empty registry; serve it with e.g. `httptest.NewServer`
+func NewRegistry(hooks RegistryHooks) *Registry {
+	return &Registry{
+		hooks: hooks,
+
+		blobs:     map[string][]byte{},
+		manifests: map[string]map[string]manifest{},
+		uploads:   map[string][]byte{},
+	}
+}
+
+// Blob returns a copy of the blob with `digest`, or `false` if the registry doesn't have it
+func (r *Registry) Blob(digest string) ([]byte, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	blob, ok := r.blobs[digest]
+	if !ok {
+		return nil, false
+	}
+
+	return append([]byte{}, blob...), true
+}
+
+// DeleteBlob removes the blob with `digest`, e.g. to emulate a registry that has garbage collected it
+func (r *Registry) DeleteBlob(digest string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.blobs, digest)
+}
+
+// SetBlob stores `blob` as the blob with `digest` without verifying it, e.g. to emulate a registry that serves corrupted blobs
+func (r *Registry) SetBlob(digest string, blob []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.blobs[digest] = append([]byte{}, blob...)
+}
+
+func getDigest(content []byte) string {
+	sum := sha256.Sum256(content)
+
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(map[string][]registryError{
+		"errors": {{Code: code, Message: message}},
+	})
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if hook := r.hooks.OnRequestReceived; hook != nil {
+		hook(req.Method, req.URL.Path)
+	}
+
+	if req.URL.Path == TokenPath && r.Token != "" {
+		r.handleToken(w, req)
+
+		return
+	}
+
+	if r.Token != "" {
+		if req.Header.Get("Authorization") != "Bearer "+r.Token {
+			scheme := "http"
+			if req.TLS != nil {
+				scheme = "https"
+			}
+
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s://%s%s",service="%s"`, scheme, req.Host, TokenPath, tokenService))
+			writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+
+			return
+		}
+	} else if r.Username != "" {
+		if !r.isAuthorized(req) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="ocitest"`)
+			writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+
+			return
+		}
+	}
+
+	path, ok := strings.CutPrefix(req.URL.Path, "/v2/")
+	if !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "unknown path")
+
+		return
+	}
+
+	if path == "" {
+		w.WriteHeader(http.StatusOK)
+
+		return
+	}
+
+	// The repository name can contain slashes, so we split at the last API component
+	for _, component := range []string{"/blobs/uploads/", "/blobs/", "/manifests/"} {
+		i := strings.LastIndex(path, component)
+		if i < 0 {
+			continue
+		}
+
+		repository, rest := path[:i], path[i+len(component):]
+
+		switch component {
+		case "/blobs/uploads/":
+			r.handleUpload(w, req, repository, rest)
+
+		case "/blobs/":
+			r.handleBlob(w, req, rest)
+
+		case "/manifests/":
+			r.handleManifest(w, req, repository, rest)
+		}
+
+		return
+	}
+
+	writeError(w, http.StatusNotFound, "NOT_FOUND", "unknown path")
+}
+
+func (r *Registry) isAuthorized(req *http.Request) bool {
+	if r.Username == "" {
+		return true
+	}
+
+	username, password, ok := req.BasicAuth()
+
+	return ok && username == r.Username && password == r.Password
+}
+
+func (r *Registry) handleToken(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "unsupported method")
+
+		return
+	}
+
+	if !r.isAuthorized(req) {
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+
+		return
+	}
+
+	if hook := r.hooks.OnTokenIssued; hook != nil {
+		hook(req.URL.Query().Get("service"), req.URL.Query().Get("scope"))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"token": r.Token,
+	})
+}
+
+func (r *Registry) handleBlob(w http.ResponseWriter, req *http.Request, digest string) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "unsupported method")
+
+		return
+	}
+
+	blob, ok := r.Blob(digest)
+	if !ok {
+		writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusOK)
+
+	if req.Method == http.MethodGet {
+		_, _ = w.Write(blob)
+	}
+}
+
+func (r *Registry) handleUpload(w http.ResponseWriter, req *http.Request, repository, session string) {
+	switch req.Method {
+	case http.MethodPost:
+		rawID := make([]byte, 16)
+		if _, err := rand.Read(rawID); err != nil {
+			writeError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+
+			return
+		}
+		id := hex.EncodeToString(rawID)
+
+		r.lock.Lock()
+		r.uploads[id] = []byte{}
+		r.lock.Unlock()
+
+		w.Header().Set("Location", "/v2/"+repository+"/blobs/uploads/"+id)
+		w.Header().Set("Range", "0-0")
+		w.WriteHeader(http.StatusAccepted)
+
+	case http.MethodPatch, http.MethodPut:
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", err.Error())
+
+			return
+		}
+
+		r.lock.Lock()
+		upload, ok := r.uploads[session]
+		if ok {
+			upload = append(upload, body...)
+			r.uploads[session] = upload
+		}
+		r.lock.Unlock()
+
+		if !ok {
+			writeError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "blob upload unknown to registry")
+
+			return
+		}
+
+		if req.Method == http.MethodPatch {
+			w.Header().Set("Location", "/v2/"+repository+"/blobs/uploads/"+session)
+			w.Header().Set("Range", "0-"+strconv.Itoa(max(len(upload)-1, 0)))
+			w.WriteHeader(http.StatusAccepted)
+
+			return
+		}
+
+		digest := req.URL.Query().Get("digest")
+		if actual := getDigest(upload); actual != digest {
+			writeError(w, http.StatusBadRequest, "DIGEST_INVALID", "provided digest did not match uploaded content")
+
+			return
+		}
+
+		r.lock.Lock()
+		delete(r.uploads, session)
+		r.blobs[digest] = upload
+		r.lock.Unlock()
+
+		if hook := r.hooks.OnBlobUploaded; hook != nil {
+			hook(repository, digest, len(upload))
+		}
+
+		w.Header().Set("Location", "/v2/"+repository+"/blobs/"+digest)
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusCreated)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "unsupported method")
+	}
+}
+
+func (r *Registry) handleManifest(w http.ResponseWriter, req *http.Request, repository, reference string) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		r.lock.Lock()
+		m, ok := r.manifests[repository][reference]
+		r.lock.Unlock()
+
+		if !ok {
+			writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown to registry")
+
+			return
+		}
+
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(m.content)))
+		w.Header().Set("Docker-Content-Digest", getDigest(m.content))
+		w.WriteHeader(http.StatusOK)
+
+		if req.Method == http.MethodGet {
+			_, _ = w.Write(m.content)
+		}
+
+	case http.MethodPut:
+		content, err := io.ReadAll(req.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+
+			return
+		}
+
+		var parsed struct {
+			Config struct {
+				Digest string `json:"digest"`
+			} `json:"config"`
+			Layers []struct {
+				Digest string `json:"digest"`
+			} `json:"layers"`
+		}
+		if err := json.Unmarshal(content, &parsed); err != nil {
+			writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+
+			return
+		}
+
+		digests := []string{parsed.Config.Digest}
+		for _, layer := range parsed.Layers {
+			digests = append(digests, layer.Digest)
+		}
+
+		digest := getDigest(content)
+
+		r.lock.Lock()
+
+		// Like real registries, we reject manifests that reference blobs that haven't been pushed
+		for _, blobDigest := range digests {
+			if _, ok := r.blobs[blobDigest]; !ok {
+				r.lock.Unlock()
+
+				writeError(w, http.StatusBadRequest, "MANIFEST_BLOB_UNKNOWN", "blob "+blobDigest+" unknown to registry")
+
+				return
+			}
+		}
+
+		if r.manifests[repository] == nil {
+			r.manifests[repository] = map[string]manifest{}
+		}
+
+		m := manifest{
+			mediaType: req.Header.Get("Content-Type"),
+			content:   content,
+		}
+		r.manifests[repository][reference] = m
+		r.manifests[repository][digest] = m
+
+		r.lock.Unlock()
+
+		if hook := r.hooks.OnManifestPushed; hook != nil {
+			hook(repository, reference, digest)
+		}
+
+		w.Header().Set("Location", "/v2/"+repository+"/manifests/"+digest)
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusCreated)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "unsupported method")
+	}
+}
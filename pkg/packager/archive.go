@@ -3,9 +3,14 @@ package packager
 import (
 	"archive/tar"
 	"context"
+	"crypto/ed25519"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/klauspost/compress/zstd"
 )
@@ -17,14 +22,50 @@ type PackagerDevice struct {
 
 type PackagerHooks struct {
 	OnBeforeProcessFile func(name, path string)
+	// Called for archive entries that don't match any of the requested devices during extraction
+	OnUnknownFile func(name string)
+	// Called for every chunk that is written to a chunk store or delta; `reused` is true if it was already present and didn't need to be written
+	OnChunkProcessed func(id string, size int64, reused bool)
+	// Called for every blob that is pushed to or pulled from an OCI registry; `reused` is true if the destination already had it
+	OnBlobProcessed func(digest string, size int64, reused bool)
+	// Called while archiving or extracting with the total number of uncompressed device bytes that have been processed so far,
+	// and the total number of bytes that have been written to or read from the package (or the index of a chunked package)
+	OnProgress func(deviceBytes, packageBytes int64)
 }
 
+type ArchiveOptions struct {
+	// Block size for the Merkle trees in the manifest; if 0, `DefaultManifestBlockSize` is used
+	ManifestBlockSize uint32
+	// Key to sign the manifest with; if nil, the manifest isn't signed
+	SigningKey ed25519.PrivateKey
+
+	// Whether to create a seekable package, whose devices can be read without extracting them (see `OpenSeekablePackage`)
+	Seekable bool
+	// Amount of uncompressed data in each independently compressed frame of a seekable package; if 0, `DefaultSeekableFrameSize` is used
+	SeekableFrameSize int
+
+	// Path to a chunk store to write the devices to; if set, a chunked package (see `ChunkIndex`) that only references the chunks
+	// in the store is created instead of an archive, and `Seekable` and `Compression` are ignored
+	ChunkStorePath string
+
+	// Compression settings; they are recorded in the package so that it is extracted with matching settings
+	Compression CompressionOptions
+
+	// Whether to write devices that contain blocks of zeros as regular instead of sparse entries. Sparse entries only contain the
+	// blocks with data, so they are faster to archive and extract; they are never used for seekable packages, since their
+	// devices are read directly from the package.
+	DisableSparse bool
+}
+
+// ArchivePackage writes `devices` to a package at `packageOutputPath`, followed by a manifest with the size and checksums of each
+// device and, if `options.SigningKey` is set, a signature of the manifest
 func ArchivePackage(
 	ctx context.Context,
 
 	devices []PackagerDevice,
 	packageOutputPath string,
 
+	options ArchiveOptions,
 	hooks PackagerHooks,
 ) error {
 	packageOutputFile, err := os.OpenFile(packageOutputPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.ModePerm)
@@ -33,15 +74,52 @@ func ArchivePackage(
 	}
 	defer packageOutputFile.Close()
 
-	compressor, err := zstd.NewWriter(packageOutputFile)
+	if err := ArchivePackageToWriter(ctx, devices, packageOutputFile, options, hooks); err != nil {
+		return err
+	}
+
+	if err := packageOutputFile.Close(); err != nil {
+		return errors.Join(ErrCouldNotCloseArchive, err)
+	}
+
+	return nil
+}
+
+// ArchivePackageToWriter is like `ArchivePackage`, but writes the package to `packageOutput`, e.g. to stream it to object storage
+// or over SSH without staging it on disk first
+func ArchivePackageToWriter(
+	ctx context.Context,
+
+	devices []PackagerDevice,
+	packageOutput io.Writer,
+
+	options ArchiveOptions,
+	hooks PackagerHooks,
+) error {
+	if err := checkDeviceNames(devices); err != nil {
+		return err
+	}
+
+	progress := newProgressCounter(hooks)
+	packageOutput = progress.countPackageWriter(packageOutput)
+
+	if strings.TrimSpace(options.ChunkStorePath) != "" {
+		return archiveChunkedPackage(ctx, devices, packageOutput, options, hooks, progress)
+	}
+
+	compressor, err := newPackageCompressor(packageOutput, options)
 	if err != nil {
-		return errors.Join(ErrCouldNotCreateCompressor, err)
+		return err
 	}
 	defer compressor.Close()
 
 	packageOutputArchive := tar.NewWriter(compressor)
 	defer packageOutputArchive.Close()
 
+	manifest := Manifest{
+		Version: manifestVersion,
+		Devices: []ManifestDevice{},
+	}
 	for _, device := range devices {
 	s:
 		select {
@@ -67,19 +145,170 @@ func ArchivePackage(
 		}
 		header.Name = device.Name
 
-		if err := packageOutputArchive.WriteHeader(header); err != nil {
-			return errors.Join(ErrCouldNotWriteTarHeader, err)
-		}
-
 		f, err := os.Open(device.Path)
 		if err != nil {
 			return errors.Join(ErrCouldNotOpenDevice, err)
 		}
 		defer f.Close()
 
-		if _, err = io.Copy(packageOutputArchive, f); err != nil {
-			return errors.Join(ErrCouldNotCopyToArchive, err)
+		hasher := newDeviceHasher(options.ManifestBlockSize)
+		if options.DisableSparse || options.Seekable || !info.Mode().IsRegular() {
+			if err := packageOutputArchive.WriteHeader(header); err != nil {
+				return errors.Join(ErrCouldNotWriteTarHeader, err)
+			}
+
+			if _, err = io.Copy(io.MultiWriter(packageOutputArchive, hasher), progress.countDevice(f)); err != nil {
+				return errors.Join(ErrCouldNotCopyToArchive, err)
+			}
+		} else if err := archiveSparseDevice(ctx, packageOutputArchive, compressor, header, f, hasher, progress); err != nil {
+			return err
 		}
+
+		manifest.Devices = append(manifest.Devices, hasher.manifestDevice(device.Name))
+	}
+
+	rawManifest, err := json.Marshal(manifest)
+	if err != nil {
+		return errors.Join(ErrCouldNotEncodeManifest, err)
+	}
+
+	if err := writeArchiveEntry(packageOutputArchive, ManifestName, rawManifest); err != nil {
+		return errors.Join(ErrCouldNotWriteManifest, err)
+	}
+
+	if options.SigningKey != nil {
+		if err := writeArchiveEntry(packageOutputArchive, ManifestSignatureName, ed25519.Sign(options.SigningKey, rawManifest)); err != nil {
+			return errors.Join(ErrCouldNotWriteManifest, err)
+		}
+	}
+
+	// The archive and compressor write their trailers (and the seek table) when they are closed, so we can't ignore their errors
+	if err := packageOutputArchive.Close(); err != nil {
+		return errors.Join(ErrCouldNotCloseArchive, err)
+	}
+
+	if err := compressor.Close(); err != nil {
+		return errors.Join(ErrCouldNotCloseArchive, err)
+	}
+
+	progress.finish()
+
+	return nil
+}
+
+// archiveSparseDevice hashes `device` while looking for blocks that only contain zeros, and then writes it as a sparse entry if it
+// has any or as a regular entry otherwise
+func archiveSparseDevice(
+	ctx context.Context,
+
+	archive *tar.Writer,
+	output io.Writer,
+	header *tar.Header,
+	device *os.File,
+
+	hasher io.Writer,
+	progress *progressCounter,
+) error {
+	fragments, size, err := getDataFragments(ctx, io.TeeReader(progress.countDevice(device), hasher))
+	if err != nil {
+		return errors.Join(ErrCouldNotHashDevice, err)
+	}
+
+	// We use the size that we hashed so that the entry matches the manifest even if the device's size changed since we stat'ed it
+	header.Size = size
+
+	var dataSize int64
+	for _, fragment := range fragments {
+		dataSize += fragment.length
+	}
+
+	if dataSize < size {
+		return writeSparseEntry(archive, output, header, device, fragments)
+	}
+
+	if err := archive.WriteHeader(header); err != nil {
+		return errors.Join(ErrCouldNotWriteTarHeader, err)
+	}
+
+	if _, err := io.Copy(archive, io.NewSectionReader(device, 0, size)); err != nil {
+		return errors.Join(ErrCouldNotCopyToArchive, err)
+	}
+
+	return nil
+}
+
+// newPackageCompressor returns a writer that compresses the archive according to `options` and writes it to `packageOutput`
+func newPackageCompressor(packageOutput io.Writer, options ArchiveOptions) (io.WriteCloser, error) {
+	if options.Compression.Disabled {
+		if options.Seekable {
+			return nil, errors.Join(ErrInvalidCompressionOptions, errors.New("seekable packages must be compressed"))
+		}
+
+		return nopWriteCloser{packageOutput}, nil
+	}
+
+	parametersFrame, err := newCompressionParametersFrame(options.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Seekable {
+		compressor, err := newSeekableWriter(packageOutput, options.SeekableFrameSize, options.Compression)
+		if err != nil {
+			return nil, errors.Join(ErrCouldNotCreateCompressor, err)
+		}
+
+		// The seek table has to account for the parameters frame, so the seekable writer needs to write it
+		if err := compressor.writeSkippableFrame(parametersFrame); err != nil {
+			return nil, errors.Join(ErrCouldNotCreateCompressor, err)
+		}
+
+		return compressor, nil
+	}
+
+	if _, err := packageOutput.Write(parametersFrame); err != nil {
+		return nil, errors.Join(ErrCouldNotCreateCompressor, err)
+	}
+
+	compressor, err := zstd.NewWriter(packageOutput, options.Compression.encoderOptions()...)
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotCreateCompressor, err)
+	}
+
+	return compressor, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func checkDeviceNames(devices []PackagerDevice) error {
+	for _, device := range devices {
+		if device.Name == ManifestName || device.Name == ManifestSignatureName {
+			return errors.Join(ErrReservedDeviceName, fmt.Errorf("device name %v", device.Name))
+		}
+	}
+
+	return nil
+}
+
+func writeArchiveEntry(archive *tar.Writer, name string, content []byte) error {
+	if err := archive.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(content)),
+		ModTime:  time.Now(),
+	}); err != nil {
+		return errors.Join(ErrCouldNotWriteTarHeader, err)
+	}
+
+	if _, err := archive.Write(content); err != nil {
+		return errors.Join(ErrCouldNotCopyToArchive, err)
 	}
 
 	return nil
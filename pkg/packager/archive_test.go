@@ -0,0 +1,146 @@
+package packager_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/loopholelabs/drafter/pkg/packager"
+)
+
+// countingWriter counts the bytes that are written to it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+
+	return n, err
+}
+
+// progress records the last values reported to `OnProgress` and checks that they never decrease
+type progress struct {
+	t *testing.T
+
+	deviceBytes  int64
+	packageBytes int64
+}
+
+func (p *progress) onProgress(deviceBytes, packageBytes int64) {
+	if deviceBytes < p.deviceBytes || packageBytes < p.packageBytes {
+		p.t.Errorf("progress decreased from %v, %v to %v, %v", p.deviceBytes, p.packageBytes, deviceBytes, packageBytes)
+	}
+
+	p.deviceBytes = deviceBytes
+	p.packageBytes = packageBytes
+}
+
+func getDevicesSize(t *testing.T, devices []packager.PackagerDevice) int64 {
+	t.Helper()
+
+	var size int64
+	for _, device := range devices {
+		info, err := os.Stat(device.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		size += info.Size()
+	}
+
+	return size
+}
+
+func TestStreamPackage(t *testing.T) {
+	devices := createDevices(t, t.TempDir())
+	devicesSize := getDevicesSize(t, devices)
+
+	for name, options := range map[string]packager.ArchiveOptions{
+		"compressed": {},
+		"seekable": {
+			Seekable: true,
+		},
+		"uncompressed": {
+			Compression: packager.CompressionOptions{
+				Disabled: true,
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// The package is extracted while it is being archived, so it is never written to disk
+			packageReader, packageWriter := io.Pipe()
+
+			var (
+				archiveProgress = &progress{t: t}
+				packageOutput   = &countingWriter{w: packageWriter}
+				archiveErr      = make(chan error)
+			)
+			go func() {
+				err := packager.ArchivePackageToWriter(context.Background(), devices, packageOutput, options, packager.PackagerHooks{
+					OnProgress: archiveProgress.onProgress,
+				})
+
+				// Closing the pipe with an error makes the extraction fail instead of waiting for more data
+				packageWriter.CloseWithError(err)
+
+				archiveErr <- err
+			}()
+
+			extractProgress := &progress{t: t}
+			outputDevices := getOutputDevices(devices, t.TempDir())
+			if err := packager.ExtractPackageFromReader(context.Background(), packageReader, outputDevices, packager.ExtractOptions{}, packager.PackagerHooks{
+				OnProgress: extractProgress.onProgress,
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			// The extraction can stop before the end of the package, e.g. before the seek table, so we drain the pipe
+			if _, err := io.Copy(io.Discard, packageReader); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := <-archiveErr; err != nil {
+				t.Fatal(err)
+			}
+
+			checkExtractedDevices(t, devices, outputDevices)
+
+			if archiveProgress.deviceBytes != devicesSize || archiveProgress.packageBytes != packageOutput.n {
+				t.Errorf("archiving reported %v device and %v package bytes, want %v and %v", archiveProgress.deviceBytes, archiveProgress.packageBytes, devicesSize, packageOutput.n)
+			}
+
+			if extractProgress.deviceBytes != devicesSize || extractProgress.packageBytes == 0 || extractProgress.packageBytes > packageOutput.n {
+				t.Errorf("extracting reported %v device and %v package bytes, want %v and up to %v", extractProgress.deviceBytes, extractProgress.packageBytes, devicesSize, packageOutput.n)
+			}
+		})
+	}
+}
+
+func TestArchivePackageToWriterRejectsReservedNames(t *testing.T) {
+	devices := createDevices(t, t.TempDir())
+
+	for _, name := range []string{packager.ManifestName, packager.ManifestSignatureName} {
+		if err := packager.ArchivePackageToWriter(context.Background(), append(devices, packager.PackagerDevice{
+			Name: name,
+			Path: devices[0].Path,
+		}), io.Discard, packager.ArchiveOptions{}, packager.PackagerHooks{}); !errors.Is(err, packager.ErrReservedDeviceName) {
+			t.Errorf("archiving a device named %v returned %v, want %v", name, err, packager.ErrReservedDeviceName)
+		}
+	}
+
+	// Archiving to a path uses the same check
+	if err := packager.ArchivePackage(context.Background(), []packager.PackagerDevice{
+		{
+			Name: packager.ManifestName,
+			Path: devices[0].Path,
+		},
+	}, filepath.Join(t.TempDir(), "package.tar.zst"), packager.ArchiveOptions{}, packager.PackagerHooks{}); !errors.Is(err, packager.ErrReservedDeviceName) {
+		t.Errorf("archiving a device named %v to a path returned %v, want %v", packager.ManifestName, err, packager.ErrReservedDeviceName)
+	}
+}
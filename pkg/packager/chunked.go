@@ -0,0 +1,307 @@
+package packager
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	chunkIndexVersion = 1
+
+	// Maximum size of a chunk index that we read into memory, which is enough for about ten million chunks
+	maxChunkIndexSize = 1024 * 1024 * 1024
+)
+
+type ChunkReference struct {
+	// Hex-encoded SHA-256 of the uncompressed chunk
+	ID   string `json:"id"`
+	Size int64  `json:"size"`
+}
+
+type ChunkedDevice struct {
+	Name   string           `json:"name"`
+	Size   int64            `json:"size"`
+	Chunks []ChunkReference `json:"chunks"`
+}
+
+// ChunkIndex is the content of a chunked package; the devices' data is stored in a chunk store
+type ChunkIndex struct {
+	Version int             `json:"version"`
+	Devices []ChunkedDevice `json:"devices"`
+
+	// Raw manifest of the package and its signature, which are verified the same way as those of regular packages
+	Manifest          json.RawMessage `json:"manifest"`
+	ManifestSignature []byte          `json:"manifestSignature,omitempty"`
+}
+
+// ReadChunkIndex reads the index of the chunked package at `indexPath`
+func ReadChunkIndex(indexPath string) (*ChunkIndex, error) {
+	indexFile, err := os.Open(indexPath)
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotOpenPackageInputFile, err)
+	}
+	defer indexFile.Close()
+
+	return decodeChunkIndex(indexFile)
+}
+
+func decodeChunkIndex(r io.Reader) (*ChunkIndex, error) {
+	var index ChunkIndex
+	if err := json.NewDecoder(r).Decode(&index); err != nil {
+		return nil, errors.Join(ErrCouldNotDecodeChunkIndex, err)
+	}
+
+	if index.Version != chunkIndexVersion {
+		return nil, errors.Join(ErrUnsupportedChunkIndexVersion, fmt.Errorf("chunk index version %v", index.Version))
+	}
+
+	for _, device := range index.Devices {
+		for _, chunk := range device.Chunks {
+			if err := validateChunkID(chunk.ID); err != nil {
+				return nil, errors.Join(ErrCouldNotDecodeChunkIndex, err)
+			}
+		}
+	}
+
+	return &index, nil
+}
+
+func writeChunkIndex(index *ChunkIndex, w io.Writer) error {
+	rawIndex, err := json.Marshal(index)
+	if err != nil {
+		return errors.Join(ErrCouldNotEncodeChunkIndex, err)
+	}
+
+	if _, err := w.Write(rawIndex); err != nil {
+		return errors.Join(ErrCouldNotWriteChunkIndex, err)
+	}
+
+	return nil
+}
+
+// isChunkIndex returns whether `r` contains a chunk index instead of an archive
+func isChunkIndex(r *bufio.Reader) bool {
+	// Uncompressed archives start with the name of their first entry, which could look like JSON
+	if isUncompressedArchive(r) {
+		return false
+	}
+
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return false
+		}
+
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			if _, err := r.Discard(1); err != nil {
+				return false
+			}
+
+		default:
+			return b[0] == '{'
+		}
+	}
+}
+
+func archiveChunkedPackage(
+	ctx context.Context,
+
+	devices []PackagerDevice,
+	packageOutput io.Writer,
+
+	options ArchiveOptions,
+	hooks PackagerHooks,
+	progress *progressCounter,
+) error {
+	store, err := NewChunkStore(options.ChunkStorePath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var (
+		index = &ChunkIndex{
+			Version: chunkIndexVersion,
+			Devices: []ChunkedDevice{},
+		}
+		manifest = Manifest{
+			Version: manifestVersion,
+			Devices: []ManifestDevice{},
+		}
+	)
+	for _, device := range devices {
+	s:
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		default:
+			break s
+		}
+
+		if hook := hooks.OnBeforeProcessFile; hook != nil {
+			hook(device.Name, device.Path)
+		}
+
+		f, err := os.Open(device.Path)
+		if err != nil {
+			return errors.Join(ErrCouldNotOpenDevice, err)
+		}
+		defer f.Close()
+
+		chunkedDevice := ChunkedDevice{
+			Name:   device.Name,
+			Chunks: []ChunkReference{},
+		}
+
+		hasher := newDeviceHasher(options.ManifestBlockSize)
+		if err := splitChunks(io.TeeReader(progress.countDevice(f), hasher), func(chunk []byte) error {
+			id, stored, err := store.Put(chunk)
+			if err != nil {
+				return err
+			}
+
+			if hook := hooks.OnChunkProcessed; hook != nil {
+				hook(id, int64(len(chunk)), !stored)
+			}
+
+			chunkedDevice.Size += int64(len(chunk))
+			chunkedDevice.Chunks = append(chunkedDevice.Chunks, ChunkReference{
+				ID:   id,
+				Size: int64(len(chunk)),
+			})
+
+			return nil
+		}); err != nil {
+			return errors.Join(ErrCouldNotCopyToArchive, err)
+		}
+
+		index.Devices = append(index.Devices, chunkedDevice)
+		manifest.Devices = append(manifest.Devices, hasher.manifestDevice(device.Name))
+	}
+
+	index.Manifest, err = json.Marshal(manifest)
+	if err != nil {
+		return errors.Join(ErrCouldNotEncodeManifest, err)
+	}
+
+	if options.SigningKey != nil {
+		index.ManifestSignature = ed25519.Sign(options.SigningKey, index.Manifest)
+	}
+
+	if err := writeChunkIndex(index, packageOutput); err != nil {
+		return err
+	}
+
+	progress.finish()
+
+	return nil
+}
+
+func extractChunkedPackage(
+	ctx context.Context,
+
+	indexReader io.Reader,
+	devices []PackagerDevice,
+
+	options ExtractOptions,
+	hooks PackagerHooks,
+	progress *progressCounter,
+) error {
+	if strings.TrimSpace(options.ChunkStorePath) == "" {
+		return ErrMissingChunkStore
+	}
+
+	index, err := decodeChunkIndex(indexReader)
+	if err != nil {
+		return err
+	}
+
+	store, err := NewChunkStore(options.ChunkStorePath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	// The manifest and its signature are part of the index, but can be extracted like devices
+	entries := map[string]func() io.Reader{}
+	for _, device := range index.Devices {
+		entries[device.Name] = func() io.Reader {
+			return &chunkReader{
+				store:  store,
+				chunks: device.Chunks,
+			}
+		}
+	}
+	entries[ManifestName] = func() io.Reader {
+		return bytes.NewReader(index.Manifest)
+	}
+	if len(index.ManifestSignature) > 0 {
+		entries[ManifestSignatureName] = func() io.Reader {
+			return bytes.NewReader(index.ManifestSignature)
+		}
+	}
+
+	missingDevices := map[string][]PackagerDevice{}
+	for _, device := range devices {
+		missingDevices[device.Name] = append(missingDevices[device.Name], device)
+	}
+
+	for _, device := range index.Devices {
+		if _, ok := missingDevices[device.Name]; !ok {
+			if hook := hooks.OnUnknownFile; hook != nil {
+				hook(device.Name)
+			}
+		}
+	}
+
+	var (
+		extractedDevices = map[string]ManifestDevice{}
+		extractedPaths   = map[string]string{}
+	)
+	for _, name := range slices.Sorted(maps.Keys(missingDevices)) {
+	s:
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		default:
+			break s
+		}
+
+		entry, ok := entries[name]
+		if !ok {
+			continue
+		}
+
+		entryDevices := missingDevices[name]
+		delete(missingDevices, name)
+
+		hasher := newDeviceHasher(DefaultManifestBlockSize)
+		if err := extractEntry(ctx, io.TeeReader(progress.countDevice(entry()), hasher), entryDevices, options, hooks); err != nil {
+			return err
+		}
+
+		extractedDevices[name] = hasher.manifestDevice(name)
+		extractedPaths[name] = entryDevices[0].Path
+	}
+
+	if err := getMissingDevicesError(missingDevices); err != nil {
+		return err
+	}
+
+	progress.finish()
+
+	return verifyExtractedDevices(index.Manifest, index.ManifestSignature, extractedDevices, extractedPaths, options)
+}
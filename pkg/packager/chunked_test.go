@@ -0,0 +1,240 @@
+package packager_test
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/loopholelabs/drafter/pkg/packager"
+)
+
+const (
+	// Large enough for dozens of chunks
+	chunkedDiskSize = 1024 * 1024 * 4
+)
+
+// createChunkedDevices writes a config and a disk with random content to `dir`
+func createChunkedDevices(t *testing.T, dir string, disk []byte) []packager.PackagerDevice {
+	t.Helper()
+
+	devices := []packager.PackagerDevice{}
+	for name, content := range map[string][]byte{
+		packager.ConfigName: []byte(`{"agentVSockPort":26}`),
+		packager.DiskName:   disk,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		devices = append(devices, packager.PackagerDevice{
+			Name: name,
+			Path: path,
+		})
+	}
+
+	return devices
+}
+
+// archiveChunkedPackage archives `devices` into the chunk store at `chunkStorePath` and returns the path of the index and the
+// number of chunks that were written to the store and reused from it
+func archiveChunkedPackage(t *testing.T, devices []packager.PackagerDevice, chunkStorePath string) (indexPath string, stored, reused int) {
+	t.Helper()
+
+	indexPath = filepath.Join(t.TempDir(), "package.json")
+	if err := packager.ArchivePackage(context.Background(), devices, indexPath, packager.ArchiveOptions{
+		ChunkStorePath: chunkStorePath,
+	}, packager.PackagerHooks{
+		OnChunkProcessed: func(id string, size int64, r bool) {
+			if r {
+				reused++
+			} else {
+				stored++
+			}
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return indexPath, stored, reused
+}
+
+func extractChunkedPackage(t *testing.T, indexPath, chunkStorePath string, devices []packager.PackagerDevice) error {
+	t.Helper()
+
+	outputDevices := getOutputDevices(devices, t.TempDir())
+	if err := packager.ExtractPackage(context.Background(), indexPath, outputDevices, packager.ExtractOptions{
+		ChunkStorePath: chunkStorePath,
+	}, packager.PackagerHooks{}); err != nil {
+		return err
+	}
+
+	checkExtractedDevices(t, devices, outputDevices)
+
+	return nil
+}
+
+func TestChunkedPackagesShareChunks(t *testing.T) {
+	disk := make([]byte, chunkedDiskSize)
+	if _, err := rand.Read(disk); err != nil {
+		t.Fatal(err)
+	}
+
+	chunkStorePath := t.TempDir()
+
+	baseDevices := createChunkedDevices(t, t.TempDir(), disk)
+	baseIndexPath, baseStored, _ := archiveChunkedPackage(t, baseDevices, chunkStorePath)
+
+	if err := extractChunkedPackage(t, baseIndexPath, chunkStorePath, baseDevices); err != nil {
+		t.Fatal(err)
+	}
+
+	// Inserting data shifts the rest of the disk, but the content-defined chunk boundaries after the insertion stay the same
+	inserted := make([]byte, 100)
+	if _, err := rand.Read(inserted); err != nil {
+		t.Fatal(err)
+	}
+
+	devices := createChunkedDevices(t, t.TempDir(), append(append(append([]byte{}, disk[:chunkedDiskSize/2]...), inserted...), disk[chunkedDiskSize/2:]...))
+	indexPath, stored, reused := archiveChunkedPackage(t, devices, chunkStorePath)
+
+	if stored == 0 || stored > 4 {
+		t.Errorf("archiving a disk with a small insertion stored %v new chunks, want 1 to 4", stored)
+	}
+
+	if reused < baseStored-4 {
+		t.Errorf("archiving a disk with a small insertion reused %v of %v chunks", reused, baseStored)
+	}
+
+	if err := extractChunkedPackage(t, indexPath, chunkStorePath, devices); err != nil {
+		t.Fatal(err)
+	}
+
+	// The base package still references its own chunks
+	if err := extractChunkedPackage(t, baseIndexPath, chunkStorePath, baseDevices); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApplyDelta(t *testing.T) {
+	disk := make([]byte, chunkedDiskSize)
+	if _, err := rand.Read(disk); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		sourceChunkStorePath = t.TempDir()
+		targetChunkStorePath = t.TempDir()
+	)
+
+	// Both hosts have the base package
+	baseDevices := createChunkedDevices(t, t.TempDir(), disk)
+	baseIndexPath, _, _ := archiveChunkedPackage(t, baseDevices, sourceChunkStorePath)
+	_, _, _ = archiveChunkedPackage(t, baseDevices, targetChunkStorePath)
+
+	changed := append([]byte{}, disk...)
+	if _, err := rand.Read(changed[chunkedDiskSize/4 : chunkedDiskSize/4+1024]); err != nil {
+		t.Fatal(err)
+	}
+
+	devices := createChunkedDevices(t, t.TempDir(), changed)
+	indexPath, _, _ := archiveChunkedPackage(t, devices, sourceChunkStorePath)
+
+	deltaPath := filepath.Join(t.TempDir(), "delta.tar.zst")
+	if err := packager.CreateDelta(context.Background(), baseIndexPath, indexPath, sourceChunkStorePath, deltaPath, packager.PackagerHooks{}); err != nil {
+		t.Fatal(err)
+	}
+
+	deltaInfo, err := os.Stat(deltaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Random data doesn't compress, so the delta is only smaller than the disk if it doesn't contain the unchanged chunks
+	if deltaInfo.Size() > chunkedDiskSize/4 {
+		t.Errorf("delta has %v bytes, but only 1024 bytes of the %v bytes of the disk changed", deltaInfo.Size(), chunkedDiskSize)
+	}
+
+	// The package can't be extracted before the delta's chunks have been added to the target's store
+	if err := extractChunkedPackage(t, indexPath, targetChunkStorePath, devices); !errors.Is(err, packager.ErrMissingChunk) {
+		t.Errorf("extracting without the delta's chunks returned %v, want %v", err, packager.ErrMissingChunk)
+	}
+
+	appliedIndexPath := filepath.Join(t.TempDir(), "package.json")
+	if err := packager.ApplyDelta(context.Background(), deltaPath, targetChunkStorePath, appliedIndexPath, packager.PackagerHooks{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := extractChunkedPackage(t, appliedIndexPath, targetChunkStorePath, devices); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChunkStoreDetectsCorruptedChunks(t *testing.T) {
+	disk := make([]byte, chunkedDiskSize)
+	if _, err := rand.Read(disk); err != nil {
+		t.Fatal(err)
+	}
+
+	chunkStorePath := t.TempDir()
+
+	devices := createChunkedDevices(t, t.TempDir(), disk)
+	indexPath, _, _ := archiveChunkedPackage(t, devices, chunkStorePath)
+
+	// We replace a chunk with another one, which is still valid zstd but has different content
+	chunkPaths := []string{}
+	if err := filepath.WalkDir(chunkStorePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() {
+			chunkPaths = append(chunkPaths, path)
+		}
+
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(chunkPaths) < 2 {
+		t.Fatalf("chunk store has %v chunks, want at least 2", len(chunkPaths))
+	}
+
+	otherChunk, err := os.ReadFile(chunkPaths[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(chunkPaths[0], otherChunk, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := extractChunkedPackage(t, indexPath, chunkStorePath, devices); !errors.Is(err, packager.ErrChunkChecksumMismatch) {
+		t.Errorf("extracting a package with a corrupted chunk returned %v, want %v", err, packager.ErrChunkChecksumMismatch)
+	}
+}
+
+func TestReadChunkIndexRejectsInvalidChunkIDs(t *testing.T) {
+	for name, id := range map[string]string{
+		"path traversal": "../../../../etc/passwd",
+		"uppercase":      strings.Repeat("A", 64),
+		"too short":      strings.Repeat("a", 63),
+	} {
+		t.Run(name, func(t *testing.T) {
+			indexPath := filepath.Join(t.TempDir(), "package.json")
+			if err := os.WriteFile(indexPath, []byte(`{"version":1,"devices":[{"name":"disk","size":1,"chunks":[{"id":"`+id+`","size":1}]}]}`), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := packager.ReadChunkIndex(indexPath); !errors.Is(err, packager.ErrInvalidChunkID) {
+				t.Errorf("reading an index with chunk ID %q returned %v, want %v", id, err, packager.ErrInvalidChunkID)
+			}
+		})
+	}
+}
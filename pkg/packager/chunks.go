@@ -0,0 +1,276 @@
+package packager
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Chunks are cut where a rolling gear hash over the content matches a mask (content-defined chunking, like casync or FastCDC),
+// so inserting or removing data in a device only changes the chunks around the change instead of shifting all following chunks
+const (
+	chunkMinSize = 1024 * 16
+	chunkAvgSize = 1024 * 64
+	chunkMaxSize = 1024 * 256
+
+	chunkMask = chunkAvgSize - 1
+
+	chunkFileExtension = ".zst"
+
+	// Chunk IDs are hex-encoded SHA-256 sums
+	chunkIDLength = sha256.Size * 2
+)
+
+var (
+	gearTable = func() (table [256]uint64) {
+		// The table must never change, since that would change the boundaries and thus the IDs of all chunks
+		for i := range table {
+			sum := sha256.Sum256([]byte{byte(i)})
+
+			table[i] = binary.LittleEndian.Uint64(sum[:])
+		}
+
+		return table
+	}()
+)
+
+// findChunkBoundary returns the length of the next chunk at the start of `data`
+func findChunkBoundary(data []byte) int {
+	if len(data) <= chunkMinSize {
+		return len(data)
+	}
+
+	var hash uint64
+	for i := chunkMinSize; i < len(data); i++ {
+		hash = (hash << 1) + gearTable[data[i]]
+
+		if hash&chunkMask == 0 {
+			return i + 1
+		}
+	}
+
+	return len(data)
+}
+
+// splitChunks splits `r` into content-defined chunks; `onChunk` must not retain the chunk after it returns
+func splitChunks(r io.Reader, onChunk func(chunk []byte) error) error {
+	var (
+		buf = make([]byte, chunkMaxSize)
+		n   = 0
+		eof = false
+	)
+	for {
+		if !eof {
+			read, err := io.ReadFull(r, buf[n:])
+			n += read
+
+			if err != nil {
+				if err != io.EOF && err != io.ErrUnexpectedEOF {
+					return err
+				}
+
+				eof = true
+			}
+		}
+
+		if n == 0 {
+			return nil
+		}
+
+		boundary := findChunkBoundary(buf[:n])
+		if err := onChunk(buf[:boundary]); err != nil {
+			return err
+		}
+
+		n = copy(buf, buf[boundary:n])
+	}
+}
+
+// ChunkStore is a content-addressed store of compressed chunks in a directory; chunks are identified by the SHA-256 of their
+// uncompressed content, so chunks that are shared between packages or devices are only stored once
+type ChunkStore struct {
+	path string
+
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func NewChunkStore(path string) (*ChunkStore, error) {
+	if err := os.MkdirAll(path, os.ModePerm); err != nil {
+		return nil, errors.Join(ErrCouldNotCreateChunkStore, err)
+	}
+
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotCreateCompressor, err)
+	}
+
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		_ = encoder.Close()
+
+		return nil, errors.Join(ErrCouldNotCreateUncompressor, err)
+	}
+
+	return &ChunkStore{
+		path: path,
+
+		encoder: encoder,
+		decoder: decoder,
+	}, nil
+}
+
+func getChunkID(chunk []byte) string {
+	sum := sha256.Sum256(chunk)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// validateChunkID checks that `id` is a chunk ID, since IDs from indexes and deltas are used in paths in the store
+func validateChunkID(id string) error {
+	if len(id) != chunkIDLength {
+		return errors.Join(ErrInvalidChunkID, fmt.Errorf("chunk ID %q", id))
+	}
+
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return errors.Join(ErrInvalidChunkID, fmt.Errorf("chunk ID %q", id))
+		}
+	}
+
+	return nil
+}
+
+func (s *ChunkStore) chunkPath(id string) (string, error) {
+	if err := validateChunkID(id); err != nil {
+		return "", err
+	}
+
+	// We shard the chunks into subdirectories so that no single directory gets too large
+	return filepath.Join(s.path, id[:4], id+chunkFileExtension), nil
+}
+
+// Has returns whether the chunk with `id` is in the store
+func (s *ChunkStore) Has(id string) bool {
+	chunkPath, err := s.chunkPath(id)
+	if err != nil {
+		return false
+	}
+
+	_, err = os.Stat(chunkPath)
+
+	return err == nil
+}
+
+// Put stores `chunk` and returns its ID; `stored` is false if the chunk was already in the store
+func (s *ChunkStore) Put(chunk []byte) (id string, stored bool, err error) {
+	id = getChunkID(chunk)
+	if s.Has(id) {
+		return id, false, nil
+	}
+
+	chunkPath, err := s.chunkPath(id)
+	if err != nil {
+		return "", false, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(chunkPath), os.ModePerm); err != nil {
+		return "", false, errors.Join(ErrCouldNotWriteChunk, err)
+	}
+
+	// We write to a temporary file first so that concurrent readers never see partially written chunks
+	chunkFile, err := os.CreateTemp(filepath.Dir(chunkPath), id+".*.tmp")
+	if err != nil {
+		return "", false, errors.Join(ErrCouldNotWriteChunk, err)
+	}
+	defer os.Remove(chunkFile.Name())
+	defer chunkFile.Close()
+
+	if _, err := chunkFile.Write(s.encoder.EncodeAll(chunk, nil)); err != nil {
+		return "", false, errors.Join(ErrCouldNotWriteChunk, err)
+	}
+
+	if err := chunkFile.Close(); err != nil {
+		return "", false, errors.Join(ErrCouldNotWriteChunk, err)
+	}
+
+	if err := os.Rename(chunkFile.Name(), chunkPath); err != nil {
+		return "", false, errors.Join(ErrCouldNotWriteChunk, err)
+	}
+
+	return id, true, nil
+}
+
+// Get returns the content of the chunk with `id`, and an error that matches `ErrChunkChecksumMismatch` if it has been corrupted
+func (s *ChunkStore) Get(id string) ([]byte, error) {
+	chunkPath, err := s.chunkPath(id)
+	if err != nil {
+		return nil, err
+	}
+
+	compressed, err := os.ReadFile(chunkPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errors.Join(ErrMissingChunk, fmt.Errorf("chunk %v", id))
+		}
+
+		return nil, errors.Join(ErrCouldNotReadChunk, err)
+	}
+
+	chunk, err := s.decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotReadChunk, err)
+	}
+
+	if actual := getChunkID(chunk); actual != id {
+		return nil, errors.Join(ErrChunkChecksumMismatch, fmt.Errorf("chunk %v has SHA-256 %v", id, actual))
+	}
+
+	return chunk, nil
+}
+
+func (s *ChunkStore) Close() error {
+	s.decoder.Close()
+
+	return s.encoder.Close()
+}
+
+// chunkReader reads the concatenated content of `chunks` from a chunk store
+type chunkReader struct {
+	store  *ChunkStore
+	chunks []ChunkReference
+
+	current []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.current) == 0 {
+		if len(r.chunks) == 0 {
+			return 0, io.EOF
+		}
+
+		chunk, err := r.store.Get(r.chunks[0].ID)
+		if err != nil {
+			return 0, err
+		}
+
+		if int64(len(chunk)) != r.chunks[0].Size {
+			return 0, errors.Join(ErrChunkChecksumMismatch, fmt.Errorf("chunk %v has size %v, expected %v", r.chunks[0].ID, len(chunk), r.chunks[0].Size))
+		}
+
+		r.current = chunk
+		r.chunks = r.chunks[1:]
+	}
+
+	n := copy(p, r.current)
+	r.current = r.current[n:]
+
+	return n, nil
+}
@@ -0,0 +1,195 @@
+package packager
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The compression parameters of a package are recorded in a skippable frame at the start of the package, which regular zstd decoders
+// ignore, so that the package can be extracted with matching decoder settings (and without having to pass its dictionary separately)
+const (
+	compressionParametersFrameMagic = 0x184D2A50
+	compressionParametersVersion    = 1
+
+	skippableFrameHeaderSize = 8
+
+	// Maximum size of the compression parameters frame, which mostly consists of the dictionary
+	maxCompressionParametersSize = 1024 * 1024 * 16
+
+	zstdFrameMagic = 0xFD2FB528
+
+	tarMagicOffset = 257
+	tarMagic       = "ustar"
+)
+
+type CompressionOptions struct {
+	// Whether to write the package without compression, e.g. for devices that are already compressed; the package is then a plain
+	// tar archive, and `Seekable` can't be used
+	Disabled bool `json:"disabled,omitempty"`
+
+	// zstd compression level from 1 (fastest) to 22 (smallest); levels are mapped to the closest level that the encoder implements.
+	// If 0, zstd's default level is used.
+	Level int `json:"level,omitempty"`
+	// Number of goroutines that compress in parallel; if 0, `GOMAXPROCS` is used. This only depends on the host that archives the
+	// package, so it isn't recorded (see `ExtractOptions.DecoderConcurrency` for extracting).
+	Concurrency int `json:"-"`
+	// Maximum distance of back-references in bytes, which must be a power of two between 1 KiB and 512 MiB; larger windows can compress
+	// large devices better, but need more memory to compress and extract. If 0, the default for the level is used.
+	WindowSize int `json:"windowSize,omitempty"`
+
+	// zstd dictionary to compress with, e.g. one created with `zstd --train`; it is stored in the package
+	Dictionary []byte `json:"-"`
+}
+
+type compressionParameters struct {
+	Version int `json:"version"`
+
+	CompressionOptions
+
+	Dictionary []byte `json:"dictionary,omitempty"`
+}
+
+func (o CompressionOptions) encoderOptions() []zstd.EOption {
+	encoderOptions := []zstd.EOption{}
+	if o.Level != 0 {
+		encoderOptions = append(encoderOptions, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(o.Level)))
+	}
+
+	if o.Concurrency > 0 {
+		encoderOptions = append(encoderOptions, zstd.WithEncoderConcurrency(o.Concurrency))
+	}
+
+	if o.WindowSize > 0 {
+		encoderOptions = append(encoderOptions, zstd.WithWindowSize(o.WindowSize))
+	}
+
+	if len(o.Dictionary) > 0 {
+		encoderOptions = append(encoderOptions, zstd.WithEncoderDict(o.Dictionary))
+	}
+
+	return encoderOptions
+}
+
+// decoderOptions returns the options to decode a package that was compressed with `o` with `concurrency` goroutines, which uses
+// zstd's default if 0
+func (o CompressionOptions) decoderOptions(concurrency int) []zstd.DOption {
+	decoderOptions := []zstd.DOption{}
+	if concurrency > 0 {
+		decoderOptions = append(decoderOptions, zstd.WithDecoderConcurrency(concurrency))
+	}
+
+	// We only allow windows up to the size that was used to compress so that corrupted packages can't make us allocate more memory
+	if o.WindowSize > 0 {
+		decoderOptions = append(decoderOptions, zstd.WithDecoderMaxWindow(uint64(max(o.WindowSize, zstd.MinWindowSize))))
+	}
+
+	if len(o.Dictionary) > 0 {
+		decoderOptions = append(decoderOptions, zstd.WithDecoderDicts(o.Dictionary))
+	}
+
+	return decoderOptions
+}
+
+// newCompressionParametersFrame returns the skippable frame that records `options`
+func newCompressionParametersFrame(options CompressionOptions) ([]byte, error) {
+	rawParameters, err := json.Marshal(compressionParameters{
+		Version: compressionParametersVersion,
+
+		CompressionOptions: options,
+
+		Dictionary: options.Dictionary,
+	})
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotEncodeCompressionParameters, err)
+	}
+
+	frame := make([]byte, 0, skippableFrameHeaderSize+len(rawParameters))
+	frame = binary.LittleEndian.AppendUint32(frame, compressionParametersFrameMagic)
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(rawParameters)))
+
+	return append(frame, rawParameters...), nil
+}
+
+func decodeCompressionParameters(rawParameters []byte) (CompressionOptions, error) {
+	var parameters compressionParameters
+	if err := json.Unmarshal(rawParameters, &parameters); err != nil {
+		return CompressionOptions{}, errors.Join(ErrCouldNotDecodeCompressionParameters, err)
+	}
+
+	if parameters.Version != compressionParametersVersion {
+		return CompressionOptions{}, errors.Join(ErrUnsupportedCompressionParametersVersion, fmt.Errorf("compression parameters version %v", parameters.Version))
+	}
+
+	options := parameters.CompressionOptions
+	options.Dictionary = parameters.Dictionary
+
+	return options, nil
+}
+
+// readCompressionParametersFrame reads the compression parameters frame at the start of `r` if there is one; packages created by older
+// versions of Drafter don't have one and use the default parameters
+func readCompressionParametersFrame(r *bufio.Reader) (options CompressionOptions, ok bool, err error) {
+	header, err := r.Peek(skippableFrameHeaderSize)
+	if err != nil || binary.LittleEndian.Uint32(header) != compressionParametersFrameMagic {
+		return CompressionOptions{}, false, nil
+	}
+
+	size := binary.LittleEndian.Uint32(header[4:])
+	if size > maxCompressionParametersSize {
+		return CompressionOptions{}, false, errors.Join(ErrCouldNotDecodeCompressionParameters, fmt.Errorf("compression parameters of %v bytes are too large", size))
+	}
+
+	if _, err := r.Discard(skippableFrameHeaderSize); err != nil {
+		return CompressionOptions{}, false, errors.Join(ErrCouldNotDecodeCompressionParameters, err)
+	}
+
+	rawParameters := make([]byte, size)
+	if _, err := io.ReadFull(r, rawParameters); err != nil {
+		return CompressionOptions{}, false, errors.Join(ErrCouldNotDecodeCompressionParameters, err)
+	}
+
+	options, err = decodeCompressionParameters(rawParameters)
+	if err != nil {
+		return CompressionOptions{}, false, err
+	}
+
+	return options, true, nil
+}
+
+// isUncompressedArchive returns whether `r` contains a plain tar archive instead of a compressed one
+func isUncompressedArchive(r *bufio.Reader) bool {
+	header, err := r.Peek(tarMagicOffset + len(tarMagic))
+	if err != nil {
+		return false
+	}
+
+	return binary.LittleEndian.Uint32(header) != zstdFrameMagic && string(header[tarMagicOffset:]) == tarMagic
+}
+
+// newPackageArchiveReader returns a reader for the uncompressed archive in `r` with decoder settings that match the ones that the
+// package was compressed with, as well as these settings; it decompresses with `concurrency` goroutines, or zstd's default if 0
+func newPackageArchiveReader(r *bufio.Reader, concurrency int) (io.ReadCloser, CompressionOptions, error) {
+	if isUncompressedArchive(r) {
+		return io.NopCloser(r), CompressionOptions{
+			Disabled: true,
+		}, nil
+	}
+
+	options, _, err := readCompressionParametersFrame(r)
+	if err != nil {
+		return nil, CompressionOptions{}, err
+	}
+
+	uncompressor, err := zstd.NewReader(r, options.decoderOptions(concurrency)...)
+	if err != nil {
+		return nil, CompressionOptions{}, errors.Join(ErrCouldNotCreateUncompressor, err)
+	}
+
+	return uncompressor.IOReadCloser(), options, nil
+}
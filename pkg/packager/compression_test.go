@@ -0,0 +1,221 @@
+package packager_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/loopholelabs/drafter/pkg/packager"
+)
+
+// createDictionary creates a zstd dictionary from the kernel in `devices`
+func createDictionary(t *testing.T, devices []packager.PackagerDevice) []byte {
+	t.Helper()
+
+	kernelIndex := slices.IndexFunc(devices, func(device packager.PackagerDevice) bool {
+		return device.Name == packager.KernelName
+	})
+	kernel, err := os.ReadFile(devices[kernelIndex].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents := [][]byte{}
+	for offset := 0; offset < len(kernel); offset += 4096 {
+		contents = append(contents, kernel[offset:min(offset+4096, len(kernel))])
+	}
+
+	dictionary, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       1,
+		Contents: contents,
+		History:  kernel[:8192],
+		Offsets:  [3]int{1, 4, 8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dictionary
+}
+
+// readCompressionParametersFrame returns the skippable frame at the start of the package at `packagePath`
+func readCompressionParametersFrame(t *testing.T, packagePath string) []byte {
+	t.Helper()
+
+	content, err := os.ReadFile(packagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(content) < 8 || binary.LittleEndian.Uint32(content) != 0x184D2A50 {
+		t.Fatalf("package %v doesn't start with a compression parameters frame", packagePath)
+	}
+
+	return content[:8+binary.LittleEndian.Uint32(content[4:])]
+}
+
+func TestCompressionOptionsRoundTrip(t *testing.T) {
+	devices := createDevices(t, t.TempDir())
+	dictionary := createDictionary(t, devices)
+
+	for name, test := range map[string]struct {
+		options  packager.CompressionOptions
+		expected packager.CompressionOptions
+	}{
+		"defaults": {},
+		"level": {
+			options: packager.CompressionOptions{
+				Level: 19,
+			},
+			expected: packager.CompressionOptions{
+				Level: 19,
+			},
+		},
+		"window size": {
+			options: packager.CompressionOptions{
+				WindowSize: 1024 * 64,
+			},
+			expected: packager.CompressionOptions{
+				WindowSize: 1024 * 64,
+			},
+		},
+		"concurrency": {
+			options: packager.CompressionOptions{
+				Concurrency: 4,
+			},
+		},
+		"dictionary": {
+			options: packager.CompressionOptions{
+				Level:      3,
+				Dictionary: dictionary,
+			},
+			expected: packager.CompressionOptions{
+				Level:      3,
+				Dictionary: dictionary,
+			},
+		},
+		"disabled": {
+			options: packager.CompressionOptions{
+				Disabled: true,
+			},
+			expected: packager.CompressionOptions{
+				Disabled: true,
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			packagePath := archivePackage(t, devices, packager.ArchiveOptions{
+				Compression: test.options,
+			})
+
+			info, err := packager.InspectPackage(context.Background(), packagePath, packager.ExtractOptions{}, packager.PackagerHooks{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if info.Compression == nil || !reflect.DeepEqual(*info.Compression, test.expected) {
+				t.Errorf("package has compression options %+v, want %+v", info.Compression, test.expected)
+			}
+
+			// The package can be extracted with any decoder concurrency, since the recorded parameters are used to decode it
+			for _, decoderConcurrency := range []int{0, 1, 4} {
+				outputDevices := getOutputDevices(devices, t.TempDir())
+				if err := packager.ExtractPackage(context.Background(), packagePath, outputDevices, packager.ExtractOptions{
+					DecoderConcurrency: decoderConcurrency,
+				}, packager.PackagerHooks{}); err != nil {
+					t.Fatal(err)
+				}
+
+				checkExtractedDevices(t, devices, outputDevices)
+			}
+		})
+	}
+}
+
+func TestCompressionConcurrencyIsNotRecorded(t *testing.T) {
+	devices := createDevices(t, t.TempDir())
+
+	frames := [][]byte{}
+	for _, concurrency := range []int{1, 4} {
+		frames = append(frames, readCompressionParametersFrame(t, archivePackage(t, devices, packager.ArchiveOptions{
+			Compression: packager.CompressionOptions{
+				Level:       3,
+				Concurrency: concurrency,
+			},
+		})))
+	}
+
+	if !bytes.Equal(frames[0], frames[1]) {
+		t.Errorf("packages compressed with different concurrencies have different compression parameters %s and %s", frames[0], frames[1])
+	}
+}
+
+func TestDictionaryPackagesRequireTheirDictionary(t *testing.T) {
+	devices := createDevices(t, t.TempDir())
+	packagePath := archivePackage(t, devices, packager.ArchiveOptions{
+		Compression: packager.CompressionOptions{
+			Dictionary: createDictionary(t, devices),
+		},
+	})
+
+	// Regular zstd decoders skip the compression parameters frame, so they don't have the dictionary
+	packageFile, err := os.Open(packagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer packageFile.Close()
+
+	decoder, err := zstd.NewReader(packageFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoder.Close()
+
+	if _, err := io.Copy(io.Discard, decoder); !errors.Is(err, zstd.ErrUnknownDictionary) {
+		t.Errorf("decompressing without the dictionary returned %v, want %v", err, zstd.ErrUnknownDictionary)
+	}
+}
+
+func TestUncompressedPackagesAreTarArchives(t *testing.T) {
+	devices := createDevices(t, t.TempDir())
+	packagePath := archivePackage(t, devices, packager.ArchiveOptions{
+		Compression: packager.CompressionOptions{
+			Disabled: true,
+		},
+	})
+
+	packageFile, err := os.Open(packagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer packageFile.Close()
+
+	names := []string{}
+	archive := tar.NewReader(packageFile)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names = append(names, header.Name)
+	}
+
+	for _, device := range devices {
+		if !slices.Contains(names, device.Name) {
+			t.Errorf("entries %v don't contain device %v", names, device.Name)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package packager
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// Delta entry that contains the raw chunk index of the new package
+	deltaIndexName = "index"
+	// Prefix of the delta entries that contain chunks, which are followed by the chunk's ID
+	deltaChunkPrefix = "chunks/"
+)
+
+// CreateDelta writes a delta to `deltaOutputPath` that contains the chunked package at `indexPath` and all of its chunks that
+// the chunked package at `baseIndexPath` doesn't reference, so that hosts that already have the base package only need to download
+// the chunks that have changed. The chunks are read from the chunk store at `chunkStorePath`.
+func CreateDelta(
+	ctx context.Context,
+
+	baseIndexPath string,
+	indexPath string,
+	chunkStorePath string,
+
+	deltaOutputPath string,
+
+	hooks PackagerHooks,
+) error {
+	baseIndex, err := ReadChunkIndex(baseIndexPath)
+	if err != nil {
+		return err
+	}
+
+	// We copy the raw index into the delta so that its manifest stays byte-for-byte the same and its signature remains valid
+	rawIndex, err := os.ReadFile(indexPath)
+	if err != nil {
+		return errors.Join(ErrCouldNotOpenPackageInputFile, err)
+	}
+
+	index, err := decodeChunkIndex(bytes.NewReader(rawIndex))
+	if err != nil {
+		return err
+	}
+
+	store, err := NewChunkStore(chunkStorePath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	deltaOutputFile, err := os.OpenFile(deltaOutputPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		return errors.Join(ErrCouldNotOpenPackageOutputFile, err)
+	}
+	defer deltaOutputFile.Close()
+
+	compressor, err := zstd.NewWriter(deltaOutputFile)
+	if err != nil {
+		return errors.Join(ErrCouldNotCreateCompressor, err)
+	}
+	defer compressor.Close()
+
+	deltaArchive := tar.NewWriter(compressor)
+	defer deltaArchive.Close()
+
+	if err := writeArchiveEntry(deltaArchive, deltaIndexName, rawIndex); err != nil {
+		return errors.Join(ErrCouldNotWriteDelta, err)
+	}
+
+	// Chunks that the host already has or that we've already added
+	presentChunks := map[string]struct{}{}
+	for _, device := range baseIndex.Devices {
+		for _, chunk := range device.Chunks {
+			presentChunks[chunk.ID] = struct{}{}
+		}
+	}
+
+	for _, device := range index.Devices {
+		if hook := hooks.OnBeforeProcessFile; hook != nil {
+			hook(device.Name, indexPath)
+		}
+
+		for _, chunk := range device.Chunks {
+		s:
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+
+			default:
+				break s
+			}
+
+			if _, ok := presentChunks[chunk.ID]; ok {
+				if hook := hooks.OnChunkProcessed; hook != nil {
+					hook(chunk.ID, chunk.Size, true)
+				}
+
+				continue
+			}
+
+			content, err := store.Get(chunk.ID)
+			if err != nil {
+				return err
+			}
+
+			if err := writeArchiveEntry(deltaArchive, deltaChunkPrefix+chunk.ID, content); err != nil {
+				return errors.Join(ErrCouldNotWriteDelta, err)
+			}
+
+			presentChunks[chunk.ID] = struct{}{}
+
+			if hook := hooks.OnChunkProcessed; hook != nil {
+				hook(chunk.ID, chunk.Size, false)
+			}
+		}
+	}
+
+	if err := deltaArchive.Close(); err != nil {
+		return errors.Join(ErrCouldNotCloseArchive, err)
+	}
+
+	if err := compressor.Close(); err != nil {
+		return errors.Join(ErrCouldNotCloseArchive, err)
+	}
+
+	return nil
+}
+
+// ApplyDelta adds the chunks in the delta at `deltaInputPath` to the chunk store at `chunkStorePath`, and writes the chunked
+// package that the delta contains to `indexOutputPath` once all of its chunks are in the store
+func ApplyDelta(
+	ctx context.Context,
+
+	deltaInputPath string,
+	chunkStorePath string,
+
+	indexOutputPath string,
+
+	hooks PackagerHooks,
+) error {
+	deltaInputFile, err := os.Open(deltaInputPath)
+	if err != nil {
+		return errors.Join(ErrCouldNotOpenPackageInputFile, err)
+	}
+	defer deltaInputFile.Close()
+
+	uncompressor, err := zstd.NewReader(deltaInputFile)
+	if err != nil {
+		return errors.Join(ErrCouldNotCreateUncompressor, err)
+	}
+	defer uncompressor.Close()
+
+	store, err := NewChunkStore(chunkStorePath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	deltaArchive := tar.NewReader(uncompressor)
+
+	var rawIndex []byte
+	for {
+	s:
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		default:
+			break s
+		}
+
+		header, err := deltaArchive.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			return errors.Join(ErrCouldNotReadNextHeader, err)
+		}
+
+		switch {
+		case header.Name == deltaIndexName:
+			rawIndex, err = readLimited(deltaArchive, maxChunkIndexSize)
+			if err != nil {
+				return errors.Join(ErrCouldNotReadDelta, err)
+			}
+
+		case strings.HasPrefix(header.Name, deltaChunkPrefix):
+			content, err := readLimited(deltaArchive, chunkMaxSize)
+			if err != nil {
+				return errors.Join(ErrCouldNotReadDelta, err)
+			}
+
+			id, stored, err := store.Put(content)
+			if err != nil {
+				return err
+			}
+
+			if expectedID := strings.TrimPrefix(header.Name, deltaChunkPrefix); id != expectedID {
+				return errors.Join(ErrChunkChecksumMismatch, fmt.Errorf("chunk %v has SHA-256 %v", expectedID, id))
+			}
+
+			if hook := hooks.OnChunkProcessed; hook != nil {
+				hook(id, int64(len(content)), !stored)
+			}
+
+		default:
+			if hook := hooks.OnUnknownFile; hook != nil {
+				hook(header.Name)
+			}
+		}
+	}
+
+	if rawIndex == nil {
+		return ErrMissingDeltaIndex
+	}
+
+	index, err := decodeChunkIndex(bytes.NewReader(rawIndex))
+	if err != nil {
+		return err
+	}
+
+	// The delta only contains the chunks that the base package didn't have, so the rest must already be in the store
+	missingChunks := 0
+	for _, device := range index.Devices {
+		for _, chunk := range device.Chunks {
+			if !store.Has(chunk.ID) {
+				missingChunks++
+			}
+		}
+	}
+
+	if missingChunks > 0 {
+		return errors.Join(ErrMissingChunk, fmt.Errorf("%v chunks are neither in the delta nor in the chunk store; is the base package in the store?", missingChunks))
+	}
+
+	if err := os.WriteFile(indexOutputPath, rawIndex, os.ModePerm); err != nil {
+		return errors.Join(ErrCouldNotOpenPackageOutputFile, err)
+	}
+
+	return nil
+}
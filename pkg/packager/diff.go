@@ -0,0 +1,265 @@
+package packager
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+)
+
+const (
+	// Block size that is used to compare devices if none is set
+	DefaultDiffBlockSize = 1024 * 64
+)
+
+type ChangedRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// DeviceDiff describes how a device differs from the device with the same name in the base package or device set
+type DeviceDiff struct {
+	Name string `json:"name"`
+
+	BaseSize int64 `json:"baseSize"`
+	Size     int64 `json:"size"`
+
+	// Whether the device only exists in the new or only in the base package or device set
+	Added   bool `json:"added,omitempty"`
+	Removed bool `json:"removed,omitempty"`
+
+	// Ranges that differ, at block granularity, with adjacent changed blocks merged into one range; blocks that only exist in
+	// the larger of the two devices count as changed
+	ChangedRanges []ChangedRange `json:"changedRanges"`
+	ChangedBytes  int64          `json:"changedBytes"`
+}
+
+// blockHashes are the SHA-256 hashes of the blocks of a device
+type blockHashes struct {
+	size   int64
+	hashes [][sha256.Size]byte
+}
+
+func hashBlocks(ctx context.Context, r io.Reader, blockSize int64) (*blockHashes, error) {
+	var (
+		blocks = &blockHashes{
+			hashes: [][sha256.Size]byte{},
+		}
+		buf = make([]byte, blockSize)
+	)
+	for {
+	s:
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+
+		default:
+			break s
+		}
+
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			blocks.size += int64(n)
+			blocks.hashes = append(blocks.hashes, sha256.Sum256(buf[:n]))
+		}
+
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return blocks, nil
+			}
+
+			return nil, err
+		}
+	}
+}
+
+func diffBlocks(name string, base, current *blockHashes, blockSize int64) DeviceDiff {
+	diff := DeviceDiff{
+		Name: name,
+
+		ChangedRanges: []ChangedRange{},
+	}
+
+	switch {
+	case base == nil:
+		diff.Added = true
+		base = &blockHashes{}
+
+	case current == nil:
+		diff.Removed = true
+		current = &blockHashes{}
+	}
+
+	diff.BaseSize = base.size
+	diff.Size = current.size
+
+	size := max(base.size, current.size)
+	for block := 0; block < max(len(base.hashes), len(current.hashes)); block++ {
+		if block < len(base.hashes) && block < len(current.hashes) && base.hashes[block] == current.hashes[block] {
+			continue
+		}
+
+		offset := int64(block) * blockSize
+		length := min(blockSize, size-offset)
+
+		if last := len(diff.ChangedRanges) - 1; last >= 0 && diff.ChangedRanges[last].Offset+diff.ChangedRanges[last].Length == offset {
+			diff.ChangedRanges[last].Length += length
+		} else {
+			diff.ChangedRanges = append(diff.ChangedRanges, ChangedRange{
+				Offset: offset,
+				Length: length,
+			})
+		}
+
+		diff.ChangedBytes += length
+	}
+
+	return diff
+}
+
+func diffBlockSets(base, current map[string]*blockHashes, blockSize int64) []DeviceDiff {
+	names := map[string]struct{}{}
+	for name := range base {
+		names[name] = struct{}{}
+	}
+	for name := range current {
+		names[name] = struct{}{}
+	}
+
+	diffs := []DeviceDiff{}
+	for _, name := range slices.Sorted(maps.Keys(names)) {
+		diffs = append(diffs, diffBlocks(name, base[name], current[name], blockSize))
+	}
+
+	return diffs
+}
+
+func hashPackageBlocks(
+	ctx context.Context,
+
+	packageInputPath string,
+	blockSize int64,
+
+	options ExtractOptions,
+	hooks PackagerHooks,
+) (map[string]*blockHashes, error) {
+	blocks := map[string]*blockHashes{}
+	if _, _, err := walkPackage(ctx, packageInputPath, options, func(name string, size int64, entry io.Reader) error {
+		// The manifest and its signature describe the devices, so they always change if any device changes
+		if name == ManifestName || name == ManifestSignatureName {
+			return nil
+		}
+
+		if hook := hooks.OnBeforeProcessFile; hook != nil {
+			hook(name, packageInputPath)
+		}
+
+		entryBlocks, err := hashBlocks(ctx, entry, blockSize)
+		if err != nil {
+			return errors.Join(ErrCouldNotHashDevice, err)
+		}
+
+		blocks[name] = entryBlocks
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
+// DiffPackages compares the devices in the package at `packageInputPath` to those in the package at `basePackageInputPath` block by
+// block, and returns the ranges that changed for each device sorted by name. Both packages are read once without extracting them; for
+// chunked packages, `options.ChunkStorePath` must be set. If `blockSize` is 0, `DefaultDiffBlockSize` is used.
+func DiffPackages(
+	ctx context.Context,
+
+	basePackageInputPath string,
+	packageInputPath string,
+
+	blockSize int64,
+
+	options ExtractOptions,
+	hooks PackagerHooks,
+) ([]DeviceDiff, error) {
+	if blockSize == 0 {
+		blockSize = DefaultDiffBlockSize
+	}
+
+	if blockSize < 0 {
+		return nil, errors.Join(ErrInvalidBlockSize, fmt.Errorf("block size %v", blockSize))
+	}
+
+	baseBlocks, err := hashPackageBlocks(ctx, basePackageInputPath, blockSize, options, hooks)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks, err := hashPackageBlocks(ctx, packageInputPath, blockSize, options, hooks)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffBlockSets(baseBlocks, blocks, blockSize), nil
+}
+
+func hashDeviceBlocks(ctx context.Context, devices []PackagerDevice, blockSize int64, hooks PackagerHooks) (map[string]*blockHashes, error) {
+	blocks := map[string]*blockHashes{}
+	for _, device := range devices {
+		if hook := hooks.OnBeforeProcessFile; hook != nil {
+			hook(device.Name, device.Path)
+		}
+
+		f, err := os.Open(device.Path)
+		if err != nil {
+			return nil, errors.Join(ErrCouldNotOpenDevice, err)
+		}
+
+		deviceBlocks, err := hashBlocks(ctx, f, blockSize)
+		_ = f.Close()
+		if err != nil {
+			return nil, errors.Join(ErrCouldNotHashDevice, err)
+		}
+
+		blocks[device.Name] = deviceBlocks
+	}
+
+	return blocks, nil
+}
+
+// DiffDevices is like `DiffPackages`, but compares two sets of devices on disk, e.g. ones that have been extracted from two packages
+func DiffDevices(
+	ctx context.Context,
+
+	baseDevices []PackagerDevice,
+	devices []PackagerDevice,
+
+	blockSize int64,
+
+	hooks PackagerHooks,
+) ([]DeviceDiff, error) {
+	if blockSize == 0 {
+		blockSize = DefaultDiffBlockSize
+	}
+
+	if blockSize < 0 {
+		return nil, errors.Join(ErrInvalidBlockSize, fmt.Errorf("block size %v", blockSize))
+	}
+
+	baseBlocks, err := hashDeviceBlocks(ctx, baseDevices, blockSize, hooks)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks, err := hashDeviceBlocks(ctx, devices, blockSize, hooks)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffBlockSets(baseBlocks, blocks, blockSize), nil
+}
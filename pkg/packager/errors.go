@@ -3,18 +3,76 @@ package packager
 import "errors"
 
 var (
-	ErrMissingDevice                 = errors.New("missing resource")
-	ErrCouldNotOpenPackageOutputFile = errors.New("could not open package output file")
-	ErrCouldNotCreateCompressor      = errors.New("could not create compressor")
-	ErrCouldNotStatDevice            = errors.New("could not stat device")
-	ErrCouldNotCreateTarHeader       = errors.New("could not create tar header")
-	ErrCouldNotWriteTarHeader        = errors.New("could not write tar header")
-	ErrCouldNotOpenDevice            = errors.New("could not open device file")
-	ErrCouldNotCopyToArchive         = errors.New("could not copy file to archive")
-	ErrCouldNotOpenPackageInputFile  = errors.New("could not open package input file")
-	ErrCouldNotCreateUncompressor    = errors.New("could not create uncompressor")
-	ErrCouldNotReadNextHeader        = errors.New("could not read next header from archive")
-	ErrCouldNotCreateOutputDir       = errors.New("could not create output directory")
-	ErrCouldNotOpenOutputFile        = errors.New("could not open output file")
-	ErrCouldNotCopyToOutput          = errors.New("could not copy file to output")
+	ErrMissingDevice                           = errors.New("missing resource")
+	ErrCouldNotOpenPackageOutputFile           = errors.New("could not open package output file")
+	ErrCouldNotCreateCompressor                = errors.New("could not create compressor")
+	ErrCouldNotStatDevice                      = errors.New("could not stat device")
+	ErrCouldNotCreateTarHeader                 = errors.New("could not create tar header")
+	ErrCouldNotWriteTarHeader                  = errors.New("could not write tar header")
+	ErrCouldNotOpenDevice                      = errors.New("could not open device file")
+	ErrCouldNotCopyToArchive                   = errors.New("could not copy file to archive")
+	ErrCouldNotOpenPackageInputFile            = errors.New("could not open package input file")
+	ErrCouldNotCreateUncompressor              = errors.New("could not create uncompressor")
+	ErrCouldNotReadNextHeader                  = errors.New("could not read next header from archive")
+	ErrCouldNotCreateOutputDir                 = errors.New("could not create output directory")
+	ErrCouldNotOpenOutputFile                  = errors.New("could not open output file")
+	ErrCouldNotCopyToOutput                    = errors.New("could not copy file to output")
+	ErrReservedDeviceName                      = errors.New("device name is reserved")
+	ErrCouldNotHashDevice                      = errors.New("could not hash device")
+	ErrCouldNotEncodeManifest                  = errors.New("could not encode manifest")
+	ErrCouldNotWriteManifest                   = errors.New("could not write manifest")
+	ErrCouldNotReadManifest                    = errors.New("could not read manifest")
+	ErrCouldNotDecodeManifest                  = errors.New("could not decode manifest")
+	ErrUnsupportedManifestVersion              = errors.New("unsupported manifest version")
+	ErrMissingManifest                         = errors.New("missing manifest")
+	ErrMissingManifestSignature                = errors.New("missing manifest signature")
+	ErrInvalidManifestSignature                = errors.New("invalid manifest signature")
+	ErrDeviceNotInManifest                     = errors.New("device not in manifest")
+	ErrDeviceChecksumMismatch                  = errors.New("device checksum mismatch")
+	ErrNotSeekablePackage                      = errors.New("package is not seekable")
+	ErrCouldNotReadSeekTable                   = errors.New("could not read seek table")
+	ErrCouldNotReadFrame                       = errors.New("could not read frame")
+	ErrReadOnlyDevice                          = errors.New("device is read-only")
+	ErrCouldNotCloseArchive                    = errors.New("could not close archive")
+	ErrCouldNotCreateChunkStore                = errors.New("could not create chunk store")
+	ErrCouldNotWriteChunk                      = errors.New("could not write chunk")
+	ErrCouldNotReadChunk                       = errors.New("could not read chunk")
+	ErrMissingChunk                            = errors.New("missing chunk")
+	ErrChunkChecksumMismatch                   = errors.New("chunk checksum mismatch")
+	ErrMissingChunkStore                       = errors.New("missing chunk store for chunked package")
+	ErrCouldNotEncodeChunkIndex                = errors.New("could not encode chunk index")
+	ErrCouldNotWriteChunkIndex                 = errors.New("could not write chunk index")
+	ErrCouldNotDecodeChunkIndex                = errors.New("could not decode chunk index")
+	ErrUnsupportedChunkIndexVersion            = errors.New("unsupported chunk index version")
+	ErrCouldNotWriteDelta                      = errors.New("could not write delta")
+	ErrCouldNotReadDelta                       = errors.New("could not read delta")
+	ErrMissingDeltaIndex                       = errors.New("missing index in delta")
+	ErrInvalidChunkID                          = errors.New("invalid chunk ID")
+	ErrEntryTooLarge                           = errors.New("archive entry is too large")
+	ErrMissingOCILayout                        = errors.New("missing OCI image layout path")
+	ErrCouldNotOpenOCILayout                   = errors.New("could not open OCI image layout")
+	ErrCouldNotReadOCIIndex                    = errors.New("could not read OCI image index")
+	ErrCouldNotWriteOCIIndex                   = errors.New("could not write OCI image index")
+	ErrOCITagNotFound                          = errors.New("OCI tag not found")
+	ErrUnsupportedOCIDigest                    = errors.New("unsupported OCI digest")
+	ErrOCIDigestMismatch                       = errors.New("OCI digest mismatch")
+	ErrCouldNotReadOCIBlob                     = errors.New("could not read OCI blob")
+	ErrCouldNotWriteOCIBlob                    = errors.New("could not write OCI blob")
+	ErrCouldNotEncodeOCIManifest               = errors.New("could not encode OCI manifest")
+	ErrCouldNotDecodeOCIManifest               = errors.New("could not decode OCI manifest")
+	ErrNotOCIPackage                           = errors.New("OCI artifact is not a package")
+	ErrInvalidOCIReference                     = errors.New("invalid OCI reference")
+	ErrCouldNotAuthenticateToRegistry          = errors.New("could not authenticate to registry")
+	ErrCouldNotPushToRegistry                  = errors.New("could not push to registry")
+	ErrCouldNotPullFromRegistry                = errors.New("could not pull from registry")
+	ErrCouldNotReadConfig                      = errors.New("could not read config")
+	ErrInvalidBlockSize                        = errors.New("invalid block size")
+	ErrInvalidCompressionOptions               = errors.New("invalid compression options")
+	ErrInvalidArchiveOptions                   = errors.New("invalid archive options")
+	ErrCouldNotEncodeCompressionParameters     = errors.New("could not encode compression parameters")
+	ErrCouldNotDecodeCompressionParameters     = errors.New("could not decode compression parameters")
+	ErrUnsupportedCompressionParametersVersion = errors.New("unsupported compression parameters version")
+	ErrCouldNotReadKey                         = errors.New("could not read key")
+	ErrCouldNotParseKey                        = errors.New("could not parse key")
+	ErrUnsupportedKeyType                      = errors.New("unsupported key type")
 )
@@ -2,22 +2,57 @@ package packager
 
 import (
 	"archive/tar"
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/ed25519"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// Size of the chunks that are passed from decompression to the writer if `WriteBufferSize` is set
+	extractChunkSize = 1024 * 1024
 
-	"github.com/klauspost/compress/zstd"
+	// Maximum size of the manifest and its signature that we read into memory
+	maxManifestSize = 1024 * 1024 * 16
 )
 
+type ExtractOptions struct {
+	// Maximum number of bytes of decompressed data to buffer for writing; if set, entries are written to disk in a separate goroutine
+	// so that decompression and writing can run in parallel. If 0, entries are written as they are decompressed.
+	WriteBufferSize int64
+	// Number of goroutines that decompress in parallel; if 0, zstd's default is used
+	DecoderConcurrency int
+
+	// Key to verify the manifest's signature with; if set, packages without a manifest or without a valid signature are rejected
+	VerificationKey ed25519.PublicKey
+
+	// Path to the chunk store to read the chunks of chunked packages from (only for chunked packages)
+	ChunkStorePath string
+}
+
+// ExtractPackage extracts the devices from the package at `packageInputPath` in a single pass over the archive, independently of the
+// order of `devices` and of the entries in the archive. Entries that aren't in `devices` are skipped (and reported to `OnUnknownFile`),
+// and if any of `devices` aren't in the archive, all of the missing devices are reported in the error.
+//
+// Extracted devices are verified against the package's manifest; since the manifest is at the end of the archive, they are only
+// verified after they have been written, so they must not be used if an error is returned. Packages created by older versions of
+// Drafter don't have a manifest and aren't verified unless `options.VerificationKey` is set, in which case they are rejected.
+// To also extract the manifest or its signature, add devices with the names `ManifestName` or `ManifestSignatureName`.
 func ExtractPackage(
 	ctx context.Context,
 
 	packageInputPath string,
 	devices []PackagerDevice,
 
+	options ExtractOptions,
 	hooks PackagerHooks,
 ) error {
 	packageFile, err := os.Open(packageInputPath)
@@ -26,67 +61,307 @@ func ExtractPackage(
 	}
 	defer packageFile.Close()
 
-	uncompressor, err := zstd.NewReader(packageFile)
+	return ExtractPackageFromReader(ctx, packageFile, devices, options, hooks)
+}
+
+// ExtractPackageFromReader is like `ExtractPackage`, but reads the package from `packageInput`, e.g. to extract it while it is being
+// downloaded without staging it on disk first. All package formats can be read from a stream, but seekable packages are read like
+// regular packages.
+func ExtractPackageFromReader(
+	ctx context.Context,
+
+	packageInput io.Reader,
+	devices []PackagerDevice,
+
+	options ExtractOptions,
+	hooks PackagerHooks,
+) error {
+	progress := newProgressCounter(hooks)
+
+	packageReader := bufio.NewReader(progress.countPackageReader(packageInput))
+	if isChunkIndex(packageReader) {
+		return extractChunkedPackage(ctx, packageReader, devices, options, hooks, progress)
+	}
+
+	uncompressor, _, err := newPackageArchiveReader(packageReader, options.DecoderConcurrency)
 	if err != nil {
-		return errors.Join(ErrCouldNotCreateUncompressor, err)
+		return err
 	}
 	defer uncompressor.Close()
 
 	packageArchive := tar.NewReader(uncompressor)
 
+	// Multiple devices can request the same entry, e.g. to extract it to two paths
+	missingDevices := map[string][]PackagerDevice{}
 	for _, device := range devices {
-		extracted := false
-		for {
-		s:
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
+		missingDevices[device.Name] = append(missingDevices[device.Name], device)
+	}
+
+	var (
+		rawManifest, signature []byte
+
+		// Checksums of the extracted devices and the path that they were extracted to first
+		extractedDevices = map[string]ManifestDevice{}
+		extractedPaths   = map[string]string{}
+	)
+	for {
+	s:
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		default:
+			break s
+		}
 
-			default:
-				break s
+		header, err := packageArchive.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
 			}
 
-			header, err := packageArchive.Next()
+			return errors.Join(ErrCouldNotReadNextHeader, err)
+		}
+
+		var entry io.Reader = packageArchive
+		switch header.Name {
+		case ManifestName:
+			rawManifest, err = readLimited(packageArchive, maxManifestSize)
 			if err != nil {
-				if err == io.EOF {
-					break
-				}
+				return errors.Join(ErrCouldNotReadManifest, err)
+			}
+
+			entry = bytes.NewReader(rawManifest)
 
-				return errors.Join(ErrCouldNotReadNextHeader, err)
+		case ManifestSignatureName:
+			signature, err = readLimited(packageArchive, maxManifestSize)
+			if err != nil {
+				return errors.Join(ErrCouldNotReadManifest, err)
 			}
 
-			if header.Name != device.Name {
+			entry = bytes.NewReader(signature)
+		}
+
+		entryDevices, ok := missingDevices[header.Name]
+		if !ok {
+			if header.Name == ManifestName || header.Name == ManifestSignatureName {
 				continue
 			}
 
-			if hook := hooks.OnBeforeProcessFile; hook != nil {
-				hook(device.Name, device.Path)
+			if hook := hooks.OnUnknownFile; hook != nil {
+				hook(header.Name)
 			}
 
-			if err := os.MkdirAll(filepath.Dir(device.Path), os.ModePerm); err != nil {
-				return errors.Join(ErrCouldNotCreateOutputDir, err)
+			continue
+		}
+		delete(missingDevices, header.Name)
+
+		hasher := newDeviceHasher(DefaultManifestBlockSize)
+		if err := extractEntry(ctx, io.TeeReader(progress.countDevice(entry), hasher), entryDevices, options, hooks); err != nil {
+			return err
+		}
+
+		extractedDevices[header.Name] = hasher.manifestDevice(header.Name)
+		extractedPaths[header.Name] = entryDevices[0].Path
+	}
+
+	if err := getMissingDevicesError(missingDevices); err != nil {
+		return err
+	}
+
+	progress.finish()
+
+	return verifyExtractedDevices(rawManifest, signature, extractedDevices, extractedPaths, options)
+}
+
+func getMissingDevicesError(missingDevices map[string][]PackagerDevice) error {
+	if len(missingDevices) == 0 {
+		return nil
+	}
+
+	missingNames := slices.Sorted(maps.Keys(missingDevices))
+
+	// We join the more specific error here first
+	return errors.Join(fmt.Errorf("missing devices: %s", strings.Join(missingNames, ", ")), ErrMissingDevice)
+}
+
+// verifyExtractedDevices checks the checksums of the extracted devices, which were computed while extracting them, against the manifest
+func verifyExtractedDevices(
+	rawManifest, signature []byte,
+
+	extractedDevices map[string]ManifestDevice,
+	extractedPaths map[string]string,
+
+	options ExtractOptions,
+) error {
+	if rawManifest == nil {
+		if options.VerificationKey != nil {
+			return ErrMissingManifest
+		}
+
+		return nil
+	}
+
+	manifest, err := ParseManifest(rawManifest, signature, options.VerificationKey)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(extractedDevices)) {
+		if name == ManifestName || name == ManifestSignatureName {
+			continue
+		}
+
+		expected, ok := manifest.Device(name)
+		if !ok {
+			return errors.Join(ErrDeviceNotInManifest, fmt.Errorf("device %v", name))
+		}
+
+		// We can only compare the Merkle root that we computed while extracting if the manifest uses the same block size
+		if expected.BlockSize != DefaultManifestBlockSize {
+			if err := VerifyDevice(expected, extractedPaths[name]); err != nil {
+				return err
 			}
 
-			outputFile, err := os.OpenFile(device.Path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.ModePerm)
-			if err != nil {
-				return errors.Join(ErrCouldNotOpenOutputFile, err)
+			continue
+		}
+
+		if err := compareManifestDevices(expected, extractedDevices[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func extractEntry(
+	ctx context.Context,
+
+	entry io.Reader,
+	devices []PackagerDevice,
+
+	options ExtractOptions,
+	hooks PackagerHooks,
+) error {
+	outputs := []io.Writer{}
+	sparseOutputs := []*sparseFileWriter{}
+	for _, device := range devices {
+		if hook := hooks.OnBeforeProcessFile; hook != nil {
+			hook(device.Name, device.Path)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(device.Path), os.ModePerm); err != nil {
+			return errors.Join(ErrCouldNotCreateOutputDir, err)
+		}
+
+		outputFile, err := os.OpenFile(device.Path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.ModePerm)
+		if err != nil {
+			return errors.Join(ErrCouldNotOpenOutputFile, err)
+		}
+		defer outputFile.Close()
+
+		// Blocks that only contain zeros are skipped so that the extracted devices only occupy the space that they actually use
+		sparseOutput, err := newSparseFileWriter(outputFile)
+		if err != nil {
+			return errors.Join(ErrCouldNotOpenOutputFile, err)
+		}
+
+		outputs = append(outputs, sparseOutput)
+		sparseOutputs = append(sparseOutputs, sparseOutput)
+	}
+
+	output := io.MultiWriter(outputs...)
+
+	if options.WriteBufferSize <= 0 {
+		if _, err := io.Copy(output, entry); err != nil {
+			return errors.Join(ErrCouldNotCopyToOutput, err)
+		}
+	} else if err := copyPipelined(ctx, output, entry, options.WriteBufferSize); err != nil {
+		return errors.Join(ErrCouldNotCopyToOutput, err)
+	}
+
+	for _, sparseOutput := range sparseOutputs {
+		if err := sparseOutput.Finish(); err != nil {
+			return errors.Join(ErrCouldNotCopyToOutput, err)
+		}
+	}
+
+	return nil
+}
+
+// copyPipelined copies `src` to `dst` like `io.Copy`, but writes to `dst` in a separate goroutine with up to `bufferSize` bytes
+// buffered so that reading (e.g. decompressing) doesn't have to wait for writes
+func copyPipelined(ctx context.Context, dst io.Writer, src io.Reader, bufferSize int64) error {
+	var (
+		chunks = make(chan []byte, max(1, bufferSize/extractChunkSize))
+
+		writeErr    error
+		writeFailed = make(chan struct{})
+		writerDone  = make(chan struct{})
+	)
+	go func() {
+		defer close(writerDone)
+
+		for chunk := range chunks {
+			// We keep receiving after a failed write so that the reader never blocks on a full channel
+			if writeErr != nil {
+				continue
 			}
-			defer outputFile.Close()
 
-			if _, err = io.Copy(outputFile, packageArchive); err != nil {
-				return errors.Join(ErrCouldNotCopyToOutput, err)
+			if _, err := dst.Write(chunk); err != nil {
+				writeErr = err
+
+				close(writeFailed)
 			}
+		}
+	}()
 
-			extracted = true
+	var readErr error
+read:
+	for {
+		chunk := make([]byte, extractChunkSize)
 
-			break
+		n, err := io.ReadFull(src, chunk)
+		if n > 0 {
+			select {
+			case chunks <- chunk[:n]:
+
+			case <-writeFailed:
+				break read
+
+			case <-ctx.Done():
+				readErr = ctx.Err()
+
+				break read
+			}
 		}
 
-		if !extracted {
-			// We join the more specific error here first
-			return errors.Join(fmt.Errorf("missing device: %s", device.Name), ErrMissingDevice)
+		if err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				readErr = err
+			}
+
+			break
 		}
 	}
 
-	return nil
+	close(chunks)
+	<-writerDone
+
+	return errors.Join(readErr, writeErr)
+}
+
+// readLimited reads all of `r` into memory, and returns an error that matches `ErrEntryTooLarge` instead of a truncated result if
+// it is larger than `limit`
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	content, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(content)) > limit {
+		return nil, errors.Join(ErrEntryTooLarge, fmt.Errorf("entry is larger than %v bytes", limit))
+	}
+
+	return content, nil
 }
@@ -0,0 +1,147 @@
+package packager_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/loopholelabs/drafter/pkg/packager"
+)
+
+// getOutputDevices returns devices with the same names as `devices` that are extracted to `dir`
+func getOutputDevices(devices []packager.PackagerDevice, dir string) []packager.PackagerDevice {
+	outputDevices := []packager.PackagerDevice{}
+	for _, device := range devices {
+		outputDevices = append(outputDevices, packager.PackagerDevice{
+			Name: device.Name,
+			Path: filepath.Join(dir, device.Name),
+		})
+	}
+
+	return outputDevices
+}
+
+// checkExtractedDevices checks that every device in `outputDevices` has the same content as the device with the same name in `devices`
+func checkExtractedDevices(t *testing.T, devices, outputDevices []packager.PackagerDevice) {
+	t.Helper()
+
+	for _, outputDevice := range outputDevices {
+		i := slices.IndexFunc(devices, func(device packager.PackagerDevice) bool {
+			return device.Name == outputDevice.Name
+		})
+		if i < 0 {
+			t.Fatalf("there is no input device for %v", outputDevice.Name)
+		}
+
+		expected, err := os.ReadFile(devices[i].Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual, err := os.ReadFile(outputDevice.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("extracted device %v differs from the archived device", outputDevice.Name)
+		}
+	}
+}
+
+func archivePackage(t *testing.T, devices []packager.PackagerDevice, options packager.ArchiveOptions) string {
+	t.Helper()
+
+	packagePath := filepath.Join(t.TempDir(), "package.tar.zst")
+	if err := packager.ArchivePackage(context.Background(), devices, packagePath, options, packager.PackagerHooks{}); err != nil {
+		t.Fatal(err)
+	}
+
+	return packagePath
+}
+
+func TestExtractPackageInAnyOrder(t *testing.T) {
+	devices := createDevices(t, t.TempDir())
+	packagePath := archivePackage(t, devices, packager.ArchiveOptions{})
+
+	for name, options := range map[string]packager.ExtractOptions{
+		"sequential": {},
+		// The buffer is smaller than the devices, so the writer has to wait for decompression and vice versa
+		"pipelined": {
+			WriteBufferSize: 4096,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// The devices are requested in the opposite order of the entries in the archive
+			outputDevices := getOutputDevices(devices, t.TempDir())
+			slices.Reverse(outputDevices)
+
+			if err := packager.ExtractPackage(context.Background(), packagePath, outputDevices, options, packager.PackagerHooks{}); err != nil {
+				t.Fatal(err)
+			}
+
+			checkExtractedDevices(t, devices, outputDevices)
+		})
+	}
+}
+
+func TestExtractPackageToMultiplePaths(t *testing.T) {
+	devices := createDevices(t, t.TempDir())
+	packagePath := archivePackage(t, devices, packager.ArchiveOptions{})
+
+	outputDevices := append(getOutputDevices(devices, t.TempDir()), getOutputDevices(devices, t.TempDir())...)
+	if err := packager.ExtractPackage(context.Background(), packagePath, outputDevices, packager.ExtractOptions{}, packager.PackagerHooks{}); err != nil {
+		t.Fatal(err)
+	}
+
+	checkExtractedDevices(t, devices, outputDevices)
+}
+
+func TestExtractPackageReportsMissingAndUnknownDevices(t *testing.T) {
+	devices := createDevices(t, t.TempDir())
+	packagePath := archivePackage(t, devices, packager.ArchiveOptions{})
+
+	outputDir := t.TempDir()
+	outputDevices := []packager.PackagerDevice{
+		{
+			Name: packager.DiskName,
+			Path: filepath.Join(outputDir, packager.DiskName),
+		},
+		{
+			Name: packager.StateName,
+			Path: filepath.Join(outputDir, packager.StateName),
+		},
+		{
+			Name: packager.MemoryName,
+			Path: filepath.Join(outputDir, packager.MemoryName),
+		},
+	}
+
+	unknownFiles := []string{}
+	err := packager.ExtractPackage(context.Background(), packagePath, outputDevices, packager.ExtractOptions{}, packager.PackagerHooks{
+		OnUnknownFile: func(name string) {
+			unknownFiles = append(unknownFiles, name)
+		},
+	})
+	if !errors.Is(err, packager.ErrMissingDevice) {
+		t.Fatalf("extracting missing devices returned %v, want %v", err, packager.ErrMissingDevice)
+	}
+
+	if want := "missing devices: " + packager.MemoryName + ", " + packager.StateName; !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q doesn't report %q", err, want)
+	}
+
+	// The manifest isn't an unknown file since it is part of every package
+	slices.Sort(unknownFiles)
+	if want := []string{packager.ConfigName, packager.KernelName}; !slices.Equal(unknownFiles, want) {
+		t.Errorf("unknown files are %v, want %v", unknownFiles, want)
+	}
+
+	// The devices that were found are still extracted
+	checkExtractedDevices(t, devices, outputDevices[:1])
+}
@@ -0,0 +1,293 @@
+package packager
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	PackageFormatArchive  = "archive"
+	PackageFormatSeekable = "seekable"
+	PackageFormatChunked  = "chunked"
+
+	// Maximum size of the config entry that we read into memory
+	maxConfigSize = 1024 * 1024 * 16
+)
+
+type PackageEntry struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// PackageInfo describes a package without extracting it
+type PackageInfo struct {
+	// One of `PackageFormatArchive`, `PackageFormatSeekable` or `PackageFormatChunked`
+	Format string `json:"format"`
+
+	// Size of the package file; for chunked packages, this includes the distinct chunks that the package references in the chunk store
+	CompressedSize int64 `json:"compressedSize"`
+	// Sum of the sizes of all entries
+	UncompressedSize int64 `json:"uncompressedSize"`
+
+	// Settings that the package was compressed with (without the dictionary), or nil for chunked packages, whose chunks are compressed
+	// individually
+	Compression *CompressionOptions `json:"compression,omitempty"`
+
+	Entries []PackageEntry `json:"entries"`
+
+	// Package manifest, or nil for packages created by older versions of Drafter; its signature isn't verified
+	Manifest *Manifest `json:"manifest,omitempty"`
+	// Raw content of the `config` entry, which is a `snapshotter.PackageConfiguration`, or nil if the package doesn't have one
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// CompressionRatio returns how many times smaller the package is than its entries, or 0 if the package is empty
+func (i *PackageInfo) CompressionRatio() float64 {
+	if i.CompressedSize == 0 {
+		return 0
+	}
+
+	return float64(i.UncompressedSize) / float64(i.CompressedSize)
+}
+
+// walkPackage calls `onEntry` for every entry of the package at `packageInputPath` in the order in which they are stored, and returns
+// the package's format; `entry` is only valid until `onEntry` returns, and doesn't need to be read completely
+func walkPackage(
+	ctx context.Context,
+
+	packageInputPath string,
+
+	options ExtractOptions,
+
+	onEntry func(name string, size int64, entry io.Reader) error,
+) (string, CompressionOptions, error) {
+	packageFile, err := os.Open(packageInputPath)
+	if err != nil {
+		return "", CompressionOptions{}, errors.Join(ErrCouldNotOpenPackageInputFile, err)
+	}
+	defer packageFile.Close()
+
+	packageReader := bufio.NewReader(packageFile)
+	if isChunkIndex(packageReader) {
+		return PackageFormatChunked, CompressionOptions{}, walkChunkedPackage(ctx, packageReader, options, onEntry)
+	}
+
+	format := PackageFormatArchive
+	if _, err := readSeekTable(packageFile); err == nil {
+		format = PackageFormatSeekable
+	}
+
+	uncompressor, compression, err := newPackageArchiveReader(packageReader, options.DecoderConcurrency)
+	if err != nil {
+		return "", CompressionOptions{}, err
+	}
+	defer uncompressor.Close()
+
+	packageArchive := tar.NewReader(uncompressor)
+	for {
+	s:
+		select {
+		case <-ctx.Done():
+			return "", CompressionOptions{}, ctx.Err()
+
+		default:
+			break s
+		}
+
+		header, err := packageArchive.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			return "", CompressionOptions{}, errors.Join(ErrCouldNotReadNextHeader, err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if err := onEntry(header.Name, header.Size, packageArchive); err != nil {
+			return "", CompressionOptions{}, err
+		}
+	}
+
+	return format, compression, nil
+}
+
+func walkChunkedPackage(
+	ctx context.Context,
+
+	indexReader io.Reader,
+
+	options ExtractOptions,
+
+	onEntry func(name string, size int64, entry io.Reader) error,
+) error {
+	if strings.TrimSpace(options.ChunkStorePath) == "" {
+		return ErrMissingChunkStore
+	}
+
+	index, err := decodeChunkIndex(indexReader)
+	if err != nil {
+		return err
+	}
+
+	store, err := NewChunkStore(options.ChunkStorePath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	for _, device := range index.Devices {
+	s:
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		default:
+			break s
+		}
+
+		if err := onEntry(device.Name, device.Size, &chunkReader{
+			store:  store,
+			chunks: device.Chunks,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := onEntry(ManifestName, int64(len(index.Manifest)), bytes.NewReader(index.Manifest)); err != nil {
+		return err
+	}
+
+	if len(index.ManifestSignature) > 0 {
+		if err := onEntry(ManifestSignatureName, int64(len(index.ManifestSignature)), bytes.NewReader(index.ManifestSignature)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// InspectPackage lists the entries of the package at `packageInputPath` and decodes its manifest and config without extracting it;
+// for chunked packages, `options.ChunkStorePath` must be set
+func InspectPackage(
+	ctx context.Context,
+
+	packageInputPath string,
+
+	options ExtractOptions,
+	hooks PackagerHooks,
+) (*PackageInfo, error) {
+	info := &PackageInfo{
+		Entries: []PackageEntry{},
+	}
+
+	var rawManifest []byte
+	format, compression, err := walkPackage(ctx, packageInputPath, options, func(name string, size int64, entry io.Reader) error {
+		if hook := hooks.OnBeforeProcessFile; hook != nil {
+			hook(name, packageInputPath)
+		}
+
+		info.Entries = append(info.Entries, PackageEntry{
+			Name: name,
+			Size: size,
+		})
+		info.UncompressedSize += size
+
+		var err error
+		switch name {
+		case ManifestName:
+			rawManifest, err = readLimited(entry, maxManifestSize)
+			if err != nil {
+				return errors.Join(ErrCouldNotReadManifest, err)
+			}
+
+		case ConfigName:
+			info.Config, err = readLimited(entry, maxConfigSize)
+			if err != nil {
+				return errors.Join(ErrCouldNotReadConfig, err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	info.Format = format
+
+	if format != PackageFormatChunked {
+		info.Compression = &compression
+	}
+
+	if rawManifest != nil {
+		if info.Manifest, err = ParseManifest(rawManifest, nil, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	packageInfo, err := os.Stat(packageInputPath)
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotOpenPackageInputFile, err)
+	}
+	info.CompressedSize = packageInfo.Size()
+
+	if format == PackageFormatChunked {
+		chunksSize, err := getChunksSize(packageInputPath, options.ChunkStorePath)
+		if err != nil {
+			return nil, err
+		}
+
+		info.CompressedSize += chunksSize
+	}
+
+	return info, nil
+}
+
+// getChunksSize returns the size of the distinct chunks that the chunked package at `indexPath` references in the chunk store
+func getChunksSize(indexPath, chunkStorePath string) (int64, error) {
+	index, err := ReadChunkIndex(indexPath)
+	if err != nil {
+		return 0, err
+	}
+
+	store := &ChunkStore{
+		path: chunkStorePath,
+	}
+
+	var (
+		size   int64
+		chunks = map[string]struct{}{}
+	)
+	for _, device := range index.Devices {
+		for _, chunk := range device.Chunks {
+			if _, ok := chunks[chunk.ID]; ok {
+				continue
+			}
+			chunks[chunk.ID] = struct{}{}
+
+			chunkPath, err := store.chunkPath(chunk.ID)
+			if err != nil {
+				return 0, err
+			}
+
+			chunkInfo, err := os.Stat(chunkPath)
+			if err != nil {
+				return 0, errors.Join(ErrMissingChunk, err)
+			}
+
+			size += chunkInfo.Size()
+		}
+	}
+
+	return size, nil
+}
@@ -0,0 +1,224 @@
+package packager_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/loopholelabs/drafter/pkg/packager"
+)
+
+func TestInspectPackage(t *testing.T) {
+	devices := createDevices(t, t.TempDir())
+	chunkStorePath := t.TempDir()
+
+	for format, options := range map[string]packager.ArchiveOptions{
+		packager.PackageFormatArchive: {
+			Compression: packager.CompressionOptions{
+				Level: 3,
+			},
+		},
+		packager.PackageFormatSeekable: {
+			Seekable: true,
+		},
+		packager.PackageFormatChunked: {
+			ChunkStorePath: chunkStorePath,
+		},
+	} {
+		t.Run(format, func(t *testing.T) {
+			packagePath := archivePackage(t, devices, options)
+
+			info, err := packager.InspectPackage(context.Background(), packagePath, packager.ExtractOptions{
+				ChunkStorePath: chunkStorePath,
+			}, packager.PackagerHooks{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if info.Format != format {
+				t.Errorf("package has format %v, want %v", info.Format, format)
+			}
+
+			if format == packager.PackageFormatChunked {
+				if info.Compression != nil {
+					t.Errorf("chunked package has compression options %+v, want none", info.Compression)
+				}
+			} else if info.Compression == nil || info.Compression.Level != options.Compression.Level {
+				t.Errorf("package has compression options %+v, want level %v", info.Compression, options.Compression.Level)
+			}
+
+			// The disk mostly contains zeros, so every format compresses it
+			if ratio := info.CompressionRatio(); ratio <= 1 {
+				t.Errorf("package has compression ratio %v, want more than 1", ratio)
+			}
+
+			if info.Manifest == nil || len(info.Manifest.Devices) != len(devices) {
+				t.Errorf("package has manifest %+v, want one with %v devices", info.Manifest, len(devices))
+			}
+
+			var uncompressedSize int64
+			for _, entry := range info.Entries {
+				uncompressedSize += entry.Size
+			}
+
+			if uncompressedSize != info.UncompressedSize {
+				t.Errorf("package has uncompressed size %v, but its entries have %v bytes", info.UncompressedSize, uncompressedSize)
+			}
+
+			for _, device := range devices {
+				content, err := os.ReadFile(device.Path)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !slices.Contains(info.Entries, packager.PackageEntry{
+					Name: device.Name,
+					Size: int64(len(content)),
+				}) {
+					t.Errorf("entries %+v don't contain device %v with size %v", info.Entries, device.Name, len(content))
+				}
+
+				if device.Name == packager.ConfigName && string(info.Config) != string(content) {
+					t.Errorf("package has config %s, want %s", info.Config, content)
+				}
+			}
+		})
+	}
+}
+
+func TestDiffPackagesAndDevices(t *testing.T) {
+	const blockSize = 4096
+
+	baseDevices := createDevices(t, t.TempDir())
+
+	// The new devices don't have the config, have an additional state device and a modified disk with the same kernel
+	dir := t.TempDir()
+	devices := []packager.PackagerDevice{}
+	for _, baseDevice := range baseDevices {
+		content, err := os.ReadFile(baseDevice.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch baseDevice.Name {
+		case packager.ConfigName:
+			continue
+
+		case packager.DiskName:
+			// Adjacent changed blocks are merged into one range
+			content[3*blockSize+10] ^= 0xff
+			content[4*blockSize] ^= 0xff
+			content[100*blockSize+blockSize-1] ^= 0xff
+
+			// Blocks that only exist in the new device are changed
+			content = append(content, make([]byte, 100)...)
+		}
+
+		path := filepath.Join(dir, baseDevice.Name)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		devices = append(devices, packager.PackagerDevice{
+			Name: baseDevice.Name,
+			Path: path,
+		})
+	}
+
+	statePath := filepath.Join(dir, packager.StateName)
+	if err := os.WriteFile(statePath, make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	devices = append(devices, packager.PackagerDevice{
+		Name: packager.StateName,
+		Path: statePath,
+	})
+
+	kernelInfo, err := os.Stat(filepath.Join(dir, packager.KernelName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diskInfo, err := os.Stat(filepath.Join(dir, packager.DiskName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	baseDiskSize := diskInfo.Size() - 100
+	configSize := int64(len(`{"agentVSockPort":26}`))
+	expected := []packager.DeviceDiff{
+		{
+			Name:     packager.ConfigName,
+			BaseSize: configSize,
+			Removed:  true,
+			ChangedRanges: []packager.ChangedRange{
+				{Offset: 0, Length: configSize},
+			},
+			ChangedBytes: configSize,
+		},
+		{
+			Name:     packager.DiskName,
+			BaseSize: baseDiskSize,
+			Size:     diskInfo.Size(),
+			ChangedRanges: []packager.ChangedRange{
+				{Offset: 3 * blockSize, Length: 2 * blockSize},
+				{Offset: 100 * blockSize, Length: blockSize},
+				{Offset: baseDiskSize, Length: 100},
+			},
+			ChangedBytes: 3*blockSize + 100,
+		},
+		{
+			Name:          packager.KernelName,
+			BaseSize:      kernelInfo.Size(),
+			Size:          kernelInfo.Size(),
+			ChangedRanges: []packager.ChangedRange{},
+		},
+		{
+			Name:  packager.StateName,
+			Size:  1000,
+			Added: true,
+			ChangedRanges: []packager.ChangedRange{
+				{Offset: 0, Length: 1000},
+			},
+			ChangedBytes: 1000,
+		},
+	}
+
+	t.Run("devices", func(t *testing.T) {
+		diffs, err := packager.DiffDevices(context.Background(), baseDevices, devices, blockSize, packager.PackagerHooks{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(diffs, expected) {
+			t.Errorf("diff is %+v, want %+v", diffs, expected)
+		}
+	})
+
+	t.Run("packages", func(t *testing.T) {
+		diffs, err := packager.DiffPackages(
+			context.Background(),
+
+			archivePackage(t, baseDevices, packager.ArchiveOptions{}),
+			archivePackage(t, devices, packager.ArchiveOptions{
+				Seekable: true,
+			}),
+
+			blockSize,
+
+			packager.ExtractOptions{},
+			packager.PackagerHooks{},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(diffs, expected) {
+			t.Errorf("diff is %+v, want %+v", diffs, expected)
+		}
+	})
+}
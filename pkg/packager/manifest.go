@@ -0,0 +1,309 @@
+package packager
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+const (
+	// Archive entry that contains the package's manifest; it is written after all devices
+	ManifestName = "manifest"
+	// Archive entry that contains the ed25519 signature of the raw manifest entry; it is written after the manifest
+	ManifestSignatureName = "manifest.sig"
+
+	// Block size that is used for the Merkle trees of devices if none is set
+	DefaultManifestBlockSize = 1024 * 64
+
+	manifestVersion = 1
+)
+
+var (
+	// Domain separation prefixes for Merkle tree nodes so that a leaf can't be passed off as an inner node
+	merkleLeafPrefix  = []byte{0x00}
+	merkleInnerPrefix = []byte{0x01}
+)
+
+type ManifestDevice struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+
+	// Size of the blocks that the Merkle tree's leaves are computed from
+	BlockSize uint32 `json:"blockSize"`
+
+	// Hex-encoded SHA-256 of the device
+	SHA256 string `json:"sha256"`
+	// Hex-encoded root of a SHA-256 Merkle tree over the device's blocks
+	MerkleRoot string `json:"merkleRoot"`
+}
+
+type Manifest struct {
+	Version int              `json:"version"`
+	Devices []ManifestDevice `json:"devices"`
+}
+
+// Device returns the entry for the device with `name`, or `false` if the manifest doesn't contain it
+func (m *Manifest) Device(name string) (ManifestDevice, bool) {
+	for _, device := range m.Devices {
+		if device.Name == name {
+			return device, true
+		}
+	}
+
+	return ManifestDevice{}, false
+}
+
+// deviceHasher computes the SHA-256 and the Merkle root of everything that is written to it
+type deviceHasher struct {
+	blockSize uint32
+
+	size   int64
+	digest hash.Hash
+
+	block  hash.Hash
+	filled uint32
+	leaves [][]byte
+}
+
+func newDeviceHasher(blockSize uint32) *deviceHasher {
+	if blockSize == 0 {
+		blockSize = DefaultManifestBlockSize
+	}
+
+	return &deviceHasher{
+		blockSize: blockSize,
+
+		digest: sha256.New(),
+
+		block: newMerkleLeafHash(),
+	}
+}
+
+func newMerkleLeafHash() hash.Hash {
+	h := sha256.New()
+	h.Write(merkleLeafPrefix)
+
+	return h
+}
+
+func (h *deviceHasher) Write(p []byte) (int, error) {
+	n := len(p)
+
+	h.size += int64(n)
+	h.digest.Write(p)
+
+	for len(p) > 0 {
+		chunk := p[:min(uint32(len(p)), h.blockSize-h.filled)]
+
+		h.block.Write(chunk)
+		h.filled += uint32(len(chunk))
+		p = p[len(chunk):]
+
+		if h.filled == h.blockSize {
+			h.finishBlock()
+		}
+	}
+
+	return n, nil
+}
+
+func (h *deviceHasher) finishBlock() {
+	h.leaves = append(h.leaves, h.block.Sum(nil))
+
+	h.block = newMerkleLeafHash()
+	h.filled = 0
+}
+
+func (h *deviceHasher) manifestDevice(name string) ManifestDevice {
+	// Devices that are empty or don't end on a block boundary have a final partial block
+	if h.filled > 0 || len(h.leaves) == 0 {
+		h.finishBlock()
+	}
+
+	level := h.leaves
+	for len(level) > 1 {
+		next := [][]byte{}
+		for i := 0; i < len(level); i += 2 {
+			// Odd nodes are promoted to the next level unchanged
+			if i+1 == len(level) {
+				next = append(next, level[i])
+
+				continue
+			}
+
+			node := sha256.New()
+			node.Write(merkleInnerPrefix)
+			node.Write(level[i])
+			node.Write(level[i+1])
+
+			next = append(next, node.Sum(nil))
+		}
+
+		level = next
+	}
+
+	return ManifestDevice{
+		Name: name,
+		Size: h.size,
+
+		BlockSize: h.blockSize,
+
+		SHA256:     hex.EncodeToString(h.digest.Sum(nil)),
+		MerkleRoot: hex.EncodeToString(level[0]),
+	}
+}
+
+// HashDevice computes the manifest entry for the device at `path`
+func HashDevice(name, path string, blockSize uint32) (ManifestDevice, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ManifestDevice{}, errors.Join(ErrCouldNotOpenDevice, err)
+	}
+	defer f.Close()
+
+	return hashDeviceContent(name, f, blockSize)
+}
+
+func hashDeviceContent(name string, content io.Reader, blockSize uint32) (ManifestDevice, error) {
+	hasher := newDeviceHasher(blockSize)
+	if _, err := io.Copy(hasher, content); err != nil {
+		return ManifestDevice{}, errors.Join(ErrCouldNotHashDevice, err)
+	}
+
+	return hasher.manifestDevice(name), nil
+}
+
+// VerifyDevice checks that the device at `path` matches `expected`, and returns an error that matches `ErrDeviceChecksumMismatch` if it doesn't
+func VerifyDevice(expected ManifestDevice, path string) error {
+	actual, err := HashDevice(expected.Name, path, expected.BlockSize)
+	if err != nil {
+		return err
+	}
+
+	return compareManifestDevices(expected, actual)
+}
+
+// VerifyDeviceContent is like `VerifyDevice`, but reads the device from `content`
+func VerifyDeviceContent(expected ManifestDevice, content io.Reader) error {
+	actual, err := hashDeviceContent(expected.Name, content, expected.BlockSize)
+	if err != nil {
+		return err
+	}
+
+	return compareManifestDevices(expected, actual)
+}
+
+func compareManifestDevices(expected, actual ManifestDevice) error {
+	if expected.Size != actual.Size {
+		return errors.Join(ErrDeviceChecksumMismatch, fmt.Errorf("device %v has size %v, expected %v", expected.Name, actual.Size, expected.Size))
+	}
+
+	if expected.SHA256 != actual.SHA256 {
+		return errors.Join(ErrDeviceChecksumMismatch, fmt.Errorf("device %v has SHA-256 %v, expected %v", expected.Name, actual.SHA256, expected.SHA256))
+	}
+
+	if expected.MerkleRoot != actual.MerkleRoot {
+		return errors.Join(ErrDeviceChecksumMismatch, fmt.Errorf("device %v has Merkle root %v, expected %v", expected.Name, actual.MerkleRoot, expected.MerkleRoot))
+	}
+
+	return nil
+}
+
+// ParseManifest decodes a raw manifest and, if `publicKey` is set, verifies that `signature` is a valid signature of it
+func ParseManifest(rawManifest, signature []byte, publicKey ed25519.PublicKey) (*Manifest, error) {
+	if publicKey != nil {
+		if len(signature) == 0 {
+			return nil, ErrMissingManifestSignature
+		}
+
+		if !ed25519.Verify(publicKey, rawManifest, signature) {
+			return nil, ErrInvalidManifestSignature
+		}
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(rawManifest, &manifest); err != nil {
+		return nil, errors.Join(ErrCouldNotDecodeManifest, err)
+	}
+
+	if manifest.Version != manifestVersion {
+		return nil, errors.Join(ErrUnsupportedManifestVersion, fmt.Errorf("manifest version %v", manifest.Version))
+	}
+
+	return &manifest, nil
+}
+
+// ReadManifest reads a manifest from `manifestPath` and, if `publicKey` is set, verifies it against the signature at `signaturePath`
+func ReadManifest(manifestPath, signaturePath string, publicKey ed25519.PublicKey) (*Manifest, error) {
+	rawManifest, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotReadManifest, err)
+	}
+
+	var signature []byte
+	if publicKey != nil {
+		signature, err = os.ReadFile(signaturePath)
+		if err != nil {
+			return nil, errors.Join(ErrMissingManifestSignature, err)
+		}
+	}
+
+	return ParseManifest(rawManifest, signature, publicKey)
+}
+
+// ReadSigningKey reads a PEM-encoded PKCS #8 ed25519 private key, e.g. one created with `openssl genpkey -algorithm ed25519`
+func ReadSigningKey(path string) (ed25519.PrivateKey, error) {
+	key, err := readPEMKey(path, x509.ParsePKCS8PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.Join(ErrUnsupportedKeyType, fmt.Errorf("key type %T", key))
+	}
+
+	return privateKey, nil
+}
+
+// ReadVerificationKey reads a PEM-encoded PKIX ed25519 public key, e.g. one created with `openssl pkey -pubout`
+func ReadVerificationKey(path string) (ed25519.PublicKey, error) {
+	key, err := readPEMKey(path, x509.ParsePKIXPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.Join(ErrUnsupportedKeyType, fmt.Errorf("key type %T", key))
+	}
+
+	return publicKey, nil
+}
+
+func readPEMKey(path string, parse func(der []byte) (any, error)) (any, error) {
+	rawKey, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotReadKey, err)
+	}
+
+	block, _ := pem.Decode(rawKey)
+	if block == nil {
+		return nil, errors.Join(ErrCouldNotParseKey, errors.New("no PEM block found"))
+	}
+
+	key, err := parse(block.Bytes)
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotParseKey, err)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,222 @@
+package packager_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/loopholelabs/drafter/pkg/packager"
+)
+
+// flipByte inverts a byte in the middle of the first occurrence of `content` in the file at `path`
+func flipByte(t *testing.T, path string, content []byte) {
+	t.Helper()
+
+	file, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offset := bytes.Index(file, content)
+	if offset < 0 {
+		t.Fatalf("%v doesn't contain the content to modify", path)
+	}
+	file[offset+len(content)/2] ^= 0xff
+
+	if err := os.WriteFile(path, file, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractPackageVerifiesManifestSignature(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devices := createDevices(t, t.TempDir())
+
+	signedPackagePath := archivePackage(t, devices, packager.ArchiveOptions{
+		SigningKey: privateKey,
+	})
+	unsignedPackagePath := archivePackage(t, devices, packager.ArchiveOptions{})
+
+	for name, test := range map[string]struct {
+		packagePath string
+		publicKey   ed25519.PublicKey
+		err         error
+	}{
+		"signed package": {
+			packagePath: signedPackagePath,
+			publicKey:   publicKey,
+		},
+		"signed package without key": {
+			packagePath: signedPackagePath,
+		},
+		"unsigned package without key": {
+			packagePath: unsignedPackagePath,
+		},
+		"signed with another key": {
+			packagePath: signedPackagePath,
+			publicKey:   otherPublicKey,
+			err:         packager.ErrInvalidManifestSignature,
+		},
+		"unsigned package": {
+			packagePath: unsignedPackagePath,
+			publicKey:   publicKey,
+			err:         packager.ErrMissingManifestSignature,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			outputDevices := getOutputDevices(devices, t.TempDir())
+
+			err := packager.ExtractPackage(context.Background(), test.packagePath, outputDevices, packager.ExtractOptions{
+				VerificationKey: test.publicKey,
+			}, packager.PackagerHooks{})
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Errorf("extracting returned %v, want %v", err, test.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkExtractedDevices(t, devices, outputDevices)
+		})
+	}
+}
+
+func TestExtractedManifestMatchesDevices(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devices := createDevices(t, t.TempDir())
+	packagePath := archivePackage(t, devices, packager.ArchiveOptions{
+		ManifestBlockSize: 4096,
+		SigningKey:        privateKey,
+	})
+
+	outputDir := t.TempDir()
+	outputDevices := append(getOutputDevices(devices, outputDir), packager.PackagerDevice{
+		Name: packager.ManifestName,
+		Path: filepath.Join(outputDir, packager.ManifestName),
+	}, packager.PackagerDevice{
+		Name: packager.ManifestSignatureName,
+		Path: filepath.Join(outputDir, packager.ManifestSignatureName),
+	})
+
+	if err := packager.ExtractPackage(context.Background(), packagePath, outputDevices, packager.ExtractOptions{
+		VerificationKey: publicKey,
+	}, packager.PackagerHooks{}); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := packager.ReadManifest(filepath.Join(outputDir, packager.ManifestName), filepath.Join(outputDir, packager.ManifestSignatureName), publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifest.Devices) != len(devices) {
+		t.Errorf("manifest has %v devices, want %v", len(manifest.Devices), len(devices))
+	}
+
+	for _, device := range devices {
+		expected, ok := manifest.Device(device.Name)
+		if !ok {
+			t.Fatalf("manifest doesn't contain device %v", device.Name)
+		}
+
+		actual, err := packager.HashDevice(device.Name, device.Path, 4096)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actual != expected {
+			t.Errorf("manifest has %+v for device %v, want %+v", expected, device.Name, actual)
+		}
+	}
+}
+
+func TestExtractPackageDetectsModifiedDevices(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devices := createDevices(t, t.TempDir())
+
+	kernelIndex := slices.IndexFunc(devices, func(device packager.PackagerDevice) bool {
+		return device.Name == packager.KernelName
+	})
+	kernel, err := os.ReadFile(devices[kernelIndex].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, blockSize := range map[string]uint32{
+		"default block size": 0,
+		// The Merkle root can't be computed while extracting, so the device is hashed again afterwards
+		"custom block size": 4096,
+	} {
+		t.Run(name, func(t *testing.T) {
+			// We don't compress the package so that we can find the kernel's content in it
+			packagePath := archivePackage(t, devices, packager.ArchiveOptions{
+				ManifestBlockSize: blockSize,
+				SigningKey:        privateKey,
+				Compression: packager.CompressionOptions{
+					Disabled: true,
+				},
+			})
+
+			flipByte(t, packagePath, kernel)
+
+			if err := packager.ExtractPackage(context.Background(), packagePath, getOutputDevices(devices, t.TempDir()), packager.ExtractOptions{
+				VerificationKey: publicKey,
+			}, packager.PackagerHooks{}); !errors.Is(err, packager.ErrDeviceChecksumMismatch) {
+				t.Errorf("extracting a modified device returned %v, want %v", err, packager.ErrDeviceChecksumMismatch)
+			}
+		})
+	}
+}
+
+func TestVerifyDevice(t *testing.T) {
+	devices := createDevices(t, t.TempDir())
+
+	for _, device := range devices {
+		expected, err := packager.HashDevice(device.Name, device.Path, 4096)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := packager.VerifyDevice(expected, device.Path); err != nil {
+			t.Errorf("verifying unmodified device %v returned %v", device.Name, err)
+		}
+
+		content, err := os.ReadFile(device.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content[len(content)-1] ^= 0xff
+
+		if err := packager.VerifyDeviceContent(expected, bytes.NewReader(content)); !errors.Is(err, packager.ErrDeviceChecksumMismatch) {
+			t.Errorf("verifying modified device %v returned %v, want %v", device.Name, err, packager.ErrDeviceChecksumMismatch)
+		}
+	}
+}
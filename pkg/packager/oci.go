@@ -0,0 +1,744 @@
+package packager
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Packages are stored as OCI artifacts (https://github.com/opencontainers/image-spec/blob/main/manifest.md#guidelines-for-artifact-usage):
+// the package config is the artifact's config, every other device is a zstd-compressed layer with its name as the title, and the
+// package's manifest and its signature are uncompressed layers
+const (
+	OCIArtifactType = "application/vnd.loopholelabs.drafter.package.v1"
+
+	OCIConfigMediaType      = "application/vnd.loopholelabs.drafter.config.v1+json"
+	OCIDeviceLayerMediaType = "application/vnd.loopholelabs.drafter.device.v1+zstd"
+
+	OCIManifestLayerMediaType          = "application/vnd.loopholelabs.drafter.manifest.v1+json"
+	OCIManifestSignatureLayerMediaType = "application/vnd.loopholelabs.drafter.manifest.v1.sig"
+
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ociIndexMediaType    = "application/vnd.oci.image.index.v1+json"
+	ociEmptyMediaType    = "application/vnd.oci.empty.v1+json"
+
+	ociTitleAnnotation   = "org.opencontainers.image.title"
+	ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+
+	ociLayoutFileName = "oci-layout"
+	ociIndexFileName  = "index.json"
+	ociBlobsDirName   = "blobs"
+
+	ociLayoutVersion = "1.0.0"
+
+	// Tag that is used if a reference doesn't have one
+	DefaultOCITag = "latest"
+)
+
+var (
+	ociEmptyConfig = []byte("{}")
+)
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        ociDescriptor     `json:"config"`
+	Layers        []ociDescriptor   `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+type ociLayoutFile struct {
+	ImageLayoutVersion string `json:"imageLayoutVersion"`
+}
+
+// ociLayout is an OCI image layout directory (https://github.com/opencontainers/image-spec/blob/main/image-layout.md)
+type ociLayout struct {
+	path string
+}
+
+func openOCILayout(path string) (*ociLayout, error) {
+	if strings.TrimSpace(path) == "" {
+		return nil, ErrMissingOCILayout
+	}
+
+	if err := os.MkdirAll(filepath.Join(path, ociBlobsDirName, "sha256"), os.ModePerm); err != nil {
+		return nil, errors.Join(ErrCouldNotOpenOCILayout, err)
+	}
+
+	layoutFilePath := filepath.Join(path, ociLayoutFileName)
+	if _, err := os.Stat(layoutFilePath); errors.Is(err, os.ErrNotExist) {
+		rawLayoutFile, err := json.Marshal(ociLayoutFile{
+			ImageLayoutVersion: ociLayoutVersion,
+		})
+		if err != nil {
+			return nil, errors.Join(ErrCouldNotOpenOCILayout, err)
+		}
+
+		if err := os.WriteFile(layoutFilePath, rawLayoutFile, 0644); err != nil {
+			return nil, errors.Join(ErrCouldNotOpenOCILayout, err)
+		}
+	} else if err != nil {
+		return nil, errors.Join(ErrCouldNotOpenOCILayout, err)
+	}
+
+	return &ociLayout{
+		path: path,
+	}, nil
+}
+
+func (l *ociLayout) blobPath(digest string) (string, error) {
+	algorithm, encoded, ok := strings.Cut(digest, ":")
+	if !ok || algorithm != "sha256" || len(encoded) != sha256.Size*2 {
+		return "", errors.Join(ErrUnsupportedOCIDigest, fmt.Errorf("digest %q", digest))
+	}
+
+	if _, err := hex.DecodeString(encoded); err != nil {
+		return "", errors.Join(ErrUnsupportedOCIDigest, fmt.Errorf("digest %q", digest))
+	}
+
+	return filepath.Join(l.path, ociBlobsDirName, algorithm, encoded), nil
+}
+
+func (l *ociLayout) hasBlob(digest string) bool {
+	blobPath, err := l.blobPath(digest)
+	if err != nil {
+		return false
+	}
+
+	_, err = os.Stat(blobPath)
+
+	return err == nil
+}
+
+func (l *ociLayout) openBlob(digest string) (*os.File, error) {
+	blobPath, err := l.blobPath(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := os.Open(blobPath)
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotReadOCIBlob, err)
+	}
+
+	return blob, nil
+}
+
+func (l *ociLayout) readBlob(digest string) ([]byte, error) {
+	blob, err := l.openBlob(digest)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	content, err := io.ReadAll(blob)
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotReadOCIBlob, err)
+	}
+
+	if actual := getOCIDigest(content); actual != digest {
+		return nil, errors.Join(ErrOCIDigestMismatch, fmt.Errorf("blob %v has digest %v", digest, actual))
+	}
+
+	return content, nil
+}
+
+// ociBlobWriter writes a blob to a temporary file in the layout and moves it to its content-addressed path once it is committed
+type ociBlobWriter struct {
+	layout *ociLayout
+
+	file   *os.File
+	digest hash.Hash
+	size   int64
+}
+
+func (l *ociLayout) newBlobWriter() (*ociBlobWriter, error) {
+	file, err := os.CreateTemp(filepath.Join(l.path, ociBlobsDirName), "*.tmp")
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotWriteOCIBlob, err)
+	}
+
+	return &ociBlobWriter{
+		layout: l,
+
+		file:   file,
+		digest: sha256.New(),
+	}, nil
+}
+
+func (w *ociBlobWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+
+	w.digest.Write(p[:n])
+	w.size += int64(n)
+
+	return n, err
+}
+
+// Commit moves the blob to its path and returns its digest; if `expectedDigest` is set and doesn't match, the blob is discarded
+func (w *ociBlobWriter) Commit(expectedDigest string) (string, int64, error) {
+	defer os.Remove(w.file.Name())
+
+	if err := w.file.Close(); err != nil {
+		return "", 0, errors.Join(ErrCouldNotWriteOCIBlob, err)
+	}
+
+	digest := "sha256:" + hex.EncodeToString(w.digest.Sum(nil))
+	if expectedDigest != "" && digest != expectedDigest {
+		return "", 0, errors.Join(ErrOCIDigestMismatch, fmt.Errorf("blob %v has digest %v", expectedDigest, digest))
+	}
+
+	blobPath, err := w.layout.blobPath(digest)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if err := os.Rename(w.file.Name(), blobPath); err != nil {
+		return "", 0, errors.Join(ErrCouldNotWriteOCIBlob, err)
+	}
+
+	return digest, w.size, nil
+}
+
+// Discard removes the blob; it is a no-op after `Commit`
+func (w *ociBlobWriter) Discard() {
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
+}
+
+func (l *ociLayout) writeBlob(content []byte) (string, error) {
+	digest := getOCIDigest(content)
+	if l.hasBlob(digest) {
+		return digest, nil
+	}
+
+	writer, err := l.newBlobWriter()
+	if err != nil {
+		return "", err
+	}
+	defer writer.Discard()
+
+	if _, err := writer.Write(content); err != nil {
+		return "", errors.Join(ErrCouldNotWriteOCIBlob, err)
+	}
+
+	if _, _, err := writer.Commit(digest); err != nil {
+		return "", err
+	}
+
+	return digest, nil
+}
+
+func (l *ociLayout) readIndex() (*ociIndex, error) {
+	index := &ociIndex{
+		SchemaVersion: 2,
+		MediaType:     ociIndexMediaType,
+		Manifests:     []ociDescriptor{},
+	}
+
+	rawIndex, err := os.ReadFile(filepath.Join(l.path, ociIndexFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return index, nil
+		}
+
+		return nil, errors.Join(ErrCouldNotReadOCIIndex, err)
+	}
+
+	if err := json.Unmarshal(rawIndex, index); err != nil {
+		return nil, errors.Join(ErrCouldNotReadOCIIndex, err)
+	}
+
+	return index, nil
+}
+
+// resolve returns the descriptor of the manifest that is tagged with `tag`
+func (l *ociLayout) resolve(tag string) (ociDescriptor, error) {
+	index, err := l.readIndex()
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+
+	for _, manifest := range index.Manifests {
+		if manifest.Annotations[ociRefNameAnnotation] == tag {
+			return manifest, nil
+		}
+	}
+
+	return ociDescriptor{}, errors.Join(ErrOCITagNotFound, fmt.Errorf("tag %v", tag))
+}
+
+// tag points `tag` to `manifest`, replacing the manifest that it previously pointed to
+func (l *ociLayout) tag(tag string, manifest ociDescriptor) error {
+	index, err := l.readIndex()
+	if err != nil {
+		return err
+	}
+
+	index.Manifests = slices.DeleteFunc(index.Manifests, func(candidate ociDescriptor) bool {
+		return candidate.Annotations[ociRefNameAnnotation] == tag
+	})
+
+	manifest.Annotations = map[string]string{
+		ociRefNameAnnotation: tag,
+	}
+	index.Manifests = append(index.Manifests, manifest)
+
+	rawIndex, err := json.Marshal(index)
+	if err != nil {
+		return errors.Join(ErrCouldNotWriteOCIIndex, err)
+	}
+
+	// We replace the index atomically so that concurrent readers never see a partially written index
+	indexFile, err := os.CreateTemp(l.path, ociIndexFileName+".*.tmp")
+	if err != nil {
+		return errors.Join(ErrCouldNotWriteOCIIndex, err)
+	}
+	defer os.Remove(indexFile.Name())
+	defer indexFile.Close()
+
+	if _, err := indexFile.Write(rawIndex); err != nil {
+		return errors.Join(ErrCouldNotWriteOCIIndex, err)
+	}
+
+	if err := indexFile.Close(); err != nil {
+		return errors.Join(ErrCouldNotWriteOCIIndex, err)
+	}
+
+	if err := os.Rename(indexFile.Name(), filepath.Join(l.path, ociIndexFileName)); err != nil {
+		return errors.Join(ErrCouldNotWriteOCIIndex, err)
+	}
+
+	return nil
+}
+
+func (l *ociLayout) readManifest(descriptor ociDescriptor) (*ociManifest, []byte, error) {
+	rawManifest, err := l.readBlob(descriptor.Digest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var manifest ociManifest
+	if err := json.Unmarshal(rawManifest, &manifest); err != nil {
+		return nil, nil, errors.Join(ErrCouldNotDecodeOCIManifest, err)
+	}
+
+	return &manifest, rawManifest, nil
+}
+
+func getOCIDigest(content []byte) string {
+	sum := sha256.Sum256(content)
+
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// ArchiveOCIPackage stores `devices` as an OCI artifact in the OCI image layout at `layoutPath` and tags it with `tag`; the package's
+// manifest (and its signature if `options.SigningKey` is set) are stored as additional layers. Blobs that are already in the layout
+// (e.g. unchanged devices from a previous version) are reused. Layers are plain zstd streams of the complete devices, so they can't
+// be seekable, chunked, uncompressed or use a dictionary; `options.DisableSparse` has no effect since layers are never sparse.
+func ArchiveOCIPackage(
+	ctx context.Context,
+
+	devices []PackagerDevice,
+
+	layoutPath string,
+	tag string,
+
+	options ArchiveOptions,
+	hooks PackagerHooks,
+) error {
+	if options.Seekable || strings.TrimSpace(options.ChunkStorePath) != "" {
+		return errors.Join(ErrInvalidArchiveOptions, errors.New("OCI packages can't be seekable or chunked"))
+	}
+
+	if options.Compression.Disabled || len(options.Compression.Dictionary) > 0 {
+		return errors.Join(ErrInvalidCompressionOptions, errors.New("OCI packages must be compressed without a dictionary"))
+	}
+
+	layout, err := openOCILayout(layoutPath)
+	if err != nil {
+		return err
+	}
+
+	progress := newProgressCounter(hooks)
+
+	manifest := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestMediaType,
+		ArtifactType:  OCIArtifactType,
+		Config: ociDescriptor{
+			MediaType: ociEmptyMediaType,
+			Digest:    getOCIDigest(ociEmptyConfig),
+			Size:      int64(len(ociEmptyConfig)),
+		},
+		Layers: []ociDescriptor{},
+	}
+	if _, err := layout.writeBlob(ociEmptyConfig); err != nil {
+		return err
+	}
+
+	encoder, err := zstd.NewWriter(nil, options.Compression.encoderOptions()...)
+	if err != nil {
+		return errors.Join(ErrCouldNotCreateCompressor, err)
+	}
+	defer encoder.Close()
+
+	packageManifest := Manifest{
+		Version: manifestVersion,
+		Devices: []ManifestDevice{},
+	}
+	for _, device := range devices {
+	s:
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		default:
+			break s
+		}
+
+		if device.Name == ManifestName || device.Name == ManifestSignatureName {
+			return errors.Join(ErrReservedDeviceName, fmt.Errorf("device %v", device.Name))
+		}
+
+		if hook := hooks.OnBeforeProcessFile; hook != nil {
+			hook(device.Name, device.Path)
+		}
+
+		hasher := newDeviceHasher(options.ManifestBlockSize)
+
+		if device.Name == ConfigName {
+			f, err := os.Open(device.Path)
+			if err != nil {
+				return errors.Join(ErrCouldNotOpenDevice, err)
+			}
+			defer f.Close()
+
+			content, err := io.ReadAll(progress.countDevice(f))
+			if err != nil {
+				return errors.Join(ErrCouldNotOpenDevice, err)
+			}
+
+			digest, err := layout.writeBlob(content)
+			if err != nil {
+				return err
+			}
+
+			manifest.Config = ociDescriptor{
+				MediaType: OCIConfigMediaType,
+				Digest:    digest,
+				Size:      int64(len(content)),
+			}
+
+			_, _ = hasher.Write(content)
+			packageManifest.Devices = append(packageManifest.Devices, hasher.manifestDevice(device.Name))
+
+			continue
+		}
+
+		layer, err := archiveOCILayer(layout, encoder, device.Path, hasher, progress)
+		if err != nil {
+			return err
+		}
+
+		layer.Annotations = map[string]string{
+			ociTitleAnnotation: device.Name,
+		}
+		manifest.Layers = append(manifest.Layers, layer)
+		packageManifest.Devices = append(packageManifest.Devices, hasher.manifestDevice(device.Name))
+	}
+
+	rawPackageManifest, err := json.Marshal(packageManifest)
+	if err != nil {
+		return errors.Join(ErrCouldNotEncodeManifest, err)
+	}
+
+	metadataLayers := []struct {
+		name      string
+		mediaType string
+		content   []byte
+	}{
+		{ManifestName, OCIManifestLayerMediaType, rawPackageManifest},
+	}
+	if options.SigningKey != nil {
+		metadataLayers = append(metadataLayers, struct {
+			name      string
+			mediaType string
+			content   []byte
+		}{ManifestSignatureName, OCIManifestSignatureLayerMediaType, ed25519.Sign(options.SigningKey, rawPackageManifest)})
+	}
+
+	for _, metadataLayer := range metadataLayers {
+		digest, err := layout.writeBlob(metadataLayer.content)
+		if err != nil {
+			return err
+		}
+
+		manifest.Layers = append(manifest.Layers, ociDescriptor{
+			MediaType: metadataLayer.mediaType,
+			Digest:    digest,
+			Size:      int64(len(metadataLayer.content)),
+			Annotations: map[string]string{
+				ociTitleAnnotation: metadataLayer.name,
+			},
+		})
+	}
+
+	rawManifest, err := json.Marshal(manifest)
+	if err != nil {
+		return errors.Join(ErrCouldNotEncodeOCIManifest, err)
+	}
+
+	manifestDigest, err := layout.writeBlob(rawManifest)
+	if err != nil {
+		return err
+	}
+
+	progress.finish()
+
+	return layout.tag(tag, ociDescriptor{
+		MediaType: ociManifestMediaType,
+		Digest:    manifestDigest,
+		Size:      int64(len(rawManifest)),
+	})
+}
+
+func archiveOCILayer(layout *ociLayout, encoder *zstd.Encoder, devicePath string, hasher io.Writer, progress *progressCounter) (ociDescriptor, error) {
+	f, err := os.Open(devicePath)
+	if err != nil {
+		return ociDescriptor{}, errors.Join(ErrCouldNotOpenDevice, err)
+	}
+	defer f.Close()
+
+	writer, err := layout.newBlobWriter()
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	defer writer.Discard()
+
+	encoder.Reset(progress.countPackageWriter(writer))
+	if _, err := io.Copy(encoder, io.TeeReader(progress.countDevice(f), hasher)); err != nil {
+		return ociDescriptor{}, errors.Join(ErrCouldNotCopyToArchive, err)
+	}
+
+	if err := encoder.Close(); err != nil {
+		return ociDescriptor{}, errors.Join(ErrCouldNotCopyToArchive, err)
+	}
+
+	digest, size, err := writer.Commit("")
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+
+	return ociDescriptor{
+		MediaType: OCIDeviceLayerMediaType,
+		Digest:    digest,
+		Size:      size,
+	}, nil
+}
+
+// ExtractOCIPackage extracts `devices` from the OCI artifact that is tagged with `tag` in the OCI image layout at `layoutPath`.
+// Like `ExtractPackage`, it reports missing devices in the error and unknown layers to `OnUnknownFile`, and verifies the extracted
+// devices against the package's manifest.
+func ExtractOCIPackage(
+	ctx context.Context,
+
+	layoutPath string,
+	tag string,
+
+	devices []PackagerDevice,
+
+	options ExtractOptions,
+	hooks PackagerHooks,
+) error {
+	layout, err := openOCILayout(layoutPath)
+	if err != nil {
+		return err
+	}
+
+	descriptor, err := layout.resolve(tag)
+	if err != nil {
+		return err
+	}
+
+	manifest, _, err := layout.readManifest(descriptor)
+	if err != nil {
+		return err
+	}
+
+	if manifest.ArtifactType != OCIArtifactType {
+		return errors.Join(ErrNotOCIPackage, fmt.Errorf("artifact type %q", manifest.ArtifactType))
+	}
+
+	// The manifest and its signature are small, so we extract them like the config, from memory
+	var (
+		rawPackageManifest []byte
+		signature          []byte
+		entries            = map[string][]byte{}
+	)
+	if manifest.Config.MediaType == OCIConfigMediaType {
+		entries[ConfigName], err = layout.readBlob(manifest.Config.Digest)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, layer := range manifest.Layers {
+		switch layer.MediaType {
+		case OCIManifestLayerMediaType:
+			if rawPackageManifest, err = layout.readBlob(layer.Digest); err != nil {
+				return err
+			}
+
+			entries[ManifestName] = rawPackageManifest
+
+		case OCIManifestSignatureLayerMediaType:
+			if signature, err = layout.readBlob(layer.Digest); err != nil {
+				return err
+			}
+
+			entries[ManifestSignatureName] = signature
+		}
+	}
+
+	missingDevices := map[string][]PackagerDevice{}
+	for _, device := range devices {
+		missingDevices[device.Name] = append(missingDevices[device.Name], device)
+	}
+
+	var (
+		extractedDevices = map[string]ManifestDevice{}
+		extractedPaths   = map[string]string{}
+	)
+	for _, name := range slices.Sorted(maps.Keys(entries)) {
+		entryDevices, ok := missingDevices[name]
+		if !ok {
+			continue
+		}
+		delete(missingDevices, name)
+
+		hasher := newDeviceHasher(DefaultManifestBlockSize)
+		if err := extractEntry(ctx, io.TeeReader(bytes.NewReader(entries[name]), hasher), entryDevices, options, hooks); err != nil {
+			return err
+		}
+
+		extractedDevices[name] = hasher.manifestDevice(name)
+		extractedPaths[name] = entryDevices[0].Path
+	}
+
+	// We read the decoder's output synchronously, so we don't need the decoder to decode ahead in other goroutines
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return errors.Join(ErrCouldNotCreateUncompressor, err)
+	}
+	defer decoder.Close()
+
+	for _, layer := range manifest.Layers {
+	s:
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		default:
+			break s
+		}
+
+		if layer.MediaType == OCIManifestLayerMediaType || layer.MediaType == OCIManifestSignatureLayerMediaType {
+			continue
+		}
+
+		name := layer.Annotations[ociTitleAnnotation]
+
+		entryDevices, ok := missingDevices[name]
+		if !ok || layer.MediaType != OCIDeviceLayerMediaType {
+			if hook := hooks.OnUnknownFile; hook != nil {
+				hook(name)
+			}
+
+			continue
+		}
+		delete(missingDevices, name)
+
+		hasher := newDeviceHasher(DefaultManifestBlockSize)
+		if err := extractOCILayer(ctx, layout, decoder, layer, hasher, entryDevices, options, hooks); err != nil {
+			return err
+		}
+
+		extractedDevices[name] = hasher.manifestDevice(name)
+		extractedPaths[name] = entryDevices[0].Path
+	}
+
+	if err := getMissingDevicesError(missingDevices); err != nil {
+		return err
+	}
+
+	return verifyExtractedDevices(rawPackageManifest, signature, extractedDevices, extractedPaths, options)
+}
+
+func extractOCILayer(
+	ctx context.Context,
+
+	layout *ociLayout,
+	decoder *zstd.Decoder,
+	layer ociDescriptor,
+	hasher io.Writer,
+
+	devices []PackagerDevice,
+
+	options ExtractOptions,
+	hooks PackagerHooks,
+) error {
+	blob, err := layout.openBlob(layer.Digest)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	// We verify the digest of the compressed layer before decompressing it so that we never write the devices of a corrupted layer
+	digest := sha256.New()
+	if _, err := io.Copy(digest, blob); err != nil {
+		return errors.Join(ErrCouldNotReadOCIBlob, err)
+	}
+
+	if actual := "sha256:" + hex.EncodeToString(digest.Sum(nil)); actual != layer.Digest {
+		return errors.Join(ErrOCIDigestMismatch, fmt.Errorf("layer %v has digest %v", layer.Digest, actual))
+	}
+
+	if _, err := blob.Seek(0, io.SeekStart); err != nil {
+		return errors.Join(ErrCouldNotReadOCIBlob, err)
+	}
+
+	if err := decoder.Reset(blob); err != nil {
+		return errors.Join(ErrCouldNotCreateUncompressor, err)
+	}
+
+	return extractEntry(ctx, io.TeeReader(decoder, hasher), devices, options, hooks)
+}
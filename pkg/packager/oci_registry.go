@@ -0,0 +1,566 @@
+package packager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// Maximum size of manifests and error responses that we read from a registry into memory
+	maxOCIManifestSize = 1024 * 1024 * 4
+)
+
+type OCIRegistryOptions struct {
+	// Whether to connect to the registry over plain HTTP instead of HTTPS, e.g. for local registries
+	Insecure bool
+
+	// Credentials for the registry; if empty, requests are anonymous
+	Username string
+	Password string
+
+	// Client to send requests with; if nil, `http.DefaultClient` is used
+	Client *http.Client
+}
+
+// OCIReference is a parsed reference to an artifact in a registry, e.g. `ghcr.io/loopholelabs/drafter-oci:latest`
+type OCIReference struct {
+	Host       string
+	Repository string
+	// Tag or digest of the artifact
+	Reference string
+}
+
+// ParseOCIReference parses a reference in the form `host[:port]/repository[:tag|@digest]`; the host is required, and the
+// reference defaults to `DefaultOCITag`
+func ParseOCIReference(reference string) (OCIReference, error) {
+	host, path, ok := strings.Cut(reference, "/")
+	if !ok || host == "" || !(strings.ContainsAny(host, ".:") || host == "localhost") {
+		return OCIReference{}, errors.Join(ErrInvalidOCIReference, fmt.Errorf("reference %q must start with a registry host", reference))
+	}
+
+	ref := OCIReference{
+		Host:      host,
+		Reference: DefaultOCITag,
+	}
+	if repository, digest, ok := strings.Cut(path, "@"); ok {
+		ref.Repository = repository
+		ref.Reference = digest
+	} else if i := strings.LastIndex(path, ":"); i > strings.LastIndex(path, "/") {
+		ref.Repository = path[:i]
+		ref.Reference = path[i+1:]
+	} else {
+		ref.Repository = path
+	}
+
+	if ref.Repository == "" || ref.Reference == "" || ref.Repository != strings.ToLower(ref.Repository) {
+		return OCIReference{}, errors.Join(ErrInvalidOCIReference, fmt.Errorf("reference %q", reference))
+	}
+
+	return ref, nil
+}
+
+func (r OCIReference) String() string {
+	if strings.Contains(r.Reference, ":") {
+		return r.Host + "/" + r.Repository + "@" + r.Reference
+	}
+
+	return r.Host + "/" + r.Repository + ":" + r.Reference
+}
+
+// ociRegistryClient is a minimal client for the OCI distribution API (https://github.com/opencontainers/distribution-spec/blob/main/spec.md)
+type ociRegistryClient struct {
+	client  *http.Client
+	baseURL string
+
+	reference OCIReference
+	options   OCIRegistryOptions
+	// Actions to request a token for, e.g. `pull` or `pull,push`
+	actions string
+
+	// Bearer token for the repository once we have authenticated with the registry's token service
+	token string
+}
+
+func newOCIRegistryClient(reference OCIReference, options OCIRegistryOptions, actions string) *ociRegistryClient {
+	client := options.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	scheme := "https"
+	if options.Insecure {
+		scheme = "http"
+	}
+
+	return &ociRegistryClient{
+		client:  client,
+		baseURL: scheme + "://" + reference.Host + "/v2/" + reference.Repository,
+
+		reference: reference,
+		options:   options,
+		actions:   actions,
+	}
+}
+
+// do sends the request that `newRequest` creates; since the body can't be re-read, the request is recreated if it needs to be retried
+// after authenticating
+func (c *ociRegistryClient) do(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req)
+
+	res, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusUnauthorized {
+		return res, nil
+	}
+
+	challenge := res.Header.Get("WWW-Authenticate")
+	_ = res.Body.Close()
+
+	if err := c.authenticate(ctx, challenge); err != nil {
+		return nil, err
+	}
+
+	req, err = newRequest()
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req)
+
+	return c.client.Do(req.WithContext(ctx))
+}
+
+func (c *ociRegistryClient) authorize(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.options.Username != "" {
+		req.SetBasicAuth(c.options.Username, c.options.Password)
+	}
+}
+
+// authenticate handles a `WWW-Authenticate` challenge; for basic authentication the credentials are simply sent with the next request,
+// for bearer authentication we fetch a token for the repository from the registry's token service
+func (c *ociRegistryClient) authenticate(ctx context.Context, challenge string) error {
+	scheme, rawParams, _ := strings.Cut(challenge, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.options.Username == "" {
+			return errors.Join(ErrCouldNotAuthenticateToRegistry, errors.New("registry requires credentials"))
+		}
+
+		return nil
+
+	case "bearer":
+		break
+
+	default:
+		return errors.Join(ErrCouldNotAuthenticateToRegistry, fmt.Errorf("unsupported challenge %q", challenge))
+	}
+
+	params := parseOCIChallengeParams(rawParams)
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return errors.Join(ErrCouldNotAuthenticateToRegistry, fmt.Errorf("invalid realm in challenge %q", challenge))
+	}
+
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	query.Set("scope", "repository:"+c.reference.Repository+":"+c.actions)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return errors.Join(ErrCouldNotAuthenticateToRegistry, err)
+	}
+
+	if c.options.Username != "" {
+		req.SetBasicAuth(c.options.Username, c.options.Password)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return errors.Join(ErrCouldNotAuthenticateToRegistry, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.Join(ErrCouldNotAuthenticateToRegistry, getOCIResponseError(res))
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxOCIManifestSize)).Decode(&token); err != nil {
+		return errors.Join(ErrCouldNotAuthenticateToRegistry, err)
+	}
+
+	c.token = token.Token
+	if c.token == "" {
+		c.token = token.AccessToken
+	}
+
+	if c.token == "" {
+		return errors.Join(ErrCouldNotAuthenticateToRegistry, errors.New("token service didn't return a token"))
+	}
+
+	return nil
+}
+
+func parseOCIChallengeParams(rawParams string) map[string]string {
+	params := map[string]string{}
+	for rawParams != "" {
+		var key, value string
+
+		key, rawParams, _ = strings.Cut(rawParams, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+
+		if strings.HasPrefix(rawParams, `"`) {
+			value, rawParams, _ = strings.Cut(rawParams[1:], `"`)
+			_, rawParams, _ = strings.Cut(rawParams, ",")
+		} else {
+			value, rawParams, _ = strings.Cut(rawParams, ",")
+		}
+
+		params[key] = strings.TrimSpace(value)
+	}
+
+	return params
+}
+
+func getOCIResponseError(res *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxOCIManifestSize))
+
+	return fmt.Errorf("%v %v: unexpected status %v: %s", res.Request.Method, res.Request.URL.Redacted(), res.Status, bytes.TrimSpace(body))
+}
+
+func (c *ociRegistryClient) hasBlob(ctx context.Context, digest string) (bool, error) {
+	res, err := c.do(ctx, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodHead, c.baseURL+"/blobs/"+digest, nil)
+	})
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+
+	case http.StatusNotFound:
+		return false, nil
+
+	default:
+		return false, getOCIResponseError(res)
+	}
+}
+
+// pushBlob uploads a blob in a single request (the "monolithic" upload of the distribution spec)
+func (c *ociRegistryClient) pushBlob(ctx context.Context, digest string, size int64, open func() (io.ReadCloser, error)) error {
+	res, err := c.do(ctx, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodPost, c.baseURL+"/blobs/uploads/", nil)
+	})
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+
+	if res.StatusCode != http.StatusAccepted {
+		return getOCIResponseError(res)
+	}
+
+	// The location can be relative to the registry and already have query parameters, e.g. a session ID
+	location, err := res.Request.URL.Parse(res.Header.Get("Location"))
+	if err != nil {
+		return err
+	}
+
+	query := location.Query()
+	query.Set("digest", digest)
+	location.RawQuery = query.Encode()
+
+	res, err = c.do(ctx, func() (*http.Request, error) {
+		body, err := open()
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequest(http.MethodPut, location.String(), body)
+		if err != nil {
+			_ = body.Close()
+
+			return nil, err
+		}
+
+		req.ContentLength = size
+		req.Header.Set("Content-Type", "application/octet-stream")
+
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		return getOCIResponseError(res)
+	}
+
+	return nil
+}
+
+func (c *ociRegistryClient) pullBlob(ctx context.Context, digest string, w io.Writer) error {
+	res, err := c.do(ctx, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, c.baseURL+"/blobs/"+digest, nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return getOCIResponseError(res)
+	}
+
+	_, err = io.Copy(w, res.Body)
+
+	return err
+}
+
+func (c *ociRegistryClient) pushManifest(ctx context.Context, reference string, rawManifest []byte) error {
+	res, err := c.do(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPut, c.baseURL+"/manifests/"+reference, bytes.NewReader(rawManifest))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", ociManifestMediaType)
+
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		return getOCIResponseError(res)
+	}
+
+	return nil
+}
+
+func (c *ociRegistryClient) pullManifest(ctx context.Context, reference string) ([]byte, error) {
+	res, err := c.do(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, c.baseURL+"/manifests/"+reference, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Accept", ociManifestMediaType)
+
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, getOCIResponseError(res)
+	}
+
+	return io.ReadAll(io.LimitReader(res.Body, maxOCIManifestSize))
+}
+
+// PushOCIPackage pushes the package that is tagged with `tag` in the OCI image layout at `layoutPath` to `reference` in a registry;
+// blobs that the registry already has are skipped
+func PushOCIPackage(
+	ctx context.Context,
+
+	layoutPath string,
+	tag string,
+
+	reference string,
+
+	options OCIRegistryOptions,
+	hooks PackagerHooks,
+) error {
+	ref, err := ParseOCIReference(reference)
+	if err != nil {
+		return err
+	}
+
+	layout, err := openOCILayout(layoutPath)
+	if err != nil {
+		return err
+	}
+
+	descriptor, err := layout.resolve(tag)
+	if err != nil {
+		return err
+	}
+
+	manifest, rawManifest, err := layout.readManifest(descriptor)
+	if err != nil {
+		return err
+	}
+
+	client := newOCIRegistryClient(ref, options, "pull,push")
+
+	for _, blob := range append([]ociDescriptor{manifest.Config}, manifest.Layers...) {
+	s:
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		default:
+			break s
+		}
+
+		exists, err := client.hasBlob(ctx, blob.Digest)
+		if err != nil {
+			return errors.Join(ErrCouldNotPushToRegistry, err)
+		}
+
+		if !exists {
+			if err := client.pushBlob(ctx, blob.Digest, blob.Size, func() (io.ReadCloser, error) {
+				blobFile, err := layout.openBlob(blob.Digest)
+				if err != nil {
+					return nil, err
+				}
+
+				return blobFile, nil
+			}); err != nil {
+				return errors.Join(ErrCouldNotPushToRegistry, err)
+			}
+		}
+
+		if hook := hooks.OnBlobProcessed; hook != nil {
+			hook(blob.Digest, blob.Size, exists)
+		}
+	}
+
+	if err := client.pushManifest(ctx, ref.Reference, rawManifest); err != nil {
+		return errors.Join(ErrCouldNotPushToRegistry, err)
+	}
+
+	return nil
+}
+
+// PullOCIPackage pulls the package at `reference` from a registry into the OCI image layout at `layoutPath` and tags it with `tag`;
+// blobs that are already in the layout are skipped, and all blobs are verified against their digests
+func PullOCIPackage(
+	ctx context.Context,
+
+	reference string,
+
+	layoutPath string,
+	tag string,
+
+	options OCIRegistryOptions,
+	hooks PackagerHooks,
+) error {
+	ref, err := ParseOCIReference(reference)
+	if err != nil {
+		return err
+	}
+
+	layout, err := openOCILayout(layoutPath)
+	if err != nil {
+		return err
+	}
+
+	client := newOCIRegistryClient(ref, options, "pull")
+
+	rawManifest, err := client.pullManifest(ctx, ref.Reference)
+	if err != nil {
+		return errors.Join(ErrCouldNotPullFromRegistry, err)
+	}
+
+	manifestDigest := getOCIDigest(rawManifest)
+	if strings.Contains(ref.Reference, ":") && ref.Reference != manifestDigest {
+		return errors.Join(ErrOCIDigestMismatch, fmt.Errorf("manifest %v has digest %v", ref.Reference, manifestDigest))
+	}
+
+	var manifest ociManifest
+	if err := json.Unmarshal(rawManifest, &manifest); err != nil {
+		return errors.Join(ErrCouldNotDecodeOCIManifest, err)
+	}
+
+	if manifest.ArtifactType != OCIArtifactType {
+		return errors.Join(ErrNotOCIPackage, fmt.Errorf("artifact type %q", manifest.ArtifactType))
+	}
+
+	for _, blob := range append([]ociDescriptor{manifest.Config}, manifest.Layers...) {
+	s:
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		default:
+			break s
+		}
+
+		exists := layout.hasBlob(blob.Digest)
+		if !exists {
+			if err := pullOCIBlob(ctx, client, layout, blob); err != nil {
+				return err
+			}
+		}
+
+		if hook := hooks.OnBlobProcessed; hook != nil {
+			hook(blob.Digest, blob.Size, exists)
+		}
+	}
+
+	// The manifest is written last so that the layout never references blobs that it doesn't have
+	if _, err := layout.writeBlob(rawManifest); err != nil {
+		return err
+	}
+
+	return layout.tag(tag, ociDescriptor{
+		MediaType: ociManifestMediaType,
+		Digest:    manifestDigest,
+		Size:      int64(len(rawManifest)),
+	})
+}
+
+func pullOCIBlob(ctx context.Context, client *ociRegistryClient, layout *ociLayout, blob ociDescriptor) error {
+	// We check the digest's format before downloading so that we don't write to arbitrary paths
+	if _, err := layout.blobPath(blob.Digest); err != nil {
+		return err
+	}
+
+	writer, err := layout.newBlobWriter()
+	if err != nil {
+		return err
+	}
+	defer writer.Discard()
+
+	if err := client.pullBlob(ctx, blob.Digest, writer); err != nil {
+		return errors.Join(ErrCouldNotPullFromRegistry, err)
+	}
+
+	if _, _, err := writer.Commit(blob.Digest); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,339 @@
+package packager_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/loopholelabs/drafter/pkg/ocitest"
+	"github.com/loopholelabs/drafter/pkg/packager"
+)
+
+// createDevices writes a config, a kernel and a partially sparse disk to `dir`
+func createDevices(t *testing.T, dir string) []packager.PackagerDevice {
+	t.Helper()
+
+	disk := make([]byte, 1024*1024)
+	if _, err := rand.Read(disk[:64*1024]); err != nil {
+		t.Fatal(err)
+	}
+
+	kernel := make([]byte, 32*1024)
+	if _, err := rand.Read(kernel); err != nil {
+		t.Fatal(err)
+	}
+
+	devices := []packager.PackagerDevice{}
+	for name, content := range map[string][]byte{
+		packager.ConfigName: []byte(`{"agentVSockPort":26}`),
+		packager.KernelName: kernel,
+		packager.DiskName:   disk,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		devices = append(devices, packager.PackagerDevice{
+			Name: name,
+			Path: path,
+		})
+	}
+
+	return devices
+}
+
+func TestOCIPackageRoundTripWithBearerToken(t *testing.T) {
+	var (
+		scopesLock sync.Mutex
+		scopes     = []string{}
+	)
+	registry := ocitest.NewRegistry(ocitest.RegistryHooks{
+		OnTokenIssued: func(service, scope string) {
+			scopesLock.Lock()
+			defer scopesLock.Unlock()
+
+			scopes = append(scopes, service+" "+scope)
+		},
+	})
+	registry.Username = "drafter"
+	registry.Password = "password"
+	registry.Token = "token"
+
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	ctx := context.Background()
+
+	inputDir := t.TempDir()
+	devices := createDevices(t, inputDir)
+
+	pushLayout := filepath.Join(t.TempDir(), "push")
+	if err := packager.ArchiveOCIPackage(ctx, devices, pushLayout, "v1", packager.ArchiveOptions{}, packager.PackagerHooks{}); err != nil {
+		t.Fatal(err)
+	}
+
+	reference := strings.TrimPrefix(server.URL, "http://") + "/drafter/valkey:v1"
+	options := packager.OCIRegistryOptions{
+		Insecure: true,
+
+		Username: registry.Username,
+		Password: registry.Password,
+	}
+
+	if err := packager.PushOCIPackage(ctx, pushLayout, "v1", reference, options, packager.PackagerHooks{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "ocitest repository:drafter/valkey:pull,push"; len(scopes) != 1 || scopes[0] != want {
+		t.Errorf("token service issued tokens for %q, want one for %q", scopes, want)
+	}
+
+	// Pushing again doesn't upload any blobs since the registry already has all of them
+	if err := packager.PushOCIPackage(ctx, pushLayout, "v1", reference, options, packager.PackagerHooks{
+		OnBlobProcessed: func(digest string, size int64, reused bool) {
+			if !reused {
+				t.Errorf("blob %v was pushed again", digest)
+			}
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	pullLayout := filepath.Join(t.TempDir(), "pull")
+	if err := packager.PullOCIPackage(ctx, reference, pullLayout, "latest", options, packager.PackagerHooks{}); err != nil {
+		t.Fatal(err)
+	}
+
+	outputDir := t.TempDir()
+	outputDevices := []packager.PackagerDevice{}
+	for _, device := range devices {
+		outputDevices = append(outputDevices, packager.PackagerDevice{
+			Name: device.Name,
+			Path: filepath.Join(outputDir, device.Name),
+		})
+	}
+
+	if err := packager.ExtractOCIPackage(ctx, pullLayout, "latest", outputDevices, packager.ExtractOptions{}, packager.PackagerHooks{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, device := range devices {
+		expected, err := os.ReadFile(device.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual, err := os.ReadFile(outputDevices[i].Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("extracted device %v differs from the archived device", device.Name)
+		}
+	}
+
+	// Requests without credentials can't get a token
+	if err := packager.PullOCIPackage(ctx, reference, filepath.Join(t.TempDir(), "anonymous"), "latest", packager.OCIRegistryOptions{
+		Insecure: true,
+	}, packager.PackagerHooks{}); !errors.Is(err, packager.ErrCouldNotAuthenticateToRegistry) {
+		t.Errorf("pulling without credentials returned %v, want %v", err, packager.ErrCouldNotAuthenticateToRegistry)
+	}
+}
+
+func TestPullOCIPackageDigestMismatch(t *testing.T) {
+	var (
+		largestBlobLock sync.Mutex
+		largestBlob     string
+		largestBlobSize int
+	)
+	registry := ocitest.NewRegistry(ocitest.RegistryHooks{
+		OnBlobUploaded: func(repository, digest string, size int) {
+			largestBlobLock.Lock()
+			defer largestBlobLock.Unlock()
+
+			if size > largestBlobSize {
+				largestBlob = digest
+				largestBlobSize = size
+			}
+		},
+	})
+
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	ctx := context.Background()
+
+	devices := createDevices(t, t.TempDir())
+
+	pushLayout := filepath.Join(t.TempDir(), "push")
+	if err := packager.ArchiveOCIPackage(ctx, devices, pushLayout, "v1", packager.ArchiveOptions{}, packager.PackagerHooks{}); err != nil {
+		t.Fatal(err)
+	}
+
+	reference := strings.TrimPrefix(server.URL, "http://") + "/drafter/valkey:v1"
+	options := packager.OCIRegistryOptions{
+		Insecure: true,
+	}
+
+	if err := packager.PushOCIPackage(ctx, pushLayout, "v1", reference, options, packager.PackagerHooks{}); err != nil {
+		t.Fatal(err)
+	}
+
+	blob, ok := registry.Blob(largestBlob)
+	if !ok {
+		t.Fatalf("registry doesn't have blob %v", largestBlob)
+	}
+	blob[len(blob)/2] ^= 0xff
+
+	registry.SetBlob(largestBlob, blob)
+
+	pullLayout := filepath.Join(t.TempDir(), "pull")
+	if err := packager.PullOCIPackage(ctx, reference, pullLayout, "latest", options, packager.PackagerHooks{}); !errors.Is(err, packager.ErrOCIDigestMismatch) {
+		t.Fatalf("pulling a corrupted blob returned %v, want %v", err, packager.ErrOCIDigestMismatch)
+	}
+
+	// The layout isn't tagged if a blob couldn't be pulled, so the package can't be extracted with corrupted devices
+	outputDir := t.TempDir()
+	outputDevices := []packager.PackagerDevice{}
+	for _, device := range devices {
+		outputDevices = append(outputDevices, packager.PackagerDevice{
+			Name: device.Name,
+			Path: filepath.Join(outputDir, device.Name),
+		})
+	}
+
+	if err := packager.ExtractOCIPackage(ctx, pullLayout, "latest", outputDevices, packager.ExtractOptions{}, packager.PackagerHooks{}); !errors.Is(err, packager.ErrOCITagNotFound) {
+		t.Errorf("extracting a package that couldn't be pulled returned %v, want %v", err, packager.ErrOCITagNotFound)
+	}
+}
+
+func TestExtractOCIPackageVerifiesLayersBeforeWritingDevices(t *testing.T) {
+	ctx := context.Background()
+
+	devices := createDevices(t, t.TempDir())
+
+	var (
+		deviceSize      int64
+		lastDeviceBytes int64
+		layoutPath      = filepath.Join(t.TempDir(), "layout")
+	)
+	for _, device := range devices {
+		info, err := os.Stat(device.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		deviceSize += info.Size()
+	}
+
+	if err := packager.ArchiveOCIPackage(ctx, devices, layoutPath, "v1", packager.ArchiveOptions{
+		Compression: packager.CompressionOptions{
+			Level: 19,
+		},
+	}, packager.PackagerHooks{
+		OnProgress: func(deviceBytes, packageBytes int64) {
+			lastDeviceBytes = deviceBytes
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if lastDeviceBytes != deviceSize {
+		t.Errorf("progress hook reported %v device bytes, want %v", lastDeviceBytes, deviceSize)
+	}
+
+	// The disk is the largest device, so its layer is the largest blob
+	var (
+		largestBlobPath string
+		largestBlobSize int64
+	)
+	blobsDir := filepath.Join(layoutPath, "blobs", "sha256")
+	entries, err := os.ReadDir(blobsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Size() > largestBlobSize {
+			largestBlobPath = filepath.Join(blobsDir, entry.Name())
+			largestBlobSize = info.Size()
+		}
+	}
+
+	blob, err := os.ReadFile(largestBlobPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob[len(blob)/2] ^= 0xff
+
+	if err := os.WriteFile(largestBlobPath, blob, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outputDir := t.TempDir()
+	diskPath := filepath.Join(outputDir, packager.DiskName)
+	if err := packager.ExtractOCIPackage(ctx, layoutPath, "v1", []packager.PackagerDevice{
+		{
+			Name: packager.DiskName,
+			Path: diskPath,
+		},
+	}, packager.ExtractOptions{}, packager.PackagerHooks{}); !errors.Is(err, packager.ErrOCIDigestMismatch) {
+		t.Fatalf("extracting a corrupted layer returned %v, want %v", err, packager.ErrOCIDigestMismatch)
+	}
+
+	if _, err := os.Stat(diskPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("device of a corrupted layer was written (stat returned %v)", err)
+	}
+}
+
+func TestArchiveOCIPackageRejectsUnsupportedOptions(t *testing.T) {
+	devices := createDevices(t, t.TempDir())
+
+	for name, test := range map[string]struct {
+		options packager.ArchiveOptions
+		err     error
+	}{
+		"uncompressed": {
+			options: packager.ArchiveOptions{
+				Compression: packager.CompressionOptions{
+					Disabled: true,
+				},
+			},
+			err: packager.ErrInvalidCompressionOptions,
+		},
+		"dictionary": {
+			options: packager.ArchiveOptions{
+				Compression: packager.CompressionOptions{
+					Dictionary: []byte("dictionary"),
+				},
+			},
+			err: packager.ErrInvalidCompressionOptions,
+		},
+		"seekable": {
+			options: packager.ArchiveOptions{
+				Seekable: true,
+			},
+			err: packager.ErrInvalidArchiveOptions,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := packager.ArchiveOCIPackage(context.Background(), devices, filepath.Join(t.TempDir(), "layout"), "v1", test.options, packager.PackagerHooks{}); !errors.Is(err, test.err) {
+				t.Errorf("archiving returned %v, want %v", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package packager
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// progressCounter counts the bytes of devices and of the package that have been processed while archiving or extracting, and
+// reports them to `OnProgress` whenever device bytes have been processed
+type progressCounter struct {
+	onProgress func(deviceBytes, packageBytes int64)
+
+	// Compressors can write to the package from another goroutine, so the counters need to be atomic
+	deviceBytes  atomic.Int64
+	packageBytes atomic.Int64
+}
+
+func newProgressCounter(hooks PackagerHooks) *progressCounter {
+	return &progressCounter{
+		onProgress: hooks.OnProgress,
+	}
+}
+
+// finish reports the final counts, which include the package bytes that were written or read after the last device bytes, e.g. for
+// the manifest and the compressor's trailers
+func (p *progressCounter) finish() {
+	if p.onProgress == nil {
+		return
+	}
+
+	p.onProgress(p.deviceBytes.Load(), p.packageBytes.Load())
+}
+
+// countDevice counts the bytes that are read from `r` as device bytes
+func (p *progressCounter) countDevice(r io.Reader) io.Reader {
+	if p.onProgress == nil {
+		return r
+	}
+
+	return &progressReader{
+		r: r,
+		onRead: func(n int) {
+			p.onProgress(p.deviceBytes.Add(int64(n)), p.packageBytes.Load())
+		},
+	}
+}
+
+// countPackageReader counts the bytes that are read from `r` as package bytes
+func (p *progressCounter) countPackageReader(r io.Reader) io.Reader {
+	if p.onProgress == nil {
+		return r
+	}
+
+	return &progressReader{
+		r: r,
+		onRead: func(n int) {
+			p.packageBytes.Add(int64(n))
+		},
+	}
+}
+
+// countPackageWriter counts the bytes that are written to `w` as package bytes
+func (p *progressCounter) countPackageWriter(w io.Writer) io.Writer {
+	if p.onProgress == nil {
+		return w
+	}
+
+	return &progressWriter{
+		w: w,
+		onWrite: func(n int) {
+			p.packageBytes.Add(int64(n))
+		},
+	}
+}
+
+type progressReader struct {
+	r      io.Reader
+	onRead func(n int)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.onRead(n)
+	}
+
+	return n, err
+}
+
+type progressWriter struct {
+	w       io.Writer
+	onWrite func(n int)
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if n > 0 {
+		w.onWrite(n)
+	}
+
+	return n, err
+}
@@ -0,0 +1,591 @@
+package packager
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Seekable packages use the zstd seekable format (https://github.com/facebook/zstd/blob/dev/contrib/seekable_format/zstd_seekable_compression_format.md):
+// the archive is split into independently compressed frames, followed by a seek table in a skippable frame. Regular zstd decoders
+// ignore the seek table, so seekable packages can still be extracted like any other package.
+const (
+	// Amount of uncompressed data in each frame of a seekable package if none is set
+	DefaultSeekableFrameSize = 1024 * 1024
+
+	seekTableFrameMagic = 0x184D2A5E
+	seekTableMagic      = 0x8F92EAB1
+
+	seekTableFrameHeaderSize   = 8
+	seekTableFooterSize        = 9
+	seekTableEntrySize         = 8
+	seekTableChecksumEntrySize = 12
+
+	seekTableChecksumFlag = 1 << 7
+
+	// Number of decompressed frames to keep in memory for reads from seekable packages
+	seekableFrameCacheSize = 16
+)
+
+type seekableFrame struct {
+	compressedOffset   int64
+	compressedSize     int64
+	decompressedOffset int64
+	decompressedSize   int64
+}
+
+// seekableWriter compresses everything that is written to it in independent frames of `frameSize` bytes, and writes the seek table on `Close`;
+// up to `concurrency` frames are compressed in parallel
+type seekableWriter struct {
+	w       io.Writer
+	encoder *zstd.Encoder
+
+	frameSize   int
+	concurrency int
+
+	buf []byte
+	// Full frames that haven't been compressed yet
+	pending [][]byte
+
+	frames []seekableFrame
+	closed bool
+}
+
+func newSeekableWriter(w io.Writer, frameSize int, options CompressionOptions) (*seekableWriter, error) {
+	if frameSize <= 0 {
+		frameSize = DefaultSeekableFrameSize
+	}
+
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	options.Concurrency = concurrency
+
+	// `EncodeAll` can be called concurrently, and the encoder's concurrency limits how many calls can run in parallel
+	encoder, err := zstd.NewWriter(nil, options.encoderOptions()...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &seekableWriter{
+		w:       w,
+		encoder: encoder,
+
+		frameSize:   frameSize,
+		concurrency: concurrency,
+
+		buf:     make([]byte, 0, frameSize),
+		pending: [][]byte{},
+
+		frames: []seekableFrame{},
+	}, nil
+}
+
+func (s *seekableWriter) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 {
+		chunk := p[:min(len(p), s.frameSize-len(s.buf))]
+
+		s.buf = append(s.buf, chunk...)
+		p = p[len(chunk):]
+
+		if len(s.buf) == s.frameSize {
+			s.pending = append(s.pending, s.buf)
+			s.buf = make([]byte, 0, s.frameSize)
+
+			if len(s.pending) >= s.concurrency {
+				if err := s.writePendingFrames(); err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// writePendingFrames compresses the pending frames in parallel and writes them in order
+func (s *seekableWriter) writePendingFrames() error {
+	var (
+		compressed = make([][]byte, len(s.pending))
+		wg         sync.WaitGroup
+	)
+	for i, frame := range s.pending {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			compressed[i] = s.encoder.EncodeAll(frame, nil)
+		}()
+	}
+	wg.Wait()
+
+	for i, frame := range compressed {
+		if _, err := s.w.Write(frame); err != nil {
+			return err
+		}
+
+		s.frames = append(s.frames, seekableFrame{
+			compressedSize:   int64(len(frame)),
+			decompressedSize: int64(len(s.pending[i])),
+		})
+	}
+	s.pending = s.pending[:0]
+
+	return nil
+}
+
+// writeSkippableFrame writes a skippable frame that doesn't contain any of the archive, e.g. metadata, and adds it to the seek table
+func (s *seekableWriter) writeSkippableFrame(frame []byte) error {
+	if len(s.buf) > 0 {
+		s.pending = append(s.pending, s.buf)
+		s.buf = make([]byte, 0, s.frameSize)
+	}
+
+	if err := s.writePendingFrames(); err != nil {
+		return err
+	}
+
+	if _, err := s.w.Write(frame); err != nil {
+		return err
+	}
+
+	s.frames = append(s.frames, seekableFrame{
+		compressedSize:   int64(len(frame)),
+		decompressedSize: 0,
+	})
+
+	return nil
+}
+
+func (s *seekableWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	if len(s.buf) > 0 {
+		s.pending = append(s.pending, s.buf)
+	}
+
+	if err := s.writePendingFrames(); err != nil {
+		return err
+	}
+
+	seekTableSize := len(s.frames)*seekTableEntrySize + seekTableFooterSize
+
+	seekTable := make([]byte, 0, seekTableFrameHeaderSize+seekTableSize)
+	seekTable = binary.LittleEndian.AppendUint32(seekTable, seekTableFrameMagic)
+	seekTable = binary.LittleEndian.AppendUint32(seekTable, uint32(seekTableSize))
+	for _, frame := range s.frames {
+		seekTable = binary.LittleEndian.AppendUint32(seekTable, uint32(frame.compressedSize))
+		seekTable = binary.LittleEndian.AppendUint32(seekTable, uint32(frame.decompressedSize))
+	}
+	seekTable = binary.LittleEndian.AppendUint32(seekTable, uint32(len(s.frames)))
+	seekTable = append(seekTable, 0) // We don't write checksums since the manifest already covers the content
+	seekTable = binary.LittleEndian.AppendUint32(seekTable, seekTableMagic)
+
+	if _, err := s.w.Write(seekTable); err != nil {
+		return err
+	}
+
+	return s.encoder.Close()
+}
+
+type seekableEntry struct {
+	offset int64
+	size   int64
+}
+
+// SeekablePackage provides random access to the devices in a seekable package without extracting it; only the frames that
+// contain the requested data are decompressed. It is safe for concurrent use.
+type SeekablePackage struct {
+	file    *os.File
+	decoder *zstd.Decoder
+
+	frames []seekableFrame
+	size   int64
+
+	entries map[string]seekableEntry
+
+	cacheLock  sync.Mutex
+	cache      map[int][]byte
+	cacheOrder []int
+}
+
+// OpenSeekablePackage opens the package at `packagePath` and indexes its entries; it returns an error that matches
+// `ErrNotSeekablePackage` if the package wasn't created with `ArchiveOptions.Seekable`
+func OpenSeekablePackage(packagePath string) (seekablePackage *SeekablePackage, errs error) {
+	file, err := os.Open(packagePath)
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotOpenPackageInputFile, err)
+	}
+	defer func() {
+		if errs != nil {
+			_ = file.Close()
+		}
+	}()
+
+	frames, err := readSeekTable(file)
+	if err != nil {
+		return nil, err
+	}
+
+	compressionOptions, _, err := readCompressionParametersFrame(bufio.NewReader(io.NewSectionReader(file, 0, skippableFrameHeaderSize+maxCompressionParametersSize)))
+	if err != nil {
+		return nil, err
+	}
+
+	decoder, err := zstd.NewReader(nil, compressionOptions.decoderOptions(0)...)
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotCreateUncompressor, err)
+	}
+
+	seekablePackage = &SeekablePackage{
+		file:    file,
+		decoder: decoder,
+
+		frames: frames,
+
+		entries: map[string]seekableEntry{},
+
+		cache:      map[int][]byte{},
+		cacheOrder: []int{},
+	}
+	if len(frames) > 0 {
+		lastFrame := frames[len(frames)-1]
+
+		seekablePackage.size = lastFrame.decompressedOffset + lastFrame.decompressedSize
+	}
+
+	// Reading the tar headers through a seeker only decompresses the frames that contain headers, since the tar reader seeks over the
+	// content of entries instead of reading it
+	archiveReader := io.NewSectionReader(seekablePackage, 0, seekablePackage.size)
+	archive := tar.NewReader(archiveReader)
+	for {
+		header, err := archive.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			decoder.Close()
+
+			return nil, errors.Join(ErrCouldNotReadNextHeader, err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		// The tar reader doesn't read ahead, so the content of the entry starts at the current offset
+		offset, err := archiveReader.Seek(0, io.SeekCurrent)
+		if err != nil {
+			decoder.Close()
+
+			return nil, errors.Join(ErrCouldNotReadNextHeader, err)
+		}
+
+		seekablePackage.entries[header.Name] = seekableEntry{
+			offset: offset,
+			size:   header.Size,
+		}
+	}
+
+	return seekablePackage, nil
+}
+
+func readSeekTable(file *os.File) ([]seekableFrame, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotReadSeekTable, err)
+	}
+
+	if info.Size() < seekTableFrameHeaderSize+seekTableFooterSize {
+		return nil, ErrNotSeekablePackage
+	}
+
+	footer := make([]byte, seekTableFooterSize)
+	if _, err := file.ReadAt(footer, info.Size()-seekTableFooterSize); err != nil {
+		return nil, errors.Join(ErrCouldNotReadSeekTable, err)
+	}
+
+	if binary.LittleEndian.Uint32(footer[5:]) != seekTableMagic {
+		return nil, ErrNotSeekablePackage
+	}
+
+	var (
+		frameCount = int64(binary.LittleEndian.Uint32(footer[0:]))
+		entrySize  = int64(seekTableEntrySize)
+	)
+	if footer[4]&seekTableChecksumFlag != 0 {
+		entrySize = seekTableChecksumEntrySize
+	}
+
+	seekTableSize := frameCount*entrySize + seekTableFooterSize
+	seekTableOffset := info.Size() - seekTableSize - seekTableFrameHeaderSize
+	if seekTableOffset < 0 {
+		return nil, errors.Join(ErrCouldNotReadSeekTable, fmt.Errorf("seek table with %v frames is larger than package", frameCount))
+	}
+
+	seekTable := make([]byte, seekTableFrameHeaderSize+seekTableSize)
+	if _, err := file.ReadAt(seekTable, seekTableOffset); err != nil {
+		return nil, errors.Join(ErrCouldNotReadSeekTable, err)
+	}
+
+	if binary.LittleEndian.Uint32(seekTable[0:]) != seekTableFrameMagic || int64(binary.LittleEndian.Uint32(seekTable[4:])) != seekTableSize {
+		return nil, errors.Join(ErrCouldNotReadSeekTable, errors.New("invalid seek table frame header"))
+	}
+
+	var (
+		frames             = make([]seekableFrame, 0, frameCount)
+		compressedOffset   int64
+		decompressedOffset int64
+	)
+	for i := int64(0); i < frameCount; i++ {
+		entry := seekTable[seekTableFrameHeaderSize+i*entrySize:]
+
+		frame := seekableFrame{
+			compressedOffset:   compressedOffset,
+			compressedSize:     int64(binary.LittleEndian.Uint32(entry[0:])),
+			decompressedOffset: decompressedOffset,
+			decompressedSize:   int64(binary.LittleEndian.Uint32(entry[4:])),
+		}
+
+		compressedOffset += frame.compressedSize
+		decompressedOffset += frame.decompressedSize
+
+		frames = append(frames, frame)
+	}
+
+	if compressedOffset != seekTableOffset {
+		return nil, errors.Join(ErrCouldNotReadSeekTable, fmt.Errorf("frames end at %v, but seek table starts at %v", compressedOffset, seekTableOffset))
+	}
+
+	return frames, nil
+}
+
+func (p *SeekablePackage) decodeFrame(index int) ([]byte, error) {
+	frame := p.frames[index]
+
+	compressed := make([]byte, frame.compressedSize)
+	if _, err := p.file.ReadAt(compressed, frame.compressedOffset); err != nil {
+		return nil, errors.Join(ErrCouldNotReadFrame, err)
+	}
+
+	decompressed, err := p.decoder.DecodeAll(compressed, make([]byte, 0, frame.decompressedSize))
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotReadFrame, err)
+	}
+
+	if int64(len(decompressed)) != frame.decompressedSize {
+		return nil, errors.Join(ErrCouldNotReadFrame, fmt.Errorf("frame %v has %v bytes, expected %v", index, len(decompressed), frame.decompressedSize))
+	}
+
+	return decompressed, nil
+}
+
+func (p *SeekablePackage) frame(index int) ([]byte, error) {
+	p.cacheLock.Lock()
+	if frame, ok := p.cache[index]; ok {
+		p.cacheLock.Unlock()
+
+		return frame, nil
+	}
+	p.cacheLock.Unlock()
+
+	// We decompress outside of the lock so that reads of different frames can run concurrently
+	frame, err := p.decodeFrame(index)
+	if err != nil {
+		return nil, err
+	}
+
+	p.cacheLock.Lock()
+	defer p.cacheLock.Unlock()
+
+	if _, ok := p.cache[index]; !ok {
+		if len(p.cacheOrder) >= seekableFrameCacheSize {
+			delete(p.cache, p.cacheOrder[0])
+			p.cacheOrder = p.cacheOrder[1:]
+		}
+
+		p.cache[index] = frame
+		p.cacheOrder = append(p.cacheOrder, index)
+	}
+
+	return frame, nil
+}
+
+// ReadAt reads from the uncompressed archive of the package
+func (p *SeekablePackage) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.Join(ErrCouldNotReadFrame, fmt.Errorf("negative offset %v", off))
+	}
+
+	n := 0
+	for n < len(b) {
+		if off >= p.size {
+			return n, io.EOF
+		}
+
+		// Index of the frame that contains `off`
+		index := sort.Search(len(p.frames), func(i int) bool {
+			return p.frames[i].decompressedOffset+p.frames[i].decompressedSize > off
+		})
+
+		frame, err := p.frame(index)
+		if err != nil {
+			return n, err
+		}
+
+		copied := copy(b[n:], frame[off-p.frames[index].decompressedOffset:])
+
+		n += copied
+		off += int64(copied)
+	}
+
+	return n, nil
+}
+
+// Entries returns the names of all entries in the package
+func (p *SeekablePackage) Entries() []string {
+	entries := []string{}
+	for name := range p.entries {
+		entries = append(entries, name)
+	}
+	slices.Sort(entries)
+
+	return entries
+}
+
+// Device returns a read-only view of the device `name`, which stays valid until the package is closed
+func (p *SeekablePackage) Device(name string) (*SeekableDevice, error) {
+	entry, ok := p.entries[name]
+	if !ok {
+		return nil, errors.Join(ErrMissingDevice, fmt.Errorf("device %v", name))
+	}
+
+	return &SeekableDevice{
+		pkg:   p,
+		entry: entry,
+	}, nil
+}
+
+// Manifest returns the manifest that is stored in the package and, if `publicKey` is set, verifies its signature; it returns
+// `ErrMissingManifest` if the package was created by an older version of Drafter and doesn't have one
+func (p *SeekablePackage) Manifest(publicKey ed25519.PublicKey) (*Manifest, error) {
+	rawManifest, ok, err := p.readEntry(ManifestName)
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotReadManifest, err)
+	}
+
+	if !ok {
+		return nil, ErrMissingManifest
+	}
+
+	signature, _, err := p.readEntry(ManifestSignatureName)
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotReadManifest, err)
+	}
+
+	return ParseManifest(rawManifest, signature, publicKey)
+}
+
+// readEntry reads the small entry `name` into memory; `ok` is false if the package doesn't have it
+func (p *SeekablePackage) readEntry(name string) (content []byte, ok bool, err error) {
+	entry, ok := p.entries[name]
+	if !ok {
+		return nil, false, nil
+	}
+
+	content, err = readLimited(io.NewSectionReader(p, entry.offset, entry.size), maxManifestSize)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return content, true, nil
+}
+
+func (p *SeekablePackage) Close() error {
+	p.decoder.Close()
+
+	return p.file.Close()
+}
+
+// SeekableDevice is a read-only device in a seekable package; it implements Silo's `storage.Provider`, so it can be used as the
+// source of a Silo device
+type SeekableDevice struct {
+	pkg   *SeekablePackage
+	entry seekableEntry
+
+	// Whether closing the device closes the package
+	ownsPackage bool
+}
+
+// OpenSeekableDevice opens the device `name` from the seekable package at `packagePath`; closing the device closes the package
+func OpenSeekableDevice(packagePath, name string) (*SeekableDevice, error) {
+	seekablePackage, err := OpenSeekablePackage(packagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	device, err := seekablePackage.Device(name)
+	if err != nil {
+		_ = seekablePackage.Close()
+
+		return nil, err
+	}
+	device.ownsPackage = true
+
+	return device, nil
+}
+
+// ReadAt reads from the device; like Silo's file storage, it returns a short read without an error at the end of the device
+func (d *SeekableDevice) ReadAt(b []byte, off int64) (int, error) {
+	if off >= d.entry.size {
+		return 0, io.EOF
+	}
+
+	n, err := d.pkg.ReadAt(b[:min(int64(len(b)), d.entry.size-off)], d.entry.offset+off)
+	if err == io.EOF {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (d *SeekableDevice) WriteAt(b []byte, off int64) (int, error) {
+	return 0, ErrReadOnlyDevice
+}
+
+func (d *SeekableDevice) Size() uint64 {
+	return uint64(d.entry.size)
+}
+
+func (d *SeekableDevice) Flush() error {
+	return nil
+}
+
+func (d *SeekableDevice) CancelWrites(offset int64, length int64) {}
+
+func (d *SeekableDevice) Close() error {
+	if d.ownsPackage {
+		return d.pkg.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package packager_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/loopholelabs/drafter/pkg/packager"
+)
+
+const (
+	// Frames are smaller than the devices and not aligned to their blocks, so that reads span multiple frames
+	seekableFrameSize = 4096*3 + 100
+)
+
+func TestSeekablePackageReads(t *testing.T) {
+	devices := createDevices(t, t.TempDir())
+	packagePath := archivePackage(t, devices, packager.ArchiveOptions{
+		Seekable:          true,
+		SeekableFrameSize: seekableFrameSize,
+	})
+
+	seekablePackage, err := packager.OpenSeekablePackage(packagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seekablePackage.Close()
+
+	entries := seekablePackage.Entries()
+	for _, device := range devices {
+		if !slices.Contains(entries, device.Name) {
+			t.Errorf("entries %v don't contain device %v", entries, device.Name)
+		}
+	}
+
+	if _, err := seekablePackage.Manifest(nil); err != nil {
+		t.Errorf("could not read manifest: %v", err)
+	}
+
+	for _, device := range devices {
+		expected, err := os.ReadFile(device.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		seekableDevice, err := seekablePackage.Device(device.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if seekableDevice.Size() != uint64(len(expected)) {
+			t.Errorf("device %v has size %v, want %v", device.Name, seekableDevice.Size(), len(expected))
+		}
+
+		size := int64(len(expected))
+		for _, read := range []struct {
+			offset int64
+			length int64
+		}{
+			{0, size},
+			{1, min(seekableFrameSize*2, size-1)},
+			{size / 2, min(seekableFrameSize+1, size/2)},
+			{size - 10, 10},
+		} {
+			actual := make([]byte, read.length)
+			if n, err := seekableDevice.ReadAt(actual, read.offset); err != nil || int64(n) != read.length {
+				t.Fatalf("reading %v bytes at offset %v of device %v returned %v, %v", read.length, read.offset, device.Name, n, err)
+			}
+
+			if !bytes.Equal(actual, expected[read.offset:read.offset+read.length]) {
+				t.Errorf("reading %v bytes at offset %v of device %v returned different content", read.length, read.offset, device.Name)
+			}
+		}
+
+		// Reads past the end are short, and reads at the end return `io.EOF`
+		if n, err := seekableDevice.ReadAt(make([]byte, 20), size-10); err != nil || n != 10 {
+			t.Errorf("reading past the end of device %v returned %v, %v, want 10, nil", device.Name, n, err)
+		}
+
+		if _, err := seekableDevice.ReadAt(make([]byte, 1), size); err != io.EOF {
+			t.Errorf("reading at the end of device %v returned %v, want %v", device.Name, err, io.EOF)
+		}
+
+		if _, err := seekableDevice.WriteAt([]byte{1}, 0); !errors.Is(err, packager.ErrReadOnlyDevice) {
+			t.Errorf("writing to device %v returned %v, want %v", device.Name, err, packager.ErrReadOnlyDevice)
+		}
+	}
+
+	if _, err := seekablePackage.Device(packager.MemoryName); !errors.Is(err, packager.ErrMissingDevice) {
+		t.Errorf("opening a device that isn't in the package returned %v, want %v", err, packager.ErrMissingDevice)
+	}
+}
+
+func TestSeekablePackageConcurrentReads(t *testing.T) {
+	devices := createDevices(t, t.TempDir())
+	packagePath := archivePackage(t, devices, packager.ArchiveOptions{
+		Seekable:          true,
+		SeekableFrameSize: seekableFrameSize,
+	})
+
+	diskIndex := slices.IndexFunc(devices, func(device packager.PackagerDevice) bool {
+		return device.Name == packager.DiskName
+	})
+	expected, err := os.ReadFile(devices[diskIndex].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	disk, err := packager.OpenSeekableDevice(packagePath, packager.DiskName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disk.Close()
+
+	// There are more readers than frames in the cache, so frames are evicted while they are being read
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+
+		go func(offset int64) {
+			defer wg.Done()
+
+			actual := make([]byte, seekableFrameSize)
+			if _, err := disk.ReadAt(actual, offset); err != nil {
+				t.Error(err)
+
+				return
+			}
+
+			if !bytes.Equal(actual, expected[offset:offset+seekableFrameSize]) {
+				t.Errorf("reading at offset %v returned different content", offset)
+			}
+		}(int64(i) * seekableFrameSize)
+	}
+	wg.Wait()
+}
+
+func TestSeekablePackageCanBeExtracted(t *testing.T) {
+	devices := createDevices(t, t.TempDir())
+	packagePath := archivePackage(t, devices, packager.ArchiveOptions{
+		Seekable:          true,
+		SeekableFrameSize: seekableFrameSize,
+	})
+
+	outputDevices := getOutputDevices(devices, t.TempDir())
+	if err := packager.ExtractPackage(context.Background(), packagePath, outputDevices, packager.ExtractOptions{}, packager.PackagerHooks{}); err != nil {
+		t.Fatal(err)
+	}
+
+	checkExtractedDevices(t, devices, outputDevices)
+}
+
+func TestOpenSeekablePackageRejectsRegularPackages(t *testing.T) {
+	packagePath := archivePackage(t, createDevices(t, t.TempDir()), packager.ArchiveOptions{})
+
+	if _, err := packager.OpenSeekablePackage(packagePath); !errors.Is(err, packager.ErrNotSeekablePackage) {
+		t.Errorf("opening a regular package returned %v, want %v", err, packager.ErrNotSeekablePackage)
+	}
+}
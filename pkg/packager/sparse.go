@@ -0,0 +1,283 @@
+package packager
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// Granularity at which blocks that only contain zeros are detected when archiving and skipped when extracting; this matches
+	// the block size of most file systems, which can't have holes that are smaller than a block
+	sparseBlockSize = 4096
+
+	// Amount of data that is read at once when looking for blocks that only contain zeros
+	sparseReadSize = sparseBlockSize * 256
+
+	tarBlockSize = 512
+
+	// Largest size that fits into the 11 octal digits of the size field of a tar header
+	maxTarOctalSize = 1<<33 - 1
+
+	// Name of the header that contains the data fragments of a sparse entry, whose real name is stored in its PAX records; tar
+	// implementations that don't support sparse entries extract them to this name
+	sparseEntryName = "GNUSparseFile.0/sparse"
+)
+
+var zeroBlock = make([]byte, sparseBlockSize)
+
+// sparseFragment is a range of a device that contains data
+type sparseFragment struct {
+	offset int64
+	length int64
+}
+
+// getDataFragments reads `device` and returns the ranges that contain data as well as its size; blocks that only contain zeros aren't
+// part of any range
+func getDataFragments(ctx context.Context, device io.Reader) ([]sparseFragment, int64, error) {
+	var (
+		fragments = []sparseFragment{}
+		size      int64
+		buf       = make([]byte, sparseReadSize)
+	)
+	for {
+	s:
+		select {
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+
+		default:
+			break s
+		}
+
+		n, err := io.ReadFull(device, buf)
+		for offset := 0; offset < n; offset += sparseBlockSize {
+			block := buf[offset:min(offset+sparseBlockSize, n)]
+			if bytes.Equal(block, zeroBlock[:len(block)]) {
+				continue
+			}
+
+			blockOffset := size + int64(offset)
+			if last := len(fragments) - 1; last >= 0 && fragments[last].offset+fragments[last].length == blockOffset {
+				fragments[last].length += int64(len(block))
+			} else {
+				fragments = append(fragments, sparseFragment{
+					offset: blockOffset,
+					length: int64(len(block)),
+				})
+			}
+		}
+		size += int64(n)
+
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return fragments, size, nil
+			}
+
+			return nil, 0, err
+		}
+	}
+}
+
+// writeSparseEntry writes the data fragments of `device` to `output` as an entry of `archive` in the PAX 1.0 sparse format that GNU
+// tar uses, which `archive/tar` can read, but not write. `header` describes the full device.
+func writeSparseEntry(archive *tar.Writer, output io.Writer, header *tar.Header, device io.ReaderAt, fragments []sparseFragment) error {
+	// GNU tar only restores holes at the end of a file if the sparse map ends with an empty fragment at the end of the file
+	if last := len(fragments) - 1; last < 0 || fragments[last].offset+fragments[last].length < header.Size {
+		fragments = append(fragments, sparseFragment{
+			offset: header.Size,
+		})
+	}
+
+	// The sparse map is stored at the start of the content of the entry, padded to a full block
+	sparseMap := strconv.AppendInt(nil, int64(len(fragments)), 10)
+	sparseMap = append(sparseMap, '\n')
+
+	var dataSize int64
+	for _, fragment := range fragments {
+		sparseMap = strconv.AppendInt(sparseMap, fragment.offset, 10)
+		sparseMap = append(sparseMap, '\n')
+		sparseMap = strconv.AppendInt(sparseMap, fragment.length, 10)
+		sparseMap = append(sparseMap, '\n')
+
+		dataSize += fragment.length
+	}
+	sparseMap = append(sparseMap, make([]byte, getTarPadding(int64(len(sparseMap))))...)
+
+	contentSize := int64(len(sparseMap)) + dataSize
+
+	records := appendPAXRecord(nil, "GNU.sparse.major", "1")
+	records = appendPAXRecord(records, "GNU.sparse.minor", "0")
+	records = appendPAXRecord(records, "GNU.sparse.name", header.Name)
+	records = appendPAXRecord(records, "GNU.sparse.realsize", strconv.FormatInt(header.Size, 10))
+
+	headerSize := contentSize
+	if contentSize > maxTarOctalSize {
+		records = appendPAXRecord(records, "size", strconv.FormatInt(contentSize, 10))
+
+		headerSize = 0
+	}
+
+	// The previous entry needs to be padded before we can write to the underlying writer directly
+	if err := archive.Flush(); err != nil {
+		return errors.Join(ErrCouldNotWriteTarHeader, err)
+	}
+
+	if _, err := output.Write(newTarHeaderBlock("PaxHeaders.0/sparse", tar.TypeXHeader, 0644, int64(len(records)), header.ModTime)); err != nil {
+		return errors.Join(ErrCouldNotWriteTarHeader, err)
+	}
+
+	if _, err := output.Write(append(records, make([]byte, getTarPadding(int64(len(records))))...)); err != nil {
+		return errors.Join(ErrCouldNotWriteTarHeader, err)
+	}
+
+	if _, err := output.Write(newTarHeaderBlock(sparseEntryName, tar.TypeReg, header.Mode, headerSize, header.ModTime)); err != nil {
+		return errors.Join(ErrCouldNotWriteTarHeader, err)
+	}
+
+	if _, err := output.Write(sparseMap); err != nil {
+		return errors.Join(ErrCouldNotCopyToArchive, err)
+	}
+
+	for _, fragment := range fragments {
+		if n, err := io.Copy(output, io.NewSectionReader(device, fragment.offset, fragment.length)); err != nil {
+			return errors.Join(ErrCouldNotCopyToArchive, err)
+		} else if n != fragment.length {
+			return errors.Join(ErrCouldNotCopyToArchive, fmt.Errorf("device changed while archiving it, expected %v bytes at offset %v, got %v", fragment.length, fragment.offset, n))
+		}
+	}
+
+	if _, err := output.Write(make([]byte, getTarPadding(contentSize))); err != nil {
+		return errors.Join(ErrCouldNotCopyToArchive, err)
+	}
+
+	return nil
+}
+
+func getTarPadding(size int64) int64 {
+	return -size & (tarBlockSize - 1)
+}
+
+// appendPAXRecord appends a record in the `"%d %s=%s\n"` format of PAX extended headers, whose length includes its own digits
+func appendPAXRecord(records []byte, key, value string) []byte {
+	record := " " + key + "=" + value + "\n"
+
+	size := len(record)
+	for {
+		next := len(strconv.Itoa(size)) + len(record)
+		if next == size {
+			break
+		}
+
+		size = next
+	}
+
+	return append(strconv.AppendInt(records, int64(size), 10), record...)
+}
+
+// newTarHeaderBlock returns a USTAR header; `name` must fit into the header and `size` into 11 octal digits
+func newTarHeaderBlock(name string, typeflag byte, mode, size int64, modTime time.Time) []byte {
+	block := make([]byte, tarBlockSize)
+
+	copy(block[0:100], name)
+	formatTarOctal(block[100:108], mode&07777)
+	formatTarOctal(block[108:116], 0) // uid
+	formatTarOctal(block[116:124], 0) // gid
+	formatTarOctal(block[124:136], size)
+	formatTarOctal(block[136:148], max(modTime.Unix(), 0))
+	block[156] = typeflag
+	copy(block[257:263], "ustar\x00")
+	copy(block[263:265], "00")
+
+	// The checksum is computed with the checksum field set to spaces
+	copy(block[148:156], "        ")
+
+	var checksum int64
+	for _, b := range block {
+		checksum += int64(b)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", checksum))
+
+	return block
+}
+
+func formatTarOctal(field []byte, value int64) {
+	copy(field, fmt.Sprintf("%0*o\x00", len(field)-1, value))
+}
+
+// sparseFileWriter writes to a file sequentially, but skips blocks that only contain zeros so that they become holes in the file; the
+// file must be empty. Files that aren't regular files, e.g. block devices, are written to as-is.
+type sparseFileWriter struct {
+	file   *os.File
+	sparse bool
+
+	offset int64
+}
+
+func newSparseFileWriter(file *os.File) (*sparseFileWriter, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return &sparseFileWriter{
+		file:   file,
+		sparse: info.Mode().IsRegular(),
+	}, nil
+}
+
+func (w *sparseFileWriter) Write(p []byte) (int, error) {
+	if !w.sparse {
+		n, err := w.file.Write(p)
+		w.offset += int64(n)
+
+		return n, err
+	}
+
+	written := 0
+	for written < len(p) {
+		// The first block can be partial so that the following ones are aligned to the file's blocks
+		end := written + int(min(int64(len(p)-written), sparseBlockSize-w.offset%sparseBlockSize))
+		if bytes.Equal(p[written:end], zeroBlock[:end-written]) {
+			w.offset += int64(end - written)
+			written = end
+
+			continue
+		}
+
+		// We write consecutive blocks that contain data at once
+		for end < len(p) {
+			next := min(end+sparseBlockSize, len(p))
+			if bytes.Equal(p[end:next], zeroBlock[:next-end]) {
+				break
+			}
+
+			end = next
+		}
+
+		n, err := w.file.WriteAt(p[written:end], w.offset)
+		w.offset += int64(n)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// Finish sets the size of the file to the number of bytes that have been written, since skipped blocks at the end of the file
+// wouldn't be part of it otherwise
+func (w *sparseFileWriter) Finish() error {
+	if !w.sparse {
+		return nil
+	}
+
+	return w.file.Truncate(w.offset)
+}
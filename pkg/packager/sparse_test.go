@@ -0,0 +1,257 @@
+package packager_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/loopholelabs/drafter/pkg/packager"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Regions are larger than filesystem blocks, so that regions of zeros can be stored as holes
+	sparseRegionSize = 64 * 1024
+)
+
+type sparseDevice struct {
+	// One region of random data for every `true`, and one of zeros for every `false`
+	regions []bool
+	// Number of bytes of random data after the regions, so that the device doesn't end at a block boundary
+	trailingBytes int
+}
+
+func (d sparseDevice) create(t *testing.T, path string) []byte {
+	t.Helper()
+
+	content := make([]byte, len(d.regions)*sparseRegionSize+d.trailingBytes)
+	for i, data := range d.regions {
+		if data {
+			if _, err := rand.Read(content[i*sparseRegionSize : (i+1)*sparseRegionSize]); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if _, err := rand.Read(content[len(d.regions)*sparseRegionSize:]); err != nil {
+		t.Fatal(err)
+	}
+
+	// We write the zeros instead of leaving holes so that the archiver has to detect them
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return content
+}
+
+// checkHoles checks that the regions of zeros of `d` are holes in the file at `path`
+func (d sparseDevice) checkHoles(t *testing.T, path string) {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for i, data := range d.regions {
+		if data {
+			continue
+		}
+
+		offset := int64(i * sparseRegionSize)
+
+		dataStart, err := unix.Seek(int(f.Fd()), offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if dataStart < offset+sparseRegionSize {
+			t.Errorf("region %v of %v only contains zeros, but isn't a hole", i, path)
+		}
+	}
+}
+
+func checkContent(t *testing.T, actual []byte, expected []byte, name string) {
+	t.Helper()
+
+	if len(actual) != len(expected) {
+		t.Errorf("%v has %v bytes, want %v", name, len(actual), len(expected))
+	}
+
+	if sha256.Sum256(actual) != sha256.Sum256(expected) {
+		t.Errorf("%v differs from the archived device", name)
+	}
+}
+
+func TestSparseEntriesRoundTrip(t *testing.T) {
+	for name, device := range map[string]sparseDevice{
+		"no data": {
+			regions: []bool{false, false, false},
+		},
+		"only data": {
+			regions: []bool{true, true},
+		},
+		"trailing hole": {
+			regions: []bool{true, false, true, false, false},
+		},
+		"leading hole and partial block": {
+			regions:       []bool{false, true, false, true},
+			trailingBytes: 1000,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			devicePath := filepath.Join(dir, "device")
+			expected := device.create(t, devicePath)
+
+			// We disable compression so that the archive can be read by other tar implementations
+			packagePath := filepath.Join(dir, "package.tar")
+			if err := packager.ArchivePackage(
+				context.Background(),
+
+				[]packager.PackagerDevice{
+					{
+						Name: packager.DiskName,
+						Path: devicePath,
+					},
+				},
+				packagePath,
+
+				packager.ArchiveOptions{
+					Compression: packager.CompressionOptions{
+						Disabled: true,
+					},
+				},
+				packager.PackagerHooks{},
+			); err != nil {
+				t.Fatal(err)
+			}
+
+			packageInfo, err := os.Stat(packagePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Entries only contain the regions with data if the device has regions of zeros
+			var dataSize int
+			for _, data := range device.regions {
+				if data {
+					dataSize += sparseRegionSize
+				}
+			}
+
+			if dataSize < len(device.regions)*sparseRegionSize && packageInfo.Size() >= int64(len(expected)) {
+				t.Errorf("package has %v bytes, but the device only has %v bytes of data", packageInfo.Size(), dataSize+device.trailingBytes)
+			}
+
+			t.Run("archive/tar", func(t *testing.T) {
+				packageFile, err := os.Open(packagePath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer packageFile.Close()
+
+				found := false
+				archive := tar.NewReader(packageFile)
+				for {
+					header, err := archive.Next()
+					if err == io.EOF {
+						break
+					}
+
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if header.Name != packager.DiskName {
+						continue
+					}
+					found = true
+
+					if header.Size != int64(len(expected)) {
+						t.Errorf("entry has size %v, want %v", header.Size, len(expected))
+					}
+
+					actual, err := io.ReadAll(archive)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					checkContent(t, actual, expected, "entry")
+				}
+
+				if !found {
+					t.Errorf("package doesn't contain an entry for %v", packager.DiskName)
+				}
+			})
+
+			t.Run("GNU tar", func(t *testing.T) {
+				tarBin, err := exec.LookPath("tar")
+				if err != nil {
+					t.Skip("tar isn't installed:", err)
+				}
+
+				if output, err := exec.Command(tarBin, "--version").Output(); err != nil || !bytes.Contains(output, []byte("GNU tar")) {
+					t.Skip("tar isn't GNU tar")
+				}
+
+				outputDir := t.TempDir()
+				if output, err := exec.Command(tarBin, "-xf", packagePath, "-C", outputDir).CombinedOutput(); err != nil {
+					t.Fatalf("could not extract package with GNU tar: %v: %s", err, output)
+				}
+
+				outputPath := filepath.Join(outputDir, packager.DiskName)
+				actual, err := os.ReadFile(outputPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				checkContent(t, actual, expected, "extracted device")
+				device.checkHoles(t, outputPath)
+			})
+
+			t.Run("ExtractPackage", func(t *testing.T) {
+				outputPath := filepath.Join(t.TempDir(), packager.DiskName)
+				if err := packager.ExtractPackage(
+					context.Background(),
+
+					packagePath,
+					[]packager.PackagerDevice{
+						{
+							Name: packager.DiskName,
+							Path: outputPath,
+						},
+					},
+
+					packager.ExtractOptions{},
+					packager.PackagerHooks{},
+				); err != nil {
+					t.Fatal(err)
+				}
+
+				actual, err := os.ReadFile(outputPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				checkContent(t, actual, expected, "extracted device")
+				device.checkHoles(t, outputPath)
+			})
+		})
+	}
+}
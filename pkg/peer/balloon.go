@@ -0,0 +1,127 @@
+package peer
+
+import (
+	"context"
+	"time"
+
+	"github.com/loopholelabs/drafter/pkg/hypervisor"
+)
+
+const (
+	balloonPollInterval = time.Millisecond * 100
+
+	// Maximum amount of time to wait for the guest to inflate the balloon if none is set
+	DefaultBalloonInflateTimeout = time.Second * 10
+)
+
+// MigrateToBalloonConfiguration configures inflating the balloon before migrating and skipping free pages in the initial pass.
+// Firecracker doesn't report which pages the guest gave to the balloon, so the initial pass skips the blocks of the memory device
+// that only contain zeros instead, which includes these pages if the guest zeroes them (e.g. with `init_on_alloc=1` for inflated
+// pages or `init_on_free=1` for reported free pages). Inflating the balloon also reduces the number of blocks that the guest
+// dirties (e.g. through its page cache) and that need to be sent again in the continuous and final passes.
+type MigrateToBalloonConfiguration struct {
+	// Whether to inflate the balloon before the initial migration pass so that the guest releases its free memory and page cache
+	InflateBeforeMigration bool
+	// Size (in MiB) to inflate the balloon to; if 0, the balloon is inflated by the guest's free memory as reported by the balloon statistics
+	AmountMiB int
+	// Maximum amount of time to wait for the guest to inflate the balloon; the migration continues with a partially inflated balloon after this.
+	// If 0, `DefaultBalloonInflateTimeout` is used.
+	InflateTimeout time.Duration
+
+	// Whether to skip the blocks of the memory device that only contain zeros in the initial migration pass without inflating the
+	// balloon, e.g. if the guest reports its free pages; this is always done if `InflateBeforeMigration` is set
+	SkipZeroBlocks bool
+}
+
+func (balloonConfiguration MigrateToBalloonConfiguration) skipZeroBlocks() bool {
+	return balloonConfiguration.InflateBeforeMigration || balloonConfiguration.SkipZeroBlocks
+}
+
+func (resumedPeer *ResumedPeer[L, R, G]) UpdateBalloon(ctx context.Context, amountMiB int) error {
+	return resumedPeer.resumedRunner.UpdateBalloon(ctx, amountMiB)
+}
+
+func (resumedPeer *ResumedPeer[L, R, G]) GetBalloonStatistics(ctx context.Context) (*hypervisor.BalloonStatistics, error) {
+	return resumedPeer.resumedRunner.GetBalloonStatistics(ctx)
+}
+
+// inflateBalloon inflates the balloon and returns the size that it had before, or -1 if the balloon wasn't changed
+func (migratablePeer *MigratablePeer[L, R, G]) inflateBalloon(
+	ctx context.Context,
+
+	balloonConfiguration MigrateToBalloonConfiguration,
+
+	hooks MigrateToHooks,
+) (int, error) {
+	if balloonConfiguration.InflateTimeout < 0 {
+		return -1, ErrInvalidBalloonInflateTimeout
+	}
+
+	inflateTimeout := balloonConfiguration.InflateTimeout
+	if inflateTimeout == 0 {
+		inflateTimeout = DefaultBalloonInflateTimeout
+	}
+
+	balloonStatistics, err := migratablePeer.resumedRunner.GetBalloonStatistics(ctx)
+	if err != nil {
+		return -1, err
+	}
+
+	amountMiB := balloonConfiguration.AmountMiB
+	if amountMiB <= 0 {
+		if balloonStatistics.FreeMemory <= 0 {
+			return -1, ErrGuestFreeMemoryUnknown
+		}
+
+		amountMiB = balloonStatistics.ActualMiB + int(balloonStatistics.FreeMemory/(1024*1024))
+	}
+
+	if hook := hooks.OnBeforeInflateBalloon; hook != nil {
+		hook(amountMiB)
+	}
+
+	// We return the original size even if updating the balloon fails, since we don't know whether the VMM applied the update
+	originalMiB := balloonStatistics.TargetMiB
+	if err := migratablePeer.resumedRunner.UpdateBalloon(ctx, amountMiB); err != nil {
+		return originalMiB, err
+	}
+
+	// We use the background context here instead of `ctx` because we want to distinguish
+	// between a context cancellation from the outside and the inflation timing out
+	inflateCtx, cancelInflateCtx := context.WithTimeout(context.Background(), inflateTimeout)
+	defer cancelInflateCtx()
+
+	ticker := time.NewTicker(balloonPollInterval)
+	defer ticker.Stop()
+
+	for {
+		balloonStatistics, err := migratablePeer.resumedRunner.GetBalloonStatistics(ctx)
+		if err != nil {
+			return originalMiB, err
+		}
+
+		if balloonStatistics.ActualMiB >= balloonStatistics.TargetMiB {
+			if hook := hooks.OnAfterInflateBalloon; hook != nil {
+				hook(balloonStatistics.ActualMiB)
+			}
+
+			return originalMiB, nil
+		}
+
+		select {
+		case <-ticker.C:
+			break
+
+		// The guest might not be able to give up all of the requested memory, so we continue with what it has released so far
+		case <-inflateCtx.Done():
+			if hook := hooks.OnAfterInflateBalloon; hook != nil {
+				hook(balloonStatistics.ActualMiB)
+			}
+
+			return originalMiB, nil
+
+		case <-ctx.Done():
+			return originalMiB, ctx.Err()
+		}
+	}
+}
@@ -15,4 +15,17 @@ var (
 	ErrCouldNotCreateMigratablePeer       = errors.New("could not create migratable peer")
 	ErrCouldNotSuspendAndCloseAgentServer = errors.New("could not suspend and close agent server")
 	ErrCouldNotMsyncRunner                = errors.New("could not msync runner")
+	ErrCouldNotInflateBalloon             = errors.New("could not inflate balloon")
+	ErrCouldNotDeflateBalloon             = errors.New("could not deflate balloon")
+	ErrCouldNotRestoreBalloon             = errors.New("could not restore balloon size")
+	ErrInvalidBalloonInflateTimeout       = errors.New("balloon inflate timeout must not be negative")
+	ErrCouldNotSendConsoleHistoryEvent    = errors.New("could not send console history event")
+	ErrCouldNotSkipZeroBlocks             = errors.New("could not skip zero blocks")
+	ErrCouldNotWriteZeroBlocks            = errors.New("could not write zero blocks")
+	ErrCouldNotSwapDrive                  = errors.New("could not swap drive")
+	ErrPackageDeviceRequiresOverlay       = errors.New("devices from packages require an overlay and state")
+	ErrCouldNotOpenPackageDevice          = errors.New("could not open package device")
+	ErrCouldNotReadOverlayState           = errors.New("could not read overlay state")
+	ErrCouldNotWriteOverlayState          = errors.New("could not write overlay state")
+	ErrGuestFreeMemoryUnknown             = errors.New("guest free memory is unknown; enable balloon statistics or set the balloon amount explicitly")
 )
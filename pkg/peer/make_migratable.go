@@ -5,6 +5,7 @@ import (
 	"errors"
 
 	"github.com/loopholelabs/drafter/internal/utils"
+	"github.com/loopholelabs/drafter/pkg/console"
 	"github.com/loopholelabs/drafter/pkg/ipc"
 	"github.com/loopholelabs/drafter/pkg/mounter"
 	"github.com/loopholelabs/drafter/pkg/runner"
@@ -22,8 +23,11 @@ type ResumedPeer[L ipc.AgentServerLocal, R ipc.AgentServerRemote[G], G any] stru
 	Close func() error
 
 	resumedRunner *runner.ResumedRunner[L, R, G]
+	console       *console.Console
 
 	stage2Inputs []migrateFromStage
+
+	addDeviceCloseFunc func(closeFunc func() error)
 }
 
 func (resumedPeer *ResumedPeer[L, R, G]) MakeMigratable(
@@ -0,0 +1,9 @@
+package peer
+
+import (
+	"context"
+)
+
+func (peer *Peer[L, R, G]) FlushMetrics(ctx context.Context) error {
+	return peer.runner.FlushMetrics(ctx)
+}
@@ -2,6 +2,7 @@ package peer
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -16,6 +17,7 @@ import (
 	"github.com/loopholelabs/drafter/internal/utils"
 	"github.com/loopholelabs/drafter/pkg/ipc"
 	"github.com/loopholelabs/drafter/pkg/mounter"
+	"github.com/loopholelabs/drafter/pkg/packager"
 	"github.com/loopholelabs/drafter/pkg/registry"
 	"github.com/loopholelabs/drafter/pkg/snapshotter"
 	"github.com/loopholelabs/drafter/pkg/terminator"
@@ -23,8 +25,11 @@ import (
 	"github.com/loopholelabs/silo/pkg/storage"
 	"github.com/loopholelabs/silo/pkg/storage/config"
 	"github.com/loopholelabs/silo/pkg/storage/device"
+	"github.com/loopholelabs/silo/pkg/storage/expose"
+	"github.com/loopholelabs/silo/pkg/storage/modules"
 	"github.com/loopholelabs/silo/pkg/storage/protocol"
 	"github.com/loopholelabs/silo/pkg/storage/protocol/packets"
+	"github.com/loopholelabs/silo/pkg/storage/sources"
 	"github.com/loopholelabs/silo/pkg/storage/waitingcache"
 	"golang.org/x/sys/unix"
 )
@@ -36,11 +41,172 @@ type MigrateFromDevice[L ipc.AgentServerLocal, R ipc.AgentServerRemote[G], G any
 	Overlay string `json:"overlay"`
 	State   string `json:"state"`
 
+	// Path to a seekable package to lazily read the local device from instead of `Base`; requires `Overlay` and `State`,
+	// and isn't supported for shared devices
+	Package string `json:"package"`
+
 	BlockSize uint32 `json:"blockSize"`
 
 	Shared bool `json:"shared"`
 }
 
+// newLocalDevice creates a Silo device for `input.Base`; if `input.Overlay` and `input.State` are set,
+// writes go to the overlay instead of the base
+func newLocalDevice[L ipc.AgentServerLocal, R ipc.AgentServerRemote[G], G any](input MigrateFromDevice[L, R, G]) (storage.Provider, storage.ExposedStorage, error) {
+	if strings.TrimSpace(input.Package) != "" {
+		return newPackageDevice(input)
+	}
+
+	stat, err := os.Stat(input.Base)
+	if err != nil {
+		return nil, nil, errors.Join(mounter.ErrCouldNotGetBaseDeviceStat, err)
+	}
+
+	var (
+		local storage.Provider
+		dev   storage.ExposedStorage
+	)
+	if strings.TrimSpace(input.Overlay) == "" || strings.TrimSpace(input.State) == "" {
+		local, dev, err = device.NewDevice(&config.DeviceSchema{
+			Name:      input.Name,
+			System:    "file",
+			Location:  input.Base,
+			Size:      fmt.Sprintf("%v", stat.Size()),
+			BlockSize: fmt.Sprintf("%v", input.BlockSize),
+			Expose:    true,
+		})
+	} else {
+		if err := os.MkdirAll(filepath.Dir(input.Overlay), os.ModePerm); err != nil {
+			return nil, nil, errors.Join(mounter.ErrCouldNotCreateOverlayDirectory, err)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(input.State), os.ModePerm); err != nil {
+			return nil, nil, errors.Join(mounter.ErrCouldNotCreateStateDirectory, err)
+		}
+
+		local, dev, err = device.NewDevice(&config.DeviceSchema{
+			Name:      input.Name,
+			System:    "sparsefile",
+			Location:  input.Overlay,
+			Size:      fmt.Sprintf("%v", stat.Size()),
+			BlockSize: fmt.Sprintf("%v", input.BlockSize),
+			Expose:    true,
+			ROSource: &config.DeviceSchema{
+				Name:     input.State,
+				System:   "file",
+				Location: input.Base,
+				Size:     fmt.Sprintf("%v", stat.Size()),
+			},
+		})
+	}
+	if err != nil {
+		return nil, nil, errors.Join(mounter.ErrCouldNotCreateLocalDevice, err)
+	}
+
+	return local, dev, nil
+}
+
+// newPackageDevice creates a Silo device that reads from the device `input.Name` in the seekable package `input.Package`
+// and writes to `input.Overlay`, so that only the blocks that the VM reads are decompressed
+func newPackageDevice[L ipc.AgentServerLocal, R ipc.AgentServerRemote[G], G any](input MigrateFromDevice[L, R, G]) (storage.Provider, storage.ExposedStorage, error) {
+	if strings.TrimSpace(input.Overlay) == "" || strings.TrimSpace(input.State) == "" {
+		return nil, nil, ErrPackageDeviceRequiresOverlay
+	}
+
+	if err := os.MkdirAll(filepath.Dir(input.Overlay), os.ModePerm); err != nil {
+		return nil, nil, errors.Join(mounter.ErrCouldNotCreateOverlayDirectory, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(input.State), os.ModePerm); err != nil {
+		return nil, nil, errors.Join(mounter.ErrCouldNotCreateStateDirectory, err)
+	}
+
+	source, err := packager.OpenSeekableDevice(input.Package, input.Name)
+	if err != nil {
+		return nil, nil, errors.Join(ErrCouldNotOpenPackageDevice, err)
+	}
+
+	blockSize := int(input.BlockSize)
+	if blockSize == 0 {
+		blockSize = device.DefaultBlockSize
+	}
+
+	var overlay storage.Provider
+	if _, err := os.Stat(input.Overlay); errors.Is(err, os.ErrNotExist) {
+		overlay, err = sources.NewFileStorageSparseCreate(input.Overlay, source.Size(), blockSize)
+		if err != nil {
+			_ = source.Close()
+
+			return nil, nil, errors.Join(mounter.ErrCouldNotCreateLocalDevice, err)
+		}
+	} else {
+		overlay, err = sources.NewFileStorageSparse(input.Overlay, source.Size(), blockSize)
+		if err != nil {
+			_ = source.Close()
+
+			return nil, nil, errors.Join(mounter.ErrCouldNotCreateLocalDevice, err)
+		}
+	}
+
+	local := modules.NewCopyOnWrite(source, overlay, blockSize)
+
+	// We store the blocks that have been written to the overlay in the same format as Silo's `sparsefile` devices,
+	// so the overlay and state can also be used with `Base` instead of `Package`
+	rawState, err := os.ReadFile(input.State)
+	if err == nil {
+		blocks := []uint{}
+		for i := 0; i+4 <= len(rawState); i += 4 {
+			blocks = append(blocks, uint(binary.LittleEndian.Uint32(rawState[i:])))
+		}
+
+		local.SetBlockExists(blocks)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		_ = local.Close()
+
+		return nil, nil, errors.Join(ErrCouldNotReadOverlayState, err)
+	}
+
+	stateful := &packageDevice{
+		CopyOnWrite: local,
+
+		state: input.State,
+	}
+
+	dev := expose.NewExposedStorageNBDNL(stateful, 8, 0, local.Size(), expose.NBDDefaultBlockSize, true)
+	if err := dev.Init(); err != nil {
+		_ = stateful.Close()
+
+		return nil, nil, errors.Join(mounter.ErrCouldNotCreateLocalDevice, err)
+	}
+
+	return stateful, dev, nil
+}
+
+// packageDevice writes the blocks that have been written to the overlay to the state file when it is closed; we don't use
+// `CloseFn` for this since it can't return an error
+type packageDevice struct {
+	*modules.CopyOnWrite
+
+	state string
+}
+
+func (d *packageDevice) Close() error {
+	if err := d.CopyOnWrite.Close(); err != nil {
+		return err
+	}
+
+	rawState := []byte{}
+	for _, block := range d.CopyOnWrite.GetBlockExists() {
+		rawState = binary.LittleEndian.AppendUint32(rawState, uint32(block))
+	}
+
+	if err := os.WriteFile(d.state, rawState, 0666); err != nil {
+		return errors.Join(ErrCouldNotWriteOverlayState, err)
+	}
+
+	return nil
+}
+
 func (peer *Peer[L, R, G]) MigrateFrom(
 	ctx context.Context,
 
@@ -67,6 +233,8 @@ func (peer *Peer[L, R, G]) MigrateFrom(
 		runner:  peer.runner,
 
 		stage2Inputs: []migrateFromStage{},
+
+		addDeviceCloseFunc: func(closeFunc func() error) {},
 	}
 
 	var (
@@ -118,8 +286,10 @@ func (peer *Peer[L, R, G]) MigrateFrom(
 			writers,
 			func(ctx context.Context, p protocol.Protocol, index uint32) {
 				var (
-					from  *protocol.FromProtocol
-					local *waitingcache.Local
+					from      *protocol.FromProtocol
+					local     *waitingcache.Local
+					remote    *waitingcache.Remote
+					blockSize uint32
 				)
 				from = protocol.NewFromProtocol(
 					ctx,
@@ -167,7 +337,7 @@ func (peer *Peer[L, R, G]) MigrateFrom(
 						deviceCloseFuncs = append(deviceCloseFuncs, peer.runner.Close) // defer runner.Close()
 						deviceCloseFuncsLock.Unlock()
 
-						var remote *waitingcache.Remote
+						blockSize = di.BlockSize
 						local, remote = waitingcache.NewWaitingCache(src, int(di.BlockSize))
 						local.NeedAt = func(offset int64, length int32) {
 							// Only access the `from` protocol if it's not already closed
@@ -282,6 +452,13 @@ func (peer *Peer[L, R, G]) MigrateFrom(
 									hook()
 								}
 
+							case byte(registry.EventCustomZeroBlocks):
+								if remote != nil {
+									if err := registry.WriteZeroBlocks(remote, int(blockSize), e.CustomPayload); err != nil {
+										panic(errors.Join(ErrCouldNotWriteZeroBlocks, err))
+									}
+								}
+
 							case byte(registry.EventCustomTransferAuthority):
 								if receivedButNotReadyRemoteDevices.Add(-1) <= 0 {
 									signalAllRemoteDevicesReady()
@@ -290,6 +467,12 @@ func (peer *Peer[L, R, G]) MigrateFrom(
 								if hook := hooks.OnRemoteDeviceAuthorityReceived; hook != nil {
 									hook(index)
 								}
+
+							case byte(registry.EventCustomConsoleHistory):
+								// The VM hasn't been resumed yet, so the history ends up before any new output
+								if peer.Console != nil {
+									_, _ = peer.Console.Write(e.CustomPayload) // Writing to the console can't fail
+								}
 							}
 
 						case packets.EventCompleted:
@@ -346,6 +529,12 @@ func (peer *Peer[L, R, G]) MigrateFrom(
 
 		return nil
 	})
+	migratedPeer.addDeviceCloseFunc = func(closeFunc func() error) {
+		deviceCloseFuncsLock.Lock()
+		defer deviceCloseFuncsLock.Unlock()
+
+		deviceCloseFuncs = append(deviceCloseFuncs, closeFunc) // defer closeFunc()
+	}
 	migratedPeer.Close = func() (errs error) {
 		// We have to close the runner before we close the devices
 		if err := peer.runner.Close(); err != nil {
@@ -446,50 +635,9 @@ func (peer *Peer[L, R, G]) MigrateFrom(
 			if input.Shared {
 				devicePath = input.Base
 			} else {
-				stat, err := os.Stat(input.Base)
-				if err != nil {
-					return errors.Join(mounter.ErrCouldNotGetBaseDeviceStat, err)
-				}
-
-				var (
-					local storage.Provider
-					dev   storage.ExposedStorage
-				)
-				if strings.TrimSpace(input.Overlay) == "" || strings.TrimSpace(input.State) == "" {
-					local, dev, err = device.NewDevice(&config.DeviceSchema{
-						Name:      input.Name,
-						System:    "file",
-						Location:  input.Base,
-						Size:      fmt.Sprintf("%v", stat.Size()),
-						BlockSize: fmt.Sprintf("%v", input.BlockSize),
-						Expose:    true,
-					})
-				} else {
-					if err := os.MkdirAll(filepath.Dir(input.Overlay), os.ModePerm); err != nil {
-						return errors.Join(mounter.ErrCouldNotCreateOverlayDirectory, err)
-					}
-
-					if err := os.MkdirAll(filepath.Dir(input.State), os.ModePerm); err != nil {
-						return errors.Join(mounter.ErrCouldNotCreateStateDirectory, err)
-					}
-
-					local, dev, err = device.NewDevice(&config.DeviceSchema{
-						Name:      input.Name,
-						System:    "sparsefile",
-						Location:  input.Overlay,
-						Size:      fmt.Sprintf("%v", stat.Size()),
-						BlockSize: fmt.Sprintf("%v", input.BlockSize),
-						Expose:    true,
-						ROSource: &config.DeviceSchema{
-							Name:     input.State,
-							System:   "file",
-							Location: input.Base,
-							Size:     fmt.Sprintf("%v", stat.Size()),
-						},
-					})
-				}
+				local, dev, err := newLocalDevice(input)
 				if err != nil {
-					return errors.Join(mounter.ErrCouldNotCreateLocalDevice, err)
+					return err
 				}
 				addDefer(local.Close)
 				addDefer(dev.Shutdown)
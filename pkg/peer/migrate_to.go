@@ -22,6 +22,11 @@ import (
 )
 
 type MigrateToHooks struct {
+	OnBeforeInflateBalloon func(amountMiB int)
+	OnAfterInflateBalloon  func(actualMiB int)
+
+	OnDeviceZeroBlocksSkipped func(deviceID uint32, remote bool, blocks int)
+
 	OnBeforeGetDirtyBlocks func(deviceID uint32, remote bool)
 
 	OnBeforeSuspend func()
@@ -54,11 +59,27 @@ func (migratablePeer *MigratablePeer[L, R, G]) MigrateTo(
 	suspendTimeout time.Duration,
 	concurrency int,
 
+	balloonConfiguration MigrateToBalloonConfiguration,
+
 	readers []io.Reader,
 	writers []io.Writer,
 
 	hooks MigrateToHooks,
 ) (errs error) {
+	// If the migration fails, the VM keeps running on this peer, so we give it back the memory that we took from it. This needs
+	// to be deferred before the goroutine manager so that it runs after panics have been collected into `errs`.
+	balloonOriginalMiB := -1
+	defer func() {
+		if errs == nil || balloonOriginalMiB < 0 {
+			return
+		}
+
+		// `ctx` might have been cancelled, which is why the migration failed in the first place
+		if err := migratablePeer.resumedRunner.UpdateBalloon(context.WithoutCancel(ctx), balloonOriginalMiB); err != nil {
+			errs = errors.Join(errs, ErrCouldNotDeflateBalloon, err)
+		}
+	}()
+
 	goroutineManager := manager.NewGoroutineManager(
 		ctx,
 		&errs,
@@ -68,6 +89,16 @@ func (migratablePeer *MigratablePeer[L, R, G]) MigrateTo(
 	defer goroutineManager.StopAllGoroutines()
 	defer goroutineManager.CreateBackgroundPanicCollector()()
 
+	// Pages that the guest gives to the balloon are no longer written to, so they won't show up as dirty in later passes;
+	// they are only skipped in the initial pass if the guest zeroed them (see `MigrateToBalloonConfiguration`)
+	if balloonConfiguration.InflateBeforeMigration {
+		var err error
+		balloonOriginalMiB, err = migratablePeer.inflateBalloon(goroutineManager.Context(), balloonConfiguration, hooks)
+		if err != nil {
+			panic(errors.Join(ErrCouldNotInflateBalloon, err))
+		}
+	}
+
 	pro := protocol.NewRW(
 		goroutineManager.Context(),
 		readers,
@@ -264,6 +295,22 @@ func (migratablePeer *MigratablePeer[L, R, G]) MigrateTo(
 				return errors.Join(registry.ErrCouldNotCreateMigrator, err)
 			}
 
+			if balloonConfiguration.skipZeroBlocks() && input.prev.prev.prev.name == packager.MemoryName {
+				// The guest's zeroed pages might only be in the shared mapping of the memory, so we need to write them back first
+				if err := migratablePeer.resumedRunner.Msync(goroutineManager.Context()); err != nil {
+					return errors.Join(ErrCouldNotMsyncRunner, err)
+				}
+
+				skippedBlocks, err := registry.SkipZeroBlocks(input.prev.dirtyRemote, int(input.prev.prev.prev.blockSize), input.prev.orderer, mig, to)
+				if err != nil {
+					return errors.Join(ErrCouldNotSkipZeroBlocks, err)
+				}
+
+				if hook := hooks.OnDeviceZeroBlocksSkipped; hook != nil {
+					hook(uint32(index), input.prev.prev.prev.remote, skippedBlocks)
+				}
+			}
+
 			if err := mig.Migrate(input.prev.totalBlocks); err != nil {
 				return errors.Join(mounter.ErrCouldNotMigrateBlocks, err)
 			}
@@ -386,6 +433,17 @@ func (migratablePeer *MigratablePeer[L, R, G]) MigrateTo(
 				}
 			}
 
+			// The VM is suspended at this point, so the console history is complete; we only need to send it once
+			if console := migratablePeer.resumedPeer.console; index == 0 && console != nil {
+				if err := to.SendEvent(&packets.Event{
+					Type:          packets.EventCustom,
+					CustomType:    byte(registry.EventCustomConsoleHistory),
+					CustomPayload: console.History(),
+				}); err != nil {
+					panic(errors.Join(ErrCouldNotSendConsoleHistoryEvent, err))
+				}
+			}
+
 			if err := to.SendEvent(&packets.Event{
 				Type:       packets.EventCustom,
 				CustomType: byte(registry.EventCustomTransferAuthority),
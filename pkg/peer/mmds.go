@@ -0,0 +1,13 @@
+package peer
+
+import (
+	"context"
+)
+
+func (resumedPeer *ResumedPeer[L, R, G]) PutMMDS(ctx context.Context, data any) error {
+	return resumedPeer.resumedRunner.PutMMDS(ctx, data)
+}
+
+func (resumedPeer *ResumedPeer[L, R, G]) PatchMMDS(ctx context.Context, patch any) error {
+	return resumedPeer.resumedRunner.PatchMMDS(ctx, patch)
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -22,6 +24,9 @@ type MigratedPeer[L ipc.AgentServerLocal, R ipc.AgentServerRemote[G], G any] str
 	runner  *runner.Runner[L, R, G]
 
 	stage2Inputs []migrateFromStage
+
+	// Schedules closing a device after the runner has been closed
+	addDeviceCloseFunc func(closeFunc func() error)
 }
 
 func (migratedPeer *MigratedPeer[L, R, G]) Resume(
@@ -43,25 +48,54 @@ func (migratedPeer *MigratedPeer[L, R, G]) Resume(
 			return nil
 		},
 
+		console: migratedPeer.runner.Console,
+
 		stage2Inputs: migratedPeer.stage2Inputs,
+
+		addDeviceCloseFunc: migratedPeer.addDeviceCloseFunc,
 	}
 
-	configBasePath := ""
+	configBasePath, configPackagePath := "", ""
 	for _, device := range migratedPeer.devices {
 		if device.Name == packager.ConfigName {
 			configBasePath = device.Base
+			configPackagePath = device.Package
 
 			break
 		}
 	}
 
-	if strings.TrimSpace(configBasePath) == "" {
-		return nil, ErrConfigFileNotFound
-	}
+	// Remote devices are always written to `Base`, even if the local device would have been read from a package
+	configReceived := slices.ContainsFunc(migratedPeer.stage2Inputs, func(input migrateFromStage) bool {
+		return input.name == packager.ConfigName && input.remote
+	})
 
-	packageConfigFile, err := os.Open(configBasePath)
-	if err != nil {
-		return nil, errors.Join(ErrCouldNotOpenConfigFile, err)
+	var (
+		packageConfigFile io.ReadCloser
+		err               error
+	)
+	if strings.TrimSpace(configPackagePath) != "" && !configReceived {
+		packageConfigDevice, err := packager.OpenSeekableDevice(configPackagePath, packager.ConfigName)
+		if err != nil {
+			return nil, errors.Join(ErrCouldNotOpenConfigFile, err)
+		}
+
+		packageConfigFile = struct {
+			io.Reader
+			io.Closer
+		}{
+			io.NewSectionReader(packageConfigDevice, 0, int64(packageConfigDevice.Size())),
+			packageConfigDevice,
+		}
+	} else {
+		if strings.TrimSpace(configBasePath) == "" {
+			return nil, ErrConfigFileNotFound
+		}
+
+		packageConfigFile, err = os.Open(configBasePath)
+		if err != nil {
+			return nil, errors.Join(ErrCouldNotOpenConfigFile, err)
+		}
 	}
 	defer packageConfigFile.Close()
 
@@ -87,6 +121,15 @@ func (migratedPeer *MigratedPeer[L, R, G]) Resume(
 	}
 	resumedPeer.Remote = resumedPeer.resumedRunner.Remote
 
+	// The source peer might have inflated the balloon before migrating the VM to us, so we reset it to the size that the package was created with
+	if packageConfig.VM != nil && packageConfig.VM.Balloon != nil {
+		if err := resumedPeer.resumedRunner.UpdateBalloon(ctx, packageConfig.VM.Balloon.AmountMiB); err != nil {
+			_ = resumedPeer.resumedRunner.Close() // We ignore errors here since we're already returning an error
+
+			return nil, errors.Join(ErrCouldNotRestoreBalloon, err)
+		}
+	}
+
 	resumedPeer.Wait = resumedPeer.resumedRunner.Wait
 	resumedPeer.Close = resumedPeer.resumedRunner.Close
 
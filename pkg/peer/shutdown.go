@@ -0,0 +1,17 @@
+package peer
+
+import (
+	"context"
+	"time"
+)
+
+// Shutdown asks the guest to shut down and waits up to `grace` for the VMM to exit before killing it
+func (peer *Peer[L, R, G]) Shutdown(ctx context.Context, grace time.Duration) error {
+	return peer.runner.Shutdown(ctx, grace)
+}
+
+// Shutdown asks the agent to shut down the guest and waits up to `grace` for the VMM to exit before killing it;
+// the VM can't be migrated afterwards
+func (resumedPeer *ResumedPeer[L, R, G]) Shutdown(ctx context.Context, grace time.Duration) error {
+	return resumedPeer.resumedRunner.Shutdown(ctx, grace)
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 
+	"github.com/loopholelabs/drafter/pkg/console"
+	"github.com/loopholelabs/drafter/pkg/hypervisor"
 	"github.com/loopholelabs/drafter/pkg/ipc"
 	"github.com/loopholelabs/drafter/pkg/runner"
 	"github.com/loopholelabs/drafter/pkg/snapshotter"
@@ -13,6 +15,10 @@ import (
 type Peer[L ipc.AgentServerLocal, R ipc.AgentServerRemote[G], G any] struct {
 	VMPath string
 	VMPid  int
+	// Cgroup of the VMM process relative to the root of the cgroup hierarchy (e.g. `/sys/fs/cgroup` for cgroup v2)
+	CgroupPath string
+
+	Console *console.Console
 
 	Wait  func() error
 	Close func() error
@@ -27,9 +33,12 @@ func StartPeer[L ipc.AgentServerLocal, R ipc.AgentServerRemote[G], G any](
 	rescueCtx context.Context,
 
 	hypervisorConfiguration snapshotter.HypervisorConfiguration,
+	hypervisorRuntime snapshotter.HypervisorRuntime,
 
 	stateName string,
 	memoryName string,
+
+	monitoringHooks hypervisor.MonitoringHooks,
 ) (
 	peer *Peer[L, R, G],
 
@@ -61,9 +70,12 @@ func StartPeer[L ipc.AgentServerLocal, R ipc.AgentServerRemote[G], G any](
 		rescueCtx,
 
 		hypervisorConfiguration,
+		hypervisorRuntime,
 
 		stateName,
 		memoryName,
+
+		monitoringHooks,
 	)
 
 	// We set both of these even if we return an error since we need to have a way to wait for rescue operations to complete
@@ -82,6 +94,8 @@ func StartPeer[L ipc.AgentServerLocal, R ipc.AgentServerRemote[G], G any](
 
 	peer.VMPath = peer.runner.VMPath
 	peer.VMPid = peer.runner.VMPid
+	peer.CgroupPath = peer.runner.CgroupPath
+	peer.Console = peer.runner.Console
 
 	// We don't track this because we return the wait function
 	goroutineManager.StartBackgroundGoroutine(func(_ context.Context) {
@@ -0,0 +1,61 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+)
+
+// SwapDrive makes the drive `device.Name` of the running VM use `device` as its backing device (see `ResumedRunner.SwapDrive`).
+// Unless `device.Shared` is set, `device` is exposed the same way as the local devices of `MigrateFrom`, so that a later
+// `MakeMigratable` and `MigrateTo` include it instead of the previous device; the destination needs to have a
+// `MigrateFromDevice` with the same name. It must not be called concurrently with `MakeMigratable`.
+func (resumedPeer *ResumedPeer[L, R, G]) SwapDrive(ctx context.Context, device MigrateFromDevice[L, R, G]) error {
+	// The previous device stays exposed until the peer is closed, but is no longer migrated
+	removePreviousDevice := func() {
+		resumedPeer.stage2Inputs = slices.DeleteFunc(slices.Clone(resumedPeer.stage2Inputs), func(input migrateFromStage) bool {
+			return input.name == device.Name
+		})
+	}
+
+	if device.Shared {
+		if err := resumedPeer.resumedRunner.SwapDrive(ctx, device.Name, device.Base); err != nil {
+			return errors.Join(ErrCouldNotSwapDrive, err)
+		}
+
+		removePreviousDevice()
+
+		return nil
+	}
+
+	local, dev, err := newLocalDevice(device)
+	if err != nil {
+		return errors.Join(ErrCouldNotSwapDrive, err)
+	}
+
+	// We have to close the runner before we close the device
+	resumedPeer.addDeviceCloseFunc(local.Close)
+	resumedPeer.addDeviceCloseFunc(dev.Shutdown)
+
+	dev.SetProvider(local)
+
+	if err := resumedPeer.resumedRunner.SwapDrive(ctx, device.Name, filepath.Join("/dev", dev.Device())); err != nil {
+		return errors.Join(ErrCouldNotSwapDrive, err)
+	}
+
+	removePreviousDevice()
+	resumedPeer.stage2Inputs = append(resumedPeer.stage2Inputs, migrateFromStage{
+		name: device.Name,
+
+		blockSize: device.BlockSize,
+
+		id:     uint32(len(resumedPeer.stage2Inputs)),
+		remote: false,
+
+		storage: local,
+		device:  dev,
+	})
+
+	return nil
+}
@@ -5,6 +5,8 @@ import "errors"
 var (
 	ErrCouldNotGetInputDeviceStatistics   = errors.New("could not get input device statistics")
 	ErrCouldNotCreateNewDevice            = errors.New("could not create new device")
+	ErrCouldNotOpenPackageDevice          = errors.New("could not open package device")
+	ErrCouldNotVerifyDevice               = errors.New("could not verify device")
 	ErrCouldNotSendDeviceInfo             = errors.New("could not send device info")
 	ErrCouldNotSendEvent                  = errors.New("could not send event")
 	ErrCouldNotMigrate                    = errors.New("could not migrate")
@@ -14,4 +16,7 @@ var (
 	ErrCouldNotHandleDontNeedAt           = errors.New("could not handle DontNeedAt")
 	ErrCouldNotCreateMigrator             = errors.New("could not create migrator")
 	ErrCouldNotWaitForMigrationCompletion = errors.New("could not wait for migration completion")
+	ErrCouldNotReadBlock                  = errors.New("could not read block")
+	ErrCouldNotWriteZeroBlocks            = errors.New("could not write zero blocks")
+	ErrInvalidZeroBlocks                  = errors.New("invalid zero blocks")
 )
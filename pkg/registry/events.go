@@ -5,4 +5,8 @@ type CustomEventType byte
 const (
 	EventCustomAllDevicesSent    = CustomEventType(0)
 	EventCustomTransferAuthority = CustomEventType(1)
+	EventCustomConsoleHistory    = CustomEventType(2)
+	// Sent before the initial migration pass with the ranges of the device that only contain zeros, which aren't migrated
+	// (see `SkipZeroBlocks` and `WriteZeroBlocks`)
+	EventCustomZeroBlocks = CustomEventType(3)
 )
@@ -1,12 +1,17 @@
 package registry
 
 import (
+	"crypto/ed25519"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/loopholelabs/drafter/internal/utils"
+	"github.com/loopholelabs/drafter/pkg/packager"
+	"github.com/loopholelabs/silo/pkg/storage"
 	"github.com/loopholelabs/silo/pkg/storage/blocks"
 	"github.com/loopholelabs/silo/pkg/storage/config"
 	"github.com/loopholelabs/silo/pkg/storage/device"
@@ -16,8 +21,10 @@ import (
 )
 
 type RegistryDevice struct {
-	Name      string `json:"name"`
-	Input     string `json:"input"`
+	Name  string `json:"name"`
+	Input string `json:"input"`
+	// Path to a seekable package to serve the device from without extracting it; if set, `Input` is ignored
+	Package   string `json:"package"`
 	BlockSize uint32 `json:"blockSize"`
 }
 
@@ -34,9 +41,32 @@ type OpenDevicesHooks struct {
 	OnDeviceOpened func(deviceID uint32, name string)
 }
 
+type OpenDevicesOptions struct {
+	// Manifest to verify devices that are read from files against; if nil, they aren't verified. Devices that are served from
+	// seekable packages are always verified against the manifest in their package instead.
+	Manifest *packager.Manifest
+	// Key to verify the signature of the manifests in seekable packages with; if set, packages without a manifest or without a
+	// valid signature are rejected
+	VerificationKey ed25519.PublicKey
+}
+
+// OpenDevices opens `devices` for migration; devices that are served from seekable packages are verified against the manifest in
+// their package first (unless the package was created by an older version of Drafter and doesn't have one)
 func OpenDevices(
 	devices []RegistryDevice,
 
+	hooks OpenDevicesHooks,
+) ([]OpenedRegistryDevice, []func() error, error) {
+	return OpenDevicesWithOptions(devices, OpenDevicesOptions{}, hooks)
+}
+
+// OpenDevicesWithOptions is like `OpenDevices`, but also verifies devices that are read from files against `options.Manifest`
+// and the manifests in seekable packages against `options.VerificationKey`. An error that matches `packager.ErrDeviceChecksumMismatch`
+// is returned if a device doesn't match its checksums.
+func OpenDevicesWithOptions(
+	devices []RegistryDevice,
+
+	options OpenDevicesOptions,
 	hooks OpenDevicesHooks,
 ) ([]OpenedRegistryDevice, []func() error, error) {
 	openedDevices, deferFuncs, err := utils.ConcurrentMap(
@@ -44,23 +74,63 @@ func OpenDevices(
 		func(index int, input RegistryDevice, output *OpenedRegistryDevice, addDefer func(deferFunc func() error)) error {
 			output.RegistryDevice = input
 
-			stat, err := os.Stat(input.Input)
-			if err != nil {
-				return errors.Join(ErrCouldNotGetInputDeviceStatistics, err)
+			var (
+				src      storage.Provider
+				manifest = options.Manifest
+			)
+			if strings.TrimSpace(input.Package) != "" {
+				seekablePackage, err := packager.OpenSeekablePackage(input.Package)
+				if err != nil {
+					return errors.Join(ErrCouldNotOpenPackageDevice, err)
+				}
+				addDefer(seekablePackage.Close)
+
+				packageDevice, err := seekablePackage.Device(input.Name)
+				if err != nil {
+					return errors.Join(ErrCouldNotOpenPackageDevice, err)
+				}
+
+				src = packageDevice
+
+				manifest, err = seekablePackage.Manifest(options.VerificationKey)
+				if err != nil {
+					// Packages created by older versions of Drafter don't have a manifest, which we only reject if we need to verify its signature
+					if !errors.Is(err, packager.ErrMissingManifest) || options.VerificationKey != nil {
+						return errors.Join(ErrCouldNotVerifyDevice, err)
+					}
+
+					manifest = nil
+				}
+			} else {
+				stat, err := os.Stat(input.Input)
+				if err != nil {
+					return errors.Join(ErrCouldNotGetInputDeviceStatistics, err)
+				}
+
+				src, _, err = device.NewDevice(&config.DeviceSchema{
+					Name:      input.Name,
+					System:    "file",
+					Location:  input.Input,
+					Size:      fmt.Sprintf("%v", stat.Size()),
+					BlockSize: fmt.Sprintf("%v", input.BlockSize),
+					Expose:    false,
+				})
+				if err != nil {
+					return errors.Join(ErrCouldNotCreateNewDevice, err)
+				}
 			}
+			addDefer(src.Close)
 
-			src, _, err := device.NewDevice(&config.DeviceSchema{
-				Name:      input.Name,
-				System:    "file",
-				Location:  input.Input,
-				Size:      fmt.Sprintf("%v", stat.Size()),
-				BlockSize: fmt.Sprintf("%v", input.BlockSize),
-				Expose:    false,
-			})
-			if err != nil {
-				return errors.Join(ErrCouldNotCreateNewDevice, err)
+			if manifest != nil {
+				expected, ok := manifest.Device(input.Name)
+				if !ok {
+					return errors.Join(ErrCouldNotVerifyDevice, packager.ErrDeviceNotInManifest, fmt.Errorf("device %v", input.Name))
+				}
+
+				if err := packager.VerifyDeviceContent(expected, io.NewSectionReader(src, 0, int64(src.Size()))); err != nil {
+					return errors.Join(ErrCouldNotVerifyDevice, err)
+				}
 			}
-			addDefer(src.Close)
 
 			dirtyLocal, dirtyRemote := dirtytracker.NewDirtyTracker(src, int(input.BlockSize))
 			output.dirtyRemote = dirtyRemote
@@ -0,0 +1,158 @@
+package registry_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/loopholelabs/drafter/pkg/packager"
+	"github.com/loopholelabs/drafter/pkg/registry"
+)
+
+const (
+	blockSize = 1024 * 64
+)
+
+func createDisk(t *testing.T, dir string) string {
+	t.Helper()
+
+	disk := make([]byte, blockSize*4)
+	if _, err := rand.Read(disk); err != nil {
+		t.Fatal(err)
+	}
+
+	diskPath := filepath.Join(dir, packager.DiskName)
+	if err := os.WriteFile(diskPath, disk, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return diskPath
+}
+
+func closeDevices(t *testing.T, defers []func() error) {
+	t.Helper()
+
+	for i := len(defers) - 1; i >= 0; i-- {
+		if err := defers[i](); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestOpenDevicesVerifiesSeekablePackages(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	diskPath := createDisk(t, dir)
+
+	packagePath := filepath.Join(dir, "package.tar.zst")
+	if err := packager.ArchivePackage(
+		context.Background(),
+
+		[]packager.PackagerDevice{
+			{
+				Name: packager.DiskName,
+				Path: diskPath,
+			},
+		},
+		packagePath,
+
+		packager.ArchiveOptions{
+			SigningKey: privateKey,
+			Seekable:   true,
+		},
+		packager.PackagerHooks{},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	devices := []registry.RegistryDevice{
+		{
+			Name:      packager.DiskName,
+			Package:   packagePath,
+			BlockSize: blockSize,
+		},
+	}
+
+	_, defers, err := registry.OpenDevices(devices, registry.OpenDevicesHooks{})
+	closeDevices(t, defers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, defers, err = registry.OpenDevicesWithOptions(devices, registry.OpenDevicesOptions{
+		VerificationKey: publicKey,
+	}, registry.OpenDevicesHooks{})
+	closeDevices(t, defers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, defers, err = registry.OpenDevicesWithOptions(devices, registry.OpenDevicesOptions{
+		VerificationKey: otherPublicKey,
+	}, registry.OpenDevicesHooks{})
+	closeDevices(t, defers)
+	if !errors.Is(err, packager.ErrInvalidManifestSignature) {
+		t.Errorf("opening a package signed with another key returned %v, want %v", err, packager.ErrInvalidManifestSignature)
+	}
+}
+
+func TestOpenDevicesVerifiesFilesAgainstManifest(t *testing.T) {
+	dir := t.TempDir()
+	diskPath := createDisk(t, dir)
+
+	expected, err := packager.HashDevice(packager.DiskName, diskPath, blockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := &packager.Manifest{
+		Devices: []packager.ManifestDevice{expected},
+	}
+
+	devices := []registry.RegistryDevice{
+		{
+			Name:      packager.DiskName,
+			Input:     diskPath,
+			BlockSize: blockSize,
+		},
+	}
+
+	_, defers, err := registry.OpenDevicesWithOptions(devices, registry.OpenDevicesOptions{
+		Manifest: manifest,
+	}, registry.OpenDevicesHooks{})
+	closeDevices(t, defers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	disk, err := os.ReadFile(diskPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk[blockSize*2+1] ^= 0xff
+
+	if err := os.WriteFile(diskPath, disk, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, defers, err = registry.OpenDevicesWithOptions(devices, registry.OpenDevicesOptions{
+		Manifest: manifest,
+	}, registry.OpenDevicesHooks{})
+	closeDevices(t, defers)
+	if !errors.Is(err, packager.ErrDeviceChecksumMismatch) {
+		t.Errorf("opening a modified device returned %v, want %v", err, packager.ErrDeviceChecksumMismatch)
+	}
+}
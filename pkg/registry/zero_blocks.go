@@ -0,0 +1,141 @@
+package registry
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/loopholelabs/silo/pkg/storage"
+	"github.com/loopholelabs/silo/pkg/storage/migrator"
+	"github.com/loopholelabs/silo/pkg/storage/protocol"
+	"github.com/loopholelabs/silo/pkg/storage/protocol/packets"
+)
+
+const (
+	// Maximum number of zeros that are written to the destination at once
+	maxZeroWriteSize = 1024 * 1024
+)
+
+// SkipZeroBlocks removes the blocks of `source` that only contain zeros from the initial migration of `mig` and sends their
+// ranges to the destination in an `EventCustomZeroBlocks` event, so that the destination can write the zeros itself instead of
+// receiving them; it returns the number of skipped blocks.
+//
+// `source` needs to be the dirty tracker's remote that `mig` migrates from, since reading from it starts tracking the blocks;
+// if they are written to after they were skipped, they show up as dirty blocks and are migrated in the continuous and final passes.
+// This needs to be called before `mig.Migrate`.
+func SkipZeroBlocks(source storage.Provider, blockSize int, orderer storage.BlockOrder, mig *migrator.Migrator, to *protocol.ToProtocol) (int, error) {
+	var (
+		size       = int64(source.Size())
+		block      = make([]byte, blockSize)
+		zeroBlocks = []int{}
+		payload    = []byte{}
+
+		rangeOffset = int64(-1)
+		rangeLength int64
+	)
+	for offset := int64(0); offset < size; offset += int64(blockSize) {
+		length := min(int64(blockSize), size-offset)
+
+		n, err := source.ReadAt(block[:length], offset)
+		if err != nil {
+			return 0, errors.Join(ErrCouldNotReadBlock, err)
+		}
+
+		if !isZero(block[:n]) {
+			continue
+		}
+
+		zeroBlocks = append(zeroBlocks, int(offset/int64(blockSize)))
+
+		// Adjacent blocks are merged into one range to keep the event small
+		if rangeOffset >= 0 && rangeOffset+rangeLength == offset {
+			rangeLength += length
+
+			continue
+		}
+
+		if rangeOffset >= 0 {
+			payload = appendZeroBlocksRange(payload, rangeOffset, rangeLength)
+		}
+
+		rangeOffset = offset
+		rangeLength = length
+	}
+
+	if len(zeroBlocks) == 0 {
+		return 0, nil
+	}
+
+	payload = appendZeroBlocksRange(payload, rangeOffset, rangeLength)
+
+	// The destination writes the zeros before it acknowledges the event, so any data that we send for these blocks later on can't
+	// be overwritten with zeros
+	if err := to.SendEvent(&packets.Event{
+		Type:          packets.EventCustom,
+		CustomType:    byte(EventCustomZeroBlocks),
+		CustomPayload: payload,
+	}); err != nil {
+		return 0, errors.Join(ErrCouldNotSendEvent, err)
+	}
+
+	for _, block := range zeroBlocks {
+		orderer.Remove(block)
+		mig.SetMigratedBlock(block)
+	}
+
+	return len(zeroBlocks), nil
+}
+
+// WriteZeroBlocks writes zeros to the ranges in the payload of an `EventCustomZeroBlocks` event; `destination` needs to be the
+// waiting cache's remote so that the blocks are marked as available
+func WriteZeroBlocks(destination storage.Provider, blockSize int, payload []byte) error {
+	// The waiting cache only marks complete blocks as available, so we write whole blocks at once
+	zeros := make([]byte, max(1, maxZeroWriteSize/blockSize)*blockSize)
+	for len(payload) > 0 {
+		offset, n := binary.Uvarint(payload)
+		if n <= 0 {
+			return ErrInvalidZeroBlocks
+		}
+		payload = payload[n:]
+
+		length, n := binary.Uvarint(payload)
+		if n <= 0 {
+			return ErrInvalidZeroBlocks
+		}
+		payload = payload[n:]
+
+		if offset+length < offset || offset+length > destination.Size() {
+			return ErrInvalidZeroBlocks
+		}
+
+		for written := uint64(0); written < length; {
+			chunkLength := min(length-written, uint64(len(zeros)))
+
+			// The waiting cache can merge local writes into the buffer, so we need to clear it again
+			clear(zeros[:chunkLength])
+
+			if _, err := destination.WriteAt(zeros[:chunkLength], int64(offset+written)); err != nil {
+				return errors.Join(ErrCouldNotWriteZeroBlocks, err)
+			}
+
+			written += chunkLength
+		}
+	}
+
+	return nil
+}
+
+func appendZeroBlocksRange(payload []byte, offset, length int64) []byte {
+	payload = binary.AppendUvarint(payload, uint64(offset))
+
+	return binary.AppendUvarint(payload, uint64(length))
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+
+	return true
+}
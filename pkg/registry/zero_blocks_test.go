@@ -0,0 +1,215 @@
+package registry_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+
+	"github.com/loopholelabs/drafter/pkg/registry"
+	"github.com/loopholelabs/silo/pkg/storage"
+	"github.com/loopholelabs/silo/pkg/storage/blocks"
+	"github.com/loopholelabs/silo/pkg/storage/dirtytracker"
+	"github.com/loopholelabs/silo/pkg/storage/migrator"
+	"github.com/loopholelabs/silo/pkg/storage/protocol"
+	"github.com/loopholelabs/silo/pkg/storage/protocol/packets"
+	"github.com/loopholelabs/silo/pkg/storage/sources"
+	"github.com/loopholelabs/silo/pkg/storage/waitingcache"
+)
+
+const (
+	zeroBlocksDeviceBlocks = 16
+)
+
+// migrateWithZeroBlocks migrates `source` to a destination that already contains other data, optionally skipping its zero blocks,
+// and returns the number of blocks that were sent in the initial pass, the destination's storage and waiting cache, and a function
+// that writes to the source and migrates the dirty blocks
+func migrateWithZeroBlocks(t *testing.T, source []byte, skipZeroBlocks bool) (sent int64, destination storage.Provider, destinationLocal *waitingcache.Local, write func(offset int64, data []byte)) {
+	t.Helper()
+
+	sourceStorage := sources.NewMemoryStorage(len(source))
+	if _, err := sourceStorage.WriteAt(source, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	sourceDirtyLocal, sourceDirtyRemote := dirtytracker.NewDirtyTracker(sourceStorage, blockSize)
+
+	orderer := blocks.NewPriorityBlockOrder(zeroBlocksDeviceBlocks, blocks.NewAnyBlockOrder(zeroBlocksDeviceBlocks, nil))
+	orderer.AddAll()
+
+	// The destination's storage isn't empty, so skipped blocks only contain zeros if the destination writes them
+	destination = sources.NewMemoryStorage(len(source))
+	if _, err := destination.WriteAt(bytes.Repeat([]byte{0xff}, len(source)), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	sourceReader, destinationWriter := io.Pipe()
+	destinationReader, sourceWriter := io.Pipe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	destinationLocalCh := make(chan *waitingcache.Local, 1)
+	destinationProtocol := protocol.NewRW(ctx, []io.Reader{destinationReader}, []io.Writer{destinationWriter}, func(ctx context.Context, p protocol.Protocol, index uint32) {
+		var remote *waitingcache.Remote
+		from := protocol.NewFromProtocol(ctx, index, func(di *packets.DevInfo) storage.Provider {
+			var local *waitingcache.Local
+			local, remote = waitingcache.NewWaitingCache(destination, int(di.BlockSize))
+			destinationLocalCh <- local
+
+			return remote
+		}, p)
+
+		go func() {
+			_ = from.HandleDevInfo()
+		}()
+
+		go func() {
+			_ = from.HandleWriteAt()
+		}()
+
+		go func() {
+			_ = from.HandleEvent(func(e *packets.Event) {
+				if e.Type == packets.EventCustom && e.CustomType == byte(registry.EventCustomZeroBlocks) {
+					if err := registry.WriteZeroBlocks(remote, blockSize, e.CustomPayload); err != nil {
+						t.Error(err)
+					}
+				}
+			})
+		}()
+	})
+	sourceProtocol := protocol.NewRW(ctx, []io.Reader{sourceReader}, []io.Writer{sourceWriter}, nil)
+
+	go func() {
+		_ = destinationProtocol.Handle()
+	}()
+
+	go func() {
+		_ = sourceProtocol.Handle()
+	}()
+
+	to := protocol.NewToProtocol(uint64(len(source)), 0, sourceProtocol)
+	if err := to.SendDevInfo("memory", blockSize, ""); err != nil {
+		t.Fatal(err)
+	}
+	destinationLocal = <-destinationLocalCh
+
+	var sentBlocks atomic.Int64
+	cfg := migrator.NewConfig().WithBlockSize(blockSize)
+	cfg.BlockHandler = func(_ *storage.BlockInfo, _ uint64, _ []byte) {
+		sentBlocks.Add(1)
+	}
+
+	mig, err := migrator.NewMigrator(sourceDirtyRemote, to, orderer, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if skipZeroBlocks {
+		if _, err := registry.SkipZeroBlocks(sourceDirtyRemote, blockSize, orderer, mig, to); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mig.Migrate(zeroBlocksDeviceBlocks); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mig.WaitForCompletion(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes after the initial pass are migrated as dirty blocks
+	write = func(offset int64, data []byte) {
+		t.Helper()
+
+		if _, err := sourceDirtyLocal.WriteAt(data, offset); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := mig.MigrateDirty(mig.GetLatestDirty()); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := mig.WaitForCompletion(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return sentBlocks.Load(), destination, destinationLocal, write
+}
+
+func TestSkipZeroBlocks(t *testing.T) {
+	// Every other block only contains zeros, e.g. because the guest gave these pages to the balloon
+	source := make([]byte, zeroBlocksDeviceBlocks*blockSize)
+	for b := 0; b < zeroBlocksDeviceBlocks; b += 2 {
+		if _, err := rand.Read(source[b*blockSize : (b+1)*blockSize]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, test := range map[string]struct {
+		skipZeroBlocks bool
+		sent           int64
+	}{
+		"without skipping": {
+			sent: zeroBlocksDeviceBlocks,
+		},
+		"with skipping": {
+			skipZeroBlocks: true,
+			sent:           zeroBlocksDeviceBlocks / 2,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			sent, destination, destinationLocal, write := migrateWithZeroBlocks(t, source, test.skipZeroBlocks)
+
+			if sent != test.sent {
+				t.Errorf("initial pass sent %v blocks, want %v", sent, test.sent)
+			}
+
+			if available, total := destinationLocal.Availability(); available != total {
+				t.Errorf("destination has %v of %v blocks available", available, total)
+			}
+
+			actual := make([]byte, len(source))
+			if _, err := destination.ReadAt(actual, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(actual, source) {
+				t.Error("destination has different content than source after initial pass")
+			}
+
+			// A skipped block that is written to after the initial pass is still tracked
+			data := bytes.Repeat([]byte{1}, blockSize)
+			write(blockSize, data)
+
+			if _, err := destination.ReadAt(actual[:blockSize], blockSize); err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(actual[:blockSize], data) {
+				t.Error("destination doesn't have data that was written to a zero block after the initial pass")
+			}
+		})
+	}
+}
+
+func TestWriteZeroBlocksRejectsInvalidRanges(t *testing.T) {
+	destination := sources.NewMemoryStorage(blockSize * 2)
+
+	for name, payload := range map[string][]byte{
+		"truncated":   {0x80},
+		"past end":    {0, 0x80, 0x80, 0x10},
+		"only offset": {0},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := registry.WriteZeroBlocks(destination, blockSize, payload); !errors.Is(err, registry.ErrInvalidZeroBlocks) {
+				t.Errorf("writing zero blocks %x returned %v, want %v", payload, err, registry.ErrInvalidZeroBlocks)
+			}
+		})
+	}
+}
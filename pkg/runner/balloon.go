@@ -0,0 +1,36 @@
+package runner
+
+import (
+	"context"
+	"errors"
+
+	"github.com/loopholelabs/drafter/pkg/hypervisor"
+)
+
+// UpdateBalloon inflates or deflates the balloon to `amountMiB`; the guest does so asynchronously, so check `GetBalloonStatistics` for the actual size
+func (resumedRunner *ResumedRunner[L, R, G]) UpdateBalloon(ctx context.Context, amountMiB int) error {
+	balloonController, ok := resumedRunner.runner.server.(hypervisor.BalloonController)
+	if !ok {
+		return errors.Join(ErrCouldNotUpdateBalloon, hypervisor.ErrUnsupported)
+	}
+
+	if err := balloonController.UpdateBalloon(ctx, amountMiB); err != nil {
+		return errors.Join(ErrCouldNotUpdateBalloon, err)
+	}
+
+	return nil
+}
+
+func (resumedRunner *ResumedRunner[L, R, G]) GetBalloonStatistics(ctx context.Context) (*hypervisor.BalloonStatistics, error) {
+	balloonController, ok := resumedRunner.runner.server.(hypervisor.BalloonController)
+	if !ok {
+		return nil, errors.Join(ErrCouldNotGetBalloonStatistics, hypervisor.ErrUnsupported)
+	}
+
+	balloonStatistics, err := balloonController.GetBalloonStatistics(ctx)
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotGetBalloonStatistics, err)
+	}
+
+	return balloonStatistics, nil
+}
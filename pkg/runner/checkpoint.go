@@ -0,0 +1,56 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/lithammer/shortuuid/v4"
+	"github.com/loopholelabs/drafter/pkg/hypervisor"
+)
+
+// Checkpoint pauses the VM, writes its state and the memory pages that changed since the last checkpoint (or since it was resumed)
+// to `statePath` and `memoryPath` and resumes it again. The memory file is sparse; use `snapshotter.MergeDiffSnapshots` to apply
+// a chain of checkpoints to the memory file that the VM was resumed from. Both paths need to be on the same filesystem as the VM's chroot.
+func (resumedRunner *ResumedRunner[L, R, G]) Checkpoint(ctx context.Context, statePath, memoryPath string) error {
+	if !resumedRunner.snapshotLoadConfiguration.TrackDirtyPages {
+		return ErrDirtyPageTrackingDisabled
+	}
+
+	var (
+		stateCopyName  = shortuuid.New()
+		memoryCopyName = shortuuid.New()
+	)
+	if err := resumedRunner.runner.server.CreateSnapshot(
+		ctx,
+
+		stateCopyName,
+		memoryCopyName,
+
+		hypervisor.SnapshotTypeDiff,
+	); err != nil {
+		// The VM might have been paused before creating the snapshot failed
+		return errors.Join(ErrCouldNotCreateCheckpoint, err, resumedRunner.runner.server.Resume(ctx))
+	}
+
+	if err := resumedRunner.runner.server.Resume(ctx); err != nil {
+		return errors.Join(ErrCouldNotResumeVM, err)
+	}
+
+	for _, file := range [][2]string{
+		{stateCopyName, statePath},
+		{memoryCopyName, memoryPath},
+	} {
+		if err := os.MkdirAll(filepath.Dir(file[1]), os.ModePerm); err != nil {
+			return errors.Join(ErrCouldNotMoveCheckpoint, err)
+		}
+
+		// We move instead of copy the files here since copying would lose the holes in the sparse memory file
+		if err := os.Rename(filepath.Join(resumedRunner.runner.server.VMPath(), file[0]), file[1]); err != nil {
+			return errors.Join(ErrCouldNotMoveCheckpoint, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/loopholelabs/drafter/pkg/hypervisor"
+	"github.com/loopholelabs/drafter/pkg/packager"
+	"github.com/loopholelabs/drafter/pkg/snapshotter"
+)
+
+// checkCompatibility compares the configuration of the package in the VM's directory with this host, so that resuming an incompatible
+// snapshot fails with a `*snapshotter.IncompatiblePackageError` instead of an opaque VMM error. Packages without a config device aren't checked.
+func (runner *Runner[L, R, G]) checkCompatibility(ctx context.Context) error {
+	packageConfigFile, err := os.Open(filepath.Join(runner.server.VMPath(), packager.ConfigName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return errors.Join(ErrCouldNotOpenPackageConfig, err)
+	}
+	defer packageConfigFile.Close()
+
+	var packageConfig snapshotter.PackageConfiguration
+	if err := json.NewDecoder(packageConfigFile).Decode(&packageConfig); err != nil {
+		return errors.Join(ErrCouldNotDecodePackageConfig, err)
+	}
+
+	hostConfiguration, err := snapshotter.GetHostConfiguration()
+	if err != nil {
+		return errors.Join(snapshotter.ErrCouldNotGetHostConfiguration, err)
+	}
+
+	// The VM can only use the CPUs that the VMM is restricted to
+	if cpuSetReporter, ok := runner.server.(hypervisor.CPUSetReporter); ok {
+		if cpus := cpuSetReporter.CPUs(); len(cpus) > 0 {
+			hostConfiguration.CPUCount = len(cpus)
+		}
+	}
+
+	// Hypervisors that don't know their version skip the version check
+	vmmVersion := ""
+	if versionReporter, ok := runner.server.(hypervisor.VersionReporter); ok {
+		vmmVersion, err = versionReporter.Version(ctx)
+		if err != nil {
+			return errors.Join(snapshotter.ErrCouldNotGetVMMVersion, err)
+		}
+	}
+
+	return snapshotter.CheckCompatibility(
+		packageConfig,
+
+		hostConfiguration,
+		vmmVersion,
+
+		runner.server.VMPath(),
+	)
+}
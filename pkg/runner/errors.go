@@ -13,4 +13,23 @@ var (
 	ErrCouldNotCallAfterResumeRPC     = errors.New("could not call AfterResume RPC")
 	ErrCouldNotCallBeforeSuspendRPC   = errors.New("could not call BeforeSuspend RPC")
 	ErrCouldNotCreateRecoverySnapshot = errors.New("could not create recovery snapshot")
+	ErrCouldNotOpenPackageConfig      = errors.New("could not open package config")
+	ErrCouldNotDecodePackageConfig    = errors.New("could not decode package config")
+	ErrCompatibilityCheckFailed       = errors.New("package compatibility check failed")
+
+	ErrCouldNotUpdateDriveRateLimiter             = errors.New("could not update drive rate limiter")
+	ErrCouldNotUpdateNetworkInterfaceRateLimiters = errors.New("could not update network interface rate limiters")
+	ErrCouldNotUpdateBalloon                      = errors.New("could not update balloon")
+	ErrCouldNotGetBalloonStatistics               = errors.New("could not get balloon statistics")
+	ErrDirtyPageTrackingDisabled                  = errors.New("dirty page tracking is disabled")
+	ErrCouldNotCreateCheckpoint                   = errors.New("could not create checkpoint")
+	ErrCouldNotResumeVM                           = errors.New("could not resume VM")
+	ErrCouldNotMoveCheckpoint                     = errors.New("could not move checkpoint")
+	ErrCouldNotFlushMetrics                       = errors.New("could not flush metrics")
+	ErrCouldNotPutMMDS                            = errors.New("could not put MMDS data")
+	ErrCouldNotPatchMMDS                          = errors.New("could not patch MMDS data")
+	ErrNotABlockDevice                            = errors.New("not a block device")
+	ErrCouldNotCreateDeviceNode                   = errors.New("could not create device node")
+	ErrCouldNotMoveDeviceNode                     = errors.New("could not move device node")
+	ErrCouldNotSwapDrive                          = errors.New("could not swap drive")
 )
@@ -0,0 +1,22 @@
+package runner
+
+import (
+	"context"
+	"errors"
+
+	"github.com/loopholelabs/drafter/pkg/hypervisor"
+)
+
+// FlushMetrics causes the VMM to report its metrics to the `OnMetrics` hook that was passed to `StartRunner` immediately
+func (runner *Runner[L, R, G]) FlushMetrics(ctx context.Context) error {
+	metricsFlusher, ok := runner.server.(hypervisor.MetricsFlusher)
+	if !ok {
+		return errors.Join(ErrCouldNotFlushMetrics, hypervisor.ErrUnsupported)
+	}
+
+	if err := metricsFlusher.FlushMetrics(ctx); err != nil {
+		return errors.Join(ErrCouldNotFlushMetrics, err)
+	}
+
+	return nil
+}
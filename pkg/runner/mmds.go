@@ -0,0 +1,37 @@
+package runner
+
+import (
+	"context"
+	"errors"
+
+	"github.com/loopholelabs/drafter/pkg/hypervisor"
+)
+
+// PutMMDS replaces the metadata document that the guest can read from the MMDS, e.g. to give a migrated or cloned VM a new identity;
+// `data` needs to marshal to a JSON object
+func (resumedRunner *ResumedRunner[L, R, G]) PutMMDS(ctx context.Context, data any) error {
+	mmdsController, ok := resumedRunner.runner.server.(hypervisor.MMDSController)
+	if !ok {
+		return errors.Join(ErrCouldNotPutMMDS, hypervisor.ErrUnsupported)
+	}
+
+	if err := mmdsController.PutMMDS(ctx, data); err != nil {
+		return errors.Join(ErrCouldNotPutMMDS, err)
+	}
+
+	return nil
+}
+
+// PatchMMDS merges `patch` into the metadata document as a JSON merge patch (RFC 7396), e.g. `{"token": null}` removes the `token` key
+func (resumedRunner *ResumedRunner[L, R, G]) PatchMMDS(ctx context.Context, patch any) error {
+	mmdsController, ok := resumedRunner.runner.server.(hypervisor.MMDSController)
+	if !ok {
+		return errors.Join(ErrCouldNotPatchMMDS, hypervisor.ErrUnsupported)
+	}
+
+	if err := mmdsController.PatchMMDS(ctx, patch); err != nil {
+		return errors.Join(ErrCouldNotPatchMMDS, err)
+	}
+
+	return nil
+}
@@ -4,21 +4,21 @@ import (
 	"context"
 	"errors"
 
-	"github.com/loopholelabs/drafter/internal/firecracker"
+	"github.com/loopholelabs/drafter/pkg/hypervisor"
 	"github.com/loopholelabs/drafter/pkg/snapshotter"
 )
 
 func (resumedRunner *ResumedRunner[L, R, G]) Msync(ctx context.Context) error {
 	if !resumedRunner.snapshotLoadConfiguration.ExperimentalMapPrivate {
-		if err := firecracker.CreateSnapshot(
-			ctx,
+		msyncer, ok := resumedRunner.runner.server.(hypervisor.Msyncer)
+		if !ok {
+			return errors.Join(snapshotter.ErrCouldNotCreateSnapshot, hypervisor.ErrUnsupported)
+		}
 
-			resumedRunner.runner.firecrackerClient,
+		if err := msyncer.Msync(
+			ctx,
 
 			resumedRunner.runner.stateName,
-			"",
-
-			firecracker.SnapshotTypeMsync,
 		); err != nil {
 			return errors.Join(snapshotter.ErrCouldNotCreateSnapshot, err)
 		}
@@ -0,0 +1,34 @@
+package runner
+
+import (
+	"context"
+	"errors"
+
+	"github.com/loopholelabs/drafter/pkg/hypervisor"
+)
+
+func (resumedRunner *ResumedRunner[L, R, G]) UpdateDriveRateLimiter(ctx context.Context, name string, rateLimiter *hypervisor.RateLimiter) error {
+	rateLimiterUpdater, ok := resumedRunner.runner.server.(hypervisor.RateLimiterUpdater)
+	if !ok {
+		return errors.Join(ErrCouldNotUpdateDriveRateLimiter, hypervisor.ErrUnsupported)
+	}
+
+	if err := rateLimiterUpdater.UpdateDriveRateLimiter(ctx, name, rateLimiter); err != nil {
+		return errors.Join(ErrCouldNotUpdateDriveRateLimiter, err)
+	}
+
+	return nil
+}
+
+func (resumedRunner *ResumedRunner[L, R, G]) UpdateNetworkInterfaceRateLimiters(ctx context.Context, hostInterface string, rxRateLimiter, txRateLimiter *hypervisor.RateLimiter) error {
+	rateLimiterUpdater, ok := resumedRunner.runner.server.(hypervisor.RateLimiterUpdater)
+	if !ok {
+		return errors.Join(ErrCouldNotUpdateNetworkInterfaceRateLimiters, hypervisor.ErrUnsupported)
+	}
+
+	if err := rateLimiterUpdater.UpdateNetworkInterfaceRateLimiters(ctx, hostInterface, rxRateLimiter, txRateLimiter); err != nil {
+		return errors.Join(ErrCouldNotUpdateNetworkInterfaceRateLimiters, err)
+	}
+
+	return nil
+}
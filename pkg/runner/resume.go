@@ -3,14 +3,15 @@ package runner
 import (
 	"context"
 	"errors"
-	"io"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
 	"github.com/lithammer/shortuuid/v4"
-	"github.com/loopholelabs/drafter/internal/firecracker"
+	iutils "github.com/loopholelabs/drafter/internal/utils"
+	"github.com/loopholelabs/drafter/pkg/hypervisor"
 	"github.com/loopholelabs/drafter/pkg/ipc"
 	"github.com/loopholelabs/drafter/pkg/packager"
 	"github.com/loopholelabs/drafter/pkg/snapshotter"
@@ -32,6 +33,8 @@ type ResumedRunner[L ipc.AgentServerLocal, R ipc.AgentServerRemote[G], G any] st
 	acceptingAgent *ipc.AcceptingAgentServer[L, R, G]
 
 	createSnapshot func(ctx context.Context) error
+
+	shuttingDown atomic.Bool
 }
 
 func (runner *Runner[L, R, G]) Resume(
@@ -72,29 +75,27 @@ func (runner *Runner[L, R, G]) Resume(
 			memoryCopyName = shortuuid.New()
 		)
 		if snapshotLoadConfiguration.ExperimentalMapPrivate {
-			if err := firecracker.CreateSnapshot(
+			if err := runner.server.CreateSnapshot(
 				ctx,
 
-				runner.firecrackerClient,
-
 				// We need to write the state and memory to a separate file since we can't truncate an `mmap`ed file
 				stateCopyName,
 				memoryCopyName,
 
-				firecracker.SnapshotTypeFull,
+				hypervisor.SnapshotTypeFull,
 			); err != nil {
 				return errors.Join(snapshotter.ErrCouldNotCreateSnapshot, err)
 			}
 		} else {
-			if err := firecracker.CreateSnapshot(
-				ctx,
+			msyncer, ok := runner.server.(hypervisor.Msyncer)
+			if !ok {
+				return errors.Join(snapshotter.ErrCouldNotCreateSnapshot, hypervisor.ErrUnsupported)
+			}
 
-				runner.firecrackerClient,
+			if err := msyncer.MsyncAndSnapshotState(
+				ctx,
 
 				runner.stateName,
-				"",
-
-				firecracker.SnapshotTypeMsyncAndState,
 			); err != nil {
 				return errors.Join(snapshotter.ErrCouldNotCreateSnapshot, err)
 			}
@@ -113,7 +114,7 @@ func (runner *Runner[L, R, G]) Resume(
 				{runner.stateName, stateCopyName, snapshotLoadConfiguration.ExperimentalMapPrivateStateOutput},
 				{runner.memoryName, memoryCopyName, snapshotLoadConfiguration.ExperimentalMapPrivateMemoryOutput},
 			} {
-				inputFile, err := os.Open(filepath.Join(runner.server.VMPath, device[1]))
+				inputFile, err := os.Open(filepath.Join(runner.server.VMPath(), device[1]))
 				if err != nil {
 					return errors.Join(snapshotter.ErrCouldNotOpenInputFile, err)
 				}
@@ -124,7 +125,7 @@ func (runner *Runner[L, R, G]) Resume(
 					addPadding = true
 				)
 				if outputPath == "" {
-					outputPath = filepath.Join(runner.server.VMPath, device[0])
+					outputPath = filepath.Join(runner.server.VMPath(), device[0])
 					addPadding = false
 				}
 
@@ -138,7 +139,7 @@ func (runner *Runner[L, R, G]) Resume(
 				}
 				defer outputFile.Close()
 
-				deviceSize, err := io.Copy(outputFile, inputFile)
+				deviceSize, err := iutils.CopySparse(outputFile, inputFile)
 				if err != nil {
 					return errors.Join(snapshotter.ErrCouldNotCopyFile, err)
 				}
@@ -198,7 +199,7 @@ func (runner *Runner[L, R, G]) Resume(
 
 	var err error
 	resumedRunner.agent, err = ipc.StartAgentServer[L, R](
-		filepath.Join(runner.server.VMPath, snapshotter.VSockName),
+		filepath.Join(runner.server.VMPath(), snapshotter.VSockName),
 		uint32(agentVSockPort),
 
 		agentServerLocal,
@@ -221,15 +222,20 @@ func (runner *Runner[L, R, G]) Resume(
 		resumeSnapshotAndAcceptCtx, cancelResumeSnapshotAndAcceptCtx := context.WithTimeout(goroutineManager.Context(), resumeTimeout)
 		defer cancelResumeSnapshotAndAcceptCtx()
 
-		if err := firecracker.ResumeSnapshot(
-			resumeSnapshotAndAcceptCtx,
+		if !snapshotLoadConfiguration.SkipCompatibilityCheck {
+			if err := runner.checkCompatibility(resumeSnapshotAndAcceptCtx); err != nil {
+				panic(errors.Join(ErrCompatibilityCheckFailed, err))
+			}
+		}
 
-			runner.firecrackerClient,
+		if err := runner.server.ResumeSnapshot(
+			resumeSnapshotAndAcceptCtx,
 
 			runner.stateName,
 			runner.memoryName,
 
 			!snapshotLoadConfiguration.ExperimentalMapPrivate,
+			snapshotLoadConfiguration.TrackDirtyPages,
 		); err != nil {
 			panic(errors.Join(ErrCouldNotResumeSnapshot, err))
 		}
@@ -251,14 +257,16 @@ func (runner *Runner[L, R, G]) Resume(
 	// We intentionally don't call `wg.Add` and `wg.Done` here since we return the process's wait method
 	// We still need to `defer handleGoroutinePanic()()` here however so that we catch any errors during this call
 	goroutineManager.StartBackgroundGoroutine(func(_ context.Context) {
-		if err := resumedRunner.acceptingAgent.Wait(); err != nil {
+		if err := resumedRunner.ignoreDisconnectAfterShutdown(resumedRunner.acceptingAgent.Wait()); err != nil {
 			panic(errors.Join(snapshotter.ErrCouldNotWaitForAcceptingAgent, err))
 		}
 	})
 
-	resumedRunner.Wait = resumedRunner.acceptingAgent.Wait
+	resumedRunner.Wait = func() error {
+		return resumedRunner.ignoreDisconnectAfterShutdown(resumedRunner.acceptingAgent.Wait())
+	}
 	resumedRunner.Close = func() error {
-		if err := resumedRunner.acceptingAgent.Close(); err != nil {
+		if err := resumedRunner.ignoreDisconnectAfterShutdown(resumedRunner.acceptingAgent.Close()); err != nil {
 			return errors.Join(snapshotter.ErrCouldNotCloseAcceptingAgent, err)
 		}
 
@@ -0,0 +1,84 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"time"
+	"unsafe"
+
+	"github.com/loopholelabs/drafter/pkg/hypervisor"
+	"github.com/loopholelabs/drafter/pkg/ipc"
+)
+
+// Shutdown asks the guest to shut down (e.g. with CTRL-ALT-DEL) and waits up to `grace` for the VMM to exit before killing it;
+// asking the guest to shut down is also limited to `grace`
+func (runner *Runner[L, R, G]) Shutdown(ctx context.Context, grace time.Duration) error {
+	return runner.shutdown(ctx, grace, runner.requestShutdown)
+}
+
+// requestShutdown asks the guest to shut down through the hypervisor, if it supports this
+func (runner *Runner[L, R, G]) requestShutdown(ctx context.Context) error {
+	shutdownRequester, ok := runner.server.(hypervisor.ShutdownRequester)
+	if !ok {
+		return hypervisor.ErrUnsupported
+	}
+
+	return shutdownRequester.RequestShutdown(ctx)
+}
+
+func (runner *Runner[L, R, G]) shutdown(ctx context.Context, grace time.Duration, requestShutdown func(ctx context.Context) error) error {
+	requestCtx, cancelRequestCtx := context.WithTimeout(ctx, grace)
+	defer cancelRequestCtx()
+
+	// If we can't ask the guest to shut down (e.g. because the architecture doesn't support CTRL-ALT-DEL), we kill the VMM right away
+	if err := requestShutdown(requestCtx); err == nil {
+		// The grace period starts once the guest has been asked to shut down, since asking it can take up most of the request's deadline
+		graceCtx, cancelGraceCtx := context.WithTimeout(ctx, grace)
+		defer cancelGraceCtx()
+
+		exited := make(chan struct{})
+		go func() {
+			defer close(exited)
+
+			_ = runner.Wait() // We ignore errors here since `Close` returns them
+		}()
+
+		select {
+		case <-exited:
+		case <-graceCtx.Done():
+		}
+	}
+
+	return runner.Close()
+}
+
+// Shutdown asks the agent to shut down the guest, falls back to `Runner.Shutdown`'s mechanism if the agent doesn't support this
+// and waits up to `grace` for the VMM to exit before killing it. Unlike `SuspendAndCloseAgentServer`, this doesn't write back the VM's state.
+func (resumedRunner *ResumedRunner[L, R, G]) Shutdown(ctx context.Context, grace time.Duration) error {
+	resumedRunner.shuttingDown.Store(true)
+
+	return resumedRunner.runner.shutdown(ctx, grace, func(requestCtx context.Context) error {
+		// This is a safe type cast because R is constrained by ipc.AgentServerRemote, so this specific Shutdown field
+		// must be defined or there will be a compile-time error.
+		// The Go Generics system can't catch this here however, it can only catch it once the type is concrete, so we need to manually cast.
+		remote := *(*ipc.AgentServerRemote[G])(unsafe.Pointer(&resumedRunner.acceptingAgent.Remote))
+		if err := remote.Shutdown(requestCtx); err != nil {
+			// The agent may have used up the request's deadline before failing, so the fallback gets its own
+			fallbackCtx, cancelFallbackCtx := context.WithTimeout(ctx, grace)
+			defer cancelFallbackCtx()
+
+			return resumedRunner.runner.requestShutdown(fallbackCtx)
+		}
+
+		return nil
+	})
+}
+
+// The agent disconnects once the guest has shut down, which isn't an error if we've asked it to
+func (resumedRunner *ResumedRunner[L, R, G]) ignoreDisconnectAfterShutdown(err error) error {
+	if resumedRunner.shuttingDown.Load() && errors.Is(err, ipc.ErrAgentClientDisconnected) {
+		return nil
+	}
+
+	return err
+}
@@ -3,13 +3,12 @@ package runner
 import (
 	"context"
 	"errors"
-	"net"
-	"net/http"
 	"os"
 	"path/filepath"
 	"sync"
 
-	"github.com/loopholelabs/drafter/internal/firecracker"
+	"github.com/loopholelabs/drafter/pkg/console"
+	"github.com/loopholelabs/drafter/pkg/hypervisor"
 	"github.com/loopholelabs/drafter/pkg/ipc"
 	"github.com/loopholelabs/drafter/pkg/snapshotter"
 	"github.com/loopholelabs/goroutine-manager/pkg/manager"
@@ -20,24 +19,34 @@ type SnapshotLoadConfiguration struct {
 
 	ExperimentalMapPrivateStateOutput  string
 	ExperimentalMapPrivateMemoryOutput string
+
+	// Whether to track dirty pages so that `ResumedRunner.Checkpoint` can create diff snapshots
+	TrackDirtyPages bool
+
+	// Whether to resume the snapshot without checking that the host is compatible with the package first
+	SkipCompatibilityCheck bool
 }
 
 type Runner[L ipc.AgentServerLocal, R ipc.AgentServerRemote[G], G any] struct {
 	VMPath string
 	VMPid  int
+	// Cgroup of the VMM process relative to the root of the cgroup hierarchy (e.g. `/sys/fs/cgroup` for cgroup v2)
+	CgroupPath string
+
+	// Serial console of the VM from the hypervisor configuration; nil if the VM isn't connected to a console
+	Console *console.Console
 
 	Wait  func() error
 	Close func() error
 
-	ongoingResumeWg   sync.WaitGroup
-	firecrackerClient *http.Client
+	ongoingResumeWg sync.WaitGroup
 
 	hypervisorConfiguration snapshotter.HypervisorConfiguration
 
 	stateName,
 	memoryName string
 
-	server *firecracker.FirecrackerServer
+	server hypervisor.Hypervisor
 
 	rescueCtx context.Context
 }
@@ -47,9 +56,12 @@ func StartRunner[L ipc.AgentServerLocal, R ipc.AgentServerRemote[G], G any](
 	rescueCtx context.Context,
 
 	hypervisorConfiguration snapshotter.HypervisorConfiguration,
+	hypervisorRuntime snapshotter.HypervisorRuntime,
 
 	stateName string,
 	memoryName string,
+
+	monitoringHooks hypervisor.MonitoringHooks,
 ) (
 	runner *Runner[L, R, G],
 
@@ -59,6 +71,8 @@ func StartRunner[L ipc.AgentServerLocal, R ipc.AgentServerRemote[G], G any](
 		Wait:  func() error { return nil },
 		Close: func() error { return nil },
 
+		Console: hypervisorRuntime.Console,
+
 		hypervisorConfiguration: hypervisorConfiguration,
 
 		stateName:  stateName,
@@ -90,31 +104,20 @@ func StartRunner[L ipc.AgentServerLocal, R ipc.AgentServerRemote[G], G any](
 		cancelFirecrackerCtx()
 	}()
 
-	var err error
-	runner.server, err = firecracker.StartFirecrackerServer(
+	runner.server = snapshotter.NewHypervisor(hypervisorConfiguration, hypervisorRuntime)
+	if err := runner.server.Start(
 		firecrackerCtx, // We use firecrackerCtx (which depends on hypervisorCtx, not goroutineManager.goroutineManager.Context()) here since this resource outlives the function call
 
-		hypervisorConfiguration.FirecrackerBin,
-		hypervisorConfiguration.JailerBin,
-
-		hypervisorConfiguration.ChrootBaseDir,
-
-		hypervisorConfiguration.UID,
-		hypervisorConfiguration.GID,
-
-		hypervisorConfiguration.NetNS,
-		hypervisorConfiguration.NumaNode,
-		hypervisorConfiguration.CgroupVersion,
-
-		hypervisorConfiguration.EnableOutput,
-		hypervisorConfiguration.EnableInput,
-	)
-	if err != nil {
+		monitoringHooks,
+	); err != nil {
 		panic(errors.Join(snapshotter.ErrCouldNotStartFirecrackerServer, err))
 	}
 
-	runner.VMPath = runner.server.VMPath
-	runner.VMPid = runner.server.VMPid
+	runner.VMPath = runner.server.VMPath()
+	runner.VMPid = runner.server.VMPid()
+	if cgroupReporter, ok := runner.server.(hypervisor.CgroupReporter); ok {
+		runner.CgroupPath = cgroupReporter.CgroupPath()
+	}
 
 	// We intentionally don't call `wg.Add` and `wg.Done` here since we return the process's wait method
 	// We still need to `defer handleGoroutinePanic()()` here however so that we catch any errors during this call
@@ -141,13 +144,5 @@ func StartRunner[L ipc.AgentServerLocal, R ipc.AgentServerRemote[G], G any](
 		return nil
 	}
 
-	runner.firecrackerClient = &http.Client{
-		Transport: &http.Transport{
-			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-				return (&net.Dialer{}).DialContext(ctx, "unix", filepath.Join(runner.VMPath, firecracker.FirecrackerSocketName))
-			},
-		},
-	}
-
 	return
 }
@@ -0,0 +1,79 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/lithammer/shortuuid/v4"
+	"github.com/loopholelabs/drafter/pkg/hypervisor"
+	"github.com/loopholelabs/drafter/pkg/snapshotter"
+	"golang.org/x/sys/unix"
+)
+
+// SwapDrive makes the drive `name` of the running VM use the block device at `devicePath` (e.g. a loop device or a device
+// exposed by `mounter.MigrateFromAndMount`) instead of its current backing device; the guest sees this as a media change.
+// Drives can't be attached to a running VM, so to attach an additional device, add a placeholder drive when creating the
+// snapshot and swap it. The drive keeps its path in the VM's chroot, so snapshots and migrations of the VM pick up the new
+// device; if the hypervisor can't swap the drive, the previous device is kept.
+func (resumedRunner *ResumedRunner[L, R, G]) SwapDrive(ctx context.Context, name, devicePath string) error {
+	// We need to check this before we replace the drive's device node, otherwise the node wouldn't match the device that the VMM uses
+	driveSwapper, ok := resumedRunner.runner.server.(hypervisor.DriveSwapper)
+	if !ok {
+		return errors.Join(ErrCouldNotSwapDrive, hypervisor.ErrUnsupported)
+	}
+
+	deviceInfo, err := os.Stat(devicePath)
+	if err != nil {
+		return errors.Join(snapshotter.ErrCouldNotGetDeviceStat, err)
+	}
+
+	if deviceInfo.Mode().Type() != os.ModeDevice { // Character devices also have `os.ModeDevice` set
+		return ErrNotABlockDevice
+	}
+
+	deviceStat, ok := deviceInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return snapshotter.ErrCouldNotGetDeviceStat
+	}
+
+	deviceMajor := uint64(deviceStat.Rdev / 256)
+	deviceMinor := uint64(deviceStat.Rdev % 256)
+
+	deviceID := int((deviceMajor << 8) | deviceMinor)
+
+	// We create the new device node next to the old one and then atomically replace it; Firecracker keeps using
+	// the old device until the drive has been updated. We keep a link to the old device node so that we can restore it
+	// if the drive can't be updated.
+	var (
+		drivePath       = filepath.Join(resumedRunner.runner.VMPath, name)
+		tempDrivePath   = filepath.Join(resumedRunner.runner.VMPath, shortuuid.New())
+		backupDrivePath = filepath.Join(resumedRunner.runner.VMPath, shortuuid.New())
+	)
+	if err := os.Link(drivePath, backupDrivePath); err != nil {
+		return errors.Join(ErrCouldNotMoveDeviceNode, err)
+	}
+	defer os.Remove(backupDrivePath) // We ignore errors here since the link is only needed until the drive has been updated
+
+	if err := unix.Mknod(tempDrivePath, unix.S_IFBLK|0666, deviceID); err != nil {
+		return errors.Join(ErrCouldNotCreateDeviceNode, err)
+	}
+
+	if err := os.Rename(tempDrivePath, drivePath); err != nil {
+		_ = os.Remove(tempDrivePath) // We ignore errors here since we're already returning an error
+
+		return errors.Join(ErrCouldNotMoveDeviceNode, err)
+	}
+
+	if err := driveSwapper.UpdateDrivePath(ctx, name, name); err != nil {
+		if rollbackErr := os.Rename(backupDrivePath, drivePath); rollbackErr != nil {
+			return errors.Join(ErrCouldNotSwapDrive, err, ErrCouldNotMoveDeviceNode, rollbackErr)
+		}
+
+		return errors.Join(ErrCouldNotSwapDrive, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package snapshotter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+
+	"github.com/loopholelabs/drafter/pkg/packager"
+)
+
+const (
+	drafterModulePath = "github.com/loopholelabs/drafter"
+
+	// CPU template that doesn't mask the host's CPU features
+	cpuTemplateNone = "None"
+)
+
+var (
+	cpuInfoPath = filepath.Join("/proc", "cpuinfo")
+)
+
+type HostConfiguration struct {
+	Architecture string `json:"architecture"`
+	// CPU features of the host as listed in `/proc/cpuinfo` (e.g. `avx2` or `sse4_2`)
+	CPUFeatures []string `json:"cpuFeatures"`
+	// Number of CPUs that the VMM can run on
+	CPUCount int `json:"cpuCount,omitempty"`
+}
+
+// GetHostConfiguration returns the architecture, CPU features and number of usable CPUs of the current host
+func GetHostConfiguration() (HostConfiguration, error) {
+	hostConfiguration := HostConfiguration{
+		Architecture: runtime.GOARCH,
+		CPUFeatures:  []string{},
+		CPUCount:     runtime.NumCPU(),
+	}
+
+	file, err := os.Open(cpuInfoPath)
+	if err != nil {
+		return HostConfiguration{}, errors.Join(ErrCouldNotReadCPUFeatures, err)
+	}
+	defer file.Close()
+
+	// x86 hosts list the features in a `flags` field, ARM hosts in a `Features` field; all CPUs share the same features, so we only read the first CPU's
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+
+		if key = strings.TrimSpace(key); key != "flags" && key != "Features" {
+			continue
+		}
+
+		hostConfiguration.CPUFeatures = strings.Fields(value)
+		slices.Sort(hostConfiguration.CPUFeatures)
+
+		break
+	}
+
+	if err := scanner.Err(); err != nil {
+		return HostConfiguration{}, errors.Join(ErrCouldNotReadCPUFeatures, err)
+	}
+
+	return hostConfiguration, nil
+}
+
+// GetDrafterVersion returns the version of the Drafter module that this binary was built with, or `(devel)` if it is unknown
+func GetDrafterVersion() string {
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+
+	if buildInfo.Main.Path == drafterModulePath && buildInfo.Main.Version != "" {
+		return buildInfo.Main.Version
+	}
+
+	for _, dep := range buildInfo.Deps {
+		if dep.Path == drafterModulePath {
+			return dep.Version
+		}
+	}
+
+	return "(devel)"
+}
+
+type CompatibilityMismatch struct {
+	Field string
+
+	// Value of the field in the package and on the host that the package is resumed on
+	Package string
+	Host    string
+}
+
+// IncompatiblePackageError describes why a package can't be resumed on a host; `errors.Is(err, ErrIncompatiblePackage)` matches it
+type IncompatiblePackageError struct {
+	Mismatches []CompatibilityMismatch
+}
+
+func (e *IncompatiblePackageError) Error() string {
+	mismatches := []string{}
+	for _, mismatch := range e.Mismatches {
+		mismatches = append(mismatches, fmt.Sprintf("%v is %q in package but %q on host", mismatch.Field, mismatch.Package, mismatch.Host))
+	}
+
+	return fmt.Sprintf("%v: %v", ErrIncompatiblePackage, strings.Join(mismatches, ", "))
+}
+
+func (e *IncompatiblePackageError) Is(target error) bool {
+	return target == ErrIncompatiblePackage
+}
+
+// CheckCompatibility checks whether a package with `packageConfig` can be resumed from the devices in `vmPath` by a VMM with `vmmVersion`
+// on a host with `hostConfiguration`, and returns an `*IncompatiblePackageError` if it can't. Only the devices that are needed to
+// resume the snapshot (the snapshot itself and the VM's disks) need to be in `vmPath`, and the VM can't have more vCPUs than
+// `hostConfiguration.CPUCount`. Fields that packages created by older versions of Drafter don't record aren't checked.
+func CheckCompatibility(
+	packageConfig PackageConfiguration,
+
+	hostConfiguration HostConfiguration,
+	vmmVersion string,
+
+	vmPath string,
+) error {
+	incompatiblePackageError := &IncompatiblePackageError{}
+	mismatch := func(field, packageValue, hostValue string) {
+		incompatiblePackageError.Mismatches = append(incompatiblePackageError.Mismatches, CompatibilityMismatch{
+			Field: field,
+
+			Package: packageValue,
+			Host:    hostValue,
+		})
+	}
+
+	if packageConfig.Host != nil {
+		if packageConfig.Host.Architecture != hostConfiguration.Architecture {
+			mismatch("architecture", packageConfig.Host.Architecture, hostConfiguration.Architecture)
+		}
+
+		// CPU templates normalize the CPU features that the guest sees, so we only need to compare them if the VM doesn't use one
+		if packageConfig.VM == nil || packageConfig.VM.CPUTemplate == "" || packageConfig.VM.CPUTemplate == cpuTemplateNone {
+			missingCPUFeatures := []string{}
+			for _, feature := range packageConfig.Host.CPUFeatures {
+				if !slices.Contains(hostConfiguration.CPUFeatures, feature) {
+					missingCPUFeatures = append(missingCPUFeatures, feature)
+				}
+			}
+
+			if len(missingCPUFeatures) > 0 {
+				mismatch("CPU features", strings.Join(missingCPUFeatures, " "), "missing")
+			}
+		}
+	}
+
+	// Snapshots are only compatible between VMMs with the same major and minor version
+	if packageConfig.FirecrackerVersion != "" && vmmVersion != "" && getMajorMinorVersion(packageConfig.FirecrackerVersion) != getMajorMinorVersion(vmmVersion) {
+		mismatch("VMM version", packageConfig.FirecrackerVersion, vmmVersion)
+	}
+
+	// The kernel and the package config aren't needed once the VM has booted, so they can be left out when resuming
+	for _, device := range packageConfig.Devices {
+		if device != packager.StateName && device != packager.MemoryName && !isDisk(device) {
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(vmPath, device)); err != nil {
+			mismatch(fmt.Sprintf("device %v", device), "present", "missing")
+		}
+	}
+
+	if packageConfig.VM != nil && packageConfig.VM.CPUCount > 0 && hostConfiguration.CPUCount > 0 && packageConfig.VM.CPUCount > hostConfiguration.CPUCount {
+		mismatch("vCPU count", fmt.Sprintf("%v", packageConfig.VM.CPUCount), fmt.Sprintf("%v CPUs", hostConfiguration.CPUCount))
+	}
+
+	if packageConfig.VM != nil && packageConfig.VM.MemorySize > 0 {
+		memorySize, err := getDeviceSize(filepath.Join(vmPath, packager.MemoryName))
+		if err == nil && memorySize < int64(packageConfig.VM.MemorySize)*1024*1024 {
+			mismatch("memory size", fmt.Sprintf("%v bytes", int64(packageConfig.VM.MemorySize)*1024*1024), fmt.Sprintf("%v bytes", memorySize))
+		}
+	}
+
+	if len(incompatiblePackageError.Mismatches) > 0 {
+		return incompatiblePackageError
+	}
+
+	return nil
+}
+
+func getMajorMinorVersion(version string) string {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return version
+	}
+
+	return parts[0] + "." + parts[1]
+}
+
+// getDeviceSize returns the size of a file or block device; `os.Stat` returns 0 for block devices, so we seek to the end instead
+func getDeviceSize(path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return file.Seek(0, io.SeekEnd)
+}
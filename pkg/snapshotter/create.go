@@ -4,17 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"io"
-	"net"
-	"net/http"
 	"os"
 	"path/filepath"
 	"slices"
 	"strings"
 	"time"
 
-	"github.com/loopholelabs/drafter/internal/firecracker"
 	iutils "github.com/loopholelabs/drafter/internal/utils"
+	"github.com/loopholelabs/drafter/pkg/hypervisor"
 	"github.com/loopholelabs/drafter/pkg/ipc"
 	"github.com/loopholelabs/drafter/pkg/packager"
 	"github.com/loopholelabs/drafter/pkg/utils"
@@ -23,6 +20,17 @@ import (
 
 type PackageConfiguration struct {
 	AgentVSockPort uint32 `json:"agentVSockPort"`
+
+	// The following fields describe how the snapshot was created and are checked before resuming it (see `CheckCompatibility`);
+	// they are empty for packages created by older versions of Drafter
+	VM      *VMConfiguration `json:"vm,omitempty"`
+	Devices []string         `json:"devices,omitempty"`
+
+	Host *HostConfiguration `json:"host,omitempty"`
+
+	// Drafter's version is only recorded for debugging since it doesn't affect whether a snapshot can be resumed
+	DrafterVersion     string `json:"drafterVersion,omitempty"`
+	FirecrackerVersion string `json:"firecrackerVersion,omitempty"`
 }
 
 type AgentConfiguration struct {
@@ -39,6 +47,9 @@ type SnapshotDevice struct {
 	Name   string `json:"name"`
 	Input  string `json:"input"`
 	Output string `json:"output"`
+
+	// Optional limit for the disk's I/O; ignored for devices that aren't attached to the VM as disks (like the kernel or memory)
+	RateLimiter *hypervisor.RateLimiter `json:"rateLimiter"`
 }
 
 type HypervisorConfiguration struct {
@@ -50,25 +61,54 @@ type HypervisorConfiguration struct {
 	UID int
 	GID int
 
-	NetNS         string
-	NumaNode      int
+	NetNS string
+	// NUMA node to run the VMM on; if `cpuset.AutoNUMANode`, the node with the most free memory is used
+	NumaNode int
+	// CPUs to run the VMM on; if empty, all CPUs of `NumaNode` are used
+	CPUs []int
+	// Whether to pin each vCPU to one of the VMM's CPUs instead of letting them float between them
+	PinVCPUs      bool
 	CgroupVersion int
 
+	// Additional cgroup settings for the VMM, e.g. `{"cpu.max": "50000 100000", "memory.max": "1073741824"}`;
+	// `cpuset.mems` and `cpuset.cpus` are derived from `NumaNode` and `CPUs` unless they are set here
+	Cgroups map[string]string
+	// Cgroup to create the VMM's cgroup in; if empty, a cgroup named after the Firecracker binary is used
+	ParentCgroup string
+	// Resource limits for the VMM, e.g. `{"no-file": 2048, "fsize": 1073741824}`; the jailer's defaults are used for resources that aren't set here
+	ResourceLimits map[string]uint64
+
 	EnableOutput bool
 	EnableInput  bool
 }
 
+type NetworkInterfaceConfiguration struct {
+	Interface string `json:"interface"`
+	MAC       string `json:"mac"`
+
+	// Optional limits for traffic received and transmitted by the guest
+	RxRateLimiter *hypervisor.RateLimiter `json:"rxRateLimiter"`
+	TxRateLimiter *hypervisor.RateLimiter `json:"txRateLimiter"`
+}
+
 type NetworkConfiguration struct {
-	Interface string
-	MAC       string
+	// Tap devices in the network namespace to attach to the VM, in the order that the guest sees them in (e.g. `eth0`, `eth1`)
+	Interfaces []NetworkInterfaceConfiguration
 }
 
 type VMConfiguration struct {
-	CPUCount    int
-	MemorySize  int
-	CPUTemplate string
+	CPUCount    int    `json:"cpuCount"`
+	MemorySize  int    `json:"memorySize"`
+	CPUTemplate string `json:"cpuTemplate"`
+
+	BootArgs string `json:"bootArgs"`
 
-	BootArgs string
+	// Balloon device to attach to the VM; if nil, the VM has no balloon device
+	Balloon *hypervisor.Balloon `json:"balloon"`
+
+	// Metadata service to enable; its configuration is part of the snapshot, but its data isn't, so set the data with
+	// `ResumedRunner.PutMMDS` after resuming. If nil, the guest has no metadata service.
+	MMDS *hypervisor.MMDS `json:"mmds"`
 }
 
 func CreateSnapshot(
@@ -78,8 +118,10 @@ func CreateSnapshot(
 
 	vmConfiguration VMConfiguration,
 	livenessConfiguration LivenessConfiguration,
+	readinessConfiguration ReadinessConfiguration,
 
 	hypervisorConfiguration HypervisorConfiguration,
+	hypervisorRuntime HypervisorRuntime,
 	networkConfiguration NetworkConfiguration,
 	agentConfiguration AgentConfiguration,
 ) (errs error) {
@@ -96,29 +138,12 @@ func CreateSnapshot(
 		panic(errors.Join(ErrCouldNotCreateChrootBaseDirectory, err))
 	}
 
-	server, err := firecracker.StartFirecrackerServer(
-		goroutineManager.Context(),
-
-		hypervisorConfiguration.FirecrackerBin,
-		hypervisorConfiguration.JailerBin,
-
-		hypervisorConfiguration.ChrootBaseDir,
-
-		hypervisorConfiguration.UID,
-		hypervisorConfiguration.GID,
-
-		hypervisorConfiguration.NetNS,
-		hypervisorConfiguration.NumaNode,
-		hypervisorConfiguration.CgroupVersion,
-
-		hypervisorConfiguration.EnableOutput,
-		hypervisorConfiguration.EnableInput,
-	)
-	if err != nil {
+	server := NewHypervisor(hypervisorConfiguration, hypervisorRuntime)
+	if err := server.Start(goroutineManager.Context(), hypervisor.MonitoringHooks{}); err != nil {
 		panic(errors.Join(ErrCouldNotStartFirecrackerServer, err))
 	}
 	defer server.Close()
-	defer os.RemoveAll(filepath.Dir(server.VMPath)) // Remove `firecracker/$id`, not just `firecracker/$id/root`
+	defer os.RemoveAll(filepath.Dir(server.VMPath())) // Remove `firecracker/$id`, not just `firecracker/$id/root`
 
 	goroutineManager.StartForegroundGoroutine(func(_ context.Context) {
 		if err := server.Wait(); err != nil {
@@ -127,7 +152,7 @@ func CreateSnapshot(
 	})
 
 	liveness := ipc.NewLivenessServer(
-		filepath.Join(server.VMPath, VSockName),
+		filepath.Join(server.VMPath(), VSockName),
 		uint32(livenessConfiguration.LivenessVSockPort),
 	)
 
@@ -142,7 +167,7 @@ func CreateSnapshot(
 	}
 
 	agent, err := ipc.StartAgentServer[struct{}, ipc.AgentServerRemote[struct{}]](
-		filepath.Join(server.VMPath, VSockName),
+		filepath.Join(server.VMPath(), VSockName),
 		uint32(agentConfiguration.AgentVSockPort),
 
 		struct{}{},
@@ -156,14 +181,6 @@ func CreateSnapshot(
 		panic(errors.Join(ErrCouldNotChownAgentServerVSock, err))
 	}
 
-	client := &http.Client{
-		Transport: &http.Transport{
-			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-				return (&net.Dialer{}).DialContext(ctx, "unix", filepath.Join(server.VMPath, firecracker.FirecrackerSocketName))
-			},
-		},
-	}
-
 	defer func() {
 		defer goroutineManager.CreateForegroundPanicCollector()()
 
@@ -172,7 +189,7 @@ func CreateSnapshot(
 		}
 
 		for _, device := range devices {
-			inputFile, err := os.Open(filepath.Join(server.VMPath, device.Name))
+			inputFile, err := os.Open(filepath.Join(server.VMPath(), device.Name))
 			if err != nil {
 				panic(errors.Join(ErrCouldNotOpenInputFile, err))
 			}
@@ -188,7 +205,7 @@ func CreateSnapshot(
 			}
 			defer outputFile.Close()
 
-			deviceSize, err := io.Copy(outputFile, inputFile)
+			deviceSize, err := iutils.CopySparse(outputFile, inputFile)
 			if err != nil {
 				panic(errors.Join(ErrCouldNotCopyFile, err))
 			}
@@ -203,42 +220,59 @@ func CreateSnapshot(
 	// We need to stop the Firecracker process from using the mount before we can unmount it
 	defer server.Close()
 
-	disks := []string{}
+	disks := []hypervisor.Disk{}
 	for _, device := range devices {
 		if strings.TrimSpace(device.Input) != "" {
-			if _, err := iutils.CopyFile(device.Input, filepath.Join(server.VMPath, device.Name), hypervisorConfiguration.UID, hypervisorConfiguration.GID); err != nil {
+			if _, err := iutils.CopyFile(device.Input, filepath.Join(server.VMPath(), device.Name), hypervisorConfiguration.UID, hypervisorConfiguration.GID); err != nil {
 				panic(errors.Join(ErrCouldNotCopyDeviceFile, err))
 			}
 		}
 
-		if !slices.Contains(packager.KnownNames, device.Name) || device.Name == packager.DiskName {
-			disks = append(disks, device.Name)
+		if isDisk(device.Name) {
+			disks = append(disks, hypervisor.Disk{
+				Name: device.Name,
+
+				RateLimiter: device.RateLimiter,
+			})
 		}
 	}
 
-	if err := firecracker.StartVM(
+	networkInterfaces := []hypervisor.NetworkInterface{}
+	for _, networkInterface := range networkConfiguration.Interfaces {
+		networkInterfaces = append(networkInterfaces, hypervisor.NetworkInterface{
+			HostInterface: networkInterface.Interface,
+			GuestMAC:      networkInterface.MAC,
+
+			RxRateLimiter: networkInterface.RxRateLimiter,
+			TxRateLimiter: networkInterface.TxRateLimiter,
+		})
+	}
+
+	if err := server.Boot(
 		goroutineManager.Context(),
 
-		client,
+		hypervisor.BootConfiguration{
+			KernelPath: packager.KernelName,
 
-		packager.KernelName,
+			Disks: disks,
 
-		disks,
+			CPUCount:    vmConfiguration.CPUCount,
+			MemorySize:  vmConfiguration.MemorySize,
+			CPUTemplate: vmConfiguration.CPUTemplate,
+			BootArgs:    vmConfiguration.BootArgs,
 
-		vmConfiguration.CPUCount,
-		vmConfiguration.MemorySize,
-		vmConfiguration.CPUTemplate,
-		vmConfiguration.BootArgs,
+			Balloon: vmConfiguration.Balloon,
 
-		networkConfiguration.Interface,
-		networkConfiguration.MAC,
+			NetworkInterfaces: networkInterfaces,
+			MMDS:              vmConfiguration.MMDS,
 
-		VSockName,
-		ipc.VSockCIDGuest,
+			VSockPath: VSockName,
+			VSockCID:  ipc.VSockCIDGuest,
+		},
 	); err != nil {
 		panic(errors.Join(ErrCouldNotStartVM, err))
 	}
-	defer os.Remove(filepath.Join(server.VMPath, VSockName))
+	defer os.Remove(filepath.Join(server.VMPath(), VSockName))
 
 	{
 		receiveCtx, cancel := context.WithTimeout(goroutineManager.Context(), livenessConfiguration.ResumeTimeout)
@@ -270,8 +304,35 @@ func CreateSnapshot(
 				panic(errors.Join(ErrCouldNotWaitForAcceptingAgent, err))
 			}
 		})
+	}
+
+	if len(readinessConfiguration.Probes) > 0 {
+		readinessCtx := goroutineManager.Context()
+		if readinessConfiguration.Timeout > 0 {
+			var cancel context.CancelFunc
+			readinessCtx, cancel = context.WithTimeout(readinessCtx, readinessConfiguration.Timeout)
+			defer cancel()
+		}
+
+		if err := waitForReadiness(
+			readinessCtx,
+
+			readinessConfiguration.Probes,
+			readinessConfiguration.Interval,
+			readinessConfiguration.AttemptTimeout,
+
+			hypervisorConfiguration.NetNS,
+			acceptingAgent.Remote.Probe,
+		); err != nil {
+			panic(err)
+		}
+	}
+
+	{
+		beforeSuspendCtx, cancel := context.WithTimeout(goroutineManager.Context(), agentConfiguration.ResumeTimeout)
+		defer cancel()
 
-		if err := acceptingAgent.Remote.BeforeSuspend(acceptCtx); err != nil {
+		if err := acceptingAgent.Remote.BeforeSuspend(beforeSuspendCtx); err != nil {
 			panic(errors.Join(ErrCouldNotBeforeSuspend, err))
 		}
 	}
@@ -283,27 +344,52 @@ func CreateSnapshot(
 	}
 	agent.Close()
 
-	if err := firecracker.CreateSnapshot(
+	if err := server.CreateSnapshot(
 		goroutineManager.Context(),
 
-		client,
-
 		packager.StateName,
 		packager.MemoryName,
 
-		firecracker.SnapshotTypeFull,
+		hypervisor.SnapshotTypeFull,
 	); err != nil {
 		panic(errors.Join(ErrCouldNotCreateSnapshot, err))
 	}
 
+	hostConfiguration, err := GetHostConfiguration()
+	if err != nil {
+		panic(errors.Join(ErrCouldNotGetHostConfiguration, err))
+	}
+
+	// Packages created with hypervisors that don't know their version aren't checked against the version of the VMM that resumes them
+	firecrackerVersion := ""
+	if versionReporter, ok := server.(hypervisor.VersionReporter); ok {
+		firecrackerVersion, err = versionReporter.Version(goroutineManager.Context())
+		if err != nil {
+			panic(errors.Join(ErrCouldNotGetVMMVersion, err))
+		}
+	}
+
+	deviceNames := []string{}
+	for _, device := range devices {
+		deviceNames = append(deviceNames, device.Name)
+	}
+
 	packageConfig, err := json.Marshal(PackageConfiguration{
 		AgentVSockPort: agentConfiguration.AgentVSockPort,
+
+		VM:      &vmConfiguration,
+		Devices: deviceNames,
+
+		Host: &hostConfiguration,
+
+		DrafterVersion:     GetDrafterVersion(),
+		FirecrackerVersion: firecrackerVersion,
 	})
 	if err != nil {
 		panic(errors.Join(ErrCouldNotMarshalPackageConfig, err))
 	}
 
-	outputFile, err := os.OpenFile(filepath.Join(server.VMPath, packager.ConfigName), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.ModePerm)
+	outputFile, err := os.OpenFile(filepath.Join(server.VMPath(), packager.ConfigName), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.ModePerm)
 	if err != nil {
 		panic(errors.Join(ErrCouldNotOpenPackageConfigFile, err))
 	}
@@ -313,9 +399,15 @@ func CreateSnapshot(
 		panic(errors.Join(ErrCouldNotWritePackageConfig, err))
 	}
 
-	if err := os.Chown(filepath.Join(server.VMPath, packager.ConfigName), hypervisorConfiguration.UID, hypervisorConfiguration.GID); err != nil {
+	if err := os.Chown(filepath.Join(server.VMPath(), packager.ConfigName), hypervisorConfiguration.UID, hypervisorConfiguration.GID); err != nil {
 		panic(errors.Join(ErrCouldNotChownPackageConfigFile, err))
 	}
 
 	return
 }
+
+// isDisk returns whether the device `name` is attached to the VM as a disk, which is the case for all devices except for the kernel,
+// the snapshot and the package config
+func isDisk(name string) bool {
+	return !slices.Contains(packager.KnownNames, name) || name == packager.DiskName
+}
@@ -27,4 +27,21 @@ var (
 	ErrCouldNotWaitForAcceptingAgent         = errors.New("could not wait for accepting agent")
 	ErrCouldNotCloseAcceptingAgent           = errors.New("could not close accepting agent")
 	ErrCouldNotCreateSnapshot                = errors.New("could not create snapshot")
+	ErrHypervisorNotStarted                  = errors.New("hypervisor not started")
+	ErrCouldNotPlaceVM                       = errors.New("could not place VM")
+	ErrCouldNotReadCPUFeatures               = errors.New("could not read CPU features")
+	ErrCouldNotGetHostConfiguration          = errors.New("could not get host configuration")
+	ErrCouldNotGetVMMVersion                 = errors.New("could not get VMM version")
+	ErrIncompatiblePackage                   = errors.New("package is incompatible with this host")
+	ErrVMNotReady                            = errors.New("VM did not become ready")
+	ErrUnknownReadinessProbeType             = errors.New("unknown readiness probe type")
+	ErrCouldNotCreateReadinessRequest        = errors.New("could not create readiness probe request")
+	ErrReadinessProbeNotSupported            = errors.New("readiness probe is not supported by the agent")
+	ErrCouldNotGetOriginalNSHandle           = errors.New("could not get original namespace handle")
+	ErrCouldNotGetNSHandle                   = errors.New("could not get namespace handle")
+	ErrCouldNotSetNSHandle                   = errors.New("could not set namespace handle")
+	ErrCouldNotSetOriginalNSHandle           = errors.New("could not set original namespace handle")
+	ErrCouldNotMergeDiffSnapshot             = errors.New("could not merge diff snapshot")
+	ErrCouldNotSeekDiffSnapshot              = errors.New("could not seek in diff snapshot")
+	ErrSparseFilesNotSupported               = errors.New("filesystem does not support finding holes in sparse files")
 )
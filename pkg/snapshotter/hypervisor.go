@@ -0,0 +1,556 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	v1 "github.com/loopholelabs/drafter/internal/api/http/firecracker/v1"
+	"github.com/loopholelabs/drafter/internal/firecracker"
+	"github.com/loopholelabs/drafter/pkg/console"
+	"github.com/loopholelabs/drafter/pkg/cpuset"
+	"github.com/loopholelabs/drafter/pkg/hypervisor"
+)
+
+// HypervisorFactory creates a new, unstarted hypervisor backend for a VM; if `vmConsole` is set, the VM's serial console is connected to it
+type HypervisorFactory func(hypervisorConfiguration HypervisorConfiguration, vmConsole *console.Console) hypervisor.Hypervisor
+
+// HypervisorRuntime contains the objects that a VM's hypervisor uses while it is running; unlike the `HypervisorConfiguration`,
+// they can't be stored or sent to another host
+type HypervisorRuntime struct {
+	// Serial console to connect the VM to; if set, the VM's input is read from the console instead of stdin (`EnableInput` is ignored),
+	// and its output is written to the console in addition to stdout (if `EnableOutput` is set)
+	Console *console.Console
+
+	// Backend to run the VM with; if nil, Firecracker is started through the jailer (see `NewFirecrackerHypervisor`)
+	Backend HypervisorFactory
+}
+
+// NewHypervisor creates a hypervisor with the backend of `hypervisorRuntime`, or Firecracker if no backend is set
+func NewHypervisor(hypervisorConfiguration HypervisorConfiguration, hypervisorRuntime HypervisorRuntime) hypervisor.Hypervisor {
+	if hypervisorRuntime.Backend != nil {
+		return hypervisorRuntime.Backend(hypervisorConfiguration, hypervisorRuntime.Console)
+	}
+
+	return NewFirecrackerHypervisor(hypervisorConfiguration, hypervisorRuntime.Console)
+}
+
+type FirecrackerHypervisor struct {
+	hypervisorConfiguration HypervisorConfiguration
+	console                 *console.Console
+
+	server *firecracker.FirecrackerServer
+	client *http.Client
+
+	cpus []int
+}
+
+// Firecracker supports all optional capabilities of a hypervisor
+var (
+	_ hypervisor.VersionReporter    = (*FirecrackerHypervisor)(nil)
+	_ hypervisor.CgroupReporter     = (*FirecrackerHypervisor)(nil)
+	_ hypervisor.RateLimiterUpdater = (*FirecrackerHypervisor)(nil)
+	_ hypervisor.DriveSwapper       = (*FirecrackerHypervisor)(nil)
+	_ hypervisor.BalloonController  = (*FirecrackerHypervisor)(nil)
+	_ hypervisor.MMDSController     = (*FirecrackerHypervisor)(nil)
+	_ hypervisor.MetricsFlusher     = (*FirecrackerHypervisor)(nil)
+	_ hypervisor.ShutdownRequester  = (*FirecrackerHypervisor)(nil)
+	_ hypervisor.Msyncer            = (*FirecrackerHypervisor)(nil)
+	_ hypervisor.CPUSetReporter     = (*FirecrackerHypervisor)(nil)
+)
+
+// NewFirecrackerHypervisor creates a hypervisor backend for the Firecracker fork with live migration support, which is started through the jailer
+func NewFirecrackerHypervisor(hypervisorConfiguration HypervisorConfiguration, vmConsole *console.Console) hypervisor.Hypervisor {
+	return &FirecrackerHypervisor{
+		hypervisorConfiguration: hypervisorConfiguration,
+		console:                 vmConsole,
+	}
+}
+
+func (f *FirecrackerHypervisor) Start(ctx context.Context, hooks hypervisor.MonitoringHooks) error {
+	var monitoringHooks firecracker.MonitoringHooks
+	if hook := hooks.OnMetrics; hook != nil {
+		monitoringHooks.OnMetrics = func(metrics *v1.Metrics) {
+			hook(fromFirecrackerMetrics(metrics))
+		}
+	}
+
+	if hook := hooks.OnLog; hook != nil {
+		monitoringHooks.OnLog = func(entry *firecracker.LogEntry) {
+			hook(hypervisor.LogEntry{
+				Time: entry.Time,
+
+				Thread: entry.Thread,
+				Level:  entry.Level,
+
+				Message: entry.Message,
+			})
+		}
+	}
+
+	monitoringHooks.OnError = hooks.OnError
+
+	// We can't pass a nil `*console.Console` directly since the interface wouldn't be nil
+	var consoleReadWriter firecracker.Console
+	if f.console != nil {
+		consoleReadWriter = f.console
+	}
+
+	numaNode, cpus, err := cpuset.Place(f.hypervisorConfiguration.NumaNode, f.hypervisorConfiguration.CPUs)
+	if err != nil {
+		return errors.Join(ErrCouldNotPlaceVM, err)
+	}
+	f.cpus = cpus
+
+	f.server, err = firecracker.StartFirecrackerServer(
+		ctx,
+
+		f.hypervisorConfiguration.FirecrackerBin,
+		f.hypervisorConfiguration.JailerBin,
+
+		f.hypervisorConfiguration.ChrootBaseDir,
+
+		f.hypervisorConfiguration.UID,
+		f.hypervisorConfiguration.GID,
+
+		f.hypervisorConfiguration.NetNS,
+		numaNode,
+		cpuset.FormatList(cpus),
+		f.hypervisorConfiguration.CgroupVersion,
+
+		f.hypervisorConfiguration.Cgroups,
+		f.hypervisorConfiguration.ParentCgroup,
+		f.hypervisorConfiguration.ResourceLimits,
+
+		f.hypervisorConfiguration.EnableOutput,
+		f.hypervisorConfiguration.EnableInput,
+
+		consoleReadWriter,
+
+		monitoringHooks,
+	)
+	if err != nil {
+		return err
+	}
+
+	socketPath := filepath.Join(f.server.VMPath, firecracker.FirecrackerSocketName)
+	f.client = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+
+	return nil
+}
+
+func (f *FirecrackerHypervisor) VMPath() string {
+	if f.server == nil {
+		return ""
+	}
+
+	return f.server.VMPath
+}
+
+func (f *FirecrackerHypervisor) VMPid() int {
+	if f.server == nil {
+		return 0
+	}
+
+	return f.server.VMPid
+}
+
+func (f *FirecrackerHypervisor) CgroupPath() string {
+	if f.server == nil {
+		return ""
+	}
+
+	return f.server.CgroupPath
+}
+
+func (f *FirecrackerHypervisor) CPUs() []int {
+	return f.cpus
+}
+
+func (f *FirecrackerHypervisor) Version(ctx context.Context) (string, error) {
+	if f.client == nil {
+		return "", ErrHypervisorNotStarted
+	}
+
+	return firecracker.GetVersion(ctx, f.client)
+}
+
+func (f *FirecrackerHypervisor) Boot(ctx context.Context, configuration hypervisor.BootConfiguration) error {
+	if f.client == nil {
+		return ErrHypervisorNotStarted
+	}
+
+	drives := []v1.Drive{}
+	for _, disk := range configuration.Disks {
+		drives = append(drives, v1.Drive{
+			DriveID:      disk.Name,
+			PathOnHost:   disk.Name,
+			IsRootDevice: false,
+			IsReadOnly:   false,
+			RateLimiter:  toFirecrackerRateLimiter(disk.RateLimiter),
+		})
+	}
+
+	networkInterfaces := []v1.NetworkInterface{}
+	for _, networkInterface := range configuration.NetworkInterfaces {
+		networkInterfaces = append(networkInterfaces, v1.NetworkInterface{
+			IfaceID:       networkInterface.HostInterface,
+			GuestMAC:      networkInterface.GuestMAC,
+			HostDevName:   networkInterface.HostInterface,
+			RxRateLimiter: toFirecrackerRateLimiter(networkInterface.RxRateLimiter),
+			TxRateLimiter: toFirecrackerRateLimiter(networkInterface.TxRateLimiter),
+		})
+	}
+
+	var balloon *v1.Balloon
+	if configuration.Balloon != nil {
+		balloon = &v1.Balloon{
+			AmountMib:             configuration.Balloon.AmountMiB,
+			DeflateOnOOM:          configuration.Balloon.DeflateOnOOM,
+			StatsPollingIntervalS: int(configuration.Balloon.StatisticsInterval.Round(time.Second).Seconds()),
+			FreePageReporting:     configuration.Balloon.FreePageReporting,
+		}
+	}
+
+	var mmdsConfig *v1.MMDSConfig
+	if configuration.MMDS != nil {
+		mmdsConfig = &v1.MMDSConfig{
+			Version:           string(configuration.MMDS.Version),
+			NetworkInterfaces: configuration.MMDS.NetworkInterfaces,
+			IPv4Address:       configuration.MMDS.IPv4Address,
+		}
+	}
+
+	if err := firecracker.StartVM(
+		ctx,
+
+		f.client,
+
+		configuration.KernelPath,
+
+		drives,
+
+		configuration.CPUCount,
+		configuration.MemorySize,
+		configuration.CPUTemplate,
+		configuration.BootArgs,
+
+		balloon,
+
+		networkInterfaces,
+		mmdsConfig,
+
+		configuration.VSockPath,
+		configuration.VSockCID,
+	); err != nil {
+		return err
+	}
+
+	return f.pinVCPUs()
+}
+
+func (f *FirecrackerHypervisor) Pause(ctx context.Context) error {
+	if f.client == nil {
+		return ErrHypervisorNotStarted
+	}
+
+	return firecracker.PauseVM(ctx, f.client)
+}
+
+func (f *FirecrackerHypervisor) Resume(ctx context.Context) error {
+	if f.client == nil {
+		return ErrHypervisorNotStarted
+	}
+
+	return firecracker.ResumeVM(ctx, f.client)
+}
+
+func (f *FirecrackerHypervisor) UpdateDriveRateLimiter(ctx context.Context, name string, rateLimiter *hypervisor.RateLimiter) error {
+	if f.client == nil {
+		return ErrHypervisorNotStarted
+	}
+
+	return firecracker.UpdateDrive(
+		ctx,
+		f.client,
+
+		v1.PartialDrive{
+			DriveID:     name,
+			RateLimiter: toFirecrackerRateLimiter(rateLimiter),
+		},
+	)
+}
+
+func (f *FirecrackerHypervisor) UpdateDrivePath(ctx context.Context, name string, path string) error {
+	if f.client == nil {
+		return ErrHypervisorNotStarted
+	}
+
+	return firecracker.UpdateDrive(
+		ctx,
+		f.client,
+
+		v1.PartialDrive{
+			DriveID:    name,
+			PathOnHost: path,
+		},
+	)
+}
+
+func (f *FirecrackerHypervisor) UpdateNetworkInterfaceRateLimiters(ctx context.Context, hostInterface string, rxRateLimiter, txRateLimiter *hypervisor.RateLimiter) error {
+	if f.client == nil {
+		return ErrHypervisorNotStarted
+	}
+
+	return firecracker.UpdateNetworkInterface(
+		ctx,
+		f.client,
+
+		v1.PartialNetworkInterface{
+			IfaceID:       hostInterface,
+			RxRateLimiter: toFirecrackerRateLimiter(rxRateLimiter),
+			TxRateLimiter: toFirecrackerRateLimiter(txRateLimiter),
+		},
+	)
+}
+
+func (f *FirecrackerHypervisor) UpdateBalloon(ctx context.Context, amountMiB int) error {
+	if f.client == nil {
+		return ErrHypervisorNotStarted
+	}
+
+	return firecracker.UpdateBalloon(ctx, f.client, amountMiB)
+}
+
+func (f *FirecrackerHypervisor) GetBalloonStatistics(ctx context.Context) (*hypervisor.BalloonStatistics, error) {
+	if f.client == nil {
+		return nil, ErrHypervisorNotStarted
+	}
+
+	balloonStatistics, err := firecracker.GetBalloonStatistics(ctx, f.client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hypervisor.BalloonStatistics{
+		TargetMiB: balloonStatistics.TargetMib,
+		ActualMiB: balloonStatistics.ActualMib,
+
+		FreeMemory:      balloonStatistics.FreeMemory,
+		TotalMemory:     balloonStatistics.TotalMemory,
+		AvailableMemory: balloonStatistics.AvailableMemory,
+		DiskCaches:      balloonStatistics.DiskCaches,
+	}, nil
+}
+
+func (f *FirecrackerHypervisor) PutMMDS(ctx context.Context, data any) error {
+	if f.client == nil {
+		return ErrHypervisorNotStarted
+	}
+
+	return firecracker.PutMMDS(ctx, f.client, data)
+}
+
+func (f *FirecrackerHypervisor) PatchMMDS(ctx context.Context, patch any) error {
+	if f.client == nil {
+		return ErrHypervisorNotStarted
+	}
+
+	return firecracker.PatchMMDS(ctx, f.client, patch)
+}
+
+func (f *FirecrackerHypervisor) FlushMetrics(ctx context.Context) error {
+	if f.client == nil {
+		return ErrHypervisorNotStarted
+	}
+
+	return firecracker.FlushMetrics(ctx, f.client)
+}
+
+func (f *FirecrackerHypervisor) RequestShutdown(ctx context.Context) error {
+	if f.client == nil {
+		return ErrHypervisorNotStarted
+	}
+
+	return firecracker.SendCtrlAltDel(ctx, f.client)
+}
+
+func (f *FirecrackerHypervisor) CreateSnapshot(ctx context.Context, statePath, memoryPath string, snapshotType hypervisor.SnapshotType) error {
+	if f.client == nil {
+		return ErrHypervisorNotStarted
+	}
+
+	var st firecracker.SnapshotType
+	switch snapshotType {
+	case hypervisor.SnapshotTypeFull:
+		st = firecracker.SnapshotTypeFull
+	case hypervisor.SnapshotTypeDiff:
+		st = firecracker.SnapshotTypeDiff
+
+	default:
+		return firecracker.ErrUnknownSnapshotType
+	}
+
+	return firecracker.CreateSnapshot(
+		ctx,
+
+		f.client,
+
+		statePath,
+		memoryPath,
+
+		st,
+	)
+}
+
+func (f *FirecrackerHypervisor) Msync(ctx context.Context, statePath string) error {
+	if f.client == nil {
+		return ErrHypervisorNotStarted
+	}
+
+	return firecracker.CreateSnapshot(ctx, f.client, statePath, "", firecracker.SnapshotTypeMsync)
+}
+
+func (f *FirecrackerHypervisor) MsyncAndSnapshotState(ctx context.Context, statePath string) error {
+	if f.client == nil {
+		return ErrHypervisorNotStarted
+	}
+
+	return firecracker.CreateSnapshot(ctx, f.client, statePath, "", firecracker.SnapshotTypeMsyncAndState)
+}
+
+func (f *FirecrackerHypervisor) ResumeSnapshot(ctx context.Context, statePath, memoryPath string, shared, trackDirtyPages bool) error {
+	if f.client == nil {
+		return ErrHypervisorNotStarted
+	}
+
+	if err := firecracker.ResumeSnapshot(
+		ctx,
+
+		f.client,
+
+		statePath,
+		memoryPath,
+
+		shared,
+		trackDirtyPages,
+	); err != nil {
+		return err
+	}
+
+	return f.pinVCPUs()
+}
+
+// pinVCPUs pins the vCPU threads, which only exist once the VM has been started or a snapshot has been loaded
+func (f *FirecrackerHypervisor) pinVCPUs() error {
+	if !f.hypervisorConfiguration.PinVCPUs {
+		return nil
+	}
+
+	return firecracker.PinVCPUs(f.server.VMPid, f.cpus)
+}
+
+func (f *FirecrackerHypervisor) Wait() error {
+	if f.server == nil {
+		return nil
+	}
+
+	return f.server.Wait()
+}
+
+func (f *FirecrackerHypervisor) Close() error {
+	if f.server == nil {
+		return nil
+	}
+
+	return f.server.Close()
+}
+
+// toFirecrackerTokenBucket converts a bucket; nil buckets are converted to a bucket with a size of 0, which disables it. Firecracker leaves
+// buckets that are missing from updates unchanged, so we can't just omit them.
+func toFirecrackerTokenBucket(tokenBucket *hypervisor.TokenBucket) *v1.TokenBucket {
+	if tokenBucket == nil {
+		return &v1.TokenBucket{}
+	}
+
+	return &v1.TokenBucket{
+		Size:         tokenBucket.Size,
+		OneTimeBurst: tokenBucket.OneTimeBurst,
+		RefillTime:   tokenBucket.RefillTime.Milliseconds(),
+	}
+}
+
+func toFirecrackerRateLimiter(rateLimiter *hypervisor.RateLimiter) *v1.RateLimiter {
+	if rateLimiter == nil {
+		return nil
+	}
+
+	return &v1.RateLimiter{
+		Bandwidth: toFirecrackerTokenBucket(rateLimiter.Bandwidth),
+		Ops:       toFirecrackerTokenBucket(rateLimiter.Ops),
+	}
+}
+
+func fromFirecrackerMetrics(metrics *v1.Metrics) hypervisor.Metrics {
+	disks := map[string]hypervisor.DiskMetrics{}
+	for driveID, blockMetrics := range metrics.Block {
+		disks[driveID] = hypervisor.DiskMetrics{
+			ReadBytes:  blockMetrics.ReadBytes,
+			WriteBytes: blockMetrics.WriteBytes,
+			ReadCount:  blockMetrics.ReadCount,
+			WriteCount: blockMetrics.WriteCount,
+			FlushCount: blockMetrics.FlushCount,
+
+			RateLimiterThrottledEvents: blockMetrics.RateLimiterThrottledEvents,
+		}
+	}
+
+	networkInterfaces := map[string]hypervisor.NetworkInterfaceMetrics{}
+	for ifaceID, netMetrics := range metrics.Net {
+		networkInterfaces[ifaceID] = hypervisor.NetworkInterfaceMetrics{
+			RxBytes:   netMetrics.RxBytesCount,
+			RxPackets: netMetrics.RxPacketsCount,
+			TxBytes:   netMetrics.TxBytesCount,
+			TxPackets: netMetrics.TxPacketsCount,
+
+			RxRateLimiterThrottledEvents: netMetrics.RxRateLimiterThrottled,
+			TxRateLimiterThrottledEvents: netMetrics.TxRateLimiterThrottled,
+		}
+	}
+
+	return hypervisor.Metrics{
+		Time: time.UnixMilli(metrics.UTCTimestampMs),
+
+		VCPU: hypervisor.VCPUMetrics{
+			ExitIOIn:      metrics.VCPU.ExitIOIn,
+			ExitIOOut:     metrics.VCPU.ExitIOOut,
+			ExitMMIORead:  metrics.VCPU.ExitMMIORead,
+			ExitMMIOWrite: metrics.VCPU.ExitMMIOWrite,
+
+			Failures: metrics.VCPU.Failures,
+		},
+
+		Disks:             disks,
+		NetworkInterfaces: networkInterfaces,
+
+		VSock: hypervisor.VSockMetrics{
+			RxBytes:   metrics.VSock.RxBytesCount,
+			RxPackets: metrics.VSock.RxPacketsCount,
+			TxBytes:   metrics.VSock.TxBytesCount,
+			TxPackets: metrics.VSock.TxPacketsCount,
+
+			ConnectionsAdded:   metrics.VSock.ConnsAdded,
+			ConnectionsKilled:  metrics.VSock.ConnsKilled,
+			ConnectionsRemoved: metrics.VSock.ConnsRemoved,
+		},
+	}
+}
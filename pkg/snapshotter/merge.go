@@ -0,0 +1,124 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	iutils "github.com/loopholelabs/drafter/internal/utils"
+	"golang.org/x/sys/unix"
+)
+
+type MergeDiffSnapshotsHooks struct {
+	OnBeforeMergeDiffSnapshot func(path string)
+}
+
+// MergeDiffSnapshots applies the memory files of a chain of diff snapshots (oldest first) to the memory file at `basePath`
+// and writes the result to `outputPath`, which can be `basePath` to merge in place.
+// Diff snapshot memory files are sparse; only their data regions (the pages that changed) are copied.
+func MergeDiffSnapshots(
+	ctx context.Context,
+
+	basePath string,
+	diffPaths []string,
+
+	outputPath string,
+
+	hooks MergeDiffSnapshotsHooks,
+) error {
+	outputFile, err := os.OpenFile(outputPath, os.O_CREATE|os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		return errors.Join(ErrCouldNotCreateOutputFile, err)
+	}
+	defer outputFile.Close()
+
+	if outputPath != basePath {
+		baseFile, err := os.Open(basePath)
+		if err != nil {
+			return errors.Join(ErrCouldNotOpenInputFile, err)
+		}
+		defer baseFile.Close()
+
+		if _, err := iutils.CopySparse(outputFile, baseFile); err != nil {
+			return errors.Join(ErrCouldNotCopyFile, err)
+		}
+	}
+
+	for _, diffPath := range diffPaths {
+		if hook := hooks.OnBeforeMergeDiffSnapshot; hook != nil {
+			hook(diffPath)
+		}
+
+		if err := mergeDiffSnapshot(ctx, diffPath, outputFile); err != nil {
+			return errors.Join(ErrCouldNotMergeDiffSnapshot, err)
+		}
+	}
+
+	return nil
+}
+
+func mergeDiffSnapshot(ctx context.Context, diffPath string, outputFile *os.File) error {
+	diffFile, err := os.Open(diffPath)
+	if err != nil {
+		return errors.Join(ErrCouldNotOpenInputFile, err)
+	}
+	defer diffFile.Close()
+
+	diffInfo, err := diffFile.Stat()
+	if err != nil {
+		return errors.Join(ErrCouldNotGetDeviceStat, err)
+	}
+
+	diffSize := diffInfo.Size()
+	fd := int(diffFile.Fd())
+
+	// Filesystems without `SEEK_HOLE` support report the entire file as data, which would overwrite unchanged pages with zeros
+	if diffStat, ok := diffInfo.Sys().(*syscall.Stat_t); ok && diffStat.Blocks*512 < diffSize {
+		firstHole, err := unix.Seek(fd, 0, unix.SEEK_HOLE)
+		if err != nil {
+			return errors.Join(ErrCouldNotSeekDiffSnapshot, err)
+		}
+
+		if firstHole >= diffSize {
+			return ErrSparseFilesNotSupported
+		}
+	}
+
+	for offset := int64(0); offset < diffSize; {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		default:
+			break
+		}
+
+		dataStart, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if err != nil {
+			// There is no more data after `offset`
+			if errors.Is(err, unix.ENXIO) {
+				break
+			}
+
+			return errors.Join(ErrCouldNotSeekDiffSnapshot, err)
+		}
+
+		dataEnd, err := unix.Seek(fd, dataStart, unix.SEEK_HOLE)
+		if err != nil {
+			return errors.Join(ErrCouldNotSeekDiffSnapshot, err)
+		}
+
+		if _, err := io.Copy(
+			io.NewOffsetWriter(outputFile, dataStart),
+			io.NewSectionReader(diffFile, dataStart, dataEnd-dataStart),
+		); err != nil {
+			return errors.Join(ErrCouldNotCopyFile, err)
+		}
+
+		offset = dataEnd
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/vishvananda/netns"
+)
+
+type ReadinessProbeType string
+
+const (
+	// ReadinessProbeTypeTCP succeeds once a TCP connection to `Address` can be established
+	ReadinessProbeTypeTCP ReadinessProbeType = "tcp"
+	// ReadinessProbeTypeHTTP succeeds once a GET request to `URL` returns a 2xx or 3xx status code
+	ReadinessProbeTypeHTTP ReadinessProbeType = "http"
+	// ReadinessProbeTypeExec succeeds once the agent can run `Command` in the guest without it failing
+	ReadinessProbeTypeExec ReadinessProbeType = "exec"
+)
+
+type ReadinessProbe struct {
+	Type ReadinessProbeType `json:"type"`
+
+	// Guest address to connect to from the VM's network namespace, e.g. `172.16.0.2:6379` (only for TCP probes)
+	Address string `json:"address"`
+	// Guest URL to request from the VM's network namespace, e.g. `http://172.16.0.2:8080/healthz` (only for HTTP probes)
+	URL string `json:"url"`
+	// Command for the agent to run with the guest's shell (only for exec probes)
+	Command string `json:"command"`
+}
+
+type ReadinessConfiguration struct {
+	// Probes that all need to succeed before the VM is snapshotted; they are run in order after the agent has connected
+	Probes []ReadinessProbe
+
+	// Time to wait between failed attempts of a probe
+	Interval time.Duration
+	// Maximum amount of time for a single attempt of a probe, after which it is cancelled and counts as failed; if 0, `Interval` is used
+	AttemptTimeout time.Duration
+	// Maximum amount of time to wait for all probes to succeed; if 0, there is no limit
+	Timeout time.Duration
+}
+
+// waitForReadiness runs every probe until it succeeds, and returns the last error of the probe that didn't succeed if `ctx` is cancelled first
+func waitForReadiness(
+	ctx context.Context,
+
+	probes []ReadinessProbe,
+	interval time.Duration,
+	attemptTimeout time.Duration,
+
+	netNS string,
+	runAgentProbe func(ctx context.Context, command string) (bool, error),
+) error {
+	if attemptTimeout <= 0 {
+		attemptTimeout = interval
+	}
+
+	for i, probe := range probes {
+		for {
+			attemptCtx, cancelAttemptCtx := context.WithCancel(ctx)
+			if attemptTimeout > 0 {
+				attemptCtx, cancelAttemptCtx = context.WithTimeout(ctx, attemptTimeout)
+			}
+			err := runReadinessProbe(attemptCtx, probe, netNS, runAgentProbe)
+			cancelAttemptCtx()
+
+			if err == nil {
+				break
+			}
+
+			// Probes with an invalid configuration or that the agent can't run will never succeed, so we don't retry them
+			if errors.Is(err, ErrUnknownReadinessProbeType) || errors.Is(err, ErrReadinessProbeNotSupported) {
+				return errors.Join(ErrVMNotReady, fmt.Errorf("probe %v (%v)", i, probe.Type), err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return errors.Join(ErrVMNotReady, fmt.Errorf("probe %v (%v)", i, probe.Type), err, ctx.Err())
+
+			case <-time.After(interval):
+			}
+		}
+	}
+
+	return nil
+}
+
+func runReadinessProbe(
+	ctx context.Context,
+
+	probe ReadinessProbe,
+
+	netNS string,
+	runAgentProbe func(ctx context.Context, command string) (bool, error),
+) error {
+	switch probe.Type {
+	case ReadinessProbeTypeTCP:
+		conn, err := dialInNetNS(ctx, netNS, "tcp", probe.Address)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close()
+
+	case ReadinessProbeTypeHTTP:
+		client := &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return dialInNetNS(ctx, netNS, network, addr)
+				},
+				DisableKeepAlives: true,
+			},
+			// We treat redirects as success without following them, since their targets might not be reachable from the namespace
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, probe.URL, nil)
+		if err != nil {
+			return errors.Join(ErrCouldNotCreateReadinessRequest, err)
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode < 200 || res.StatusCode >= 400 {
+			return fmt.Errorf("unexpected status code %v", res.StatusCode)
+		}
+
+		return nil
+
+	case ReadinessProbeTypeExec:
+		supported, err := runAgentProbe(ctx, probe.Command)
+		if err != nil {
+			return err
+		}
+
+		if !supported {
+			return ErrReadinessProbeNotSupported
+		}
+
+		return nil
+
+	default:
+		return errors.Join(ErrUnknownReadinessProbeType, fmt.Errorf("probe type %q", probe.Type))
+	}
+}
+
+// dialInNetNS connects to `address` from inside the network namespace `netNS`, in which the VM's network interfaces are
+func dialInNetNS(ctx context.Context, netNS string, network, address string) (conn net.Conn, errs error) {
+	// Namespaces are per-thread, so we need to make sure that the socket is created on the thread that we switch to the namespace
+	runtime.LockOSThread()
+
+	// If we can't switch the thread back to the original namespace, we keep it locked so that Go terminates it once the
+	// goroutine exits instead of running other goroutines in the VM's namespace
+	unlockOSThread := true
+	defer func() {
+		if unlockOSThread {
+			runtime.UnlockOSThread()
+		}
+	}()
+
+	originalNSHandle, err := netns.Get()
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotGetOriginalNSHandle, err)
+	}
+	defer originalNSHandle.Close()
+
+	nsHandle, err := netns.GetFromName(netNS)
+	if err != nil {
+		return nil, errors.Join(ErrCouldNotGetNSHandle, err)
+	}
+	defer nsHandle.Close()
+
+	if err := netns.Set(nsHandle); err != nil {
+		return nil, errors.Join(ErrCouldNotSetNSHandle, err)
+	}
+	defer func() {
+		if err := netns.Set(originalNSHandle); err != nil {
+			unlockOSThread = false
+
+			if conn != nil {
+				_ = conn.Close() // We ignore errors here since we return the error of switching back instead
+				conn = nil
+			}
+
+			errs = errors.Join(errs, ErrCouldNotSetOriginalNSHandle, err)
+		}
+	}()
+
+	// The socket stays in the namespace that it was created in, even after we switch back
+	return (&net.Dialer{}).DialContext(ctx, network, address)
+}
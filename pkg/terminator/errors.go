@@ -13,5 +13,6 @@ var (
 	ErrCouldNotHandleDevInfo            = errors.New("could not handle device info")
 	ErrCouldNotHandleEvent              = errors.New("could not handle event")
 	ErrCouldNotHandleDirtyList          = errors.New("could not handle dirty list")
+	ErrCouldNotWriteZeroBlocks          = errors.New("could not write zero blocks")
 	ErrUnknownDeviceName                = errors.New("unknown device name")
 )
@@ -63,8 +63,10 @@ func Terminate(
 		writers,
 		func(ctx context.Context, p protocol.Protocol, index uint32) {
 			var (
-				from  *protocol.FromProtocol
-				local *waitingcache.Local
+				from      *protocol.FromProtocol
+				local     *waitingcache.Local
+				remote    *waitingcache.Remote
+				blockSize uint32
 			)
 			from = protocol.NewFromProtocol(
 				ctx,
@@ -108,7 +110,7 @@ func Terminate(
 					deviceCloseFuncs = append(deviceCloseFuncs, device.Shutdown) // defer device.Shutdown()
 					deviceCloseFuncsLock.Unlock()
 
-					var remote *waitingcache.Remote
+					blockSize = di.BlockSize
 					local, remote = waitingcache.NewWaitingCache(src, int(di.BlockSize))
 					local.NeedAt = func(offset int64, length int32) {
 						// Only access the `from` protocol if it's not already closed
@@ -172,6 +174,13 @@ func Terminate(
 								hook()
 							}
 
+						case byte(registry.EventCustomZeroBlocks):
+							if remote != nil {
+								if err := registry.WriteZeroBlocks(remote, int(blockSize), e.CustomPayload); err != nil {
+									panic(errors.Join(ErrCouldNotWriteZeroBlocks, err))
+								}
+							}
+
 						case byte(registry.EventCustomTransferAuthority):
 							if hook := hooks.OnDeviceAuthorityReceived; hook != nil {
 								hook(index)